@@ -0,0 +1,478 @@
+package cauth
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gocopper/copper/cerrors"
+	"github.com/gocopper/copper/chttp"
+	"github.com/gocopper/copper/clogger"
+	"github.com/gocopper/copper/csql"
+	"github.com/gocopper/pkg/crandom"
+	"github.com/google/uuid"
+)
+
+const (
+	refreshTokenLen = 64
+
+	accessTokenAlgorithm = "EdDSA"
+	accessTokenType      = "JWT"
+)
+
+// ErrAccessTokensDisabled is returned when access tokens are used without Config.AccessTokenSigningKeys.
+var ErrAccessTokensDisabled = errors.New("access tokens disabled")
+
+// AccessTokenResult holds a new access token and the refresh token that can be exchanged for the next one with
+// Svc.RefreshAccessToken. Its JSON matches an OAuth 2.0 token response.
+type AccessTokenResult struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int    `json:"expires_in"`
+	RefreshToken string `json:"refresh_token"`
+}
+
+// AccessTokenClaims are the claims of an access token. Subject is the user that the token authenticates. When a
+// session impersonates a user, Subject is the impersonated user and Actor holds the user that started the
+// impersonation, as described in RFC 8693.
+type AccessTokenClaims struct {
+	Issuer           string            `json:"iss,omitempty"`
+	Subject          string            `json:"sub"`
+	Actor            *AccessTokenActor `json:"act,omitempty"`
+	SessionUUID      string            `json:"sid"`
+	OrganizationUUID *string           `json:"org,omitempty"`
+	Email            string            `json:"email,omitempty"`
+	Username         string            `json:"username,omitempty"`
+	IssuedAt         int64             `json:"iat"`
+	ExpiresAt        int64             `json:"exp"`
+}
+
+// AccessTokenActor is the user acting on behalf of the subject of an access token.
+type AccessTokenActor struct {
+	Subject string `json:"sub"`
+}
+
+// Session returns the session that the access token was issued for, as far as the claims describe it.
+func (c *AccessTokenClaims) Session() *Session {
+	session := &Session{
+		UUID:                   c.SessionUUID,
+		UserUUID:               c.Subject,
+		ExpiresAt:              time.Unix(c.ExpiresAt, 0),
+		ActiveOrganizationUUID: c.OrganizationUUID,
+	}
+
+	if c.Actor != nil {
+		impersonatedUserUUID := c.Subject
+
+		session.UserUUID = c.Actor.Subject
+		session.ImpersonatedUserUUID = &impersonatedUserUUID
+	}
+
+	return session
+}
+
+// User returns the user that the access token authenticates. Only the fields held in the claims are set.
+func (c *AccessTokenClaims) User() *User {
+	return &User{
+		UUID:     c.Subject,
+		Email:    c.Email,
+		Username: c.Username,
+	}
+}
+
+// JSONWebKeySet is the set of public keys that verify access tokens. It is served by the auth router so that other
+// services can verify access tokens on their own.
+type JSONWebKeySet struct {
+	Keys []JSONWebKey `json:"keys"`
+}
+
+// JSONWebKey is an Ed25519 public key as described in RFC 8037.
+type JSONWebKey struct {
+	KeyType   string `json:"kty"`
+	Curve     string `json:"crv"`
+	X         string `json:"x"`
+	KeyID     string `json:"kid"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+}
+
+type accessTokenKey struct {
+	id         string
+	privateKey ed25519.PrivateKey
+}
+
+type accessTokenHeader struct {
+	Algorithm string `json:"alg"`
+	Type      string `json:"typ"`
+	KeyID     string `json:"kid"`
+}
+
+// newAccessTokenKeys decodes the given base64-encoded Ed25519 seeds. A key's ID is derived from its public key so
+// that it stays the same across restarts.
+func newAccessTokenKeys(seeds []string) ([]accessTokenKey, error) {
+	keys := make([]accessTokenKey, 0, len(seeds))
+
+	for i, seed := range seeds {
+		seedBytes, err := base64.StdEncoding.DecodeString(strings.TrimSpace(seed))
+		if err != nil {
+			return nil, cerrors.New(err, "failed to decode access token signing key", map[string]interface{}{
+				"index": i,
+			})
+		}
+
+		if len(seedBytes) != ed25519.SeedSize {
+			return nil, cerrors.New(nil, "invalid access token signing key size", map[string]interface{}{
+				"index": i,
+				"size":  len(seedBytes),
+			})
+		}
+
+		privateKey := ed25519.NewKeyFromSeed(seedBytes)
+		keyHash := sha256.Sum256(privateKey.Public().(ed25519.PublicKey)) //nolint:forcetypeassert
+
+		keys = append(keys, accessTokenKey{
+			id:         base64.RawURLEncoding.EncodeToString(keyHash[:8]),
+			privateKey: privateKey,
+		})
+	}
+
+	return keys, nil
+}
+
+// AccessTokensEnabled returns true if Config.AccessTokenSigningKeys is set.
+func (s *Svc) AccessTokensEnabled() bool {
+	return len(s.accessTokenKeys) > 0
+}
+
+// CreateAccessToken issues an access token and a refresh token for the given session. It is used by clients that
+// logged in with a session but need to authenticate without it, e.g. with other services that verify the access
+// token with the JWKS.
+func (s *Svc) CreateAccessToken(ctx context.Context, session *Session) (*AccessTokenResult, error) {
+	if !s.AccessTokensEnabled() {
+		return nil, ErrAccessTokensDisabled
+	}
+
+	user, err := s.queries.GetUserByUUID(ctx, session.CurrentUserID())
+	if err != nil {
+		return nil, cerrors.New(err, "failed to get user by uuid", map[string]interface{}{
+			"userUUID": session.CurrentUserID(),
+		})
+	}
+
+	return s.createAccessToken(ctx, session, user)
+}
+
+// RefreshAccessToken exchanges the given refresh token for a new access token and refresh token. Each refresh token
+// can only be used once. If a used refresh token is presented again, it has likely been stolen, so its session is
+// ended along with all of the refresh tokens issued for it, and ErrInvalidCredentials is returned. Refreshing renews
+// the session like any other request made with it and fails once the session has expired or been logged out of.
+// Access tokens that were already issued remain valid until they expire.
+func (s *Svc) RefreshAccessToken(ctx context.Context, plainRefreshToken string) (*AccessTokenResult, error) {
+	if !s.AccessTokensEnabled() {
+		return nil, ErrAccessTokensDisabled
+	}
+
+	refreshToken, err := s.queries.GetRefreshTokenByTokenHash(ctx, s.sessionTokenDigest(plainRefreshToken))
+	if err != nil && errors.Is(err, ErrNotFound) {
+		return nil, ErrInvalidCredentials
+	} else if err != nil {
+		return nil, cerrors.New(err, "failed to get refresh token by token hash", nil)
+	}
+
+	if refreshToken.UsedAt != nil {
+		return nil, s.endReusedRefreshTokenSession(ctx, refreshToken)
+	}
+
+	now := time.Now()
+	if now.After(refreshToken.ExpiresAt) {
+		return nil, ErrInvalidCredentials
+	}
+
+	// The token may have been used by a concurrent request since it was read. Only one of them succeeds.
+	ok, err := s.queries.MarkRefreshTokenUsed(ctx, refreshToken.UUID, now)
+	if err != nil {
+		return nil, cerrors.New(err, "failed to mark refresh token as used", map[string]interface{}{
+			"refreshTokenUUID": refreshToken.UUID,
+		})
+	}
+
+	if !ok {
+		return nil, ErrInvalidCredentials
+	}
+
+	session, err := s.queries.GetSession(ctx, refreshToken.SessionUUID)
+	if err != nil && errors.Is(err, ErrNotFound) {
+		return nil, ErrInvalidCredentials
+	} else if err != nil {
+		return nil, cerrors.New(err, "failed to get session", map[string]interface{}{
+			"sessionUUID": refreshToken.SessionUUID,
+		})
+	}
+
+	if now.After(session.ExpiresAt) {
+		return nil, ErrInvalidCredentials
+	}
+
+	_, err = s.touchSession(ctx, session)
+	if err != nil {
+		return nil, cerrors.New(err, "failed to update session last seen", map[string]interface{}{
+			"sessionUUID": session.UUID,
+		})
+	}
+
+	user, err := s.queries.GetUserByUUID(ctx, session.CurrentUserID())
+	if err != nil {
+		return nil, cerrors.New(err, "failed to get user by uuid", map[string]interface{}{
+			"userUUID": session.CurrentUserID(),
+		})
+	}
+
+	if user.DeletedAt != nil {
+		return nil, ErrInvalidCredentials
+	}
+
+	return s.createAccessToken(ctx, session, user)
+}
+
+// endReusedRefreshTokenSession ends the session of a refresh token that was used more than once. It is done outside
+// the request's database transaction so that it is not rolled back along with the failed request. It always returns
+// ErrInvalidCredentials unless the session could not be ended.
+func (s *Svc) endReusedRefreshTokenSession(ctx context.Context, refreshToken *RefreshToken) error {
+	ctx = csql.CtxWithoutTx(ctx)
+
+	session, err := s.queries.GetSession(ctx, refreshToken.SessionUUID)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return cerrors.New(err, "failed to get session", map[string]interface{}{
+			"sessionUUID": refreshToken.SessionUUID,
+		})
+	}
+
+	if err == nil && time.Now().Before(session.ExpiresAt) {
+		session.UpdatedAt = time.Now()
+		session.ExpiresAt = session.UpdatedAt
+
+		err = s.updateSession(ctx, session)
+		if err != nil {
+			return cerrors.New(err, "failed to update session", map[string]interface{}{
+				"sessionUUID": session.UUID,
+			})
+		}
+	}
+
+	err = s.recordAuditEvent(ctx, &AuditEvent{
+		Type:        AuditEventRefreshTokenReused,
+		UserUUID:    refreshToken.UserUUID,
+		SessionUUID: refreshToken.SessionUUID,
+		Metadata:    AuditMetadata{"refresh_token_uuid": refreshToken.UUID},
+	})
+	if err != nil {
+		return err
+	}
+
+	return ErrInvalidCredentials
+}
+
+func (s *Svc) createAccessToken(ctx context.Context, session *Session, user *User) (*AccessTokenResult, error) {
+	plainRefreshToken := crandom.GenerateRandomString(refreshTokenLen)
+
+	refreshToken := &RefreshToken{
+		UUID:        uuid.New().String(),
+		CreatedAt:   time.Now(),
+		UpdatedAt:   time.Now(),
+		SessionUUID: session.UUID,
+		UserUUID:    session.UserUUID,
+		TokenHash:   s.sessionTokenDigest(plainRefreshToken),
+		ExpiresAt:   session.ExpiresAt,
+	}
+
+	err := s.queries.InsertRefreshToken(ctx, refreshToken)
+	if err != nil {
+		return nil, cerrors.New(err, "failed to insert refresh token", map[string]interface{}{
+			"sessionUUID": session.UUID,
+		})
+	}
+
+	claims := &AccessTokenClaims{
+		Issuer:           s.config.AccessTokenIssuer,
+		Subject:          user.UUID,
+		SessionUUID:      session.UUID,
+		OrganizationUUID: session.ActiveOrganizationUUID,
+		Email:            user.Email,
+		Username:         user.Username,
+		IssuedAt:         refreshToken.CreatedAt.Unix(),
+		ExpiresAt:        refreshToken.CreatedAt.Add(s.config.AccessTokenTTL).Unix(),
+	}
+
+	if session.ImpersonatedUserUUID != nil {
+		claims.Actor = &AccessTokenActor{Subject: session.UserUUID}
+	}
+
+	accessToken, err := s.signAccessToken(claims)
+	if err != nil {
+		return nil, err
+	}
+
+	return &AccessTokenResult{
+		AccessToken:  accessToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    int(s.config.AccessTokenTTL.Seconds()),
+		RefreshToken: plainRefreshToken,
+	}, nil
+}
+
+// signAccessToken encodes the given claims as a JWT signed with the first access token key.
+func (s *Svc) signAccessToken(claims *AccessTokenClaims) (string, error) {
+	key := s.accessTokenKeys[0]
+
+	header, err := json.Marshal(accessTokenHeader{
+		Algorithm: accessTokenAlgorithm,
+		Type:      accessTokenType,
+		KeyID:     key.id,
+	})
+	if err != nil {
+		return "", cerrors.New(err, "failed to marshal access token header", nil)
+	}
+
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", cerrors.New(err, "failed to marshal access token claims", nil)
+	}
+
+	signingInput := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	signature := ed25519.Sign(key.privateKey, []byte(signingInput))
+
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+// ValidateAccessToken verifies the signature of the given access token and returns its claims. It does not query the
+// database, so tokens stay valid until they expire even if their session is logged out of. ErrInvalidCredentials is
+// returned if the token is malformed, was not signed by one of the access token keys, has expired or was issued by
+// a different issuer.
+func (s *Svc) ValidateAccessToken(accessToken string) (*AccessTokenClaims, error) {
+	if !s.AccessTokensEnabled() {
+		return nil, ErrAccessTokensDisabled
+	}
+
+	parts := strings.Split(accessToken, ".")
+	if len(parts) != 3 {
+		return nil, ErrInvalidCredentials
+	}
+
+	var (
+		header accessTokenHeader
+		claims AccessTokenClaims
+	)
+
+	err := decodeAccessTokenPart(parts[0], &header)
+	if err != nil || header.Algorithm != accessTokenAlgorithm {
+		return nil, ErrInvalidCredentials
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrInvalidCredentials
+	}
+
+	verified := false
+
+	for _, key := range s.accessTokenKeys {
+		if key.id == header.KeyID {
+			verified = ed25519.Verify(key.privateKey.Public().(ed25519.PublicKey), //nolint:forcetypeassert
+				[]byte(parts[0]+"."+parts[1]), signature)
+			break
+		}
+	}
+
+	if !verified {
+		return nil, ErrInvalidCredentials
+	}
+
+	err = decodeAccessTokenPart(parts[1], &claims)
+	if err != nil {
+		return nil, ErrInvalidCredentials
+	}
+
+	if claims.Issuer != s.config.AccessTokenIssuer || claims.Subject == "" || time.Now().Unix() >= claims.ExpiresAt {
+		return nil, ErrInvalidCredentials
+	}
+
+	return &claims, nil
+}
+
+func decodeAccessTokenPart(part string, dest interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(part)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(data, dest)
+}
+
+// AccessTokenJWKS returns the public keys of Config.AccessTokenSigningKeys.
+func (s *Svc) AccessTokenJWKS() *JSONWebKeySet {
+	keys := make([]JSONWebKey, 0, len(s.accessTokenKeys))
+
+	for _, key := range s.accessTokenKeys {
+		keys = append(keys, JSONWebKey{
+			KeyType:   "OKP",
+			Curve:     "Ed25519",
+			X:         base64.RawURLEncoding.EncodeToString(key.privateKey.Public().(ed25519.PublicKey)), //nolint:forcetypeassert
+			KeyID:     key.id,
+			Use:       "sig",
+			Algorithm: accessTokenAlgorithm,
+		})
+	}
+
+	return &JSONWebKeySet{Keys: keys}
+}
+
+// NewVerifyAccessTokenMiddleware instantiates and creates a new VerifyAccessTokenMiddleware
+func NewVerifyAccessTokenMiddleware(auth *Svc, rw *chttp.HTMLReaderWriter, logger clogger.Logger) *VerifyAccessTokenMiddleware {
+	return &VerifyAccessTokenMiddleware{
+		auth:   auth,
+		rw:     rw,
+		logger: logger,
+	}
+}
+
+// VerifyAccessTokenMiddleware is a middleware that checks for a valid access token in the Authorization header using
+// the Bearer scheme. If the token is valid, the session and user described by its claims are saved in the request
+// ctx so that GetCurrentSession and GetCurrentUser can be used like with VerifySessionMiddleware. The database is not
+// queried, so only the fields held in the claims are set. If the token is missing or invalid, an unauthorized
+// response is sent back.
+type VerifyAccessTokenMiddleware struct {
+	auth   *Svc
+	rw     *chttp.HTMLReaderWriter
+	logger clogger.Logger
+}
+
+// Handle implements the middleware for VerifyAccessTokenMiddleware.
+func (mw *VerifyAccessTokenMiddleware) Handle(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		scheme, accessToken, _ := strings.Cut(r.Header.Get("Authorization"), " ")
+		if !strings.EqualFold(scheme, "Bearer") || accessToken == "" {
+			mw.rw.Unauthorized(w, r)
+			return
+		}
+
+		claims, err := mw.auth.ValidateAccessToken(strings.TrimSpace(accessToken))
+		if err != nil && errors.Is(err, ErrInvalidCredentials) {
+			mw.rw.Unauthorized(w, r)
+			return
+		} else if err != nil {
+			mw.rw.WriteHTMLError(w, r, cerrors.New(err, "failed to validate access token", nil))
+			return
+		}
+
+		ctxWithUser := mw.auth.ctxWithUser(r.Context(), claims.User())
+		ctxWithUserAndSession := context.WithValue(ctxWithUser, ctxKeySession, claims.Session())
+
+		next.ServeHTTP(w, r.WithContext(ctxWithUserAndSession))
+	})
+}
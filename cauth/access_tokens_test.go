@@ -0,0 +1,182 @@
+package cauth_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/gocopper/pkg/cauth"
+	"github.com/gocopper/pkg/cauth/cauthtest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRouter_AccessTokens(t *testing.T) {
+	t.Parallel()
+
+	var (
+		oldKey = base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{1}, ed25519.SeedSize))
+		newKey = base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{2}, ed25519.SeedSize))
+		dbPath = path.Join(t.TempDir(), "cauth.db")
+
+		jwks      cauth.JSONWebKeySet
+		tokens    cauth.AccessTokenResult
+		refreshed cauth.AccessTokenResult
+		current   struct {
+			Session struct {
+				UUID     string `json:"uuid"`
+				UserUUID string `json:"user_uuid"`
+			} `json:"session"`
+			User struct {
+				UUID     string `json:"uuid"`
+				Username string `json:"username"`
+			} `json:"user"`
+		}
+	)
+
+	newServer := func(keys ...string) (*httptest.Server, *cauth.Svc) {
+		handler, svc := cauthtest.NewHandlerAndSvc(t, cauthtest.HandlerParams{
+			DBPath: dbPath,
+			Config: `
+[cauth]
+access_token_signing_keys = ["` + strings.Join(keys, `", "`) + `"]
+access_token_issuer = "test"
+`,
+		})
+
+		server := httptest.NewServer(handler)
+		t.Cleanup(server.Close)
+
+		return server, svc
+	}
+
+	getWithAccessToken := func(url, accessToken string) *http.Response {
+		req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, url, nil)
+		assert.NoError(t, err)
+
+		req.Header.Set("Authorization", "Bearer "+accessToken)
+
+		resp, err := http.DefaultClient.Do(req)
+		assert.NoError(t, err)
+		t.Cleanup(func() { _ = resp.Body.Close() })
+
+		return resp
+	}
+
+	server, svc := newServer(oldKey, newKey)
+	session := cauthtest.CreateNewUserSession(t, server)
+
+	// Both keys are published but only the first one signs
+	resp := getJSON(t, server.URL+"/api/auth/jwks", nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&jwks))
+
+	if !assert.Len(t, jwks.Keys, 2) {
+		return
+	}
+
+	assert.Equal(t, "OKP", jwks.Keys[0].KeyType)
+	assert.Equal(t, "EdDSA", jwks.Keys[0].Algorithm)
+
+	resp = postJSON(t, server.URL+"/api/auth/token", `{}`, session)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&tokens))
+	assert.Equal(t, "Bearer", tokens.TokenType)
+	assert.Equal(t, 900, tokens.ExpiresIn)
+	assert.NotEmpty(t, tokens.RefreshToken)
+
+	// Other services can verify access tokens with the JWKS
+	parts := strings.Split(tokens.AccessToken, ".")
+	if assert.Len(t, parts, 3) {
+		publicKey, err := base64.RawURLEncoding.DecodeString(jwks.Keys[0].X)
+		assert.NoError(t, err)
+
+		signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+		assert.NoError(t, err)
+
+		assert.True(t, ed25519.Verify(publicKey, []byte(parts[0]+"."+parts[1]), signature))
+	}
+
+	// The access token authenticates the session's user without a session
+	resp = getWithAccessToken(server.URL+"/api/test/access-token", tokens.AccessToken)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&current))
+	assert.Equal(t, session.Session.UUID, current.Session.UUID)
+	assert.Equal(t, session.User.UUID, current.Session.UserUUID)
+	assert.Equal(t, session.User.UUID, current.User.UUID)
+	assert.Equal(t, "test-user", current.User.Username)
+
+	resp = getWithAccessToken(server.URL+"/api/test/access-token", tokens.AccessToken+"x")
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	resp = getJSON(t, server.URL+"/api/test/access-token", session)
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	// Tokens signed with a key that was rotated out of signing are still accepted
+	rotatedServer, _ := newServer(newKey, oldKey)
+
+	resp = getWithAccessToken(rotatedServer.URL+"/api/test/access-token", tokens.AccessToken)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	_, otherIssuerSvc := cauthtest.NewHandlerAndSvc(t, cauthtest.HandlerParams{
+		Config: `
+[cauth]
+access_token_signing_keys = ["` + oldKey + `"]
+`,
+	})
+
+	_, err := otherIssuerSvc.ValidateAccessToken(tokens.AccessToken)
+	assert.ErrorIs(t, err, cauth.ErrInvalidCredentials)
+
+	// Refresh tokens are rotated on every use
+	resp = postJSON(t, server.URL+"/api/auth/token/refresh", `{"refresh_token": "`+tokens.RefreshToken+`"}`, nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&refreshed))
+	assert.NotEqual(t, tokens.RefreshToken, refreshed.RefreshToken)
+
+	claims, err := svc.ValidateAccessToken(refreshed.AccessToken)
+	if assert.NoError(t, err) {
+		assert.Equal(t, session.Session.UUID, claims.SessionUUID)
+		assert.Equal(t, "test", claims.Issuer)
+	}
+
+	// Reusing a refresh token ends its session along with the refresh tokens issued for it
+	resp = postJSON(t, server.URL+"/api/auth/token/refresh", `{"refresh_token": "`+tokens.RefreshToken+`"}`, nil)
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	resp = postJSON(t, server.URL+"/api/auth/token/refresh", `{"refresh_token": "`+refreshed.RefreshToken+`"}`, nil)
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	resp = getJSON(t, server.URL+"/api/auth/sessions", session)
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	events, err := svc.ListAuditEvents(context.Background(), cauth.ListAuditEventsParams{
+		UserUUID: session.User.UUID,
+		Type:     cauth.AuditEventRefreshTokenReused,
+	})
+	assert.NoError(t, err)
+	assert.Len(t, events, 1)
+}
+
+func TestRouter_AccessTokensDisabled(t *testing.T) {
+	t.Parallel()
+
+	handler, svc := cauthtest.NewHandlerAndSvc(t, cauthtest.HandlerParams{})
+
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	session := cauthtest.CreateNewUserSession(t, server)
+
+	resp := postJSON(t, server.URL+"/api/auth/token", `{}`, session)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	_, err := svc.CreateAccessToken(context.Background(), session.Session)
+	assert.ErrorIs(t, err, cauth.ErrAccessTokensDisabled)
+}
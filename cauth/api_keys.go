@@ -0,0 +1,290 @@
+package cauth
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql/driver"
+	"errors"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gocopper/copper/cerrors"
+	"github.com/gocopper/copper/chttp"
+	"github.com/gocopper/copper/clogger"
+	"github.com/gocopper/pkg/crandom"
+	"github.com/google/uuid"
+)
+
+const (
+	apiKeySecretLen     = 40
+	apiKeyVisibleLen    = 8
+	apiKeyTouchInterval = time.Minute
+)
+
+var (
+	// ErrAPIKeyNameRequired is returned when an API key is created without a name.
+	ErrAPIKeyNameRequired = errors.New("api key name required")
+
+	// ErrInvalidAPIKeyScope is returned when an API key is created with a scope that is empty, contains whitespace or
+	// is not listed in Config.APIKeyScopes.
+	ErrInvalidAPIKeyScope = errors.New("invalid api key scope")
+
+	// ErrInvalidAPIKeyExpiry is returned when an API key is created with an expiry in the past.
+	ErrInvalidAPIKeyExpiry = errors.New("invalid api key expiry")
+)
+
+// APIKeyScopes is the list of scopes granted to an API key. It is stored as a space-separated string.
+type APIKeyScopes []string
+
+// Value implements driver.Valuer.
+func (s APIKeyScopes) Value() (driver.Value, error) {
+	return strings.Join(s, " "), nil
+}
+
+// Scan implements sql.Scanner.
+func (s *APIKeyScopes) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case string:
+		*s = strings.Fields(v)
+	case []byte:
+		*s = strings.Fields(string(v))
+	case nil:
+		*s = APIKeyScopes{}
+	default:
+		return errors.New("unsupported api key scopes type")
+	}
+
+	return nil
+}
+
+// CreateAPIKeyParams hold the params needed to create an API key. If ExpiresAt is nil, the key does not expire.
+type CreateAPIKeyParams struct {
+	UserUUID  string     `json:"-"`
+	Name      string     `json:"name"`
+	Scopes    []string   `json:"scopes"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+// CreateAPIKeyResult holds the new API key and its plain value. The plain key is only available when the key is
+// created and should be shown to the user once.
+type CreateAPIKeyResult struct {
+	APIKey   *APIKey `json:"api_key"`
+	PlainKey string  `json:"plain_key"`
+}
+
+// CreateAPIKey creates a named API key for the given user. The key starts with Config.APIKeyPrefix so that it can be
+// recognized (e.g. by secret scanners) and only its hash is stored.
+func (s *Svc) CreateAPIKey(ctx context.Context, p CreateAPIKeyParams) (*CreateAPIKeyResult, error) {
+	name := strings.TrimSpace(p.Name)
+	if name == "" {
+		return nil, ErrAPIKeyNameRequired
+	}
+
+	scopes := make(APIKeyScopes, 0, len(p.Scopes))
+
+	for _, scope := range p.Scopes {
+		if scope == "" || strings.ContainsAny(scope, " \t\r\n") {
+			return nil, ErrInvalidAPIKeyScope
+		}
+
+		if len(s.config.APIKeyScopes) > 0 && !slices.Contains(s.config.APIKeyScopes, scope) {
+			return nil, ErrInvalidAPIKeyScope
+		}
+
+		if !slices.Contains(scopes, scope) {
+			scopes = append(scopes, scope)
+		}
+	}
+
+	if p.ExpiresAt != nil && p.ExpiresAt.Before(time.Now()) {
+		return nil, ErrInvalidAPIKeyExpiry
+	}
+
+	plainKey := s.config.APIKeyPrefix + crandom.GenerateRandomString(apiKeySecretLen)
+	keyHash := sha256.Sum256([]byte(plainKey))
+
+	apiKey := &APIKey{
+		UUID:      uuid.New().String(),
+		CreatedAt: time.Now(),
+		UpdatedAt: time.Now(),
+		UserUUID:  p.UserUUID,
+		Name:      name,
+		Prefix:    plainKey[:len(s.config.APIKeyPrefix)+apiKeyVisibleLen],
+		KeyHash:   keyHash[:],
+		Scopes:    scopes,
+		ExpiresAt: p.ExpiresAt,
+	}
+
+	err := s.queries.InsertAPIKey(ctx, apiKey)
+	if err != nil {
+		return nil, cerrors.New(err, "failed to insert api key", map[string]interface{}{
+			"userUUID": p.UserUUID,
+		})
+	}
+
+	err = s.recordAuditEvent(ctx, &AuditEvent{
+		Type:     AuditEventAPIKeyCreated,
+		UserUUID: p.UserUUID,
+		Metadata: AuditMetadata{"api_key_uuid": apiKey.UUID},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &CreateAPIKeyResult{
+		APIKey:   apiKey,
+		PlainKey: plainKey,
+	}, nil
+}
+
+// ListAPIKeys returns the API keys of the given user that have not been revoked, including expired ones.
+func (s *Svc) ListAPIKeys(ctx context.Context, userUUID string) ([]APIKey, error) {
+	apiKeys, err := s.queries.ListAPIKeysByUserUUID(ctx, userUUID)
+	if err != nil {
+		return nil, cerrors.New(err, "failed to list api keys", map[string]interface{}{
+			"userUUID": userUUID,
+		})
+	}
+
+	return apiKeys, nil
+}
+
+// RevokeAPIKey revokes the API key identified by apiKeyUUID so that it can no longer be used. ErrNotFound is
+// returned if the key does not belong to the given user or is already revoked.
+func (s *Svc) RevokeAPIKey(ctx context.Context, userUUID, apiKeyUUID string) error {
+	apiKey, err := s.queries.GetAPIKey(ctx, apiKeyUUID)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return cerrors.New(err, "failed to get api key", map[string]interface{}{
+			"apiKeyUUID": apiKeyUUID,
+		})
+	} else if err != nil || apiKey.UserUUID != userUUID || apiKey.RevokedAt != nil {
+		return ErrNotFound
+	}
+
+	apiKey.UpdatedAt = time.Now()
+	apiKey.RevokedAt = &apiKey.UpdatedAt
+
+	err = s.queries.UpdateAPIKey(ctx, apiKey)
+	if err != nil {
+		return cerrors.New(err, "failed to update api key", map[string]interface{}{
+			"apiKeyUUID": apiKeyUUID,
+		})
+	}
+
+	return s.recordAuditEvent(ctx, &AuditEvent{
+		Type:     AuditEventAPIKeyRevoked,
+		UserUUID: userUUID,
+		Metadata: AuditMetadata{"api_key_uuid": apiKeyUUID},
+	})
+}
+
+// ValidateAPIKey returns the API key and its user if the given plain key is valid. It returns ErrInvalidCredentials
+// if the key does not exist, has expired or has been revoked. The key's last used time is updated at most once a
+// minute.
+func (s *Svc) ValidateAPIKey(ctx context.Context, plainKey string) (*APIKey, *User, error) {
+	if !strings.HasPrefix(plainKey, s.config.APIKeyPrefix) {
+		return nil, nil, ErrInvalidCredentials
+	}
+
+	keyHash := sha256.Sum256([]byte(plainKey))
+
+	apiKey, err := s.queries.GetAPIKeyByKeyHash(ctx, keyHash[:])
+	if err != nil && errors.Is(err, ErrNotFound) {
+		return nil, nil, ErrInvalidCredentials
+	} else if err != nil {
+		return nil, nil, cerrors.New(err, "failed to get api key by key hash", nil)
+	}
+
+	if apiKey.RevokedAt != nil || (apiKey.ExpiresAt != nil && time.Now().After(*apiKey.ExpiresAt)) {
+		return nil, nil, ErrInvalidCredentials
+	}
+
+	if apiKey.LastUsedAt == nil || time.Since(*apiKey.LastUsedAt) >= apiKeyTouchInterval {
+		apiKey.UpdatedAt = time.Now()
+		apiKey.LastUsedAt = &apiKey.UpdatedAt
+
+		err = s.queries.UpdateAPIKey(ctx, apiKey)
+		if err != nil {
+			return nil, nil, cerrors.New(err, "failed to update api key", map[string]interface{}{
+				"apiKeyUUID": apiKey.UUID,
+			})
+		}
+	}
+
+	user, err := s.queries.GetUserByUUID(ctx, apiKey.UserUUID)
+	if err != nil {
+		return nil, nil, cerrors.New(err, "failed to get user by uuid", map[string]interface{}{
+			"userUUID": apiKey.UserUUID,
+		})
+	}
+
+	if user.DeletedAt != nil {
+		return nil, nil, ErrInvalidCredentials
+	}
+
+	return apiKey, user, nil
+}
+
+// NewVerifyAPIKeyMiddleware instantiates and creates a new VerifyAPIKeyMiddleware
+func NewVerifyAPIKeyMiddleware(auth *Svc, rw *chttp.HTMLReaderWriter, logger clogger.Logger) *VerifyAPIKeyMiddleware {
+	return &VerifyAPIKeyMiddleware{
+		auth:   auth,
+		rw:     rw,
+		logger: logger,
+	}
+}
+
+// VerifyAPIKeyMiddleware is a middleware that checks for a valid API key in the Authorization header using the
+// Bearer scheme. If the key is valid, the key and its user are saved in the request ctx and the next handler is
+// called. The granted scopes can be checked with HasAPIKeyScope. If the key is missing or invalid, an unauthorized
+// response is sent back.
+type VerifyAPIKeyMiddleware struct {
+	auth   *Svc
+	rw     *chttp.HTMLReaderWriter
+	logger clogger.Logger
+}
+
+// Handle implements the middleware for VerifyAPIKeyMiddleware.
+func (mw *VerifyAPIKeyMiddleware) Handle(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		scheme, plainKey, _ := strings.Cut(r.Header.Get("Authorization"), " ")
+		if !strings.EqualFold(scheme, "Bearer") || plainKey == "" {
+			mw.rw.Unauthorized(w, r)
+			return
+		}
+
+		apiKey, user, err := mw.auth.ValidateAPIKey(r.Context(), strings.TrimSpace(plainKey))
+		if err != nil && errors.Is(err, ErrInvalidCredentials) {
+			mw.rw.Unauthorized(w, r)
+			return
+		} else if err != nil {
+			mw.rw.WriteHTMLError(w, r, cerrors.New(err, "failed to validate api key", nil))
+			return
+		}
+
+		ctxWithUser := mw.auth.ctxWithUser(r.Context(), user)
+		ctxWithUserAndAPIKey := context.WithValue(ctxWithUser, ctxKeyAPIKey, apiKey)
+
+		next.ServeHTTP(w, r.WithContext(ctxWithUserAndAPIKey))
+	})
+}
+
+// GetCurrentAPIKey returns the API key in the HTTP request context. It should only be used in HTTP request handlers
+// that have the VerifyAPIKeyMiddleware on them. If an API key is not found, this method will panic.
+func GetCurrentAPIKey(ctx context.Context) *APIKey {
+	apiKey, ok := ctx.Value(ctxKeyAPIKey).(*APIKey)
+	if !ok || apiKey == nil {
+		panic("api key not found in context")
+	}
+
+	return apiKey
+}
+
+// HasAPIKeyScope checks if the context has an API key that was granted the given scope.
+func HasAPIKeyScope(ctx context.Context, scope string) bool {
+	apiKey, ok := ctx.Value(ctxKeyAPIKey).(*APIKey)
+
+	return ok && apiKey != nil && slices.Contains(apiKey.Scopes, scope)
+}
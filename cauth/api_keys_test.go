@@ -0,0 +1,127 @@
+package cauth_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gocopper/pkg/cauth"
+	"github.com/gocopper/pkg/cauth/cauthtest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRouter_APIKeys(t *testing.T) {
+	t.Parallel()
+
+	var (
+		created cauth.CreateAPIKeyResult
+		list    struct {
+			APIKeys []struct {
+				UUID       string   `json:"uuid"`
+				Name       string   `json:"name"`
+				Prefix     string   `json:"prefix"`
+				Scopes     []string `json:"scopes"`
+				LastUsedAt *string  `json:"last_used_at"`
+			} `json:"api_keys"`
+		}
+	)
+
+	server := httptest.NewServer(cauthtest.NewHandler(t))
+	defer server.Close()
+
+	do := func(method, url, bearer string, session *cauth.SessionResult) *http.Response {
+		req, err := http.NewRequestWithContext(context.Background(), method, url, nil)
+		assert.NoError(t, err)
+
+		if bearer != "" {
+			req.Header.Set("Authorization", "Bearer "+bearer)
+		}
+
+		if session != nil {
+			req.SetBasicAuth(session.Session.UUID, session.PlainSessionToken)
+		}
+
+		resp, err := http.DefaultClient.Do(req)
+		assert.NoError(t, err)
+		t.Cleanup(func() { _ = resp.Body.Close() })
+
+		return resp
+	}
+
+	session := cauthtest.CreateNewUserSession(t, server)
+
+	resp := postJSON(t, server.URL+"/api/auth/api-keys", `{"name": " ", "scopes": ["read"]}`, session)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	resp = postJSON(t, server.URL+"/api/auth/api-keys", `{"name": "cli", "scopes": ["read", "read write"]}`, session)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	resp = postJSON(t, server.URL+"/api/auth/api-keys", `{"name": "cli", "scopes": ["read", "read"]}`, session)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&created))
+	assert.True(t, strings.HasPrefix(created.PlainKey, "cak_"))
+	assert.True(t, strings.HasPrefix(created.PlainKey, created.APIKey.Prefix))
+	assert.Equal(t, []string{"read"}, []string(created.APIKey.Scopes))
+
+	// The API key authenticates its user and exposes its scopes
+	var apiKeyInfo struct {
+		UserUUID string   `json:"user_uuid"`
+		Scopes   []string `json:"scopes"`
+		CanWrite bool     `json:"can_write"`
+	}
+
+	resp = do(http.MethodGet, server.URL+"/api/test/api-key", created.PlainKey, nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&apiKeyInfo))
+	assert.Equal(t, session.User.UUID, apiKeyInfo.UserUUID)
+	assert.Equal(t, []string{"read"}, apiKeyInfo.Scopes)
+	assert.False(t, apiKeyInfo.CanWrite)
+
+	resp = do(http.MethodGet, server.URL+"/api/test/api-key", created.PlainKey+"x", nil)
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	// Sessions cannot be used where an API key is required
+	resp = do(http.MethodGet, server.URL+"/api/test/api-key", "", session)
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	resp = do(http.MethodGet, server.URL+"/api/auth/api-keys", "", session)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&list))
+
+	if assert.Len(t, list.APIKeys, 1) {
+		assert.Equal(t, created.APIKey.UUID, list.APIKeys[0].UUID)
+		assert.Equal(t, "cli", list.APIKeys[0].Name)
+		assert.Equal(t, created.APIKey.Prefix, list.APIKeys[0].Prefix)
+		assert.NotNil(t, list.APIKeys[0].LastUsedAt)
+	}
+
+	resp = do(http.MethodDelete, server.URL+"/api/auth/api-keys/"+created.APIKey.UUID, "", session)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	resp = do(http.MethodDelete, server.URL+"/api/auth/api-keys/"+created.APIKey.UUID, "", session)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	resp = do(http.MethodGet, server.URL+"/api/test/api-key", created.PlainKey, nil)
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	resp = do(http.MethodGet, server.URL+"/api/auth/api-keys", "", session)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&list))
+	assert.Empty(t, list.APIKeys)
+}
+
+func TestRouter_CreateAPIKey_ExpiryInPast(t *testing.T) {
+	t.Parallel()
+
+	server := httptest.NewServer(cauthtest.NewHandler(t))
+	defer server.Close()
+
+	session := cauthtest.CreateNewUserSession(t, server)
+
+	resp := postJSON(t, server.URL+"/api/auth/api-keys",
+		`{"name": "cli", "expires_at": "2000-01-01T00:00:00Z"}`, session)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
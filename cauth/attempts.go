@@ -0,0 +1,318 @@
+package cauth
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/gocopper/copper/cerrors"
+	"github.com/gocopper/copper/csql"
+	"github.com/redis/go-redis/v9"
+)
+
+const redisAttemptKeyPrefix = "cauth:failed_attempts:"
+
+// ErrTooManyAttempts is returned when an account or IP has failed too many attempts recently. The client must wait
+// before trying again.
+var ErrTooManyAttempts = errors.New("too many attempts")
+
+// AttemptStore stores counters of failed attempts (e.g. wrong passwords or verification codes) that are used to
+// throttle logins, as well as counters of requested magic link emails. Counters can be kept in the database with NewSQLAttemptStore or in Redis with NewRedisAttemptStore,
+// which suits deployments with many instances. Other storage can be used by implementing this interface.
+type AttemptStore interface {
+	// Get returns the counter for the given key. A zero counter is returned if there is no counter or if it has
+	// expired.
+	Get(ctx context.Context, key string) (AttemptCounter, error)
+
+	// Increment adds a failed attempt to the counter for the given key and returns the updated counter. The counter
+	// expires ttl after the last failed attempt.
+	Increment(ctx context.Context, key string, ttl time.Duration) (AttemptCounter, error)
+
+	// Reset deletes the counter for the given key.
+	Reset(ctx context.Context, key string) error
+}
+
+// AttemptCounter holds the number of failed attempts for a key and when the last one happened.
+type AttemptCounter struct {
+	Count        int
+	LastFailedAt time.Time
+}
+
+// NewSQLAttemptStore creates an AttemptStore that keeps counters in the cauth_failed_attempts table. Counters are
+// read and written outside the request's database transaction so that they are not rolled back along with the failed
+// request. SQLite databases should use WAL mode so that these writes are not blocked by the request's transaction.
+func NewSQLAttemptStore(queries *Queries) AttemptStore {
+	return &sqlAttemptStore{queries: queries}
+}
+
+type sqlAttemptStore struct {
+	queries *Queries
+}
+
+func (s *sqlAttemptStore) Get(ctx context.Context, key string) (AttemptCounter, error) {
+	attempt, err := s.queries.GetFailedAttempt(csql.CtxWithoutTx(ctx), key, time.Now())
+	if err != nil && errors.Is(err, ErrNotFound) {
+		return AttemptCounter{}, nil
+	} else if err != nil {
+		return AttemptCounter{}, err
+	}
+
+	return AttemptCounter{
+		Count:        attempt.Count,
+		LastFailedAt: attempt.LastFailedAt,
+	}, nil
+}
+
+func (s *sqlAttemptStore) Increment(ctx context.Context, key string, ttl time.Duration) (AttemptCounter, error) {
+	now := time.Now()
+
+	attempt, err := s.queries.IncrementFailedAttempt(csql.CtxWithoutTx(ctx), key, now, now.Add(ttl))
+	if err != nil {
+		return AttemptCounter{}, err
+	}
+
+	return AttemptCounter{
+		Count:        attempt.Count,
+		LastFailedAt: attempt.LastFailedAt,
+	}, nil
+}
+
+func (s *sqlAttemptStore) Reset(ctx context.Context, key string) error {
+	return s.queries.DeleteFailedAttempt(ctx, key)
+}
+
+// NewRedisAttemptStore creates an AttemptStore that keeps counters in Redis. Counters are stored as hashes that
+// expire on their own.
+func NewRedisAttemptStore(client *redis.Client) AttemptStore {
+	return &redisAttemptStore{client: client}
+}
+
+type redisAttemptStore struct {
+	client *redis.Client
+}
+
+func (s *redisAttemptStore) Get(ctx context.Context, key string) (AttemptCounter, error) {
+	var counter struct {
+		Count        int   `redis:"count"`
+		LastFailedAt int64 `redis:"last_failed_at"`
+	}
+
+	err := s.client.HGetAll(ctx, redisAttemptKeyPrefix+key).Scan(&counter)
+	if err != nil {
+		return AttemptCounter{}, cerrors.New(err, "failed to get counter from redis", map[string]interface{}{
+			"key": key,
+		})
+	}
+
+	if counter.Count == 0 {
+		return AttemptCounter{}, nil
+	}
+
+	return AttemptCounter{
+		Count:        counter.Count,
+		LastFailedAt: time.UnixMilli(counter.LastFailedAt),
+	}, nil
+}
+
+func (s *redisAttemptStore) Increment(ctx context.Context, key string, ttl time.Duration) (AttemptCounter, error) {
+	var (
+		now      = time.Now()
+		countCmd *redis.IntCmd
+	)
+
+	_, err := s.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		countCmd = pipe.HIncrBy(ctx, redisAttemptKeyPrefix+key, "count", 1)
+		pipe.HSet(ctx, redisAttemptKeyPrefix+key, "last_failed_at", now.UnixMilli())
+		pipe.PExpire(ctx, redisAttemptKeyPrefix+key, ttl)
+
+		return nil
+	})
+	if err != nil {
+		return AttemptCounter{}, cerrors.New(err, "failed to increment counter in redis", map[string]interface{}{
+			"key": key,
+		})
+	}
+
+	return AttemptCounter{
+		Count:        int(countCmd.Val()),
+		LastFailedAt: now,
+	}, nil
+}
+
+func (s *redisAttemptStore) Reset(ctx context.Context, key string) error {
+	err := s.client.Del(ctx, redisAttemptKeyPrefix+key).Err()
+	if err != nil {
+		return cerrors.New(err, "failed to delete counter from redis", map[string]interface{}{
+			"key": key,
+		})
+	}
+
+	return nil
+}
+
+// checkAttempts returns ErrTooManyAttempts if the account identified by accountKey or the client's IP is locked out
+// or in a backoff period. Accounts are locked out for Config.LoginLockoutDuration after Config.LoginMaxAttempts failed
+// attempts. Before that, every failed attempt after the first Config.LoginFreeAttempts doubles the time the client
+// must wait, starting at Config.LoginBackoffBase. IPs are only locked out, after Config.LoginIPMaxAttempts failed
+// attempts, so that users sharing an IP are not slowed down by each other.
+func (s *Svc) checkAttempts(ctx context.Context, accountKey string) error {
+	now := time.Now()
+
+	counter, err := s.attempts.Get(ctx, accountKey)
+	if err != nil {
+		return cerrors.New(err, "failed to get failed attempts", map[string]interface{}{
+			"key": accountKey,
+		})
+	}
+
+	if now.Before(counter.LastFailedAt.Add(s.attemptsBackoff(counter.Count))) {
+		return ErrTooManyAttempts
+	}
+
+	ip := clientInfoFromContext(ctx).IP
+	if ip == "" {
+		return nil
+	}
+
+	counter, err = s.attempts.Get(ctx, attemptKeyIP(ip))
+	if err != nil {
+		return cerrors.New(err, "failed to get failed attempts", map[string]interface{}{
+			"ip": ip,
+		})
+	}
+
+	if counter.Count >= s.config.LoginIPMaxAttempts && now.Before(counter.LastFailedAt.Add(s.config.LoginLockoutDuration)) {
+		return ErrTooManyAttempts
+	}
+
+	return nil
+}
+
+// throttleEmail returns ErrTooManyAttempts if too many emails were requested recently for the account identified by
+// accountKey, and counts the request otherwise. Requests are throttled like failed attempts so that endpoints that
+// send emails cannot be used to flood someone's inbox.
+func (s *Svc) throttleEmail(ctx context.Context, accountKey string) error {
+	err := s.checkAttempts(ctx, accountKey)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.attempts.Increment(ctx, accountKey, s.config.LoginLockoutDuration)
+	if err != nil {
+		return cerrors.New(err, "failed to record email request", map[string]interface{}{
+			"key": accountKey,
+		})
+	}
+
+	return nil
+}
+
+// attemptsBackoff returns how long a client must wait after the given number of failed attempts.
+func (s *Svc) attemptsBackoff(count int) time.Duration {
+	if count >= s.config.LoginMaxAttempts {
+		return s.config.LoginLockoutDuration
+	} else if count < s.config.LoginFreeAttempts {
+		return 0
+	}
+
+	backoff := s.config.LoginBackoffBase
+	for i := s.config.LoginFreeAttempts; i < count && backoff < s.config.LoginLockoutDuration; i++ {
+		backoff *= 2
+	}
+
+	return min(backoff, s.config.LoginLockoutDuration)
+}
+
+// recordFailedAttempt counts a failed attempt against each of the given keys and the client's IP. It returns err
+// so that callers can record the attempt and return in one statement, unless the attempt could not be recorded.
+func (s *Svc) recordFailedAttempt(ctx context.Context, err error, keys ...string) error {
+	if ip := clientInfoFromContext(ctx).IP; ip != "" {
+		keys = append(keys, attemptKeyIP(ip))
+	}
+
+	// Counters must outlive verification codes so that a code cannot be guessed again once its counter expires
+	ttl := max(s.config.LoginLockoutDuration, verificationCodeTTL)
+
+	for _, key := range keys {
+		_, incErr := s.attempts.Increment(ctx, key, ttl)
+		if incErr != nil {
+			return cerrors.New(incErr, "failed to record failed attempt", map[string]interface{}{
+				"key": key,
+			})
+		}
+	}
+
+	return err
+}
+
+// resetAttempts clears the failed attempts of the given key. It is called when the account owner proves their
+// identity so that earlier failures don't slow them down.
+func (s *Svc) resetAttempts(ctx context.Context, key string) error {
+	err := s.attempts.Reset(ctx, key)
+	if err != nil {
+		return cerrors.New(err, "failed to reset failed attempts", map[string]interface{}{
+			"key": key,
+		})
+	}
+
+	return nil
+}
+
+// checkVerificationCodeAttempts returns ErrVerificationCodeExpired if the verification code identified by codeKey
+// has been guessed wrong Config.VerificationCodeMaxAttempts times. The user must request a new code, which resets
+// the counter.
+func (s *Svc) checkVerificationCodeAttempts(ctx context.Context, codeKey string) error {
+	counter, err := s.attempts.Get(ctx, codeKey)
+	if err != nil {
+		return cerrors.New(err, "failed to get failed attempts", map[string]interface{}{
+			"key": codeKey,
+		})
+	}
+
+	if counter.Count >= s.config.VerificationCodeMaxAttempts {
+		return ErrVerificationCodeExpired
+	}
+
+	return nil
+}
+
+func attemptKeyEmail(email string) string {
+	return "email:" + strings.ToLower(email)
+}
+
+func attemptKeyPhone(phone string) string {
+	return "phone:" + phone
+}
+
+func attemptKeyUsername(username string) string {
+	return "username:" + username
+}
+
+func attemptKeyPassword(userUUID string) string {
+	return "password:" + userUUID
+}
+
+func attemptKeyTwoFactor(userUUID string) string {
+	return "two_factor:" + userUUID
+}
+
+func attemptKeyEmailVerificationCode(userUUID string) string {
+	return "email_verification_code:" + userUUID
+}
+
+func attemptKeyPhoneVerificationCode(userUUID string) string {
+	return "phone_verification_code:" + userUUID
+}
+
+func attemptKeyEmailChangeCode(userUUID string) string {
+	return "email_change_code:" + userUUID
+}
+
+func attemptKeyMagicLink(email string) string {
+	return "magic_link:" + strings.ToLower(email)
+}
+
+func attemptKeyIP(ip string) string {
+	return "ip:" + ip
+}
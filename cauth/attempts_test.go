@@ -0,0 +1,222 @@
+package cauth_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/gocopper/pkg/cauth"
+	"github.com/gocopper/pkg/cauth/cauthtest"
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRouter_Login_TooManyAttempts(t *testing.T) {
+	t.Parallel()
+
+	server := httptest.NewServer(cauthtest.NewHandlerWithParams(t, cauthtest.HandlerParams{
+		Config: `
+[cauth]
+login_free_attempts = 2
+login_max_attempts = 3
+login_ip_max_attempts = 5
+login_backoff_base = "1h"
+`,
+	}))
+	defer server.Close()
+
+	cauthtest.CreateNewUserSession(t, server)
+
+	// A successful login resets the account's failed attempts
+	resp := postJSON(t, server.URL+"/api/auth/login", `{"username": "test-user", "password": "wrong"}`, nil)
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	resp = postJSON(t, server.URL+"/api/auth/login", `{"username": "test-user", "password": "test-pass"}`, nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	for range 2 {
+		resp = postJSON(t, server.URL+"/api/auth/login", `{"username": "test-user", "password": "wrong"}`, nil)
+		assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	}
+
+	// The account must now wait before trying again, even with the right password
+	resp = postJSON(t, server.URL+"/api/auth/login", `{"username": "test-user", "password": "test-pass"}`, nil)
+	assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+
+	// Other accounts are not affected until the IP reaches its limit
+	resp = postJSON(t, server.URL+"/api/auth/login", `{"username": "other-user", "password": "wrong"}`, nil)
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	resp = postJSON(t, server.URL+"/api/auth/login", `{"username": "another-user", "password": "wrong"}`, nil)
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	resp = postJSON(t, server.URL+"/api/auth/login", `{"username": "third-user", "password": "wrong"}`, nil)
+	assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+}
+
+func TestRouter_TOTP_TooManyAttempts(t *testing.T) {
+	t.Parallel()
+
+	var enrollment cauth.TOTPEnrollment
+
+	server := httptest.NewServer(cauthtest.NewHandlerWithParams(t, cauthtest.HandlerParams{
+		Config: `
+[cauth]
+login_free_attempts = 2
+login_max_attempts = 3
+login_backoff_base = "1h"
+`,
+	}))
+	defer server.Close()
+
+	session := cauthtest.CreateNewUserSession(t, server)
+
+	resp := postJSON(t, server.URL+"/api/auth/totp/enroll", `{}`, session)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&enrollment))
+
+	// Users without an email are labeled with their username
+	assert.Contains(t, enrollment.URI, "test-user")
+
+	resp = postJSON(t, server.URL+"/api/auth/totp/confirm", `{"code": "wrong"}`, session)
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	code, err := cauth.TOTPCode(enrollment.Secret, time.Now())
+	assert.NoError(t, err)
+
+	resp = postJSON(t, server.URL+"/api/auth/totp/confirm", `{"code": "`+code+`"}`, session)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	for range 2 {
+		resp = postJSON(t, server.URL+"/api/auth/totp/disable", `{"code": "wrong"}`, session)
+		assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	}
+
+	// Wrong codes count towards the same limit as two-factor logins
+	resp = postJSON(t, server.URL+"/api/auth/totp/disable", `{"code": "`+code+`"}`, session)
+	assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+}
+
+func TestRouter_DeleteAccount_TooManyAttempts(t *testing.T) {
+	t.Parallel()
+
+	server := httptest.NewServer(cauthtest.NewHandlerWithParams(t, cauthtest.HandlerParams{
+		Config: `
+[cauth]
+login_free_attempts = 2
+login_max_attempts = 3
+login_backoff_base = "1h"
+`,
+	}))
+	defer server.Close()
+
+	session := cauthtest.CreateNewUserSession(t, server)
+
+	for range 2 {
+		resp := postJSON(t, server.URL+"/api/auth/account/delete", `{"password": "wrong-pass"}`, session)
+		assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	}
+
+	resp := postJSON(t, server.URL+"/api/auth/account/delete", `{"password": "test-pass"}`, session)
+	assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+}
+
+func TestRouter_VerifyPhone_TooManyAttempts(t *testing.T) {
+	t.Parallel()
+
+	var (
+		smsSender  = cauthtest.NewSMSSender()
+		codeRegexp = regexp.MustCompile(`\d+`)
+	)
+
+	server := httptest.NewServer(cauthtest.NewHandlerWithParams(t, cauthtest.HandlerParams{
+		Config: `
+[cauth]
+login_free_attempts = 10
+verification_code_max_attempts = 2
+`,
+		SMSSender: smsSender,
+	}))
+	defer server.Close()
+
+	resp := postJSON(t, server.URL+"/api/auth/signup", `{"phone": "+14155550123"}`, nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	code := codeRegexp.FindString(smsSender.Last().Body)
+
+	for range 2 {
+		resp = postJSON(t, server.URL+"/api/auth/verify-phone",
+			`{"phone": "+14155550123", "verification_code": "wrong"}`, nil)
+		assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	}
+
+	// The code is invalidated after too many wrong guesses
+	resp = postJSON(t, server.URL+"/api/auth/verify-phone",
+		`{"phone": "+14155550123", "verification_code": "`+code+`"}`, nil)
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	// A new code can be used
+	resp = postJSON(t, server.URL+"/api/auth/signup", `{"phone": "+14155550123"}`, nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	code = codeRegexp.FindString(smsSender.Last().Body)
+
+	resp = postJSON(t, server.URL+"/api/auth/verify-phone",
+		`{"phone": "+14155550123", "verification_code": "`+code+`"}`, nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+}
+
+func TestRedisAttemptStore(t *testing.T) {
+	t.Parallel()
+
+	addr := os.Getenv(cauthtest.EnvRedisAddr)
+	if addr == "" {
+		t.Skip(cauthtest.EnvRedisAddr + " is not set")
+	}
+
+	var (
+		ctx    = context.Background()
+		client = redis.NewClient(&redis.Options{Addr: addr})
+		store  = cauth.NewRedisAttemptStore(client)
+		key    = "test:" + uuid.New().String()
+	)
+
+	t.Cleanup(func() { _ = client.Close() })
+
+	counter, err := store.Get(ctx, key)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, counter.Count)
+
+	for i := 1; i <= 2; i++ {
+		counter, err = store.Increment(ctx, key, time.Minute)
+		assert.NoError(t, err)
+		assert.Equal(t, i, counter.Count)
+	}
+
+	counter, err = store.Get(ctx, key)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, counter.Count)
+	assert.WithinDuration(t, time.Now(), counter.LastFailedAt, time.Minute)
+
+	assert.NoError(t, store.Reset(ctx, key))
+
+	counter, err = store.Get(ctx, key)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, counter.Count)
+
+	// Counters expire ttl after the last failed attempt
+	_, err = store.Increment(ctx, key, 10*time.Millisecond)
+	assert.NoError(t, err)
+
+	time.Sleep(50 * time.Millisecond)
+
+	counter, err = store.Get(ctx, key)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, counter.Count)
+}
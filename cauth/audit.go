@@ -0,0 +1,204 @@
+package cauth
+
+import (
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/gocopper/copper/cerrors"
+	"github.com/gocopper/copper/chttp"
+	"github.com/gocopper/copper/csql"
+	"github.com/google/uuid"
+)
+
+// Audit event types recorded by Svc.
+const (
+	AuditEventSignup                    = "signup"
+	AuditEventLogin                     = "login"
+	AuditEventLoginFailed               = "login_failed"
+	AuditEventLogout                    = "logout"
+	AuditEventPasswordChanged           = "password_changed"
+	AuditEventPasswordReset             = "password_reset"
+	AuditEventEmailVerified             = "email_verified"
+	AuditEventPhoneVerified             = "phone_verified"
+	AuditEventUsernameChanged           = "username_changed"
+	AuditEventImpersonationStarted      = "impersonation_started"
+	AuditEventImpersonationStopped      = "impersonation_stopped"
+	AuditEventSessionRevoked            = "session_revoked"
+	AuditEventOtherSessionsRevoked      = "other_sessions_revoked"
+	AuditEventTOTPEnabled               = "totp_enabled"
+	AuditEventTOTPDisabled              = "totp_disabled"
+	AuditEventBackupCodesRegenerated    = "backup_codes_regenerated"
+	AuditEventWebAuthnCredentialAdded   = "webauthn_credential_added"
+	AuditEventWebAuthnCredentialDeleted = "webauthn_credential_deleted"
+	AuditEventAPIKeyCreated             = "api_key_created"
+	AuditEventAPIKeyRevoked             = "api_key_revoked"
+	AuditEventRefreshTokenReused        = "refresh_token_reused"
+	AuditEventRoleCreated               = "role_created"
+	AuditEventRoleDeleted               = "role_deleted"
+	AuditEventPermissionGranted         = "permission_granted"
+	AuditEventPermissionRevoked         = "permission_revoked"
+	AuditEventRoleAssigned              = "role_assigned"
+	AuditEventRoleUnassigned            = "role_unassigned"
+	AuditEventOrganizationCreated       = "organization_created"
+	AuditEventOrganizationSwitched      = "organization_switched"
+	AuditEventOrganizationMemberAdded   = "organization_member_added"
+	AuditEventOrganizationMemberRemoved = "organization_member_removed"
+	AuditEventInvitationCreated         = "invitation_created"
+	AuditEventInvitationResent          = "invitation_resent"
+	AuditEventInvitationRevoked         = "invitation_revoked"
+	AuditEventInvitationAccepted        = "invitation_accepted"
+	AuditEventEmailChangeRequested      = "email_change_requested"
+	AuditEventEmailChanged              = "email_changed"
+	AuditEventUserDeleted               = "user_deleted"
+	AuditEventUserRestored              = "user_restored"
+	AuditEventUserPurged                = "user_purged"
+)
+
+// Login methods recorded in the metadata of login events.
+const (
+	loginMethodPassword         = "password"
+	loginMethodVerificationCode = "verification_code"
+	loginMethodTwoFactor        = "two_factor"
+	loginMethodOAuth            = "oauth"
+	loginMethodMagicLink        = "magic_link"
+	loginMethodWebAuthn         = "webauthn"
+	loginMethodInvitation       = "invitation"
+)
+
+const (
+	auditEventsDefaultLimit = 50
+	auditEventsMaxLimit     = 500
+)
+
+// AuditMetadata holds additional details of an audit event such as the login method. It is stored as JSON.
+type AuditMetadata map[string]string
+
+// Value implements driver.Valuer.
+func (m AuditMetadata) Value() (driver.Value, error) {
+	if m == nil {
+		return "{}", nil
+	}
+
+	j, err := json.Marshal(m)
+	if err != nil {
+		return nil, err
+	}
+
+	return string(j), nil
+}
+
+// Scan implements sql.Scanner.
+func (m *AuditMetadata) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case string:
+		return json.Unmarshal([]byte(v), m)
+	case []byte:
+		return json.Unmarshal(v, m)
+	case nil:
+		*m = nil
+		return nil
+	default:
+		return errors.New("unsupported audit metadata type")
+	}
+}
+
+// ListAuditEventsParams hold the params used to filter and paginate audit events. Empty filters match all events.
+// Limit defaults to 50 and cannot be more than 500.
+type ListAuditEventsParams struct {
+	UserUUID string
+	Type     string
+	Limit    int
+	Offset   int
+}
+
+// ListAuditEvents returns the audit events that match the given filters, most recent first.
+func (s *Svc) ListAuditEvents(ctx context.Context, p ListAuditEventsParams) ([]AuditEvent, error) {
+	limit := p.Limit
+	if limit <= 0 {
+		limit = auditEventsDefaultLimit
+	}
+
+	events, err := s.queries.ListAuditEvents(ctx, p.UserUUID, p.Type, min(limit, auditEventsMaxLimit), max(p.Offset, 0))
+	if err != nil {
+		return nil, cerrors.New(err, "failed to list audit events", map[string]interface{}{
+			"userUUID": p.UserUUID,
+			"type":     p.Type,
+		})
+	}
+
+	return events, nil
+}
+
+// recordAuditEvent saves the given event along with the client's IP, user agent and the request id. The event is
+// saved in the request's database transaction so that it is only kept if the change it describes is kept. Events with
+// a pubsub topic are published once the transaction commits.
+func (s *Svc) recordAuditEvent(ctx context.Context, event *AuditEvent) error {
+	clientInfo := clientInfoFromContext(ctx)
+
+	event.UUID = uuid.New().String()
+	event.CreatedAt = time.Now()
+	event.IP = clientInfo.IP
+	event.UserAgent = clientInfo.UserAgent
+	event.RequestID = chttp.GetRequestID(ctx)
+
+	err := s.queries.InsertAuditEvent(ctx, event)
+	if err != nil {
+		return cerrors.New(err, "failed to insert audit event", map[string]interface{}{
+			"type":     event.Type,
+			"userUUID": event.UserUUID,
+		})
+	}
+
+	return s.publishAuditEvent(ctx, event)
+}
+
+// recordLogin records a login event if the given result holds a new session. Logins that are waiting on a
+// two-factor challenge are recorded once the challenge is completed.
+func (s *Svc) recordLogin(ctx context.Context, method string, sessionResult *SessionResult) error {
+	if sessionResult.Session == nil {
+		return nil
+	}
+
+	return s.recordAuditEvent(ctx, &AuditEvent{
+		Type:        AuditEventLogin,
+		UserUUID:    sessionResult.User.UUID,
+		SessionUUID: sessionResult.Session.UUID,
+		Metadata:    AuditMetadata{"method": method},
+	})
+}
+
+// recordLoginFailure records a failed login if err means that the credentials were rejected. The event is saved
+// outside the request's database transaction since the transaction is rolled back when the login fails. It returns
+// err so that callers can record the failure and return in one statement, unless the event could not be saved.
+func (s *Svc) recordLoginFailure(ctx context.Context, err error, userUUID, method, identifier string) error {
+	var reason error
+
+	for _, loginErr := range []error{ErrInvalidCredentials, ErrVerificationCodeExpired, ErrTooManyAttempts} {
+		if errors.Is(err, loginErr) {
+			reason = loginErr
+			break
+		}
+	}
+
+	if reason == nil {
+		return err
+	}
+
+	recordErr := s.recordAuditEvent(csql.CtxWithoutTx(ctx), &AuditEvent{
+		Type:     AuditEventLoginFailed,
+		UserUUID: userUUID,
+		Metadata: AuditMetadata{
+			"method":     method,
+			"identifier": identifier,
+			"reason":     reason.Error(),
+		},
+	})
+	if recordErr != nil {
+		return recordErr
+	}
+
+	return err
+}
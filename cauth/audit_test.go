@@ -0,0 +1,85 @@
+package cauth_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gocopper/pkg/cauth"
+	"github.com/gocopper/pkg/cauth/cauthtest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRouter_AuditEvents(t *testing.T) {
+	t.Parallel()
+
+	type auditEvents struct {
+		Events []cauth.AuditEvent `json:"events"`
+	}
+
+	server := httptest.NewServer(cauthtest.NewHandler(t))
+	defer server.Close()
+
+	listEvents := func(query string, session *cauth.SessionResult) auditEvents {
+		var events auditEvents
+
+		req, err := http.NewRequestWithContext(context.Background(), http.MethodGet,
+			server.URL+"/api/auth/audit-events"+query, nil)
+		assert.NoError(t, err)
+
+		req.SetBasicAuth(session.Session.UUID, session.PlainSessionToken)
+
+		resp, err := http.DefaultClient.Do(req)
+		assert.NoError(t, err)
+		defer func() { _ = resp.Body.Close() }()
+
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.NoError(t, json.NewDecoder(resp.Body).Decode(&events))
+
+		return events
+	}
+
+	signup := cauthtest.CreateNewUserSession(t, server)
+
+	// Failed logins are recorded even though the request's transaction is rolled back
+	resp := postJSON(t, server.URL+"/api/auth/login", `{"username": "test-user", "password": "wrong"}`, nil)
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	var login cauth.SessionResult
+
+	resp = postJSON(t, server.URL+"/api/auth/login", `{"username": "test-user", "password": "test-pass"}`, nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&login))
+
+	resp = postJSON(t, server.URL+"/api/auth/logout", `{}`, signup)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	events := listEvents("", &login)
+	if assert.Len(t, events.Events, 4) {
+		assert.Equal(t, cauth.AuditEventLogout, events.Events[0].Type)
+		assert.Equal(t, cauth.AuditEventLogin, events.Events[1].Type)
+		assert.Equal(t, cauth.AuditEventLoginFailed, events.Events[2].Type)
+		assert.Equal(t, cauth.AuditEventSignup, events.Events[3].Type)
+
+		assert.Equal(t, login.Session.UUID, events.Events[1].SessionUUID)
+		assert.Equal(t, "password", events.Events[1].Metadata["method"])
+		assert.Equal(t, "test-user", events.Events[2].Metadata["identifier"])
+
+		for _, event := range events.Events {
+			assert.Equal(t, login.User.UUID, event.UserUUID)
+			assert.Equal(t, "127.0.0.1", event.IP)
+			assert.Equal(t, "Go-http-client/1.1", event.UserAgent)
+		}
+	}
+
+	events = listEvents("?type="+cauth.AuditEventLogin, &login)
+	assert.Len(t, events.Events, 1)
+
+	events = listEvents("?limit=2&offset=1", &login)
+	if assert.Len(t, events.Events, 2) {
+		assert.Equal(t, cauth.AuditEventLogin, events.Events[0].Type)
+		assert.Equal(t, cauth.AuditEventLoginFailed, events.Events[1].Type)
+	}
+}
@@ -0,0 +1,51 @@
+package cauthtest
+
+import (
+	"context"
+	"sync"
+
+	"github.com/gocopper/pkg/cmailer"
+)
+
+// NewMailer creates a cmailer.Mailer that records all emails that are sent so that tests can read them.
+func NewMailer() *Mailer {
+	return &Mailer{}
+}
+
+// Mailer is a cmailer.Mailer that records all sends.
+type Mailer struct {
+	mu   sync.Mutex
+	sent []cmailer.SendParams
+}
+
+// Send implements cmailer.Mailer.
+func (m *Mailer) Send(_ context.Context, p cmailer.SendParams) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.sent = append(m.sent, p)
+
+	return nil
+}
+
+// Sent returns all emails that have been sent so far.
+func (m *Mailer) Sent() []cmailer.SendParams {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return append([]cmailer.SendParams(nil), m.sent...)
+}
+
+// Last returns the last email that was sent. It returns nil if no emails have been sent.
+func (m *Mailer) Last() *cmailer.SendParams {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if len(m.sent) == 0 {
+		return nil
+	}
+
+	last := m.sent[len(m.sent)-1]
+
+	return &last
+}
@@ -2,7 +2,6 @@ package cauthtest
 
 import (
 	"context"
-	"database/sql"
 	"encoding/json"
 	"io"
 	"net/http"
@@ -13,9 +12,11 @@ import (
 
 	"github.com/gocopper/copper/cconfig"
 	"github.com/gocopper/copper/cconfig/cconfigtest"
+	"github.com/gocopper/copper/clifecycle"
 	"github.com/gocopper/copper/csql"
 
 	"github.com/gocopper/pkg/cmailer"
+	"github.com/gocopper/pkg/cpubsub"
 
 	"github.com/gocopper/copper/chttp"
 
@@ -23,7 +24,6 @@ import (
 
 	"github.com/gocopper/copper/clogger"
 	"github.com/gocopper/pkg/cauth"
-	_ "github.com/mattn/go-sqlite3"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -31,20 +31,61 @@ import (
 func NewHandler(t *testing.T) http.Handler {
 	t.Helper()
 
-	const (
-		dbDialect = "sqlite3"
-		dbDSN     = ":memory:"
-	)
+	return NewHandlerWithParams(t, HandlerParams{})
+}
+
+// HandlerParams hold the optional params used by NewHandlerWithParams.
+type HandlerParams struct {
+	// Config is the TOML used to load the auth config. The [cauth] table must be used for the config to be
+	// picked up. A test session_token_key is used if it is not set.
+	Config string
+
+	// Mailer is used to send emails. If nil, emails are logged.
+	Mailer cmailer.Mailer
+
+	// SMSSender is used to send text messages. If nil, text messages are logged.
+	SMSSender cauth.SMSSender
+
+	// PubSub receives the events published by the Svc. If nil, events are not published.
+	PubSub cpubsub.PubSub
+
+	// DBPath is the path of the SQLite database file. If empty, a new database is created in a temporary directory.
+	// Handlers created with the same path share their data. With Postgres and MySQL, the path only identifies the
+	// schema or database that is shared.
+	DBPath string
+
+	// Dialect is the csql dialect of the database, i.e. "sqlite3", "postgres" or "mysql". If empty, the dialect and
+	// DSN are read from the EnvDialect and EnvDSN environment variables, and SQLite is used if they are not set.
+	Dialect string
+
+	// DSN is used to connect to the Postgres or MySQL server. Each handler creates its own schema or database in it.
+	DSN string
+}
+
+// NewHandlerWithParams is like NewHandler but allows the config and dependencies to be customized.
+func NewHandlerWithParams(t *testing.T, p HandlerParams) http.Handler {
+	t.Helper()
+
+	handler, _ := NewHandlerAndSvc(t, p)
+
+	return handler
+}
+
+// NewHandlerAndSvc is like NewHandlerWithParams but also returns the Svc used by the handler so that tests can set up
+// data that cannot be created through the auth router, such as roles. Svc methods that are called outside of a
+// request are not run in a database transaction.
+func NewHandlerAndSvc(t *testing.T, p HandlerParams) (http.Handler, *cauth.Svc) {
+	t.Helper()
+
+	db, dbDialect, dbDSN := OpenDB(t, p)
 
 	var (
-		logger = clogger.New()
+		logger = clogger.NewNoop()
+		lc     = clifecycle.New(logger)
 		jsonRW = chttptest.NewJSONReaderWriter(t)
 		htmlRW = chttptest.NewHTMLReaderWriter(t)
 	)
 
-	db, err := sql.Open(dbDialect, dbDSN)
-	assert.NoError(t, err)
-
 	csqlConfig := csql.Config{
 		Dialect: dbDialect,
 		DSN:     dbDSN,
@@ -53,15 +94,18 @@ func NewHandler(t *testing.T) http.Handler {
 		},
 	}
 
+	migrations, err := cauth.MigrationsForDialect(dbDialect)
+	assert.NoError(t, err)
+
 	err = csql.NewMigrator(csql.NewMigratorParams{
 		DB:         db,
-		Migrations: csql.Migrations(cauth.SQLiteMigrations),
+		Migrations: migrations,
 		Config:     csqlConfig,
 		Logger:     logger,
 	}).Run()
 	assert.NoError(t, err)
 
-	configDir := cconfigtest.SetupDirWithConfigs(t, map[string]string{"test.toml": ""})
+	configDir := cconfigtest.SetupDirWithConfigs(t, map[string]string{"test.toml": p.Config})
 
 	configLoader, err := cconfig.New(cconfig.Path(path.Join(configDir, "test.toml")), "")
 	assert.NoError(t, err)
@@ -69,31 +113,62 @@ func NewHandler(t *testing.T) http.Handler {
 	config, err := cauth.LoadConfig(configLoader)
 	assert.NoError(t, err)
 
+	if config.SessionTokenKey == "" {
+		config.SessionTokenKey = "test-session-token-key"
+	}
+
+	querier := csql.NewQuerier(db, lc, csqlConfig, logger)
+
+	mailer := p.Mailer
+	if mailer == nil {
+		mailer = cmailer.NewLogMailer(logger)
+	}
+
+	smsSender := p.SMSSender
+	if smsSender == nil {
+		smsSender = cauth.NewLogSMSSender(logger)
+	}
+
+	queries := cauth.NewQueries(querier, csqlConfig)
+
 	svc, err := cauth.NewSvc(
-		cauth.NewQueries(csql.NewQuerier(db, csqlConfig, logger)),
-		cmailer.NewLogMailer(logger),
+		queries,
+		mailer,
+		smsSender,
+		cauth.NewSQLAttemptStore(queries),
+		p.PubSub,
 		config,
 	)
 	assert.NoError(t, err)
 
 	verifySessionMW := cauth.NewVerifySessionMiddleware(svc, htmlRW, logger)
-	dbTxMW := csql.NewTxMiddleware(db, csqlConfig, logger)
+	dbTxMW := csql.NewTxMiddleware(db, querier, csqlConfig, logger)
 
 	router := cauth.NewRouter(cauth.NewRouterParams{
 		Auth:      svc,
 		JSON:      jsonRW,
 		HTML:      htmlRW,
 		SessionMW: verifySessionMW,
+		CSRFMW:    cauth.NewCSRFMiddleware(jsonRW),
 		Logger:    logger,
 	})
 
+	testRouter := &testRouter{
+		sessionMW:     verifySessionMW,
+		apiKeyMW:      cauth.NewVerifyAPIKeyMiddleware(svc, htmlRW, logger),
+		accessTokenMW: cauth.NewVerifyAccessTokenMiddleware(svc, htmlRW, logger),
+		orgMW:         cauth.NewVerifyOrganizationMiddleware(svc, htmlRW, logger),
+		json:          jsonRW,
+		html:          htmlRW,
+	}
+
 	handler := chttp.NewHandler(chttp.NewHandlerParams{
-		Routers:           []chttp.Router{router},
+		Routers:           []chttp.Router{router, testRouter},
 		GlobalMiddlewares: []chttp.Middleware{dbTxMW},
 		Logger:            logger,
 	})
 
-	return handler
+	return handler, svc
 }
 
 // CreateNewUserSession creates a new user using the given router and returns the session created by it.
@@ -128,3 +203,79 @@ func CreateNewUserSession(t *testing.T, server *httptest.Server) *cauth.SessionR
 
 	return &session
 }
+
+// testRouter serves routes that exercise the cauth middlewares:
+//   - GET /api/test/api-key is behind the VerifyAPIKeyMiddleware and responds with the uuid of the key's user and
+//     the key's scopes.
+//   - GET /api/test/access-token is behind the VerifyAccessTokenMiddleware and responds with the current session
+//     and user.
+//   - GET /api/test/permission requires a session with the "test.read" permission and responds with whether the
+//     user also has the "test.write" permission.
+//   - GET /api/test/organization is behind the VerifyOrganizationMiddleware and responds with the active
+//     organization, the user's role in it and whether they have the "test.write" permission.
+type testRouter struct {
+	sessionMW     *cauth.VerifySessionMiddleware
+	apiKeyMW      *cauth.VerifyAPIKeyMiddleware
+	accessTokenMW *cauth.VerifyAccessTokenMiddleware
+	orgMW         *cauth.VerifyOrganizationMiddleware
+	json          *chttp.JSONReaderWriter
+	html          *chttp.HTMLReaderWriter
+}
+
+func (ro *testRouter) Routes() []chttp.Route {
+	return []chttp.Route{
+		{
+			Middlewares: []chttp.Middleware{ro.apiKeyMW},
+			Path:        "/api/test/api-key",
+			Methods:     []string{http.MethodGet},
+			Handler: func(w http.ResponseWriter, r *http.Request) {
+				ro.json.WriteJSON(w, chttp.WriteJSONParams{
+					Data: map[string]interface{}{
+						"user_uuid": cauth.GetCurrentUser(r.Context()).UUID,
+						"scopes":    cauth.GetCurrentAPIKey(r.Context()).Scopes,
+						"can_write": cauth.HasAPIKeyScope(r.Context(), "write"),
+					},
+				})
+			},
+		},
+		{
+			Middlewares: []chttp.Middleware{ro.accessTokenMW},
+			Path:        "/api/test/access-token",
+			Methods:     []string{http.MethodGet},
+			Handler: func(w http.ResponseWriter, r *http.Request) {
+				ro.json.WriteJSON(w, chttp.WriteJSONParams{
+					Data: map[string]interface{}{
+						"session": cauth.GetCurrentSession(r.Context()),
+						"user":    cauth.GetCurrentUser(r.Context()),
+					},
+				})
+			},
+		},
+		{
+			Middlewares: []chttp.Middleware{ro.sessionMW, cauth.RequirePermission(ro.html, "test.read")},
+			Path:        "/api/test/permission",
+			Methods:     []string{http.MethodGet},
+			Handler: func(w http.ResponseWriter, r *http.Request) {
+				ro.json.WriteJSON(w, chttp.WriteJSONParams{
+					Data: map[string]interface{}{
+						"can_write": cauth.Can(r.Context(), "test.write"),
+					},
+				})
+			},
+		},
+		{
+			Middlewares: []chttp.Middleware{ro.sessionMW, ro.orgMW},
+			Path:        "/api/test/organization",
+			Methods:     []string{http.MethodGet},
+			Handler: func(w http.ResponseWriter, r *http.Request) {
+				ro.json.WriteJSON(w, chttp.WriteJSONParams{
+					Data: map[string]interface{}{
+						"organization_uuid": cauth.GetCurrentOrganization(r.Context()).UUID,
+						"role":              cauth.GetCurrentMembership(r.Context()).Role,
+						"can_write":         cauth.Can(r.Context(), "test.write"),
+					},
+				})
+			},
+		},
+	}
+}
@@ -0,0 +1,51 @@
+package cauthtest
+
+import (
+	"context"
+	"sync"
+
+	"github.com/gocopper/pkg/cauth"
+)
+
+// NewSMSSender creates a cauth.SMSSender that records all text messages that are sent so that tests can read them.
+func NewSMSSender() *SMSSender {
+	return &SMSSender{}
+}
+
+// SMSSender is a cauth.SMSSender that records all sends.
+type SMSSender struct {
+	mu   sync.Mutex
+	sent []cauth.SendSMSParams
+}
+
+// Send implements cauth.SMSSender.
+func (s *SMSSender) Send(_ context.Context, p cauth.SendSMSParams) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.sent = append(s.sent, p)
+
+	return nil
+}
+
+// Sent returns all text messages that have been sent so far.
+func (s *SMSSender) Sent() []cauth.SendSMSParams {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]cauth.SendSMSParams(nil), s.sent...)
+}
+
+// Last returns the last text message that was sent. It returns nil if no messages have been sent.
+func (s *SMSSender) Last() *cauth.SendSMSParams {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.sent) == 0 {
+		return nil
+	}
+
+	last := s.sent[len(s.sent)-1]
+
+	return &last
+}
@@ -0,0 +1,136 @@
+package cauth
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// cborMaxDepth limits nesting while decoding so that malicious payloads cannot exhaust the stack.
+const cborMaxDepth = 16
+
+var errCBORMalformed = errors.New("malformed cbor")
+
+// decodeCBOR decodes the first CBOR data item in data and returns it along with the number of bytes consumed. It
+// supports the subset of CBOR used by WebAuthn: integers, byte and text strings, arrays, maps, and simple values.
+// Integers are returned as int64, maps as map[interface{}]interface{} and arrays as []interface{}.
+func decodeCBOR(data []byte) (interface{}, int, error) {
+	return decodeCBORItem(data, 0)
+}
+
+func decodeCBORItem(data []byte, depth int) (interface{}, int, error) {
+	if depth > cborMaxDepth || len(data) < 1 {
+		return nil, 0, errCBORMalformed
+	}
+
+	major := data[0] >> 5
+	info := data[0] & 0x1f
+
+	if major == 7 {
+		return decodeCBORSimple(data, info)
+	}
+
+	arg, n, err := decodeCBORArg(data, info)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	switch major {
+	case 0:
+		if arg > 1<<63-1 {
+			return nil, 0, errCBORMalformed
+		}
+		return int64(arg), n, nil
+	case 1:
+		if arg > 1<<63-1 {
+			return nil, 0, errCBORMalformed
+		}
+		return -1 - int64(arg), n, nil
+	case 2, 3:
+		if uint64(len(data)-n) < arg {
+			return nil, 0, errCBORMalformed
+		}
+
+		end := n + int(arg)
+		if major == 2 {
+			return append([]byte(nil), data[n:end]...), end, nil
+		}
+		return string(data[n:end]), end, nil
+	case 4:
+		if uint64(len(data)-n) < arg {
+			return nil, 0, errCBORMalformed
+		}
+
+		arr := make([]interface{}, 0, arg)
+		for i := uint64(0); i < arg; i++ {
+			v, m, err := decodeCBORItem(data[n:], depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+			arr = append(arr, v)
+			n += m
+		}
+		return arr, n, nil
+	case 5:
+		if uint64(len(data)-n) < arg {
+			return nil, 0, errCBORMalformed
+		}
+
+		m := make(map[interface{}]interface{}, arg)
+		for i := uint64(0); i < arg; i++ {
+			k, kn, err := decodeCBORItem(data[n:], depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+			n += kn
+
+			switch k.(type) {
+			case int64, string:
+			default:
+				return nil, 0, errCBORMalformed
+			}
+
+			v, vn, err := decodeCBORItem(data[n:], depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+			n += vn
+
+			m[k] = v
+		}
+		return m, n, nil
+	default:
+		// Tags (major type 6) are not used by WebAuthn
+		return nil, 0, errCBORMalformed
+	}
+}
+
+func decodeCBORArg(data []byte, info byte) (uint64, int, error) {
+	switch {
+	case info < 24:
+		return uint64(info), 1, nil
+	case info == 24 && len(data) >= 2:
+		return uint64(data[1]), 2, nil
+	case info == 25 && len(data) >= 3:
+		return uint64(binary.BigEndian.Uint16(data[1:3])), 3, nil
+	case info == 26 && len(data) >= 5:
+		return uint64(binary.BigEndian.Uint32(data[1:5])), 5, nil
+	case info == 27 && len(data) >= 9:
+		return binary.BigEndian.Uint64(data[1:9]), 9, nil
+	default:
+		// Indefinite lengths are not allowed in CTAP2 canonical CBOR
+		return 0, 0, errCBORMalformed
+	}
+}
+
+func decodeCBORSimple(data []byte, info byte) (interface{}, int, error) {
+	switch info {
+	case 20:
+		return false, 1, nil
+	case 21:
+		return true, 1, nil
+	case 22, 23:
+		return nil, 1, nil
+	default:
+		return nil, 0, errCBORMalformed
+	}
+}
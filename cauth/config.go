@@ -1,8 +1,11 @@
 package cauth
 
 import (
+	"time"
+
 	"github.com/gocopper/copper/cconfig"
 	"github.com/gocopper/copper/cerrors"
+	"golang.org/x/crypto/bcrypt"
 )
 
 // Config configures the cauth module
@@ -11,6 +14,153 @@ type Config struct {
 	VerificationEmailSubject  string `toml:"verification_email_subject"`
 	VerificationEmailFrom     string `toml:"verification_email_from"`
 	VerificationEmailBodyHTML string `toml:"verification_email_body_html"`
+	VerificationSMSBody       string `toml:"verification_sms_body"`
+	TOTPIssuer                string `toml:"totp_issuer"`
+
+	// ReservedUsernames cannot be used as usernames. They are compared case-insensitively.
+	ReservedUsernames      []string `toml:"reserved_usernames"`
+	UsernameReuseDelayDays int      `toml:"username_reuse_delay_days"`
+
+	WebAuthnRPID    string   `toml:"webauthn_rp_id"`
+	WebAuthnRPName  string   `toml:"webauthn_rp_name"`
+	WebAuthnOrigins []string `toml:"webauthn_origins"`
+
+	// SessionAbsoluteTimeout is the maximum lifetime of a session, regardless of activity. SessionIdleTimeout ends
+	// sessions that have not been used for the given duration. Sessions created with "remember me" use
+	// SessionRememberMeIdleTimeout instead. Active sessions are renewed at most once per SessionRenewalInterval.
+	SessionAbsoluteTimeout       time.Duration `toml:"session_absolute_timeout"`
+	SessionIdleTimeout           time.Duration `toml:"session_idle_timeout"`
+	SessionRememberMeIdleTimeout time.Duration `toml:"session_remember_me_idle_timeout"`
+	SessionRenewalInterval       time.Duration `toml:"session_renewal_interval"`
+
+	// SessionTokenKey is the secret used to compute the HMAC-SHA256 digests of the session and refresh tokens that
+	// are stored in the database. It is required and should be a long random string, e.g. from
+	// `openssl rand -base64 32`. Changing it invalidates all sessions and refresh tokens. Validated sessions are cached in memory for SessionCacheTTL, up
+	// to SessionCacheSize sessions, so that authenticated requests don't each query the database. The cache is not
+	// shared between instances, so a session revoked on one instance may still be accepted by others until the TTL
+	// passes. It is disabled if either is zero.
+	SessionTokenKey  string        `toml:"session_token_key"`
+	SessionCacheSize int           `toml:"session_cache_size"`
+	SessionCacheTTL  time.Duration `toml:"session_cache_ttl"`
+
+	// AccessTokenSigningKeys enables signed access tokens (JWTs) for clients that need to authenticate without a
+	// database lookup, such as mobile apps and edge services. Each key is a base64-encoded 32-byte Ed25519 seed, e.g.
+	// from `openssl rand -base64 32`. The first key signs new tokens and all keys are published in the JWKS so that
+	// they can verify tokens. To rotate keys, append the new key, move it to the front once clients have refreshed
+	// their JWKS, and remove the old key once AccessTokenTTL has passed. Access tokens expire after AccessTokenTTL and
+	// hold AccessTokenIssuer in their "iss" claim.
+	AccessTokenSigningKeys []string      `toml:"access_token_signing_keys"`
+	AccessTokenTTL         time.Duration `toml:"access_token_ttl"`
+	AccessTokenIssuer      string        `toml:"access_token_issuer"`
+
+	// Failed logins and verification codes are throttled per account and per IP. After LoginFreeAttempts failures, an
+	// account must wait LoginBackoffBase before trying again, doubling with every further failure. Accounts are locked
+	// for LoginLockoutDuration after LoginMaxAttempts failures, and IPs after LoginIPMaxAttempts failures. A
+	// verification code is invalidated after VerificationCodeMaxAttempts wrong guesses. Magic link emails are
+	// throttled per email the same way, with every request counting as an attempt.
+	LoginFreeAttempts           int           `toml:"login_free_attempts"`
+	LoginMaxAttempts            int           `toml:"login_max_attempts"`
+	LoginIPMaxAttempts          int           `toml:"login_ip_max_attempts"`
+	LoginBackoffBase            time.Duration `toml:"login_backoff_base"`
+	LoginLockoutDuration        time.Duration `toml:"login_lockout_duration"`
+	VerificationCodeMaxAttempts int           `toml:"verification_code_max_attempts"`
+
+	// APIKeyPrefix is prepended to all API keys so that they can be recognized. If APIKeyScopes is set, API keys can
+	// only be granted the listed scopes.
+	APIKeyPrefix string   `toml:"api_key_prefix"`
+	APIKeyScopes []string `toml:"api_key_scopes"`
+
+	// OrganizationOwnerRole is the role given to users that create an organization. It must be the name of a role
+	// created with Svc.CreateRole, otherwise organizations cannot be created. The last member with this role cannot be
+	// removed from an organization.
+	OrganizationOwnerRole string `toml:"organization_owner_role"`
+
+	// Passwords must be between PasswordMinLength and PasswordMaxLength characters long. Regardless of
+	// PasswordMaxLength, passwords hashed with bcrypt cannot be longer than 72 bytes since bcrypt ignores the rest. The
+	// PasswordRequire* options require at least one character of each class. PasswordBreachedHashesFile is an
+	// optional path to a local copy of breached password SHA-1 hashes, such as HIBP's Pwned Passwords ranges merged
+	// into one file. It must have one "<hash>:<count>" line per hash, sorted by hash.
+	PasswordMinLength          int    `toml:"password_min_length"`
+	PasswordMaxLength          int    `toml:"password_max_length"`
+	PasswordRequireLowercase   bool   `toml:"password_require_lowercase"`
+	PasswordRequireUppercase   bool   `toml:"password_require_uppercase"`
+	PasswordRequireDigit       bool   `toml:"password_require_digit"`
+	PasswordRequireSymbol      bool   `toml:"password_require_symbol"`
+	PasswordBreachedHashesFile string `toml:"password_breached_hashes_file"`
+
+	// PasswordHashAlgorithm is used to hash new passwords and must be "bcrypt" or "argon2id". BcryptCost and the
+	// Argon2id options set the cost of each algorithm, with Argon2idMemory in KiB. Argon2idIterations and
+	// Argon2idParallelism must be at least 1, and Argon2idMemory must be between 8 KiB per lane and 1 GiB. Password
+	// hashes created with another algorithm or cost are upgraded when users login with their password.
+	PasswordHashAlgorithm string `toml:"password_hash_algorithm"`
+	BcryptCost            int    `toml:"bcrypt_cost"`
+	Argon2idMemory        uint32 `toml:"argon2id_memory"`
+	Argon2idIterations    uint32 `toml:"argon2id_iterations"`
+	Argon2idParallelism   uint8  `toml:"argon2id_parallelism"`
+
+	// ClientIPHeader is the request header that holds the client's IP when the app runs behind a proxy (e.g.
+	// X-Forwarded-For). If empty, the IP is read from the connection. Each proxy appends an IP to the header, so the
+	// client can set any entries to the left of them. ClientIPTrustedHops is the number of proxies in front of the app,
+	// and the IP is read from that many entries from the right. It must match the deployment so that clients cannot
+	// spoof their IP to get around LoginIPMaxAttempts or to fake the IPs in sessions and audit events.
+	ClientIPHeader      string `toml:"client_ip_header"`
+	ClientIPTrustedHops int    `toml:"client_ip_trusted_hops"`
+
+	// TwoFactorRedirectURL is the page that users with two-factor authentication enabled are redirected to after
+	// logging in with a link (e.g. OAuth or magic link). The challenge is passed in the query string.
+	TwoFactorRedirectURL string `toml:"two_factor_redirect_url"`
+
+	OAuthRedirectBaseURL    string                         `toml:"oauth_redirect_base_url"`
+	OAuthSuccessRedirectURL string                         `toml:"oauth_success_redirect_url"`
+	OAuthProviders          map[string]OAuthProviderConfig `toml:"oauth_providers"`
+
+	// MagicLinkURLTemplate is used to create the link sent in magic link emails. It should point to the
+	// /api/auth/magic-link/callback route and include the {{.Token}} query param. Magic links are disabled until
+	// MagicLinkSigningKey is set.
+	MagicLinkURLTemplate        string `toml:"magic_link_url_template"`
+	MagicLinkSigningKey         string `toml:"magic_link_signing_key"`
+	MagicLinkEmailSubject       string `toml:"magic_link_email_subject"`
+	MagicLinkEmailBodyHTML      string `toml:"magic_link_email_body_html"`
+	MagicLinkSuccessRedirectURL string `toml:"magic_link_success_redirect_url"`
+
+	// InvitationURLTemplate is used to create the link sent in invitation emails. It should point to a page that
+	// submits the {{.Token}} query param to the /api/auth/invitations/accept route. The email body can also use
+	// {{.OrganizationName}}, which is empty for invitations without an organization.
+	InvitationURLTemplate   string        `toml:"invitation_url_template"`
+	InvitationEmailSubject  string        `toml:"invitation_email_subject"`
+	InvitationEmailBodyHTML string        `toml:"invitation_email_body_html"`
+	InvitationTTL           time.Duration `toml:"invitation_ttl"`
+
+	// EmailChangeEmailBodyHTML is sent to the new email when a user changes their email and must include the
+	// {{.VerificationCode}}. EmailChangeNoticeBodyHTML is sent to the current email so that users notice changes they
+	// didn't request. It can include the {{.NewEmail}}.
+	EmailChangeEmailSubject   string `toml:"email_change_email_subject"`
+	EmailChangeEmailBodyHTML  string `toml:"email_change_email_body_html"`
+	EmailChangeNoticeSubject  string `toml:"email_change_notice_subject"`
+	EmailChangeNoticeBodyHTML string `toml:"email_change_notice_body_html"`
+
+	// AccountDeletionGracePeriod is how long a deleted user's data is kept before it is purged by PurgeDeletedUsers.
+	// Users are deleted right away if it is zero.
+	AccountDeletionGracePeriod time.Duration `toml:"account_deletion_grace_period"`
+
+	// The Janitor purges expired data every JanitorInterval, JanitorBatchSize rows at a time. It is disabled if the
+	// interval is zero. Sessions are kept for SessionRetention after they expire or are logged out of. Users that
+	// signed up without a password and never verified their email or phone are deleted once
+	// UnverifiedUserRetention has passed since they signed up. They are kept if it is zero.
+	JanitorInterval         time.Duration `toml:"janitor_interval"`
+	JanitorBatchSize        int           `toml:"janitor_batch_size"`
+	SessionRetention        time.Duration `toml:"session_retention"`
+	UnverifiedUserRetention time.Duration `toml:"unverified_user_retention"`
+}
+
+// OAuthProviderConfig configures an OAuth provider that users can login with. Type must be one of "oidc",
+// "google" or "github". IssuerURL is only used by the "oidc" type.
+type OAuthProviderConfig struct {
+	Type         string   `toml:"type"`
+	ClientID     string   `toml:"client_id"`
+	ClientSecret string   `toml:"client_secret"`
+	IssuerURL    string   `toml:"issuer_url"`
+	Scopes       []string `toml:"scopes"`
 }
 
 // LoadConfig loads the config for cauth module
@@ -20,6 +170,58 @@ func LoadConfig(loader cconfig.Loader) (Config, error) {
 		VerificationEmailSubject:  "Your Verification Code",
 		VerificationEmailFrom:     "webmaster@example.com",
 		VerificationEmailBodyHTML: `Your verification code is <b>{{.VerificationCode}}</b>`,
+		VerificationSMSBody:       `Your verification code is {{.VerificationCode}}`,
+		ReservedUsernames: []string{
+			"admin", "administrator", "root", "system", "support", "help", "security", "api", "auth",
+			"login", "logout", "signup", "settings", "account", "me", "www", "mail", "null", "undefined",
+		},
+		UsernameReuseDelayDays:       30,
+		SessionAbsoluteTimeout:       30 * 24 * time.Hour,
+		SessionIdleTimeout:           24 * time.Hour,
+		SessionRememberMeIdleTimeout: 30 * 24 * time.Hour,
+		SessionRenewalInterval:       time.Minute,
+		SessionCacheTTL:              10 * time.Second,
+		AccessTokenTTL:               15 * time.Minute,
+		LoginFreeAttempts:            3,
+		LoginMaxAttempts:             10,
+		LoginIPMaxAttempts:           100,
+		LoginBackoffBase:             time.Second,
+		LoginLockoutDuration:         15 * time.Minute,
+		VerificationCodeMaxAttempts:  5,
+		PasswordMinLength:            8,
+		PasswordMaxLength:            72,
+		PasswordHashAlgorithm:        PasswordHashBcrypt,
+		BcryptCost:                   bcrypt.DefaultCost,
+		Argon2idMemory:               64 * 1024,
+		Argon2idIterations:           3,
+		Argon2idParallelism:          4,
+		APIKeyPrefix:                 "cak_",
+		OrganizationOwnerRole:        "owner",
+		TOTPIssuer:                   "Copper",
+		WebAuthnRPID:                 "localhost",
+		WebAuthnRPName:               "Copper",
+		WebAuthnOrigins:              []string{"http://localhost:7501"},
+		ClientIPTrustedHops:          1,
+		TwoFactorRedirectURL:         "/login/two-factor",
+		OAuthRedirectBaseURL:         "http://localhost:7501",
+		OAuthSuccessRedirectURL:      "/",
+		MagicLinkURLTemplate:         "http://localhost:7501/api/auth/magic-link/callback?token={{.Token}}",
+		MagicLinkEmailSubject:        "Your Login Link",
+		MagicLinkEmailBodyHTML:       `<a href="{{.MagicLink}}">Click here to login</a>`,
+		MagicLinkSuccessRedirectURL:  "/",
+		InvitationURLTemplate:        "http://localhost:7501/invitations/accept?token={{.Token}}",
+		InvitationEmailSubject:       "You're Invited",
+		InvitationEmailBodyHTML:      `<a href="{{.InvitationLink}}">Click here to accept the invitation</a>`,
+		InvitationTTL:                7 * 24 * time.Hour,
+		EmailChangeEmailSubject:      "Confirm Your New Email",
+		EmailChangeEmailBodyHTML:     `Your verification code is <b>{{.VerificationCode}}</b>`,
+		EmailChangeNoticeSubject:     "Your Email Is Being Changed",
+		EmailChangeNoticeBodyHTML: `A request was made to change the email of your account to <b>{{.NewEmail}}</b>. ` +
+			`If you didn't make this request, please secure your account.`,
+		AccountDeletionGracePeriod: 30 * 24 * time.Hour,
+		JanitorInterval:            time.Hour,
+		JanitorBatchSize:           defaultJanitorBatchSize,
+		SessionRetention:           7 * 24 * time.Hour,
 	}
 
 	err := loader.Load("cauth", &config)
@@ -2,17 +2,32 @@ package cauth
 
 import (
 	"net/http"
+	"time"
 )
 
+const oauthStateCookieName = "OAuthState"
+
 func GetLogoutHTTPCookies() []http.Cookie {
-	cookies := getHTTPCookies("", "")
-	for i := range cookies {
-		cookies[i].MaxAge = -1
+	return getHTTPCookies("", "", -1)
+}
+
+// getSessionHTTPCookies returns the cookies for the given session. Unless the session was created with "remember me",
+// the cookies are browser session cookies that are cleared when the browser is closed. Otherwise, they expire along
+// with the session.
+func getSessionHTTPCookies(session *Session, token string) []http.Cookie {
+	var maxAge = 0
+
+	if session.RememberMe {
+		maxAge = int(time.Until(session.ExpiresAt).Seconds())
+		if maxAge <= 0 {
+			maxAge = -1
+		}
 	}
-	return cookies
+
+	return getHTTPCookies(session.UUID, token, maxAge)
 }
 
-func getHTTPCookies(sessionUUID, token string) []http.Cookie {
+func getHTTPCookies(sessionUUID, token string, maxAge int) []http.Cookie {
 	return []http.Cookie{
 		{
 			Name:     "SessionUUID",
@@ -21,7 +36,7 @@ func getHTTPCookies(sessionUUID, token string) []http.Cookie {
 			HttpOnly: true,
 			Secure:   true,
 			SameSite: http.SameSiteStrictMode,
-			MaxAge:   86_400, // 1 day expiration
+			MaxAge:   maxAge,
 		},
 		{
 			Name:     "SessionToken",
@@ -30,7 +45,21 @@ func getHTTPCookies(sessionUUID, token string) []http.Cookie {
 			HttpOnly: true,
 			Secure:   true,
 			SameSite: http.SameSiteStrictMode,
-			MaxAge:   86_400, // 1 day expiration
+			MaxAge:   maxAge,
 		},
 	}
 }
+
+func getOAuthStateHTTPCookie(state string, maxAge int) http.Cookie {
+	return http.Cookie{
+		Name:     oauthStateCookieName,
+		Value:    state,
+		Path:     "/api/auth/oauth",
+		HttpOnly: true,
+		Secure:   true,
+		// Lax is needed since the cookie must be sent on the top-level navigation from the provider back to the
+		// callback route
+		SameSite: http.SameSiteLaxMode,
+		MaxAge:   maxAge,
+	}
+}
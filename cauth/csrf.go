@@ -0,0 +1,183 @@
+package cauth
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"mime"
+	"net/http"
+
+	"github.com/gocopper/copper/chttp"
+	"github.com/gocopper/pkg/crandom"
+)
+
+const (
+	// CSRFCookieName is the cookie that holds the CSRF token. It is readable by JavaScript so that clients such as
+	// Inertia (through axios) can send it back in the CSRFHeaderName header.
+	CSRFCookieName = "XSRF-TOKEN"
+
+	// CSRFHeaderName is the header that clients send the CSRF token in.
+	CSRFHeaderName = "X-XSRF-TOKEN"
+
+	// CSRFFormField is the form field that HTML forms send the CSRF token in.
+	CSRFFormField = "_csrf"
+
+	// StatusCSRFTokenExpired is returned when a request has no CSRF cookie, e.g. because the browser was restarted
+	// since the page was loaded. The client should reload the page to get a new token.
+	StatusCSRFTokenExpired = 419
+
+	csrfTokenLen = 40
+
+	ctxKeyCSRFToken = ctxKey("cauth/csrf_token")
+)
+
+var (
+	// ErrCSRFTokenExpired is returned when a request that needs a CSRF token has no CSRF cookie.
+	ErrCSRFTokenExpired = errors.New("csrf token expired")
+
+	// ErrCSRFTokenMismatch is returned when the CSRF token sent with a request does not match the CSRF cookie.
+	ErrCSRFTokenMismatch = errors.New("csrf token mismatch")
+)
+
+// NewCSRFMiddleware instantiates and creates a new CSRFMiddleware.
+func NewCSRFMiddleware(rw *chttp.JSONReaderWriter) *CSRFMiddleware {
+	return &CSRFMiddleware{rw: rw}
+}
+
+// CSRFMiddleware protects cookie-authenticated requests against cross-site request forgery using double-submit
+// tokens. Every response sets the CSRFCookieName cookie if the request doesn't have it. Requests with unsafe methods
+// (e.g. POST) that carry session cookies must send the same token in the CSRFHeaderName header or the CSRFFormField
+// form field. Otherwise, StatusCSRFTokenExpired is sent back if the cookie is missing and 403 Forbidden if the token
+// does not match.
+//
+// Requests that authenticate with the Authorization header (basic auth or bearer tokens) are not checked since
+// browsers don't add the header to cross-site requests on their own. Neither are requests without session cookies,
+// since they can't act on behalf of a user.
+//
+// The token is stored in the request ctx and can be read with CSRFToken or rendered in HTML templates with the
+// function returned by HTMLRenderFunc.
+type CSRFMiddleware struct {
+	rw *chttp.JSONReaderWriter
+}
+
+// Handle implements the middleware for CSRFMiddleware.
+func (mw *CSRFMiddleware) Handle(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The middleware may be used globally as well as on the cauth routes
+		if CSRFToken(r.Context()) != "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		var (
+			cookieToken = csrfTokenFromCookie(r)
+			token       = cookieToken
+		)
+
+		// A new token is set even if the request is rejected so that the client can retry with it
+		if token == "" {
+			token = crandom.GenerateRandomString(csrfTokenLen)
+
+			http.SetCookie(w, &http.Cookie{
+				Name:     CSRFCookieName,
+				Value:    token,
+				Path:     "/",
+				HttpOnly: false,
+				Secure:   true,
+				SameSite: http.SameSiteLaxMode,
+			})
+		}
+
+		err := checkCSRFToken(r, cookieToken)
+		if err != nil {
+			statusCode := http.StatusForbidden
+			if errors.Is(err, ErrCSRFTokenExpired) {
+				statusCode = StatusCSRFTokenExpired
+			}
+
+			mw.rw.WriteJSON(w, chttp.WriteJSONParams{
+				StatusCode: statusCode,
+				Data:       map[string]string{"error": err.Error()},
+			})
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), ctxKeyCSRFToken, token)))
+	})
+}
+
+// HTMLRenderFunc returns the "csrfToken" template function, which returns the CSRF token of the current request.
+// It can be used in HTML forms as <input type="hidden" name="_csrf" value="{{ csrfToken }}">.
+func (mw *CSRFMiddleware) HTMLRenderFunc() chttp.HTMLRenderFunc {
+	return chttp.HTMLRenderFunc{
+		Name: "csrfToken",
+		Func: func(r *http.Request) (interface{}, error) {
+			return func() string {
+				return CSRFToken(r.Context())
+			}, nil
+		},
+	}
+}
+
+// CSRFToken returns the CSRF token in the HTTP request context. It returns an empty string if the request did not go
+// through the CSRFMiddleware.
+func CSRFToken(ctx context.Context) string {
+	token, _ := ctx.Value(ctxKeyCSRFToken).(string)
+
+	return token
+}
+
+func checkCSRFToken(r *http.Request, cookieToken string) error {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return nil
+	}
+
+	if r.Header.Get("Authorization") != "" || !hasSessionCookies(r) {
+		return nil
+	}
+
+	if cookieToken == "" {
+		return ErrCSRFTokenExpired
+	}
+
+	token := r.Header.Get(CSRFHeaderName)
+	if token == "" && isFormRequest(r) {
+		token = r.PostFormValue(CSRFFormField)
+	}
+
+	if subtle.ConstantTimeCompare([]byte(token), []byte(cookieToken)) != 1 {
+		return ErrCSRFTokenMismatch
+	}
+
+	return nil
+}
+
+func csrfTokenFromCookie(r *http.Request) string {
+	cookie, err := r.Cookie(CSRFCookieName)
+	if err != nil || len(cookie.Value) != csrfTokenLen {
+		return ""
+	}
+
+	return cookie.Value
+}
+
+func hasSessionCookies(r *http.Request) bool {
+	for _, name := range []string{"SessionUUID", "SessionToken"} {
+		cookie, err := r.Cookie(name)
+		if err == nil && cookie.Value != "" {
+			return true
+		}
+	}
+
+	return false
+}
+
+func isFormRequest(r *http.Request) bool {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil {
+		return false
+	}
+
+	return mediaType == "application/x-www-form-urlencoded" || mediaType == "multipart/form-data"
+}
@@ -0,0 +1,139 @@
+package cauth_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/gocopper/copper/chttp/chttptest"
+	"github.com/gocopper/pkg/cauth"
+	"github.com/gocopper/pkg/cauth/cauthtest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRouter_CSRF(t *testing.T) {
+	t.Parallel()
+
+	server := httptest.NewServer(cauthtest.NewHandler(t))
+	defer server.Close()
+
+	session := cauthtest.CreateNewUserSession(t, server)
+
+	do := func(method, path, csrfCookie, csrfHeader string) *http.Response {
+		req, err := http.NewRequestWithContext(context.Background(), method, server.URL+path, strings.NewReader(`{}`))
+		assert.NoError(t, err)
+
+		req.AddCookie(&http.Cookie{Name: "SessionUUID", Value: session.Session.UUID})
+		req.AddCookie(&http.Cookie{Name: "SessionToken", Value: session.PlainSessionToken})
+
+		if csrfCookie != "" {
+			req.AddCookie(&http.Cookie{Name: cauth.CSRFCookieName, Value: csrfCookie})
+		}
+
+		if csrfHeader != "" {
+			req.Header.Set(cauth.CSRFHeaderName, csrfHeader)
+		}
+
+		resp, err := http.DefaultClient.Do(req)
+		assert.NoError(t, err)
+		t.Cleanup(func() { _ = resp.Body.Close() })
+
+		return resp
+	}
+
+	csrfCookie := func(resp *http.Response) string {
+		for _, cookie := range resp.Cookies() {
+			if cookie.Name == cauth.CSRFCookieName {
+				assert.False(t, cookie.HttpOnly)
+				return cookie.Value
+			}
+		}
+
+		return ""
+	}
+
+	// Safe requests are allowed and receive a token
+	resp := do(http.MethodGet, "/api/auth/me", "", "")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	token := csrfCookie(resp)
+	assert.NotEmpty(t, token)
+
+	// Requests that already have the token don't receive a new one
+	resp = do(http.MethodGet, "/api/auth/me", token, "")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Empty(t, csrfCookie(resp))
+
+	// Unsafe requests without the cookie are rejected as expired, but receive a new token to retry with
+	resp = do(http.MethodPost, "/api/auth/sessions/revoke-others", "", "")
+	assert.Equal(t, cauth.StatusCSRFTokenExpired, resp.StatusCode)
+	assert.NotEmpty(t, csrfCookie(resp))
+
+	var body struct {
+		Error string `json:"error"`
+	}
+
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+	assert.Equal(t, cauth.ErrCSRFTokenExpired.Error(), body.Error)
+
+	resp = do(http.MethodPost, "/api/auth/sessions/revoke-others", token, "")
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+	resp = do(http.MethodPost, "/api/auth/sessions/revoke-others", token, strings.Repeat("x", len(token)))
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+	resp = do(http.MethodPost, "/api/auth/sessions/revoke-others", token, token)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	// Requests that authenticate with the Authorization header don't need a token
+	resp = postJSON(t, server.URL+"/api/auth/sessions/revoke-others", `{}`, session)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+}
+
+func TestCSRFMiddleware_Form(t *testing.T) {
+	t.Parallel()
+
+	var (
+		mw       = cauth.NewCSRFMiddleware(chttptest.NewJSONReaderWriter(t))
+		rendered string
+	)
+
+	handler := mw.Handle(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fn, err := mw.HTMLRenderFunc().Func(r)
+		assert.NoError(t, err)
+
+		rendered = fn.(func() string)()
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/form", nil)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	cookies := rec.Result().Cookies()
+	if !assert.Len(t, cookies, 1) {
+		return
+	}
+
+	assert.Equal(t, cookies[0].Value, rendered)
+
+	post := func(token string) int {
+		form := url.Values{"_csrf": []string{token}}
+
+		req := httptest.NewRequest(http.MethodPost, "/form", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.AddCookie(cookies[0])
+		req.AddCookie(&http.Cookie{Name: "SessionUUID", Value: "session-uuid"})
+
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		return rec.Code
+	}
+
+	assert.Equal(t, http.StatusForbidden, post("wrong-token"))
+	assert.Equal(t, http.StatusOK, post(rendered))
+}
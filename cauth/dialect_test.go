@@ -0,0 +1,223 @@
+package cauth_test
+
+import (
+	"context"
+	"net/url"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/gocopper/pkg/cauth"
+	"github.com/gocopper/pkg/cauth/cauthtest"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestDialects runs the Svc against every database in cauthtest.Dialects to check that the migrations and queries
+// behave the same on each of them.
+func TestDialects(t *testing.T) {
+	t.Parallel()
+
+	for _, dialect := range cauthtest.Dialects() {
+		t.Run(dialect.Name, func(t *testing.T) {
+			t.Parallel()
+
+			testDialect(t, dialect)
+		})
+	}
+}
+
+func testDialect(t *testing.T, dialect cauthtest.Dialect) {
+	var (
+		ctx        = context.Background()
+		mailer     = cauthtest.NewMailer()
+		codeRegexp = regexp.MustCompile(`<b>(\d+)</b>`)
+		hrefRegexp = regexp.MustCompile(`href="([^"]+)"`)
+		password   = "test-pass"
+		wrongPass  = "wrong-pass"
+	)
+
+	_, svc := cauthtest.NewHandlerAndSvc(t, cauthtest.HandlerParams{
+		Dialect: dialect.Name,
+		DSN:     dialect.DSN,
+		Mailer:  mailer,
+		Config: `
+[cauth]
+login_free_attempts = 2
+login_max_attempts = 3
+login_ip_max_attempts = 100
+login_backoff_base = "1h"
+account_deletion_grace_period = "0s"
+`,
+	})
+
+	// Inserts read back the row with its defaults
+	alice, err := svc.Signup(ctx, cauth.SignupParams{Email: "alice@example.com", Password: &password})
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, "alice@example.com", alice.User.Email)
+	assert.Equal(t, "", alice.User.Username)
+	assert.False(t, alice.User.CreatedAt.IsZero())
+	assert.Equal(t, alice.User.UUID, alice.Session.UserUUID)
+	assert.False(t, alice.Session.RememberMe)
+
+	// Uniqueness is only enforced on non-empty values
+	_, err = svc.Signup(ctx, cauth.SignupParams{Email: "alice@example.com", Password: &password})
+	assert.ErrorIs(t, err, cauth.ErrUserAlreadyExists)
+
+	bob, err := svc.Signup(ctx, cauth.SignupParams{Username: "bob", Password: &password})
+	assert.NoError(t, err)
+
+	_, err = svc.Signup(ctx, cauth.SignupParams{Username: "carol", Password: &password})
+	assert.NoError(t, err)
+
+	_, err = svc.ChangeUsername(ctx, cauth.ChangeUsernameParams{UserUUID: alice.User.UUID, Username: "bob"})
+	assert.ErrorIs(t, err, cauth.ErrUsernameUnavailable)
+
+	// Failed attempts are counted with an upsert
+	for range 2 {
+		_, err = svc.Login(ctx, cauth.LoginParams{Email: "alice@example.com", Password: &wrongPass})
+		assert.ErrorIs(t, err, cauth.ErrInvalidCredentials)
+	}
+
+	_, err = svc.Login(ctx, cauth.LoginParams{Email: "alice@example.com", Password: &password})
+	assert.ErrorIs(t, err, cauth.ErrTooManyAttempts)
+
+	session, err := svc.Login(ctx, cauth.LoginParams{Username: "bob", Password: &password, RememberMe: true})
+	if assert.NoError(t, err) {
+		assert.True(t, session.Session.RememberMe)
+
+		ok, _, err := svc.ValidateSession(ctx, session.Session.UUID, session.PlainSessionToken)
+		assert.NoError(t, err)
+		assert.True(t, ok)
+	}
+
+	sessions, err := svc.ListSessions(ctx, bob.User.UUID)
+	assert.NoError(t, err)
+	assert.Len(t, sessions, 2)
+
+	// The email is only changed if no other user has taken it
+	err = svc.RequestEmailChange(ctx, cauth.RequestEmailChangeParams{UserUUID: bob.User.UUID, NewEmail: "bob@example.com"})
+	assert.NoError(t, err)
+
+	match := codeRegexp.FindStringSubmatch(*mailer.Last().HTMLBody)
+	if assert.Len(t, match, 2) {
+		user, err := svc.ConfirmEmailChange(ctx, cauth.ConfirmEmailChangeParams{
+			UserUUID:         bob.User.UUID,
+			VerificationCode: match[1],
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, "bob@example.com", user.Email)
+		assert.NotNil(t, user.EmailVerifiedAt)
+	}
+
+	err = svc.RequestEmailChange(ctx, cauth.RequestEmailChangeParams{UserUUID: bob.User.UUID, NewEmail: "taken@example.com"})
+	assert.NoError(t, err)
+
+	match = codeRegexp.FindStringSubmatch(*mailer.Sent()[len(mailer.Sent())-2].HTMLBody)
+
+	_, err = svc.Signup(ctx, cauth.SignupParams{Email: "taken@example.com", Password: &password})
+	assert.NoError(t, err)
+
+	if assert.Len(t, match, 2) {
+		_, err = svc.ConfirmEmailChange(ctx, cauth.ConfirmEmailChangeParams{
+			UserUUID:         bob.User.UUID,
+			VerificationCode: match[1],
+		})
+		assert.ErrorIs(t, err, cauth.ErrUserAlreadyExists)
+	}
+
+	// Roles, organizations and invitations
+	role, err := svc.CreateRole(ctx, cauth.CreateRoleParams{
+		Name:        "admin",
+		Permissions: []string{cauth.PermissionInviteUsers, "test.read"},
+	})
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.NoError(t, svc.AssignRole(ctx, bob.User.UUID, role.UUID))
+
+	permissions, err := svc.ListUserPermissions(ctx, bob.User.UUID)
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{cauth.PermissionInviteUsers, "test.read"}, permissions)
+
+	_, err = svc.CreateRole(ctx, cauth.CreateRoleParams{Name: "member", Permissions: []string{"test.read"}})
+	assert.NoError(t, err)
+
+	_, err = svc.CreateRole(ctx, cauth.CreateRoleParams{Name: "owner", Permissions: []string{"test.read"}})
+	assert.NoError(t, err)
+
+	org, err := svc.CreateOrganization(ctx, cauth.CreateOrganizationParams{UserUUID: bob.User.UUID, Name: "Acme"})
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	_, err = svc.CreateInvitation(ctx, cauth.CreateInvitationParams{
+		InviterUUID:      bob.User.UUID,
+		Email:            "dave@example.com",
+		OrganizationUUID: &org.UUID,
+		Role:             "member",
+	})
+	assert.NoError(t, err)
+
+	match = hrefRegexp.FindStringSubmatch(*mailer.Last().HTMLBody)
+	if assert.Len(t, match, 2) {
+		link, err := url.Parse(match[1])
+		assert.NoError(t, err)
+
+		dave, err := svc.AcceptInvitation(ctx, cauth.AcceptInvitationParams{
+			Token:    link.Query().Get("token"),
+			Password: &password,
+		})
+		if assert.NoError(t, err) {
+			members, err := svc.ListOrganizationMembers(ctx, org.UUID)
+			assert.NoError(t, err)
+			assert.Len(t, members, 2)
+
+			orgs, err := svc.ListOrganizations(ctx, dave.User.UUID)
+			assert.NoError(t, err)
+			if assert.Len(t, orgs, 1) {
+				assert.Equal(t, "member", orgs[0].Role)
+			}
+		}
+	}
+
+	// API keys are looked up by their hash
+	expiresAt := time.Now().Add(time.Hour)
+
+	apiKey, err := svc.CreateAPIKey(ctx, cauth.CreateAPIKeyParams{
+		UserUUID:  bob.User.UUID,
+		Name:      "ci",
+		Scopes:    []string{"read"},
+		ExpiresAt: &expiresAt,
+	})
+	if assert.NoError(t, err) {
+		_, user, err := svc.ValidateAPIKey(ctx, apiKey.PlainKey)
+		assert.NoError(t, err)
+		assert.Equal(t, bob.User.UUID, user.UUID)
+	}
+
+	// Audit events are paginated and keep their metadata
+	events, err := svc.ListAuditEvents(ctx, cauth.ListAuditEventsParams{
+		UserUUID: bob.User.UUID,
+		Type:     cauth.AuditEventEmailChanged,
+	})
+	assert.NoError(t, err)
+	if assert.Len(t, events, 1) {
+		assert.Equal(t, "bob@example.com", events[0].Metadata["new_email"])
+	}
+
+	page, err := svc.ListAuditEvents(ctx, cauth.ListAuditEventsParams{UserUUID: bob.User.UUID, Limit: 2, Offset: 1})
+	assert.NoError(t, err)
+	assert.Len(t, page, 2)
+
+	// Deleting a user removes their rows from every table
+	assert.NoError(t, svc.DeleteUser(ctx, cauth.DeleteUserParams{UserUUID: bob.User.UUID, Password: &password}))
+
+	_, err = svc.GetUserByUUID(ctx, bob.User.UUID)
+	assert.ErrorIs(t, err, cauth.ErrNotFound)
+
+	sessions, err = svc.ListSessions(ctx, bob.User.UUID)
+	assert.NoError(t, err)
+	assert.Empty(t, sessions)
+}
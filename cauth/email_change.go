@@ -0,0 +1,223 @@
+package cauth
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/gocopper/copper/cerrors"
+	"github.com/gocopper/pkg/cmailer"
+	"github.com/gocopper/pkg/crandom"
+)
+
+// ErrInvalidEmail is returned when a user tries to change their email to an empty or malformed email, or to the
+// email that they already have.
+var ErrInvalidEmail = errors.New("invalid email")
+
+// RequestEmailChangeParams hold the params needed to request a change of the user's email.
+type RequestEmailChangeParams struct {
+	UserUUID string `json:"-"`
+	NewEmail string `json:"new_email"`
+}
+
+// ConfirmEmailChangeParams hold the params needed to confirm a pending change of the user's email.
+type ConfirmEmailChangeParams struct {
+	UserUUID         string `json:"-"`
+	VerificationCode string `json:"verification_code"`
+}
+
+// RequestEmailChange starts a change of the user's email. A verification code is sent to the new email and a notice
+// is sent to the current one. The change is stored separately from the user, so they keep logging in with their
+// current email until the change is confirmed with ConfirmEmailChange. Requesting another change replaces the
+// pending one. ErrUserAlreadyExists is returned if another user has the new email.
+func (s *Svc) RequestEmailChange(ctx context.Context, p RequestEmailChangeParams) error {
+	newEmail := strings.TrimSpace(p.NewEmail)
+	if !strings.Contains(newEmail, "@") {
+		return ErrInvalidEmail
+	}
+
+	user, err := s.queries.GetUserByUUID(ctx, p.UserUUID)
+	if err != nil {
+		return cerrors.New(err, "failed to get user by uuid", map[string]interface{}{
+			"userUUID": p.UserUUID,
+		})
+	}
+
+	if strings.EqualFold(user.Email, newEmail) {
+		return ErrInvalidEmail
+	}
+
+	err = s.checkEmailAvailable(ctx, newEmail)
+	if err != nil {
+		return err
+	}
+
+	emailChange := &EmailChange{
+		UserUUID:         user.UUID,
+		CreatedAt:        time.Now(),
+		UpdatedAt:        time.Now(),
+		NewEmail:         newEmail,
+		VerificationCode: strconv.Itoa(int(crandom.GenerateRandomNumericalCode(s.config.VerificationCodeLen))),
+		ExpiresAt:        time.Now().Add(verificationCodeTTL),
+	}
+
+	err = s.queries.DeleteEmailChange(ctx, user.UUID)
+	if err != nil {
+		return cerrors.New(err, "failed to delete email change", map[string]interface{}{
+			"userUUID": user.UUID,
+		})
+	}
+
+	err = s.queries.InsertEmailChange(ctx, emailChange)
+	if err != nil {
+		return cerrors.New(err, "failed to insert email change", map[string]interface{}{
+			"userUUID": user.UUID,
+		})
+	}
+
+	err = s.resetAttempts(ctx, attemptKeyEmailChangeCode(user.UUID))
+	if err != nil {
+		return err
+	}
+
+	err = s.sendTemplateEmail(ctx, newEmail, s.config.EmailChangeEmailSubject, s.config.EmailChangeEmailBodyHTML,
+		map[string]string{"VerificationCode": emailChange.VerificationCode})
+	if err != nil {
+		return err
+	}
+
+	if user.Email != "" {
+		err = s.sendTemplateEmail(ctx, user.Email, s.config.EmailChangeNoticeSubject, s.config.EmailChangeNoticeBodyHTML,
+			map[string]string{"NewEmail": template.HTMLEscapeString(newEmail)})
+		if err != nil {
+			return err
+		}
+	}
+
+	return s.recordAuditEvent(ctx, &AuditEvent{
+		Type:     AuditEventEmailChangeRequested,
+		UserUUID: user.UUID,
+		Metadata: AuditMetadata{"new_email": newEmail},
+	})
+}
+
+// ConfirmEmailChange replaces the user's email with the pending new email if the verification code matches. Since
+// the code proves that the user owns the new email, it is marked as verified. The email is only changed if no other
+// user has taken it since the change was requested, in which case ErrUserAlreadyExists is returned.
+func (s *Svc) ConfirmEmailChange(ctx context.Context, p ConfirmEmailChangeParams) (*User, error) {
+	codeKey := attemptKeyEmailChangeCode(p.UserUUID)
+
+	err := s.checkVerificationCodeAttempts(ctx, codeKey)
+	if err != nil {
+		return nil, err
+	}
+
+	emailChange, err := s.queries.GetEmailChange(ctx, p.UserUUID)
+	if err != nil && errors.Is(err, ErrNotFound) {
+		return nil, ErrInvalidCredentials
+	} else if err != nil {
+		return nil, cerrors.New(err, "failed to get email change", map[string]interface{}{
+			"userUUID": p.UserUUID,
+		})
+	}
+
+	if time.Now().After(emailChange.ExpiresAt) {
+		return nil, ErrVerificationCodeExpired
+	} else if emailChange.VerificationCode != p.VerificationCode {
+		return nil, s.recordFailedAttempt(ctx, ErrInvalidCredentials, codeKey)
+	}
+
+	user, err := s.queries.GetUserByUUID(ctx, p.UserUUID)
+	if err != nil {
+		return nil, cerrors.New(err, "failed to get user by uuid", map[string]interface{}{
+			"userUUID": p.UserUUID,
+		})
+	}
+
+	// The email is changed with a conditional update so that two users cannot end up with the same email even if
+	// they confirm a change to it at the same time.
+	changed, err := s.queries.ChangeUserEmail(ctx, user.UUID, emailChange.NewEmail, time.Now())
+	if err != nil {
+		return nil, cerrors.New(err, "failed to change user email", map[string]interface{}{
+			"userUUID": user.UUID,
+		})
+	} else if !changed {
+		return nil, ErrUserAlreadyExists
+	}
+
+	err = s.queries.DeleteEmailChange(ctx, user.UUID)
+	if err != nil {
+		return nil, cerrors.New(err, "failed to delete email change", map[string]interface{}{
+			"userUUID": user.UUID,
+		})
+	}
+
+	err = s.recordAuditEvent(ctx, &AuditEvent{
+		Type:     AuditEventEmailChanged,
+		UserUUID: user.UUID,
+		Metadata: AuditMetadata{"old_email": user.Email, "new_email": emailChange.NewEmail},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	updatedUser, err := s.queries.GetUserByUUID(ctx, user.UUID)
+	if err != nil {
+		return nil, cerrors.New(err, "failed to get user by uuid", map[string]interface{}{
+			"userUUID": user.UUID,
+		})
+	}
+
+	return updatedUser, nil
+}
+
+func (s *Svc) checkEmailAvailable(ctx context.Context, email string) error {
+	_, err := s.queries.GetUserByEmail(ctx, email)
+	if err == nil {
+		return ErrUserAlreadyExists
+	} else if !errors.Is(err, ErrNotFound) {
+		return cerrors.New(err, "failed to get user by email", map[string]interface{}{
+			"email": email,
+		})
+	}
+
+	return nil
+}
+
+func (s *Svc) sendTemplateEmail(ctx context.Context, to, subject, bodyTmpl string, data map[string]string) error {
+	var emailBodySb strings.Builder
+
+	tmpl, err := template.New("email").Parse(bodyTmpl)
+	if err != nil {
+		return cerrors.New(err, "failed to parse email template", map[string]interface{}{
+			"subject": subject,
+		})
+	}
+
+	err = tmpl.Execute(&emailBodySb, data)
+	if err != nil {
+		return cerrors.New(err, "failed to execute email template", map[string]interface{}{
+			"subject": subject,
+		})
+	}
+
+	emailBody := emailBodySb.String()
+
+	err = s.mailer.Send(ctx, cmailer.SendParams{
+		From:     s.config.VerificationEmailFrom,
+		To:       []string{to},
+		Subject:  subject,
+		HTMLBody: &emailBody,
+	})
+	if err != nil {
+		return cerrors.New(err, "failed to send email", map[string]interface{}{
+			"to":      to,
+			"subject": subject,
+		})
+	}
+
+	return nil
+}
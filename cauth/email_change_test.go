@@ -0,0 +1,89 @@
+package cauth_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+
+	"github.com/gocopper/pkg/cauth"
+	"github.com/gocopper/pkg/cauth/cauthtest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRouter_ChangeEmail(t *testing.T) {
+	t.Parallel()
+
+	var (
+		mailer     = cauthtest.NewMailer()
+		codeRegexp = regexp.MustCompile(`<b>(\d+)</b>`)
+		session    cauth.SessionResult
+		user       cauth.User
+	)
+
+	handler := cauthtest.NewHandlerWithParams(t, cauthtest.HandlerParams{Mailer: mailer})
+
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	resp := postJSON(t, server.URL+"/api/auth/signup", `{"email": "old@example.com", "password": "test-pass"}`, nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&session))
+
+	resp = postJSON(t, server.URL+"/api/auth/signup", `{"email": "taken@example.com", "password": "test-pass"}`, nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	resp = postJSON(t, server.URL+"/api/auth/email/change", `{"new_email": "new@example.com"}`, nil)
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	for _, body := range []string{
+		`{"new_email": "not-an-email"}`,
+		`{"new_email": "OLD@example.com"}`,
+		`{"new_email": "taken@example.com"}`,
+	} {
+		resp = postJSON(t, server.URL+"/api/auth/email/change", body, &session)
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode, body)
+	}
+
+	sentBefore := len(mailer.Sent())
+
+	resp = postJSON(t, server.URL+"/api/auth/email/change", `{"new_email": "new@example.com"}`, &session)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	sent := mailer.Sent()[sentBefore:]
+	if !assert.Len(t, sent, 2) {
+		return
+	}
+
+	assert.Equal(t, []string{"new@example.com"}, sent[0].To)
+	assert.Equal(t, []string{"old@example.com"}, sent[1].To)
+	assert.Contains(t, *sent[1].HTMLBody, "new@example.com")
+
+	match := codeRegexp.FindStringSubmatch(*sent[0].HTMLBody)
+	if !assert.Len(t, match, 2) {
+		return
+	}
+
+	// The pending change does not affect logins until it is confirmed
+	resp = postJSON(t, server.URL+"/api/auth/login", `{"email": "old@example.com", "password": "test-pass"}`, nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	resp = postJSON(t, server.URL+"/api/auth/email/change/confirm", `{"verification_code": "wrong"}`, &session)
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	resp = postJSON(t, server.URL+"/api/auth/email/change/confirm", `{"verification_code": "`+match[1]+`"}`, &session)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&user))
+	assert.Equal(t, "new@example.com", user.Email)
+
+	// The code can only be used once
+	resp = postJSON(t, server.URL+"/api/auth/email/change/confirm", `{"verification_code": "`+match[1]+`"}`, &session)
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	resp = postJSON(t, server.URL+"/api/auth/login", `{"email": "old@example.com", "password": "test-pass"}`, nil)
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	resp = postJSON(t, server.URL+"/api/auth/login", `{"email": "new@example.com", "password": "test-pass"}`, nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+}
@@ -0,0 +1,145 @@
+package cauth
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/gocopper/copper/cerrors"
+)
+
+// Topics that Svc publishes events on when it is created with a PubSub. Events are published once the database
+// transaction that caused them commits, or right away outside a transaction. Each topic has its own payload type.
+const (
+	// TopicSignup receives a SignupEvent when a user signs up, including through OAuth and invitations.
+	TopicSignup = "cauth.signup"
+
+	// TopicEmailVerified receives an EmailVerifiedEvent when a user verifies their email with a verification code.
+	TopicEmailVerified = "cauth.email_verified"
+
+	// TopicPasswordReset receives a PasswordResetEvent when a user resets their password.
+	TopicPasswordReset = "cauth.password_reset"
+
+	// TopicLogout receives a LogoutEvent when a user logs out of a session.
+	TopicLogout = "cauth.logout"
+)
+
+// SignupEvent is published on TopicSignup.
+type SignupEvent struct {
+	UserUUID    string `json:"user_uuid"`
+	Email       string `json:"email"`
+	Username    string `json:"username"`
+	Phone       string `json:"phone"`
+	SessionUUID string `json:"session_uuid"`
+}
+
+// EmailVerifiedEvent is published on TopicEmailVerified.
+type EmailVerifiedEvent struct {
+	UserUUID string `json:"user_uuid"`
+	Email    string `json:"email"`
+}
+
+// PasswordResetEvent is published on TopicPasswordReset.
+type PasswordResetEvent struct {
+	UserUUID string `json:"user_uuid"`
+	Email    string `json:"email"`
+}
+
+// LogoutEvent is published on TopicLogout.
+type LogoutEvent struct {
+	UserUUID    string `json:"user_uuid"`
+	SessionUUID string `json:"session_uuid"`
+}
+
+// DecodeEvent decodes the payload received by a cpubsub.Handler into dest, which should be a pointer to the event
+// type of the topic. LocalPubSub passes the event as it was published while RedisPubSub passes it as decoded JSON, so
+// handlers should use DecodeEvent instead of a type assertion to work with both.
+func DecodeEvent(payload interface{}, dest interface{}) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return cerrors.New(err, "failed to marshal event payload", nil)
+	}
+
+	err = json.Unmarshal(data, dest)
+	if err != nil {
+		return cerrors.New(err, "failed to unmarshal event payload", nil)
+	}
+
+	return nil
+}
+
+// publishAuditEvent publishes the event that corresponds to the given audit event, if there is one. The user is read
+// after the transaction commits so that the payload holds their committed data.
+func (s *Svc) publishAuditEvent(ctx context.Context, event *AuditEvent) error {
+	var topic string
+
+	switch event.Type {
+	case AuditEventSignup:
+		topic = TopicSignup
+	case AuditEventEmailVerified:
+		topic = TopicEmailVerified
+	case AuditEventPasswordReset:
+		topic = TopicPasswordReset
+	case AuditEventLogout:
+		topic = TopicLogout
+	default:
+		return nil
+	}
+
+	if s.pubSub == nil {
+		return nil
+	}
+
+	return s.queries.OnCommit(ctx, func(ctx context.Context) error {
+		payload, err := s.eventPayload(ctx, topic, event)
+		if err != nil {
+			return err
+		}
+
+		err = s.pubSub.Publish(ctx, topic, payload)
+		if err != nil {
+			return cerrors.New(err, "failed to publish event", map[string]interface{}{
+				"topic":    topic,
+				"userUUID": event.UserUUID,
+			})
+		}
+
+		return nil
+	})
+}
+
+func (s *Svc) eventPayload(ctx context.Context, topic string, event *AuditEvent) (interface{}, error) {
+	if topic == TopicLogout {
+		return &LogoutEvent{
+			UserUUID:    event.UserUUID,
+			SessionUUID: event.SessionUUID,
+		}, nil
+	}
+
+	user, err := s.queries.GetUserByUUID(ctx, event.UserUUID)
+	if err != nil {
+		return nil, cerrors.New(err, "failed to get user by uuid", map[string]interface{}{
+			"userUUID": event.UserUUID,
+		})
+	}
+
+	switch topic {
+	case TopicSignup:
+		return &SignupEvent{
+			UserUUID:    user.UUID,
+			Email:       user.Email,
+			Username:    user.Username,
+			Phone:       user.Phone,
+			SessionUUID: event.SessionUUID,
+		}, nil
+	case TopicEmailVerified:
+		return &EmailVerifiedEvent{
+			UserUUID: user.UUID,
+			Email:    user.Email,
+		}, nil
+	default:
+		return &PasswordResetEvent{
+			UserUUID: user.UUID,
+			Email:    user.Email,
+		}, nil
+	}
+}
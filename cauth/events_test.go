@@ -0,0 +1,146 @@
+package cauth_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/gocopper/copper/clifecycle"
+	"github.com/gocopper/copper/clogger"
+	"github.com/gocopper/pkg/cauth"
+	"github.com/gocopper/pkg/cauth/cauthtest"
+	"github.com/gocopper/pkg/cpubsub"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSvc_Events(t *testing.T) {
+	t.Parallel()
+
+	var (
+		ctx        = context.Background()
+		logger     = clogger.NewNoop()
+		pubSub     = cpubsub.NewLocalPubSub(clifecycle.New(logger), logger)
+		mailer     = cauthtest.NewMailer()
+		codeRegexp = regexp.MustCompile(`<b>(\d+)</b>`)
+		events     = make(chan interface{}, 10)
+		session    cauth.SessionResult
+	)
+
+	subscribe := func(topic string, newEvent func() interface{}) {
+		err := pubSub.Subscribe(ctx, topic, func(_ context.Context, payload interface{}) error {
+			event := newEvent()
+
+			err := cauth.DecodeEvent(payload, event)
+			if err != nil {
+				return err
+			}
+
+			events <- event
+
+			return nil
+		})
+		assert.NoError(t, err)
+	}
+
+	subscribe(cauth.TopicSignup, func() interface{} { return &cauth.SignupEvent{} })
+	subscribe(cauth.TopicEmailVerified, func() interface{} { return &cauth.EmailVerifiedEvent{} })
+	subscribe(cauth.TopicPasswordReset, func() interface{} { return &cauth.PasswordResetEvent{} })
+	subscribe(cauth.TopicLogout, func() interface{} { return &cauth.LogoutEvent{} })
+
+	nextEvent := func() interface{} {
+		select {
+		case event := <-events:
+			return event
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for event")
+			return nil
+		}
+	}
+
+	lastCode := func() string {
+		match := codeRegexp.FindStringSubmatch(*mailer.Last().HTMLBody)
+		if !assert.Len(t, match, 2) {
+			return ""
+		}
+
+		return match[1]
+	}
+
+	handler, svc := cauthtest.NewHandlerAndSvc(t, cauthtest.HandlerParams{Mailer: mailer, PubSub: pubSub})
+
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	// Signups that fail don't publish events
+	resp := postJSON(t, server.URL+"/api/auth/signup", `{"email": "user@example.com", "password": "short"}`, nil)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	resp = postJSON(t, server.URL+"/api/auth/signup", `{"email": "user@example.com", "password": "test-pass"}`, nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&session))
+
+	assert.Equal(t, &cauth.SignupEvent{
+		UserUUID:    session.User.UUID,
+		Email:       "user@example.com",
+		SessionUUID: session.Session.UUID,
+	}, nextEvent())
+
+	_, err := svc.VerifyEmail(ctx, cauth.VerifyEmailParams{Email: "user@example.com", VerificationCode: lastCode()})
+	assert.NoError(t, err)
+
+	assert.Equal(t, &cauth.EmailVerifiedEvent{
+		UserUUID: session.User.UUID,
+		Email:    "user@example.com",
+	}, nextEvent())
+
+	resp = postJSON(t, server.URL+"/api/auth/logout", `{}`, &session)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	assert.Equal(t, &cauth.LogoutEvent{
+		UserUUID:    session.User.UUID,
+		SessionUUID: session.Session.UUID,
+	}, nextEvent())
+
+	resp = postJSON(t, server.URL+"/api/auth/password/forgot", `{"email": "user@example.com"}`, nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	resp = postJSON(t, server.URL+"/api/auth/password/reset",
+		`{"email": "user@example.com", "password": "new-pass-1", "verification_code": "`+lastCode()+`"}`, nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	assert.Equal(t, &cauth.PasswordResetEvent{
+		UserUUID: session.User.UUID,
+		Email:    "user@example.com",
+	}, nextEvent())
+
+	assert.Empty(t, events)
+}
+
+func TestDecodeEvent(t *testing.T) {
+	t.Parallel()
+
+	var (
+		event = cauth.SignupEvent{UserUUID: "user-uuid", Email: "user@example.com", SessionUUID: "session-uuid"}
+
+		decoded     cauth.SignupEvent
+		redisDecode interface{}
+	)
+
+	// LocalPubSub passes the event as it was published
+	assert.NoError(t, cauth.DecodeEvent(&event, &decoded))
+	assert.Equal(t, event, decoded)
+
+	// RedisPubSub passes the event as decoded JSON
+	data, err := json.Marshal(&event)
+	assert.NoError(t, err)
+	assert.NoError(t, json.Unmarshal(data, &redisDecode))
+
+	decoded = cauth.SignupEvent{}
+
+	assert.NoError(t, cauth.DecodeEvent(redisDecode, &decoded))
+	assert.Equal(t, event, decoded)
+}
@@ -0,0 +1,200 @@
+package cauth
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"github.com/gocopper/copper/cerrors"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Password hashing algorithms that can be set in Config.PasswordHashAlgorithm.
+const (
+	PasswordHashBcrypt   = "bcrypt"
+	PasswordHashArgon2id = "argon2id"
+)
+
+const (
+	argon2idSaltLen = 16
+	argon2idKeyLen  = 32
+
+	// argon2idMaxMemory bounds the memory in KiB that a single hash can use so that a stored hash cannot make
+	// verification allocate an arbitrary amount of memory.
+	argon2idMaxMemory = 1024 * 1024
+)
+
+// PasswordHasher hashes passwords into PHC strings (e.g. $argon2id$v=19$m=65536,t=3,p=4$<salt>$<hash>) that hold the
+// algorithm and its parameters along with the hash. Hashers can verify hashes created by any supported algorithm so
+// that users can keep logging in while their hashes are migrated to a new algorithm or cost.
+type PasswordHasher interface {
+	// Hash returns the PHC string for the given password.
+	Hash(password string) ([]byte, error)
+
+	// Verify checks if the given password matches the hash.
+	Verify(hash []byte, password string) bool
+
+	// NeedsRehash checks if the hash was created with another algorithm or other parameters than the ones that the
+	// hasher uses.
+	NeedsRehash(hash []byte) bool
+}
+
+// NewPasswordHasher returns the PasswordHasher for the algorithm and cost set in the given config.
+func NewPasswordHasher(config Config) (PasswordHasher, error) {
+	switch config.PasswordHashAlgorithm {
+	case PasswordHashBcrypt:
+		return &bcryptHasher{cost: config.BcryptCost}, nil
+	case PasswordHashArgon2id:
+		if !validArgon2idParams(config.Argon2idMemory, config.Argon2idIterations, config.Argon2idParallelism) {
+			return nil, cerrors.New(nil, "invalid argon2id parameters", map[string]interface{}{
+				"memory":      config.Argon2idMemory,
+				"iterations":  config.Argon2idIterations,
+				"parallelism": config.Argon2idParallelism,
+			})
+		}
+
+		return &argon2idHasher{
+			memory:      config.Argon2idMemory,
+			iterations:  config.Argon2idIterations,
+			parallelism: config.Argon2idParallelism,
+		}, nil
+	default:
+		return nil, cerrors.New(nil, "unsupported password hash algorithm", map[string]interface{}{
+			"algorithm": config.PasswordHashAlgorithm,
+		})
+	}
+}
+
+type bcryptHasher struct {
+	cost int
+}
+
+func (h *bcryptHasher) Hash(password string) ([]byte, error) {
+	return bcrypt.GenerateFromPassword([]byte(password), h.cost)
+}
+
+func (h *bcryptHasher) Verify(hash []byte, password string) bool {
+	return verifyPasswordHash(hash, password)
+}
+
+func (h *bcryptHasher) NeedsRehash(hash []byte) bool {
+	cost, err := bcrypt.Cost(hash)
+
+	return err != nil || cost != h.cost
+}
+
+type argon2idHasher struct {
+	memory      uint32
+	iterations  uint32
+	parallelism uint8
+}
+
+func (h *argon2idHasher) Hash(password string) ([]byte, error) {
+	salt := make([]byte, argon2idSaltLen)
+
+	_, err := rand.Read(salt)
+	if err != nil {
+		return nil, cerrors.New(err, "failed to generate salt", nil)
+	}
+
+	params := argon2idParams{
+		memory:      h.memory,
+		iterations:  h.iterations,
+		parallelism: h.parallelism,
+		salt:        salt,
+	}
+
+	return params.encode(params.key(password)), nil
+}
+
+func (h *argon2idHasher) Verify(hash []byte, password string) bool {
+	return verifyPasswordHash(hash, password)
+}
+
+func (h *argon2idHasher) NeedsRehash(hash []byte) bool {
+	params, _, ok := decodeArgon2idHash(hash)
+
+	return !ok || params.memory != h.memory || params.iterations != h.iterations || params.parallelism != h.parallelism
+}
+
+// argon2idParams are the parameters that are encoded in an Argon2id PHC string along with the hash.
+type argon2idParams struct {
+	memory      uint32
+	iterations  uint32
+	parallelism uint8
+	salt        []byte
+}
+
+func (p *argon2idParams) key(password string) []byte {
+	return argon2.IDKey([]byte(password), p.salt, p.iterations, p.memory, p.parallelism, argon2idKeyLen)
+}
+
+func (p *argon2idParams) encode(key []byte) []byte {
+	return []byte(fmt.Sprintf("$%s$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		PasswordHashArgon2id,
+		argon2.Version,
+		p.memory,
+		p.iterations,
+		p.parallelism,
+		base64.RawStdEncoding.EncodeToString(p.salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	))
+}
+
+func decodeArgon2idHash(hash []byte) (*argon2idParams, []byte, bool) {
+	var (
+		params  argon2idParams
+		version int
+	)
+
+	parts := strings.Split(string(hash), "$")
+	if len(parts) != 6 || parts[1] != PasswordHashArgon2id {
+		return nil, nil, false
+	}
+
+	_, err := fmt.Sscanf(parts[2], "v=%d", &version)
+	if err != nil || version != argon2.Version {
+		return nil, nil, false
+	}
+
+	_, err = fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.memory, &params.iterations, &params.parallelism)
+	if err != nil || !validArgon2idParams(params.memory, params.iterations, params.parallelism) {
+		return nil, nil, false
+	}
+
+	params.salt, err = base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return nil, nil, false
+	}
+
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return nil, nil, false
+	}
+
+	return &params, key, true
+}
+
+// validArgon2idParams checks that the parameters can be passed to argon2.IDKey, which panics if parallelism is zero,
+// and that the memory is between the minimum of 8 KiB per lane and argon2idMaxMemory.
+func validArgon2idParams(memory, iterations uint32, parallelism uint8) bool {
+	return parallelism >= 1 && iterations >= 1 && memory >= 8*uint32(parallelism) && memory <= argon2idMaxMemory
+}
+
+// verifyPasswordHash checks the password against a hash created by any of the supported algorithms.
+func verifyPasswordHash(hash []byte, password string) bool {
+	if bytes.HasPrefix(hash, []byte("$"+PasswordHashArgon2id+"$")) {
+		params, key, ok := decodeArgon2idHash(hash)
+		if !ok {
+			return false
+		}
+
+		return subtle.ConstantTimeCompare(params.key(password), key) == 1
+	}
+
+	return bcrypt.CompareHashAndPassword(hash, []byte(password)) == nil
+}
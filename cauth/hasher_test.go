@@ -0,0 +1,135 @@
+package cauth_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/gocopper/pkg/cauth"
+	"github.com/gocopper/pkg/cauth/cauthtest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPasswordHasher(t *testing.T) {
+	t.Parallel()
+
+	bcryptHasher, err := cauth.NewPasswordHasher(cauth.Config{PasswordHashAlgorithm: cauth.PasswordHashBcrypt, BcryptCost: 4})
+	assert.NoError(t, err)
+
+	argon2idHasher, err := cauth.NewPasswordHasher(cauth.Config{
+		PasswordHashAlgorithm: cauth.PasswordHashArgon2id,
+		Argon2idMemory:        1024,
+		Argon2idIterations:    1,
+		Argon2idParallelism:   1,
+	})
+	assert.NoError(t, err)
+
+	_, err = cauth.NewPasswordHasher(cauth.Config{PasswordHashAlgorithm: "md5"})
+	assert.Error(t, err)
+
+	for _, config := range []cauth.Config{
+		{Argon2idMemory: 1024, Argon2idIterations: 1, Argon2idParallelism: 0},
+		{Argon2idMemory: 1024, Argon2idIterations: 0, Argon2idParallelism: 1},
+		{Argon2idMemory: 4, Argon2idIterations: 1, Argon2idParallelism: 1},
+		{Argon2idMemory: 4 * 1024 * 1024, Argon2idIterations: 1, Argon2idParallelism: 1},
+	} {
+		config.PasswordHashAlgorithm = cauth.PasswordHashArgon2id
+
+		_, err = cauth.NewPasswordHasher(config)
+		assert.Error(t, err)
+	}
+
+	bcryptHash, err := bcryptHasher.Hash("test-pass")
+	assert.NoError(t, err)
+
+	argon2idHash, err := argon2idHasher.Hash("test-pass")
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(argon2idHash), "$argon2id$v=19$m=1024,t=1,p=1$"))
+
+	// Hashes created by either algorithm can be verified by both hashers
+	for _, hasher := range []cauth.PasswordHasher{bcryptHasher, argon2idHasher} {
+		assert.True(t, hasher.Verify(bcryptHash, "test-pass"))
+		assert.True(t, hasher.Verify(argon2idHash, "test-pass"))
+		assert.False(t, hasher.Verify(bcryptHash, "wrong-pass"))
+		assert.False(t, hasher.Verify(argon2idHash, "wrong-pass"))
+		assert.False(t, hasher.Verify(nil, ""))
+
+		// Hashes with parameters that the hasher would reject are not verified instead of crashing or using
+		// unbounded memory
+		for _, params := range []string{"m=1024,t=1,p=0", "m=1024,t=0,p=1", "m=4294967295,t=1,p=1"} {
+			hash := strings.Replace(string(argon2idHash), "m=1024,t=1,p=1", params, 1)
+			assert.False(t, hasher.Verify([]byte(hash), "test-pass"))
+		}
+	}
+
+	assert.False(t, bcryptHasher.NeedsRehash(bcryptHash))
+	assert.True(t, bcryptHasher.NeedsRehash(argon2idHash))
+	assert.False(t, argon2idHasher.NeedsRehash(argon2idHash))
+	assert.True(t, argon2idHasher.NeedsRehash(bcryptHash))
+
+	strongerHasher, err := cauth.NewPasswordHasher(cauth.Config{
+		PasswordHashAlgorithm: cauth.PasswordHashArgon2id,
+		Argon2idMemory:        2048,
+		Argon2idIterations:    1,
+		Argon2idParallelism:   1,
+	})
+	assert.NoError(t, err)
+	assert.True(t, strongerHasher.NeedsRehash(argon2idHash))
+}
+
+func TestRouter_Login_RehashesPassword(t *testing.T) {
+	t.Parallel()
+
+	dbPath := path.Join(t.TempDir(), "cauth.db")
+
+	var session cauth.SessionResult
+
+	bcryptHandler, bcryptSvc := cauthtest.NewHandlerAndSvc(t, cauthtest.HandlerParams{DBPath: dbPath})
+
+	bcryptServer := httptest.NewServer(bcryptHandler)
+	defer bcryptServer.Close()
+
+	passwordHash := func() string {
+		user, err := bcryptSvc.GetUserByUUID(context.Background(), session.User.UUID)
+		assert.NoError(t, err)
+
+		return string(user.Password)
+	}
+
+	resp := postJSON(t, bcryptServer.URL+"/api/auth/signup", `{"email": "rehash@example.com", "password": "test-pass"}`, nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&session))
+	assert.True(t, strings.HasPrefix(passwordHash(), "$2a$"))
+
+	argon2idServer := httptest.NewServer(cauthtest.NewHandlerWithParams(t, cauthtest.HandlerParams{
+		DBPath: dbPath,
+		Config: `
+[cauth]
+password_hash_algorithm = "argon2id"
+argon2id_memory = 1024
+argon2id_iterations = 1
+argon2id_parallelism = 1
+`,
+	}))
+	defer argon2idServer.Close()
+
+	// A failed login leaves the old hash in place
+	resp = postJSON(t, argon2idServer.URL+"/api/auth/login", `{"email": "rehash@example.com", "password": "wrong-pass"}`, nil)
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	assert.True(t, strings.HasPrefix(passwordHash(), "$2a$"))
+
+	resp = postJSON(t, argon2idServer.URL+"/api/auth/login", `{"email": "rehash@example.com", "password": "test-pass"}`, nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.True(t, strings.HasPrefix(passwordHash(), "$argon2id$v=19$m=1024,t=1,p=1$"))
+
+	// The upgraded hash keeps working with the new hasher and with servers that still use bcrypt
+	resp = postJSON(t, argon2idServer.URL+"/api/auth/login", `{"email": "rehash@example.com", "password": "test-pass"}`, nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	resp = postJSON(t, bcryptServer.URL+"/api/auth/login", `{"email": "rehash@example.com", "password": "test-pass"}`, nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+}
@@ -0,0 +1,436 @@
+package cauth
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"slices"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/gocopper/copper/cerrors"
+	"github.com/gocopper/pkg/cmailer"
+	"github.com/gocopper/pkg/crandom"
+	"github.com/google/uuid"
+)
+
+const invitationTokenLen = 40
+
+// PermissionInviteUsers allows a user to invite people with CreateInvitation. Users with the permission through
+// their role in an organization can only invite people into that organization.
+const PermissionInviteUsers = "cauth.invite_users"
+
+// ErrInvalidInvitation is returned when an invitation is created without an email, for an organization or role that
+// does not exist, or with a role but no organization.
+var ErrInvalidInvitation = errors.New("invalid invitation")
+
+// CreateInvitationParams hold the params needed to invite a person by email. If OrganizationUUID is set, the person
+// becomes a member of the organization with the given role when they accept the invitation.
+type CreateInvitationParams struct {
+	InviterUUID      string  `json:"-"`
+	Email            string  `json:"email"`
+	OrganizationUUID *string `json:"organization_uuid"`
+	Role             string  `json:"role"`
+}
+
+// AcceptInvitationParams hold the params needed to accept an invitation. Password is only used if the invited email
+// does not belong to a user yet, in which case it must satisfy the password policy. If it is nil, the new user can
+// login with verification codes.
+type AcceptInvitationParams struct {
+	Token    string  `json:"token"`
+	Password *string `json:"password"`
+}
+
+// CreateInvitation emails an invitation link to the given email. The inviter must have the PermissionInviteUsers
+// permission, either through their roles or through their role in the organization that the person is invited to.
+// The invited role can only have permissions that the inviter has in the same way, otherwise ErrPermissionDenied is
+// returned. The link expires after Config.InvitationTTL.
+func (s *Svc) CreateInvitation(ctx context.Context, p CreateInvitationParams) (*Invitation, error) {
+	email := strings.TrimSpace(p.Email)
+	if email == "" || (p.OrganizationUUID == nil && p.Role != "") {
+		return nil, ErrInvalidInvitation
+	}
+
+	if p.OrganizationUUID != nil {
+		_, err := s.queries.GetOrganization(ctx, *p.OrganizationUUID)
+		if err != nil && errors.Is(err, ErrNotFound) {
+			return nil, ErrInvalidInvitation
+		} else if err != nil {
+			return nil, cerrors.New(err, "failed to get organization", map[string]interface{}{
+				"organizationUUID": *p.OrganizationUUID,
+			})
+		}
+	}
+
+	permissions, err := s.inviterPermissions(ctx, p.InviterUUID, p.OrganizationUUID)
+	if err != nil {
+		return nil, err
+	} else if !slices.Contains(permissions, PermissionInviteUsers) {
+		return nil, ErrPermissionDenied
+	}
+
+	if p.Role != "" {
+		role, err := s.queries.GetRoleByName(ctx, p.Role)
+		if err != nil && errors.Is(err, ErrNotFound) {
+			return nil, ErrInvalidInvitation
+		} else if err != nil {
+			return nil, cerrors.New(err, "failed to get role by name", map[string]interface{}{
+				"name": p.Role,
+			})
+		}
+
+		rolePermissions, err := s.queries.ListRolePermissions(ctx, role.UUID)
+		if err != nil {
+			return nil, cerrors.New(err, "failed to list role permissions", map[string]interface{}{
+				"roleUUID": role.UUID,
+			})
+		}
+
+		// Members could otherwise grant more than they have, e.g. by inviting a second account of theirs as an owner
+		for _, permission := range rolePermissions {
+			if !slices.Contains(permissions, permission) {
+				return nil, ErrPermissionDenied
+			}
+		}
+	}
+
+	invitation := &Invitation{
+		UUID:             uuid.New().String(),
+		CreatedAt:        time.Now(),
+		UpdatedAt:        time.Now(),
+		InviterUUID:      p.InviterUUID,
+		Email:            email,
+		OrganizationUUID: p.OrganizationUUID,
+		Role:             p.Role,
+		ExpiresAt:        time.Now().Add(s.config.InvitationTTL),
+	}
+
+	plainToken := crandom.GenerateRandomString(invitationTokenLen)
+	tokenHash := sha256.Sum256([]byte(plainToken))
+
+	invitation.TokenHash = tokenHash[:]
+
+	err = s.queries.InsertInvitation(ctx, invitation)
+	if err != nil {
+		return nil, cerrors.New(err, "failed to insert invitation", map[string]interface{}{
+			"inviterUUID": p.InviterUUID,
+		})
+	}
+
+	err = s.sendInvitationEmail(ctx, invitation, plainToken)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.recordAuditEvent(ctx, &AuditEvent{
+		Type:     AuditEventInvitationCreated,
+		UserUUID: p.InviterUUID,
+		Metadata: AuditMetadata{"invitation_uuid": invitation.UUID},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return invitation, nil
+}
+
+// ListInvitations returns the invitations sent by the given user that have not been accepted or revoked, including
+// expired ones.
+func (s *Svc) ListInvitations(ctx context.Context, inviterUUID string) ([]Invitation, error) {
+	invitations, err := s.queries.ListPendingInvitationsByInviterUUID(ctx, inviterUUID)
+	if err != nil {
+		return nil, cerrors.New(err, "failed to list invitations", map[string]interface{}{
+			"inviterUUID": inviterUUID,
+		})
+	}
+
+	return invitations, nil
+}
+
+// RevokeInvitation revokes the invitation identified by invitationUUID so that it can no longer be accepted.
+// ErrNotFound is returned if the invitation was not sent by the given user or is no longer pending.
+func (s *Svc) RevokeInvitation(ctx context.Context, inviterUUID, invitationUUID string) error {
+	invitation, err := s.getPendingInvitation(ctx, inviterUUID, invitationUUID)
+	if err != nil {
+		return err
+	}
+
+	invitation.UpdatedAt = time.Now()
+	invitation.RevokedAt = &invitation.UpdatedAt
+
+	err = s.queries.UpdateInvitation(ctx, invitation)
+	if err != nil {
+		return cerrors.New(err, "failed to update invitation", map[string]interface{}{
+			"invitationUUID": invitationUUID,
+		})
+	}
+
+	return s.recordAuditEvent(ctx, &AuditEvent{
+		Type:     AuditEventInvitationRevoked,
+		UserUUID: inviterUUID,
+		Metadata: AuditMetadata{"invitation_uuid": invitationUUID},
+	})
+}
+
+// ResendInvitation emails a new invitation link and extends the invitation by Config.InvitationTTL. Links sent
+// earlier stop working. ErrNotFound is returned if the invitation was not sent by the given user or is no longer
+// pending.
+func (s *Svc) ResendInvitation(ctx context.Context, inviterUUID, invitationUUID string) error {
+	invitation, err := s.getPendingInvitation(ctx, inviterUUID, invitationUUID)
+	if err != nil {
+		return err
+	}
+
+	plainToken := crandom.GenerateRandomString(invitationTokenLen)
+	tokenHash := sha256.Sum256([]byte(plainToken))
+
+	invitation.UpdatedAt = time.Now()
+	invitation.TokenHash = tokenHash[:]
+	invitation.ExpiresAt = time.Now().Add(s.config.InvitationTTL)
+
+	err = s.queries.UpdateInvitation(ctx, invitation)
+	if err != nil {
+		return cerrors.New(err, "failed to update invitation", map[string]interface{}{
+			"invitationUUID": invitationUUID,
+		})
+	}
+
+	err = s.sendInvitationEmail(ctx, invitation, plainToken)
+	if err != nil {
+		return err
+	}
+
+	return s.recordAuditEvent(ctx, &AuditEvent{
+		Type:     AuditEventInvitationResent,
+		UserUUID: inviterUUID,
+		Metadata: AuditMetadata{"invitation_uuid": invitationUUID},
+	})
+}
+
+// AcceptInvitation accepts the invitation with the given token. If the invited email belongs to an existing user,
+// the invitation is linked to that user. Otherwise, a new user is signed up with the email. Since opening the link
+// proves that the person owns the email, the email is marked as verified and they are logged in. If the user has
+// two-factor authentication enabled, a pending TwoFactorChallenge is returned instead of a session.
+func (s *Svc) AcceptInvitation(ctx context.Context, p AcceptInvitationParams) (*SessionResult, error) {
+	var (
+		sessionResult *SessionResult
+		tokenHash     = sha256.Sum256([]byte(p.Token))
+	)
+
+	invitation, err := s.queries.GetInvitationByTokenHash(ctx, tokenHash[:])
+	if err != nil && errors.Is(err, ErrNotFound) {
+		return nil, ErrInvalidCredentials
+	} else if err != nil {
+		return nil, cerrors.New(err, "failed to get invitation by token hash", nil)
+	}
+
+	if time.Now().After(invitation.ExpiresAt) {
+		return nil, ErrVerificationCodeExpired
+	}
+
+	user, err := s.queries.GetUserByEmail(ctx, invitation.Email)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return nil, cerrors.New(err, "failed to get user by email", map[string]interface{}{
+			"email": invitation.Email,
+		})
+	} else if err != nil && p.Password != nil {
+		err = s.checkPassword(*p.Password, invitation.Email, "")
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// The invitation is marked as accepted with a conditional update so that it cannot be accepted twice, even by
+	// concurrent requests.
+	accepted, err := s.queries.MarkInvitationAccepted(ctx, invitation.UUID, time.Now())
+	if err != nil {
+		return nil, cerrors.New(err, "failed to mark invitation as accepted", map[string]interface{}{
+			"invitationUUID": invitation.UUID,
+		})
+	} else if !accepted {
+		return nil, ErrInvalidCredentials
+	}
+
+	if user != nil {
+		sessionResult, err = s.acceptInvitationAsUser(ctx, user)
+	} else {
+		sessionResult, err = s.acceptInvitationWithSignup(ctx, invitation, p.Password)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if invitation.OrganizationUUID != nil {
+		_, err = s.AddOrganizationMember(ctx, *invitation.OrganizationUUID, sessionResult.User.UUID, invitation.Role)
+		if err != nil && !errors.Is(err, ErrAlreadyMember) {
+			return nil, err
+		}
+	}
+
+	err = s.recordAuditEvent(ctx, &AuditEvent{
+		Type:     AuditEventInvitationAccepted,
+		UserUUID: sessionResult.User.UUID,
+		Metadata: AuditMetadata{"invitation_uuid": invitation.UUID, "inviter_uuid": invitation.InviterUUID},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.recordLogin(ctx, loginMethodInvitation, sessionResult)
+	if err != nil {
+		return nil, err
+	}
+
+	return sessionResult, nil
+}
+
+func (s *Svc) acceptInvitationAsUser(ctx context.Context, user *User) (*SessionResult, error) {
+	if user.EmailVerifiedAt == nil {
+		user.UpdatedAt = time.Now()
+		user.EmailVerifiedAt = &user.UpdatedAt
+
+		err := s.queries.UpdateUser(ctx, user)
+		if err != nil {
+			return nil, cerrors.New(err, "failed to update user", map[string]interface{}{
+				"userUUID": user.UUID,
+			})
+		}
+	}
+
+	return s.loginUser(ctx, user, false)
+}
+
+func (s *Svc) acceptInvitationWithSignup(ctx context.Context, invitation *Invitation, password *string) (*SessionResult, error) {
+	sessionResult, err := s.signupWithEmail(ctx, invitation.Email, password, true)
+	if err != nil {
+		return nil, err
+	}
+
+	if sessionResult.Session == nil {
+		// Users without a password are logged in right away since the invitation proves that they own the email
+		newSessionResult, err := s.createSessionResult(ctx, sessionResult.User, false)
+		if err != nil {
+			return nil, err
+		}
+
+		newSessionResult.NewUser = sessionResult.NewUser
+		sessionResult = newSessionResult
+	}
+
+	err = s.recordAuditEvent(ctx, &AuditEvent{
+		Type:        AuditEventSignup,
+		UserUUID:    sessionResult.User.UUID,
+		SessionUUID: sessionResult.Session.UUID,
+		Metadata:    AuditMetadata{"invitation_uuid": invitation.UUID},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return sessionResult, nil
+}
+
+func (s *Svc) getPendingInvitation(ctx context.Context, inviterUUID, invitationUUID string) (*Invitation, error) {
+	invitation, err := s.queries.GetInvitation(ctx, invitationUUID)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return nil, cerrors.New(err, "failed to get invitation", map[string]interface{}{
+			"invitationUUID": invitationUUID,
+		})
+	} else if err != nil || invitation.InviterUUID != inviterUUID ||
+		invitation.AcceptedAt != nil || invitation.RevokedAt != nil {
+		return nil, ErrNotFound
+	}
+
+	return invitation, nil
+}
+
+// inviterPermissions returns the permissions that the given user has through their roles and, if orgUUID is set,
+// through their role in that organization.
+func (s *Svc) inviterPermissions(ctx context.Context, userUUID string, orgUUID *string) ([]string, error) {
+	permissions, err := s.ListUserPermissions(ctx, userUUID)
+	if err != nil || orgUUID == nil {
+		return permissions, err
+	}
+
+	membership, err := s.queries.GetMembership(ctx, *orgUUID, userUUID)
+	if err != nil && errors.Is(err, ErrNotFound) {
+		return permissions, nil
+	} else if err != nil {
+		return nil, cerrors.New(err, "failed to get membership", map[string]interface{}{
+			"organizationUUID": *orgUUID,
+			"userUUID":         userUUID,
+		})
+	}
+
+	orgPermissions, err := s.queries.ListPermissionsByRoleName(ctx, membership.Role)
+	if err != nil {
+		return nil, cerrors.New(err, "failed to list role permissions", map[string]interface{}{
+			"role": membership.Role,
+		})
+	}
+
+	return append(permissions, orgPermissions...), nil
+}
+
+func (s *Svc) sendInvitationEmail(ctx context.Context, invitation *Invitation, plainToken string) error {
+	var (
+		linkSb           strings.Builder
+		emailBodySb      strings.Builder
+		organizationName string
+	)
+
+	if invitation.OrganizationUUID != nil {
+		org, err := s.queries.GetOrganization(ctx, *invitation.OrganizationUUID)
+		if err != nil {
+			return cerrors.New(err, "failed to get organization", map[string]interface{}{
+				"organizationUUID": *invitation.OrganizationUUID,
+			})
+		}
+
+		organizationName = org.Name
+	}
+
+	linkTmpl, err := template.New("invitation_url").Parse(s.config.InvitationURLTemplate)
+	if err != nil {
+		return cerrors.New(err, "failed to parse invitation url template", nil)
+	}
+
+	err = linkTmpl.Execute(&linkSb, map[string]string{
+		"Token": plainToken,
+	})
+	if err != nil {
+		return cerrors.New(err, "failed to execute invitation url template", nil)
+	}
+
+	bodyTmpl, err := template.New("email_invitation").Parse(s.config.InvitationEmailBodyHTML)
+	if err != nil {
+		return cerrors.New(err, "failed to parse invitation email template", nil)
+	}
+
+	// Any user can create an organization, so its name is escaped to keep it from adding markup to the email
+	err = bodyTmpl.Execute(&emailBodySb, map[string]string{
+		"InvitationLink":   linkSb.String(),
+		"OrganizationName": template.HTMLEscapeString(organizationName),
+	})
+	if err != nil {
+		return cerrors.New(err, "failed to execute invitation email template", nil)
+	}
+
+	emailBody := emailBodySb.String()
+
+	err = s.mailer.Send(ctx, cmailer.SendParams{
+		From:     s.config.VerificationEmailFrom,
+		To:       []string{invitation.Email},
+		Subject:  s.config.InvitationEmailSubject,
+		HTMLBody: &emailBody,
+	})
+	if err != nil {
+		return cerrors.New(err, "failed to send invitation email", map[string]interface{}{
+			"to": invitation.Email,
+		})
+	}
+
+	return nil
+}
@@ -0,0 +1,239 @@
+package cauth_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"testing"
+
+	"github.com/gocopper/pkg/cauth"
+	"github.com/gocopper/pkg/cauth/cauthtest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRouter_Invitations(t *testing.T) {
+	t.Parallel()
+
+	var (
+		ctx        = context.Background()
+		mailer     = cauthtest.NewMailer()
+		hrefRegexp = regexp.MustCompile(`href="([^"]+)"`)
+		invitation cauth.Invitation
+		accepted   cauth.SessionResult
+		list       struct {
+			Invitations []cauth.Invitation `json:"invitations"`
+		}
+	)
+
+	handler, svc := cauthtest.NewHandlerAndSvc(t, cauthtest.HandlerParams{Mailer: mailer})
+
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	do := func(method, url string, session *cauth.SessionResult) *http.Response {
+		req, err := http.NewRequestWithContext(ctx, method, url, nil)
+		assert.NoError(t, err)
+
+		req.SetBasicAuth(session.Session.UUID, session.PlainSessionToken)
+
+		resp, err := http.DefaultClient.Do(req)
+		assert.NoError(t, err)
+		t.Cleanup(func() { _ = resp.Body.Close() })
+
+		return resp
+	}
+
+	lastToken := func() string {
+		email := mailer.Last()
+		if !assert.NotNil(t, email) {
+			return ""
+		}
+
+		match := hrefRegexp.FindStringSubmatch(*email.HTMLBody)
+		if !assert.Len(t, match, 2) {
+			return ""
+		}
+
+		link, err := url.Parse(match[1])
+		assert.NoError(t, err)
+
+		return link.Query().Get("token")
+	}
+
+	session := cauthtest.CreateNewUserSession(t, server)
+
+	resp := postJSON(t, server.URL+"/api/auth/invitations", `{"email": "invited@example.com"}`, session)
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+	role, err := svc.CreateRole(ctx, cauth.CreateRoleParams{
+		Name:        "admin",
+		Permissions: []string{cauth.PermissionInviteUsers},
+	})
+	assert.NoError(t, err)
+	assert.NoError(t, svc.AssignRole(ctx, session.User.UUID, role.UUID))
+
+	resp = postJSON(t, server.URL+"/api/auth/invitations", `{"email": " "}`, session)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	resp = postJSON(t, server.URL+"/api/auth/invitations", `{"email": "invited@example.com"}`, session)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&invitation))
+	assert.Equal(t, []string{"invited@example.com"}, mailer.Last().To)
+
+	firstToken := lastToken()
+
+	// Resending the invitation invalidates the link that was sent before
+	resp = postJSON(t, server.URL+"/api/auth/invitations/"+invitation.UUID+"/resend", `{}`, session)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	token := lastToken()
+	assert.NotEqual(t, firstToken, token)
+
+	resp = postJSON(t, server.URL+"/api/auth/invitations/accept", `{"token": "`+firstToken+`"}`, nil)
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	resp = do(http.MethodGet, server.URL+"/api/auth/invitations", session)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&list))
+
+	if assert.Len(t, list.Invitations, 1) {
+		assert.Equal(t, invitation.UUID, list.Invitations[0].UUID)
+	}
+
+	// Accepting the invitation signs up the invited user with a verified email and logs them in
+	sentBefore := len(mailer.Sent())
+
+	resp = postJSON(t, server.URL+"/api/auth/invitations/accept",
+		`{"token": "`+token+`", "password": "invited-pass"}`, nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&accepted))
+	assert.True(t, accepted.NewUser)
+	assert.NotNil(t, accepted.Session)
+	assert.Len(t, mailer.Sent(), sentBefore)
+
+	resp = postJSON(t, server.URL+"/api/auth/login", `{"email": "invited@example.com", "password": "invited-pass"}`, nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	resp = postJSON(t, server.URL+"/api/auth/invitations/accept", `{"token": "`+token+`"}`, nil)
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	resp = do(http.MethodGet, server.URL+"/api/auth/invitations", session)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&list))
+	assert.Empty(t, list.Invitations)
+
+	// Inviting an existing user into an organization links the invitation to that user
+	memberRole, err := svc.CreateRole(ctx, cauth.CreateRoleParams{Name: "member"})
+	assert.NoError(t, err)
+
+	_, err = svc.CreateRole(ctx, cauth.CreateRoleParams{
+		Name:        "owner",
+		Permissions: []string{cauth.PermissionInviteUsers, "test.manage"},
+	})
+	assert.NoError(t, err)
+
+	org, err := svc.CreateOrganization(ctx, cauth.CreateOrganizationParams{UserUUID: session.User.UUID, Name: "Acme"})
+	assert.NoError(t, err)
+
+	resp = postJSON(t, server.URL+"/api/auth/invitations",
+		`{"email": "invited@example.com", "organization_uuid": "`+org.UUID+`", "role": "member"}`, session)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	resp = postJSON(t, server.URL+"/api/auth/invitations/accept", `{"token": "`+lastToken()+`"}`, nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&accepted))
+	assert.False(t, accepted.NewUser)
+
+	members, err := svc.ListOrganizationMembers(ctx, org.UUID)
+	assert.NoError(t, err)
+
+	if assert.Len(t, members, 2) {
+		assert.Equal(t, accepted.User.UUID, members[1].UserUUID)
+		assert.Equal(t, "member", members[1].Role)
+	}
+
+	// Members can only invite people into their organization if their role allows it
+	resp = postJSON(t, server.URL+"/api/auth/invitations",
+		`{"email": "other@example.com", "organization_uuid": "`+org.UUID+`", "role": "member"}`, &accepted)
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+	// Members can only invite people with roles that don't have more permissions than their own
+	assert.NoError(t, svc.GrantPermission(ctx, memberRole.UUID, cauth.PermissionInviteUsers))
+
+	resp = postJSON(t, server.URL+"/api/auth/invitations",
+		`{"email": "other@example.com", "organization_uuid": "`+org.UUID+`", "role": "owner"}`, &accepted)
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+	resp = postJSON(t, server.URL+"/api/auth/invitations",
+		`{"email": "other@example.com", "organization_uuid": "`+org.UUID+`", "role": "unknown"}`, &accepted)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	resp = postJSON(t, server.URL+"/api/auth/invitations",
+		`{"email": "other@example.com", "organization_uuid": "`+org.UUID+`", "role": "member"}`, &accepted)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	// Revoked invitations cannot be accepted
+	resp = postJSON(t, server.URL+"/api/auth/invitations", `{"email": "revoked@example.com"}`, session)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&invitation))
+
+	token = lastToken()
+
+	assert.Equal(t, http.StatusOK, do(http.MethodDelete, server.URL+"/api/auth/invitations/"+invitation.UUID, session).StatusCode)
+	assert.Equal(t, http.StatusNotFound, do(http.MethodDelete, server.URL+"/api/auth/invitations/"+invitation.UUID, session).StatusCode)
+
+	resp = postJSON(t, server.URL+"/api/auth/invitations/accept", `{"token": "`+token+`"}`, nil)
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+}
+
+func TestSvc_CreateInvitation_EscapesOrganizationName(t *testing.T) {
+	t.Parallel()
+
+	var (
+		ctx    = context.Background()
+		mailer = cauthtest.NewMailer()
+	)
+
+	handler, svc := cauthtest.NewHandlerAndSvc(t, cauthtest.HandlerParams{
+		Mailer: mailer,
+		// The config is itself rendered as a template, so the email template is written out as a string literal
+		Config: `
+[cauth]
+invitation_email_body_html = '{{"Join {{.OrganizationName}}"}}'
+`,
+	})
+
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	session := cauthtest.CreateNewUserSession(t, server)
+
+	_, err := svc.CreateRole(ctx, cauth.CreateRoleParams{Name: "member"})
+	assert.NoError(t, err)
+
+	_, err = svc.CreateRole(ctx, cauth.CreateRoleParams{Name: "owner", Permissions: []string{cauth.PermissionInviteUsers}})
+	assert.NoError(t, err)
+
+	org, err := svc.CreateOrganization(ctx, cauth.CreateOrganizationParams{
+		UserUUID: session.User.UUID,
+		Name:     `<a href="https://evil.example.com">Acme</a>`,
+	})
+	assert.NoError(t, err)
+
+	_, err = svc.CreateInvitation(ctx, cauth.CreateInvitationParams{
+		InviterUUID:      session.User.UUID,
+		Email:            "invited@example.com",
+		OrganizationUUID: &org.UUID,
+		Role:             "member",
+	})
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	body := *mailer.Last().HTMLBody
+	assert.NotContains(t, body, "<a")
+	assert.Contains(t, body, "&lt;a href=&#34;https://evil.example.com&#34;&gt;Acme&lt;/a&gt;")
+}
@@ -0,0 +1,186 @@
+package cauth
+
+import (
+	"context"
+	"time"
+
+	"github.com/gocopper/copper/cerrors"
+	"github.com/gocopper/copper/clifecycle"
+	"github.com/gocopper/copper/clogger"
+)
+
+const defaultJanitorBatchSize = 500
+
+// PurgeExpiredDataResult holds the number of rows deleted or cleared by PurgeExpiredData.
+type PurgeExpiredDataResult struct {
+	Sessions          int
+	VerificationCodes int
+	UnverifiedUsers   int
+	DeletedUsers      int
+	ExpiredTokens     int
+}
+
+// PurgeExpiredData deletes the data that cauth no longer needs:
+//   - Sessions that expired, or were logged out of, more than Config.SessionRetention ago.
+//   - Email and phone verification codes that expired. They are cleared from the users.
+//   - Users that signed up without a password and never verified their email or phone, once
+//     Config.UnverifiedUserRetention has passed since they signed up. They are kept if it is zero.
+//   - Deleted users whose grace period has passed, like PurgeDeletedUsers.
+//   - Expired challenges, OAuth states, magic links, pending email changes, refresh tokens and failed attempt
+//     counters.
+//
+// Rows are deleted Config.JanitorBatchSize at a time so that large tables are not locked for long. PurgeExpiredData
+// should not be called in a database transaction for the same reason. It is run periodically by the Janitor.
+func (s *Svc) PurgeExpiredData(ctx context.Context) (*PurgeExpiredDataResult, error) {
+	var (
+		result    PurgeExpiredDataResult
+		batchSize = s.config.JanitorBatchSize
+		now       = time.Now()
+	)
+
+	if batchSize <= 0 {
+		batchSize = defaultJanitorBatchSize
+	}
+
+	for {
+		sessionUUIDs, err := s.queries.ListSessionUUIDsExpiredBefore(ctx, now.Add(-s.config.SessionRetention), batchSize)
+		if err != nil {
+			return &result, cerrors.New(err, "failed to list expired sessions", nil)
+		}
+
+		if len(sessionUUIDs) > 0 {
+			err = s.queries.DeleteSessions(ctx, sessionUUIDs)
+			if err != nil {
+				return &result, cerrors.New(err, "failed to delete sessions", nil)
+			}
+		}
+
+		result.Sessions += len(sessionUUIDs)
+
+		if len(sessionUUIDs) < batchSize {
+			break
+		}
+	}
+
+	for {
+		userUUIDs, err := s.queries.ListUserUUIDsWithExpiredVerificationCodes(ctx, now, batchSize)
+		if err != nil {
+			return &result, cerrors.New(err, "failed to list users with expired verification codes", nil)
+		}
+
+		if len(userUUIDs) > 0 {
+			err = s.queries.ClearExpiredVerificationCodes(ctx, userUUIDs, now)
+			if err != nil {
+				return &result, cerrors.New(err, "failed to clear expired verification codes", nil)
+			}
+		}
+
+		result.VerificationCodes += len(userUUIDs)
+
+		if len(userUUIDs) < batchSize {
+			break
+		}
+	}
+
+	for s.config.UnverifiedUserRetention > 0 {
+		userUUIDs, err := s.queries.ListUnverifiedUserUUIDsCreatedBefore(ctx,
+			now.Add(-s.config.UnverifiedUserRetention), batchSize)
+		if err != nil {
+			return &result, cerrors.New(err, "failed to list unverified users", nil)
+		}
+
+		for _, userUUID := range userUUIDs {
+			err = s.purgeUser(ctx, userUUID)
+			if err != nil {
+				return &result, err
+			}
+
+			result.UnverifiedUsers++
+		}
+
+		if len(userUUIDs) < batchSize {
+			break
+		}
+	}
+
+	deletedUsers, err := s.PurgeDeletedUsers(ctx)
+	result.DeletedUsers = deletedUsers
+	if err != nil {
+		return &result, err
+	}
+
+	for {
+		deleted, err := s.queries.DeleteExpiredTokens(ctx, now, batchSize)
+		result.ExpiredTokens += deleted
+		if err != nil {
+			return &result, cerrors.New(err, "failed to delete expired tokens", nil)
+		}
+
+		if deleted == 0 {
+			break
+		}
+	}
+
+	return &result, nil
+}
+
+// NewJanitor instantiates and returns a Janitor.
+func NewJanitor(lc *clifecycle.Lifecycle, svc *Svc, config Config, logger clogger.Logger) *Janitor {
+	return &Janitor{
+		lc:     lc,
+		svc:    svc,
+		config: config,
+		logger: logger,
+	}
+}
+
+// Janitor runs Svc.PurgeExpiredData every Config.JanitorInterval in the background and logs the number of rows
+// that were purged. It must be started with Start.
+type Janitor struct {
+	lc     *clifecycle.Lifecycle
+	svc    *Svc
+	config Config
+	logger clogger.Logger
+}
+
+// Start purges expired data right away and then every Config.JanitorInterval until the app shuts down. It does
+// nothing if the interval is zero.
+func (j *Janitor) Start() {
+	if j.config.JanitorInterval <= 0 {
+		return
+	}
+
+	j.lc.Go(func(ctx context.Context) {
+		ticker := time.NewTicker(j.config.JanitorInterval)
+		defer ticker.Stop()
+
+		for {
+			j.run(ctx)
+
+			select {
+			case <-j.lc.Shutdown():
+				return
+			case <-ticker.C:
+			}
+		}
+	})
+}
+
+func (j *Janitor) run(ctx context.Context) {
+	result, err := j.svc.PurgeExpiredData(ctx)
+
+	logger := j.logger.WithTags(map[string]interface{}{
+		"sessions":          result.Sessions,
+		"verificationCodes": result.VerificationCodes,
+		"unverifiedUsers":   result.UnverifiedUsers,
+		"deletedUsers":      result.DeletedUsers,
+		"expiredTokens":     result.ExpiredTokens,
+	})
+
+	if err != nil {
+		logger.Error("Failed to purge expired auth data", err)
+		return
+	}
+
+	logger.Info("Purged expired auth data")
+}
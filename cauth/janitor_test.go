@@ -0,0 +1,63 @@
+package cauth_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/gocopper/pkg/cauth"
+	"github.com/gocopper/pkg/cauth/cauthtest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSvc_PurgeExpiredData(t *testing.T) {
+	t.Parallel()
+
+	var (
+		ctx      = context.Background()
+		password = "test-pass"
+	)
+
+	_, svc := cauthtest.NewHandlerAndSvc(t, cauthtest.HandlerParams{
+		Config: `
+[cauth]
+janitor_batch_size = 1
+session_retention = "0s"
+unverified_user_retention = "1ns"
+`,
+	})
+
+	verified, err := svc.Signup(ctx, cauth.SignupParams{Username: "verified", Password: &password})
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	for range 3 {
+		session, err := svc.Login(ctx, cauth.LoginParams{Username: "verified", Password: &password})
+		assert.NoError(t, err)
+		assert.NoError(t, svc.Logout(ctx, session.Session.UUID))
+	}
+
+	unverified, err := svc.Signup(ctx, cauth.SignupParams{Phone: "+14155550123"})
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	result, err := svc.PurgeExpiredData(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, &cauth.PurgeExpiredDataResult{
+		Sessions:        3,
+		UnverifiedUsers: 1,
+	}, result)
+
+	ok, _, err := svc.ValidateSession(ctx, verified.Session.UUID, verified.PlainSessionToken)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+
+	_, err = svc.GetUserByUUID(ctx, unverified.User.UUID)
+	assert.ErrorIs(t, err, cauth.ErrNotFound)
+
+	// The logged out sessions were deleted, so nothing is left to purge
+	result, err = svc.PurgeExpiredData(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, &cauth.PurgeExpiredDataResult{}, result)
+}
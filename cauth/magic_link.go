@@ -0,0 +1,211 @@
+package cauth
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/gocopper/copper/cerrors"
+	"github.com/gocopper/pkg/cmailer"
+	"github.com/google/uuid"
+)
+
+const magicLinkTTL = 15 * time.Minute
+
+// SendMagicLinkParams hold the params needed to send a magic link.
+type SendMagicLinkParams struct {
+	Email string `json:"email"`
+}
+
+// SendMagicLink emails the user a link that logs them in when opened. The link is signed with
+// Config.MagicLinkSigningKey, expires after 15 minutes and can only be used once. Requests are throttled per email,
+// whether or not it belongs to a user, and ErrInvalidCredentials is returned if it does not.
+func (s *Svc) SendMagicLink(ctx context.Context, p SendMagicLinkParams) error {
+	var (
+		linkSb      strings.Builder
+		emailBodySb strings.Builder
+	)
+
+	if s.config.MagicLinkSigningKey == "" {
+		return cerrors.New(nil, "magic link signing key is not configured", nil)
+	}
+
+	err := s.throttleEmail(ctx, attemptKeyMagicLink(p.Email))
+	if err != nil {
+		return err
+	}
+
+	user, err := s.queries.GetUserByEmail(ctx, p.Email)
+	if err != nil && errors.Is(err, ErrNotFound) {
+		return ErrInvalidCredentials
+	} else if err != nil {
+		return cerrors.New(err, "failed to get user by email", map[string]interface{}{
+			"email": p.Email,
+		})
+	}
+
+	magicLink := &MagicLink{
+		UUID:      uuid.New().String(),
+		CreatedAt: time.Now(),
+		UpdatedAt: time.Now(),
+		UserUUID:  user.UUID,
+		ExpiresAt: time.Now().Add(magicLinkTTL),
+	}
+
+	err = s.queries.InsertMagicLink(ctx, magicLink)
+	if err != nil {
+		return cerrors.New(err, "failed to insert magic link", map[string]interface{}{
+			"userUUID": user.UUID,
+		})
+	}
+
+	linkTmpl, err := template.New("magic_link_url").Parse(s.config.MagicLinkURLTemplate)
+	if err != nil {
+		return cerrors.New(err, "failed to parse magic link url template", nil)
+	}
+
+	err = linkTmpl.Execute(&linkSb, map[string]string{
+		"Token": s.signMagicLinkToken(magicLink.UUID),
+	})
+	if err != nil {
+		return cerrors.New(err, "failed to execute magic link url template", nil)
+	}
+
+	bodyTmpl, err := template.New("email_magic_link").Parse(s.config.MagicLinkEmailBodyHTML)
+	if err != nil {
+		return cerrors.New(err, "failed to parse magic link email template", nil)
+	}
+
+	err = bodyTmpl.Execute(&emailBodySb, map[string]string{
+		"MagicLink": linkSb.String(),
+	})
+	if err != nil {
+		return cerrors.New(err, "failed to execute magic link email template", nil)
+	}
+
+	emailBody := emailBodySb.String()
+
+	err = s.mailer.Send(ctx, cmailer.SendParams{
+		From:     s.config.VerificationEmailFrom,
+		To:       []string{user.Email},
+		Subject:  s.config.MagicLinkEmailSubject,
+		HTMLBody: &emailBody,
+	})
+	if err != nil {
+		return cerrors.New(err, "failed to send magic link email", map[string]interface{}{
+			"to": user.Email,
+		})
+	}
+
+	return nil
+}
+
+// LoginWithMagicLink logs in the user that the given magic link token was sent to. Since opening the link proves
+// that the user owns the email, their email is marked as verified. If the user has two-factor authentication
+// enabled, a pending TwoFactorChallenge is returned instead of a session.
+func (s *Svc) LoginWithMagicLink(ctx context.Context, token string) (*SessionResult, error) {
+	magicLinkUUID, ok := s.verifyMagicLinkToken(token)
+	if !ok {
+		return nil, ErrInvalidCredentials
+	}
+
+	magicLink, err := s.queries.GetMagicLink(ctx, magicLinkUUID)
+	if err != nil && errors.Is(err, ErrNotFound) {
+		return nil, ErrInvalidCredentials
+	} else if err != nil {
+		return nil, cerrors.New(err, "failed to get magic link", map[string]interface{}{
+			"magicLinkUUID": magicLinkUUID,
+		})
+	}
+
+	if time.Now().After(magicLink.ExpiresAt) {
+		return nil, ErrVerificationCodeExpired
+	}
+
+	// The link is marked as used with a conditional update so that two concurrent requests with the same link
+	// cannot both login.
+	used, err := s.queries.MarkMagicLinkUsed(ctx, magicLink.UUID, time.Now())
+	if err != nil {
+		return nil, cerrors.New(err, "failed to mark magic link as used", map[string]interface{}{
+			"magicLinkUUID": magicLink.UUID,
+		})
+	} else if !used {
+		return nil, ErrInvalidCredentials
+	}
+
+	user, err := s.queries.GetUserByUUID(ctx, magicLink.UserUUID)
+	if err != nil {
+		return nil, cerrors.New(err, "failed to get user by uuid", map[string]interface{}{
+			"userUUID": magicLink.UserUUID,
+		})
+	}
+
+	if user.EmailVerifiedAt == nil {
+		user.UpdatedAt = time.Now()
+		user.EmailVerifiedAt = &user.UpdatedAt
+
+		err = s.queries.UpdateUser(ctx, user)
+		if err != nil {
+			return nil, cerrors.New(err, "failed to update user", map[string]interface{}{
+				"userUUID": user.UUID,
+			})
+		}
+	}
+
+	err = s.resetAttempts(ctx, attemptKeyMagicLink(user.Email))
+	if err != nil {
+		return nil, err
+	}
+
+	sessionResult, err := s.loginUser(ctx, user, false)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.recordLogin(ctx, loginMethodMagicLink, sessionResult)
+	if err != nil {
+		return nil, err
+	}
+
+	return sessionResult, nil
+}
+
+// signMagicLinkToken returns a token of the form <uuid>.<signature> where the signature is an HMAC-SHA256 of the
+// magic link's uuid.
+func (s *Svc) signMagicLinkToken(magicLinkUUID string) string {
+	return magicLinkUUID + "." + base64.RawURLEncoding.EncodeToString(s.magicLinkSignature(magicLinkUUID))
+}
+
+func (s *Svc) verifyMagicLinkToken(token string) (string, bool) {
+	if s.config.MagicLinkSigningKey == "" {
+		return "", false
+	}
+
+	magicLinkUUID, encodedSig, ok := strings.Cut(token, ".")
+	if !ok {
+		return "", false
+	}
+
+	sig, err := base64.RawURLEncoding.DecodeString(encodedSig)
+	if err != nil {
+		return "", false
+	}
+
+	if !hmac.Equal(sig, s.magicLinkSignature(magicLinkUUID)) {
+		return "", false
+	}
+
+	return magicLinkUUID, true
+}
+
+func (s *Svc) magicLinkSignature(magicLinkUUID string) []byte {
+	mac := hmac.New(sha256.New, []byte(s.config.MagicLinkSigningKey))
+	_, _ = mac.Write([]byte("cauth-magic-link:" + magicLinkUUID))
+
+	return mac.Sum(nil)
+}
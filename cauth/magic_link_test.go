@@ -0,0 +1,129 @@
+package cauth_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"testing"
+
+	"github.com/gocopper/pkg/cauth/cauthtest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRouter_MagicLink(t *testing.T) {
+	t.Parallel()
+
+	var (
+		mailer     = cauthtest.NewMailer()
+		hrefRegexp = regexp.MustCompile(`href="([^"]+)"`)
+	)
+
+	server := httptest.NewServer(cauthtest.NewHandlerWithParams(t, cauthtest.HandlerParams{
+		Config: `
+[cauth]
+magic_link_signing_key = "test-signing-key"
+`,
+		Mailer: mailer,
+	}))
+	defer server.Close()
+
+	get := func(u string) *http.Response {
+		req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, u, nil)
+		assert.NoError(t, err)
+
+		resp, err := http.DefaultClient.Do(req)
+		assert.NoError(t, err)
+		t.Cleanup(func() { _ = resp.Body.Close() })
+
+		return resp
+	}
+
+	resp := postJSON(t, server.URL+"/api/auth/signup", `{"email": "magic@example.com", "password": "test-pass"}`, nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	// Unknown emails get the same response, but no email
+	sentBefore := len(mailer.Sent())
+
+	resp = postJSON(t, server.URL+"/api/auth/magic-link", `{"email": "unknown@example.com"}`, nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Len(t, mailer.Sent(), sentBefore)
+
+	resp = postJSON(t, server.URL+"/api/auth/magic-link", `{"email": "magic@example.com"}`, nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	email := mailer.Last()
+	assert.NotNil(t, email)
+	assert.Equal(t, []string{"magic@example.com"}, email.To)
+
+	match := hrefRegexp.FindStringSubmatch(*email.HTMLBody)
+	assert.Len(t, match, 2)
+
+	link, err := url.Parse(match[1])
+	assert.NoError(t, err)
+	assert.Equal(t, "/api/auth/magic-link/callback", link.Path)
+
+	token := link.Query().Get("token")
+	callbackURL := server.URL + link.Path + "?" + url.Values{"token": []string{token}}.Encode()
+
+	// A token with a tampered signature is rejected
+	resp = get(server.URL + link.Path + "?" + url.Values{"token": []string{token + "x"}}.Encode())
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	resp = get(callbackURL)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	cookies := make(map[string]string)
+	for _, c := range resp.Cookies() {
+		cookies[c.Name] = c.Value
+	}
+
+	assert.NotEmpty(t, cookies["SessionUUID"])
+	assert.NotEmpty(t, cookies["SessionToken"])
+
+	// The link cannot be reused
+	resp = get(callbackURL)
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+}
+
+func TestRouter_MagicLink_TooManyRequests(t *testing.T) {
+	t.Parallel()
+
+	mailer := cauthtest.NewMailer()
+
+	server := httptest.NewServer(cauthtest.NewHandlerWithParams(t, cauthtest.HandlerParams{
+		Config: `
+[cauth]
+magic_link_signing_key = "test-signing-key"
+login_free_attempts = 2
+login_max_attempts = 3
+login_backoff_base = "1h"
+`,
+		Mailer: mailer,
+	}))
+	defer server.Close()
+
+	resp := postJSON(t, server.URL+"/api/auth/signup", `{"email": "magic@example.com", "password": "test-pass"}`, nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	sentBefore := len(mailer.Sent())
+
+	for range 2 {
+		resp = postJSON(t, server.URL+"/api/auth/magic-link", `{"email": "magic@example.com"}`, nil)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+	}
+
+	resp = postJSON(t, server.URL+"/api/auth/magic-link", `{"email": "MAGIC@example.com"}`, nil)
+	assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+	assert.Len(t, mailer.Sent(), sentBefore+2)
+
+	// Unknown emails are throttled the same way
+	for range 2 {
+		resp = postJSON(t, server.URL+"/api/auth/magic-link", `{"email": "unknown@example.com"}`, nil)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+	}
+
+	resp = postJSON(t, server.URL+"/api/auth/magic-link", `{"email": "unknown@example.com"}`, nil)
+	assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+}
@@ -1,6 +1,52 @@
 package cauth
 
-import "embed"
+import (
+	"embed"
 
-//go:embed migrations.sqlite.sql
+	"github.com/gocopper/copper/cerrors"
+	"github.com/gocopper/copper/csql"
+)
+
+// Dialects of the databases that cauth supports. They match the driver names used in csql.Config.
+const (
+	dialectSQLite   = "sqlite3"
+	dialectPostgres = "postgres"
+	dialectPgx      = "pgx"
+	dialectMySQL    = "mysql"
+)
+
+// SQLiteMigrations hold the migrations that create the cauth tables in SQLite databases.
+//
+//go:embed migrations.sqlite.sql migrations.sqlite.v*.sql
 var SQLiteMigrations embed.FS
+
+// PostgresMigrations hold the migrations that create the cauth tables in Postgres databases.
+//
+//go:embed migrations.postgres.sql migrations.postgres.v*.sql
+var PostgresMigrations embed.FS
+
+// MySQLMigrations hold the migrations that create the cauth tables in MySQL databases. MySQL 8.0.19 or later is
+// required. The DSN must set parseTime=true so that timestamps can be read into time.Time.
+//
+//go:embed migrations.mysql.sql migrations.mysql.v*.sql
+var MySQLMigrations embed.FS
+
+// MigrationsForDialect returns the migrations that create the cauth tables for the given csql dialect.
+//
+// Each dialect starts with migrations.<dialect>.sql, which creates the original tables. Every later schema change is
+// a separate migration named migrations.<dialect>.vNNN_<change>.sql, which sorts after the original one so that
+// databases that already ran it only apply the new changes. Migrations must not be edited once they are released.
+func MigrationsForDialect(dialect string) (csql.Migrations, error) {
+	switch dialect {
+	case dialectSQLite:
+		return csql.Migrations(SQLiteMigrations), nil
+	case dialectPostgres, dialectPgx:
+		return csql.Migrations(PostgresMigrations), nil
+	case dialectMySQL:
+		return csql.Migrations(MySQLMigrations), nil
+	default:
+		return csql.Migrations{}, cerrors.New(nil, "unsupported dialect", map[string]interface{}{
+			"dialect": dialect,
+		})
+	}
+}
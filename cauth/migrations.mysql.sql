@@ -0,0 +1,28 @@
+-- +migrate Up
+-- Tables use a binary collation so that comparisons are case-sensitive like they are in SQLite and Postgres
+CREATE TABLE IF NOT EXISTS cauth_users
+(
+    uuid                         VARCHAR(255) PRIMARY KEY,
+    created_at                   DATETIME(6)  NOT NULL,
+    updated_at                   DATETIME(6)  NOT NULL,
+    email                        VARCHAR(255) UNIQUE,
+    email_verified_at            DATETIME(6),
+    verification_code            VARCHAR(255),
+    verification_code_expires_at DATETIME(6),
+    password                     BLOB
+) DEFAULT CHARSET = utf8mb4 COLLATE = utf8mb4_bin;
+
+CREATE TABLE IF NOT EXISTS cauth_sessions
+(
+    uuid                   VARCHAR(255) PRIMARY KEY,
+    created_at             DATETIME(6)  NOT NULL,
+    updated_at             DATETIME(6)  NOT NULL,
+    user_uuid              VARCHAR(255) NOT NULL,
+    impersonated_user_uuid VARCHAR(255),
+    token                  BLOB         NOT NULL,
+    expires_at             DATETIME(6)  NOT NULL
+) DEFAULT CHARSET = utf8mb4 COLLATE = utf8mb4_bin;
+
+-- +migrate Down
+DROP TABLE IF EXISTS cauth_sessions;
+DROP TABLE IF EXISTS cauth_users;
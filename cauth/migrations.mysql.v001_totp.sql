@@ -0,0 +1,31 @@
+-- +migrate Up
+ALTER TABLE cauth_users ADD COLUMN totp_secret VARCHAR(255);
+ALTER TABLE cauth_users ADD COLUMN totp_enabled_at DATETIME(6);
+ALTER TABLE cauth_users ADD COLUMN totp_last_used_step BIGINT;
+
+CREATE TABLE IF NOT EXISTS cauth_backup_codes
+(
+    uuid       VARCHAR(255) PRIMARY KEY,
+    created_at DATETIME(6)  NOT NULL,
+    user_uuid  VARCHAR(255) NOT NULL,
+    code       BLOB         NOT NULL,
+    used_at    DATETIME(6),
+    INDEX cauth_backup_codes_user_uuid_idx (user_uuid)
+) DEFAULT CHARSET = utf8mb4 COLLATE = utf8mb4_bin;
+
+CREATE TABLE IF NOT EXISTS cauth_two_factor_challenges
+(
+    uuid       VARCHAR(255) PRIMARY KEY,
+    created_at DATETIME(6)  NOT NULL,
+    updated_at DATETIME(6)  NOT NULL,
+    user_uuid  VARCHAR(255) NOT NULL,
+    token      BLOB         NOT NULL,
+    expires_at DATETIME(6)  NOT NULL
+) DEFAULT CHARSET = utf8mb4 COLLATE = utf8mb4_bin;
+
+-- +migrate Down
+DROP TABLE IF EXISTS cauth_two_factor_challenges;
+DROP TABLE IF EXISTS cauth_backup_codes;
+ALTER TABLE cauth_users DROP COLUMN totp_last_used_step;
+ALTER TABLE cauth_users DROP COLUMN totp_enabled_at;
+ALTER TABLE cauth_users DROP COLUMN totp_secret;
@@ -0,0 +1,29 @@
+-- +migrate Up
+CREATE TABLE IF NOT EXISTS cauth_webauthn_credentials
+(
+    uuid          VARCHAR(255)    PRIMARY KEY,
+    created_at    DATETIME(6)     NOT NULL,
+    updated_at    DATETIME(6)     NOT NULL,
+    user_uuid     VARCHAR(255)    NOT NULL,
+    name          VARCHAR(255)    NOT NULL,
+    credential_id VARBINARY(1023) NOT NULL UNIQUE,
+    public_key    BLOB            NOT NULL,
+    sign_count    BIGINT          NOT NULL,
+    last_used_at  DATETIME(6),
+    INDEX cauth_webauthn_credentials_user_uuid_idx (user_uuid)
+) DEFAULT CHARSET = utf8mb4 COLLATE = utf8mb4_bin;
+
+CREATE TABLE IF NOT EXISTS cauth_webauthn_challenges
+(
+    uuid       VARCHAR(255) PRIMARY KEY,
+    created_at DATETIME(6)  NOT NULL,
+    updated_at DATETIME(6)  NOT NULL,
+    user_uuid  VARCHAR(255),
+    ceremony   VARCHAR(255) NOT NULL,
+    challenge  BLOB         NOT NULL,
+    expires_at DATETIME(6)  NOT NULL
+) DEFAULT CHARSET = utf8mb4 COLLATE = utf8mb4_bin;
+
+-- +migrate Down
+DROP TABLE IF EXISTS cauth_webauthn_challenges;
+DROP TABLE IF EXISTS cauth_webauthn_credentials;
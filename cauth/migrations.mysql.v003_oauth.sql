@@ -0,0 +1,28 @@
+-- +migrate Up
+CREATE TABLE IF NOT EXISTS cauth_identities
+(
+    uuid       VARCHAR(255) PRIMARY KEY,
+    created_at DATETIME(6)  NOT NULL,
+    updated_at DATETIME(6)  NOT NULL,
+    user_uuid  VARCHAR(255) NOT NULL,
+    provider   VARCHAR(255) NOT NULL,
+    subject    VARCHAR(255) NOT NULL,
+    email      VARCHAR(255) NOT NULL,
+    UNIQUE (provider, subject),
+    INDEX cauth_identities_user_uuid_idx (user_uuid)
+) DEFAULT CHARSET = utf8mb4 COLLATE = utf8mb4_bin;
+
+CREATE TABLE IF NOT EXISTS cauth_oauth_states
+(
+    uuid          VARCHAR(255) PRIMARY KEY,
+    created_at    DATETIME(6)  NOT NULL,
+    updated_at    DATETIME(6)  NOT NULL,
+    provider      VARCHAR(255) NOT NULL,
+    state         VARCHAR(255) NOT NULL UNIQUE,
+    code_verifier VARCHAR(255) NOT NULL,
+    expires_at    DATETIME(6)  NOT NULL
+) DEFAULT CHARSET = utf8mb4 COLLATE = utf8mb4_bin;
+
+-- +migrate Down
+DROP TABLE IF EXISTS cauth_oauth_states;
+DROP TABLE IF EXISTS cauth_identities;
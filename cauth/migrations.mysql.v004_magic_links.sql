@@ -0,0 +1,13 @@
+-- +migrate Up
+CREATE TABLE IF NOT EXISTS cauth_magic_links
+(
+    uuid       VARCHAR(255) PRIMARY KEY,
+    created_at DATETIME(6)  NOT NULL,
+    updated_at DATETIME(6)  NOT NULL,
+    user_uuid  VARCHAR(255) NOT NULL,
+    expires_at DATETIME(6)  NOT NULL,
+    used_at    DATETIME(6)
+) DEFAULT CHARSET = utf8mb4 COLLATE = utf8mb4_bin;
+
+-- +migrate Down
+DROP TABLE IF EXISTS cauth_magic_links;
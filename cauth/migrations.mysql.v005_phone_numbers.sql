@@ -0,0 +1,32 @@
+-- +migrate Up
+-- Users may signup with either an email or a phone number, so email is no longer nullable and uniqueness is only
+-- enforced on non-empty values. MySQL does not support partial indexes, so empty values are indexed as NULL instead.
+-- Since lookups don't match the indexed expressions, the columns are also indexed as they are.
+DROP INDEX email ON cauth_users;
+UPDATE cauth_users SET email = '' WHERE email IS NULL;
+ALTER TABLE cauth_users MODIFY COLUMN email VARCHAR(255) NOT NULL;
+
+ALTER TABLE cauth_users ADD COLUMN phone VARCHAR(255) NOT NULL DEFAULT '';
+ALTER TABLE cauth_users ADD COLUMN phone_verified_at DATETIME(6);
+ALTER TABLE cauth_users ADD COLUMN phone_verification_code VARCHAR(255);
+ALTER TABLE cauth_users ADD COLUMN phone_verification_code_expires_at DATETIME(6);
+
+CREATE UNIQUE INDEX cauth_users_email_idx ON cauth_users ((NULLIF(email, '')));
+CREATE UNIQUE INDEX cauth_users_phone_idx ON cauth_users ((NULLIF(phone, '')));
+CREATE INDEX cauth_users_email_lookup_idx ON cauth_users (email);
+CREATE INDEX cauth_users_phone_lookup_idx ON cauth_users (phone);
+
+-- +migrate Down
+DROP INDEX cauth_users_phone_lookup_idx ON cauth_users;
+DROP INDEX cauth_users_email_lookup_idx ON cauth_users;
+DROP INDEX cauth_users_phone_idx ON cauth_users;
+DROP INDEX cauth_users_email_idx ON cauth_users;
+
+ALTER TABLE cauth_users DROP COLUMN phone_verification_code_expires_at;
+ALTER TABLE cauth_users DROP COLUMN phone_verification_code;
+ALTER TABLE cauth_users DROP COLUMN phone_verified_at;
+ALTER TABLE cauth_users DROP COLUMN phone;
+
+ALTER TABLE cauth_users MODIFY COLUMN email VARCHAR(255);
+UPDATE cauth_users SET email = NULL WHERE email = '';
+CREATE UNIQUE INDEX email ON cauth_users (email);
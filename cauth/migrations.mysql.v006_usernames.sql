@@ -0,0 +1,21 @@
+-- +migrate Up
+ALTER TABLE cauth_users ADD COLUMN username VARCHAR(255) NOT NULL DEFAULT '';
+
+-- Users may also signup with a username, so it is indexed like the email and phone
+CREATE UNIQUE INDEX cauth_users_username_idx ON cauth_users ((NULLIF(username, '')));
+CREATE INDEX cauth_users_username_lookup_idx ON cauth_users (username);
+
+CREATE TABLE IF NOT EXISTS cauth_username_history
+(
+    uuid       VARCHAR(255) PRIMARY KEY,
+    created_at DATETIME(6)  NOT NULL,
+    user_uuid  VARCHAR(255) NOT NULL,
+    username   VARCHAR(255) NOT NULL,
+    INDEX cauth_username_history_username_idx (username)
+) DEFAULT CHARSET = utf8mb4 COLLATE = utf8mb4_bin;
+
+-- +migrate Down
+DROP TABLE IF EXISTS cauth_username_history;
+DROP INDEX cauth_users_username_lookup_idx ON cauth_users;
+DROP INDEX cauth_users_username_idx ON cauth_users;
+ALTER TABLE cauth_users DROP COLUMN username;
@@ -0,0 +1,16 @@
+-- +migrate Up
+ALTER TABLE cauth_sessions ADD COLUMN user_agent VARCHAR(1024) NOT NULL DEFAULT '';
+ALTER TABLE cauth_sessions ADD COLUMN ip VARCHAR(255) NOT NULL DEFAULT '';
+
+-- Existing sessions were last seen when they were updated
+ALTER TABLE cauth_sessions ADD COLUMN last_seen_at DATETIME(6);
+UPDATE cauth_sessions SET last_seen_at = updated_at;
+ALTER TABLE cauth_sessions MODIFY COLUMN last_seen_at DATETIME(6) NOT NULL;
+
+CREATE INDEX cauth_sessions_user_uuid_idx ON cauth_sessions (user_uuid);
+
+-- +migrate Down
+DROP INDEX cauth_sessions_user_uuid_idx ON cauth_sessions;
+ALTER TABLE cauth_sessions DROP COLUMN last_seen_at;
+ALTER TABLE cauth_sessions DROP COLUMN ip;
+ALTER TABLE cauth_sessions DROP COLUMN user_agent;
@@ -0,0 +1,7 @@
+-- +migrate Up
+ALTER TABLE cauth_sessions ADD COLUMN remember_me BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE cauth_two_factor_challenges ADD COLUMN remember_me BOOLEAN NOT NULL DEFAULT FALSE;
+
+-- +migrate Down
+ALTER TABLE cauth_two_factor_challenges DROP COLUMN remember_me;
+ALTER TABLE cauth_sessions DROP COLUMN remember_me;
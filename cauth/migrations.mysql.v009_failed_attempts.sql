@@ -0,0 +1,13 @@
+-- +migrate Up
+CREATE TABLE IF NOT EXISTS cauth_failed_attempts
+(
+    attempt_key    VARCHAR(255) PRIMARY KEY,
+    created_at     DATETIME(6)  NOT NULL,
+    updated_at     DATETIME(6)  NOT NULL,
+    count          BIGINT       NOT NULL,
+    last_failed_at DATETIME(6)  NOT NULL,
+    expires_at     DATETIME(6)  NOT NULL
+) DEFAULT CHARSET = utf8mb4 COLLATE = utf8mb4_bin;
+
+-- +migrate Down
+DROP TABLE IF EXISTS cauth_failed_attempts;
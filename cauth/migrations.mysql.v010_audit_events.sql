@@ -0,0 +1,18 @@
+-- +migrate Up
+CREATE TABLE IF NOT EXISTS cauth_audit_events
+(
+    uuid         VARCHAR(255)  PRIMARY KEY,
+    created_at   DATETIME(6)   NOT NULL,
+    type         VARCHAR(255)  NOT NULL,
+    user_uuid    VARCHAR(255)  NOT NULL DEFAULT '',
+    session_uuid VARCHAR(255)  NOT NULL DEFAULT '',
+    ip           VARCHAR(255)  NOT NULL DEFAULT '',
+    user_agent   VARCHAR(1024) NOT NULL DEFAULT '',
+    request_id   VARCHAR(255)  NOT NULL DEFAULT '',
+    metadata     JSON          NOT NULL DEFAULT ('{}'),
+    INDEX cauth_audit_events_user_uuid_idx (user_uuid, created_at),
+    INDEX cauth_audit_events_type_idx (type, created_at)
+) DEFAULT CHARSET = utf8mb4 COLLATE = utf8mb4_bin;
+
+-- +migrate Down
+DROP TABLE IF EXISTS cauth_audit_events;
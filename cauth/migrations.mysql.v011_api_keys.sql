@@ -0,0 +1,19 @@
+-- +migrate Up
+CREATE TABLE IF NOT EXISTS cauth_api_keys
+(
+    uuid         VARCHAR(255)   PRIMARY KEY,
+    created_at   DATETIME(6)    NOT NULL,
+    updated_at   DATETIME(6)    NOT NULL,
+    user_uuid    VARCHAR(255)   NOT NULL,
+    name         VARCHAR(255)   NOT NULL,
+    prefix       VARCHAR(255)   NOT NULL,
+    key_hash     VARBINARY(255) NOT NULL UNIQUE,
+    scopes       VARCHAR(1024)  NOT NULL DEFAULT '',
+    expires_at   DATETIME(6),
+    last_used_at DATETIME(6),
+    revoked_at   DATETIME(6),
+    INDEX cauth_api_keys_user_uuid_idx (user_uuid)
+) DEFAULT CHARSET = utf8mb4 COLLATE = utf8mb4_bin;
+
+-- +migrate Down
+DROP TABLE IF EXISTS cauth_api_keys;
@@ -0,0 +1,31 @@
+-- +migrate Up
+CREATE TABLE IF NOT EXISTS cauth_roles
+(
+    uuid        VARCHAR(255)  PRIMARY KEY,
+    created_at  DATETIME(6)   NOT NULL,
+    updated_at  DATETIME(6)   NOT NULL,
+    name        VARCHAR(255)  NOT NULL UNIQUE,
+    description VARCHAR(1024) NOT NULL DEFAULT ''
+) DEFAULT CHARSET = utf8mb4 COLLATE = utf8mb4_bin;
+
+CREATE TABLE IF NOT EXISTS cauth_role_permissions
+(
+    role_uuid  VARCHAR(255) NOT NULL,
+    permission VARCHAR(255) NOT NULL,
+    created_at DATETIME(6)  NOT NULL,
+    PRIMARY KEY (role_uuid, permission)
+) DEFAULT CHARSET = utf8mb4 COLLATE = utf8mb4_bin;
+
+CREATE TABLE IF NOT EXISTS cauth_user_roles
+(
+    user_uuid  VARCHAR(255) NOT NULL,
+    role_uuid  VARCHAR(255) NOT NULL,
+    created_at DATETIME(6)  NOT NULL,
+    PRIMARY KEY (user_uuid, role_uuid),
+    INDEX cauth_user_roles_role_uuid_idx (role_uuid)
+) DEFAULT CHARSET = utf8mb4 COLLATE = utf8mb4_bin;
+
+-- +migrate Down
+DROP TABLE IF EXISTS cauth_user_roles;
+DROP TABLE IF EXISTS cauth_role_permissions;
+DROP TABLE IF EXISTS cauth_roles;
@@ -0,0 +1,26 @@
+-- +migrate Up
+CREATE TABLE IF NOT EXISTS cauth_organizations
+(
+    uuid       VARCHAR(255) PRIMARY KEY,
+    created_at DATETIME(6)  NOT NULL,
+    updated_at DATETIME(6)  NOT NULL,
+    name       VARCHAR(255) NOT NULL
+) DEFAULT CHARSET = utf8mb4 COLLATE = utf8mb4_bin;
+
+CREATE TABLE IF NOT EXISTS cauth_memberships
+(
+    organization_uuid VARCHAR(255) NOT NULL,
+    user_uuid         VARCHAR(255) NOT NULL,
+    created_at        DATETIME(6)  NOT NULL,
+    updated_at        DATETIME(6)  NOT NULL,
+    role              VARCHAR(255) NOT NULL,
+    PRIMARY KEY (organization_uuid, user_uuid),
+    INDEX cauth_memberships_user_uuid_idx (user_uuid)
+) DEFAULT CHARSET = utf8mb4 COLLATE = utf8mb4_bin;
+
+ALTER TABLE cauth_sessions ADD COLUMN active_organization_uuid VARCHAR(255);
+
+-- +migrate Down
+ALTER TABLE cauth_sessions DROP COLUMN active_organization_uuid;
+DROP TABLE IF EXISTS cauth_memberships;
+DROP TABLE IF EXISTS cauth_organizations;
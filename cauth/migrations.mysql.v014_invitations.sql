@@ -0,0 +1,19 @@
+-- +migrate Up
+CREATE TABLE IF NOT EXISTS cauth_invitations
+(
+    uuid              VARCHAR(255)   PRIMARY KEY,
+    created_at        DATETIME(6)    NOT NULL,
+    updated_at        DATETIME(6)    NOT NULL,
+    inviter_uuid      VARCHAR(255)   NOT NULL,
+    email             VARCHAR(255)   NOT NULL,
+    organization_uuid VARCHAR(255),
+    role              VARCHAR(255)   NOT NULL DEFAULT '',
+    token_hash        VARBINARY(255) NOT NULL UNIQUE,
+    expires_at        DATETIME(6)    NOT NULL,
+    accepted_at       DATETIME(6),
+    revoked_at        DATETIME(6),
+    INDEX cauth_invitations_inviter_uuid_idx (inviter_uuid)
+) DEFAULT CHARSET = utf8mb4 COLLATE = utf8mb4_bin;
+
+-- +migrate Down
+DROP TABLE IF EXISTS cauth_invitations;
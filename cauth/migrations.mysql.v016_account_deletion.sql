@@ -0,0 +1,8 @@
+-- +migrate Up
+ALTER TABLE cauth_users ADD COLUMN deleted_at DATETIME(6);
+
+CREATE INDEX cauth_users_deleted_at_idx ON cauth_users (deleted_at);
+
+-- +migrate Down
+DROP INDEX cauth_users_deleted_at_idx ON cauth_users;
+ALTER TABLE cauth_users DROP COLUMN deleted_at;
@@ -0,0 +1,16 @@
+-- +migrate Up
+CREATE TABLE IF NOT EXISTS cauth_refresh_tokens
+(
+    uuid         VARCHAR(255)   PRIMARY KEY,
+    created_at   DATETIME(6)    NOT NULL,
+    updated_at   DATETIME(6)    NOT NULL,
+    session_uuid VARCHAR(255)   NOT NULL,
+    user_uuid    VARCHAR(255)   NOT NULL,
+    token_hash   VARBINARY(255) NOT NULL UNIQUE,
+    expires_at   DATETIME(6)    NOT NULL,
+    used_at      DATETIME(6),
+    INDEX cauth_refresh_tokens_user_uuid_idx (user_uuid)
+) DEFAULT CHARSET = utf8mb4 COLLATE = utf8mb4_bin;
+
+-- +migrate Down
+DROP TABLE IF EXISTS cauth_refresh_tokens;
@@ -0,0 +1,32 @@
+-- +migrate Up
+alter table cauth_users add column totp_secret text;
+alter table cauth_users add column totp_enabled_at timestamp with time zone;
+alter table cauth_users add column totp_last_used_step bigint;
+
+create table if not exists cauth_backup_codes
+(
+    uuid       text primary key,
+    created_at timestamp with time zone not null,
+    user_uuid  text                     not null,
+    code       bytea                    not null,
+    used_at    timestamp with time zone
+);
+
+create index if not exists cauth_backup_codes_user_uuid_idx on cauth_backup_codes (user_uuid);
+
+create table if not exists cauth_two_factor_challenges
+(
+    uuid       text primary key,
+    created_at timestamp with time zone not null,
+    updated_at timestamp with time zone not null,
+    user_uuid  text                     not null,
+    token      bytea                    not null,
+    expires_at timestamp with time zone not null
+);
+
+-- +migrate Down
+drop table if exists cauth_two_factor_challenges;
+drop table if exists cauth_backup_codes;
+alter table cauth_users drop column totp_last_used_step;
+alter table cauth_users drop column totp_enabled_at;
+alter table cauth_users drop column totp_secret;
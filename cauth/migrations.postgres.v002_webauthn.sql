@@ -0,0 +1,30 @@
+-- +migrate Up
+create table if not exists cauth_webauthn_credentials
+(
+    uuid          text primary key,
+    created_at    timestamp with time zone not null,
+    updated_at    timestamp with time zone not null,
+    user_uuid     text                     not null,
+    name          text                     not null,
+    credential_id bytea                    not null unique,
+    public_key    bytea                    not null,
+    sign_count    bigint                   not null,
+    last_used_at  timestamp with time zone
+);
+
+create index if not exists cauth_webauthn_credentials_user_uuid_idx on cauth_webauthn_credentials (user_uuid);
+
+create table if not exists cauth_webauthn_challenges
+(
+    uuid       text primary key,
+    created_at timestamp with time zone not null,
+    updated_at timestamp with time zone not null,
+    user_uuid  text,
+    ceremony   text                     not null,
+    challenge  bytea                    not null,
+    expires_at timestamp with time zone not null
+);
+
+-- +migrate Down
+drop table if exists cauth_webauthn_challenges;
+drop table if exists cauth_webauthn_credentials;
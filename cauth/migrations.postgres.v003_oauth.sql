@@ -0,0 +1,29 @@
+-- +migrate Up
+create table if not exists cauth_identities
+(
+    uuid       text primary key,
+    created_at timestamp with time zone not null,
+    updated_at timestamp with time zone not null,
+    user_uuid  text                     not null,
+    provider   text                     not null,
+    subject    text                     not null,
+    email      text                     not null,
+    unique (provider, subject)
+);
+
+create index if not exists cauth_identities_user_uuid_idx on cauth_identities (user_uuid);
+
+create table if not exists cauth_oauth_states
+(
+    uuid          text primary key,
+    created_at    timestamp with time zone not null,
+    updated_at    timestamp with time zone not null,
+    provider      text                     not null,
+    state         text                     not null unique,
+    code_verifier text                     not null,
+    expires_at    timestamp with time zone not null
+);
+
+-- +migrate Down
+drop table if exists cauth_oauth_states;
+drop table if exists cauth_identities;
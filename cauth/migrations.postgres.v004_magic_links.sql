@@ -0,0 +1,13 @@
+-- +migrate Up
+create table if not exists cauth_magic_links
+(
+    uuid       text primary key,
+    created_at timestamp with time zone not null,
+    updated_at timestamp with time zone not null,
+    user_uuid  text                     not null,
+    expires_at timestamp with time zone not null,
+    used_at    timestamp with time zone
+);
+
+-- +migrate Down
+drop table if exists cauth_magic_links;
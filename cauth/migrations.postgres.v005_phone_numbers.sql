@@ -0,0 +1,27 @@
+-- +migrate Up
+-- Users may signup with either an email or a phone number, so email is no longer nullable and uniqueness is only
+-- enforced on non-empty values
+alter table cauth_users drop constraint if exists cauth_users_email_key;
+update cauth_users set email = '' where email is null;
+alter table cauth_users alter column email set not null;
+
+alter table cauth_users add column phone text not null default '';
+alter table cauth_users add column phone_verified_at timestamp with time zone;
+alter table cauth_users add column phone_verification_code text;
+alter table cauth_users add column phone_verification_code_expires_at timestamp with time zone;
+
+create unique index if not exists cauth_users_email_idx on cauth_users (email) where email <> '';
+create unique index if not exists cauth_users_phone_idx on cauth_users (phone) where phone <> '';
+
+-- +migrate Down
+drop index if exists cauth_users_phone_idx;
+drop index if exists cauth_users_email_idx;
+
+alter table cauth_users drop column phone_verification_code_expires_at;
+alter table cauth_users drop column phone_verification_code;
+alter table cauth_users drop column phone_verified_at;
+alter table cauth_users drop column phone;
+
+alter table cauth_users alter column email drop not null;
+update cauth_users set email = null where email = '';
+alter table cauth_users add constraint cauth_users_email_key unique (email);
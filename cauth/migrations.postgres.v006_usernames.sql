@@ -0,0 +1,20 @@
+-- +migrate Up
+alter table cauth_users add column username text not null default '';
+
+-- Users may also signup with a username, so its uniqueness is only enforced on non-empty values too
+create unique index if not exists cauth_users_username_idx on cauth_users (username) where username <> '';
+
+create table if not exists cauth_username_history
+(
+    uuid       text primary key,
+    created_at timestamp with time zone not null,
+    user_uuid  text                     not null,
+    username   text                     not null
+);
+
+create index if not exists cauth_username_history_username_idx on cauth_username_history (username);
+
+-- +migrate Down
+drop table if exists cauth_username_history;
+drop index if exists cauth_users_username_idx;
+alter table cauth_users drop column username;
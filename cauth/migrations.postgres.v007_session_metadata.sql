@@ -0,0 +1,16 @@
+-- +migrate Up
+alter table cauth_sessions add column user_agent text not null default '';
+alter table cauth_sessions add column ip text not null default '';
+
+-- Existing sessions were last seen when they were updated
+alter table cauth_sessions add column last_seen_at timestamp with time zone;
+update cauth_sessions set last_seen_at = updated_at;
+alter table cauth_sessions alter column last_seen_at set not null;
+
+create index if not exists cauth_sessions_user_uuid_idx on cauth_sessions (user_uuid);
+
+-- +migrate Down
+drop index if exists cauth_sessions_user_uuid_idx;
+alter table cauth_sessions drop column last_seen_at;
+alter table cauth_sessions drop column ip;
+alter table cauth_sessions drop column user_agent;
@@ -0,0 +1,7 @@
+-- +migrate Up
+alter table cauth_sessions add column remember_me boolean not null default false;
+alter table cauth_two_factor_challenges add column remember_me boolean not null default false;
+
+-- +migrate Down
+alter table cauth_two_factor_challenges drop column remember_me;
+alter table cauth_sessions drop column remember_me;
@@ -0,0 +1,13 @@
+-- +migrate Up
+create table if not exists cauth_failed_attempts
+(
+    attempt_key    text primary key,
+    created_at     timestamp with time zone not null,
+    updated_at     timestamp with time zone not null,
+    count          bigint                   not null,
+    last_failed_at timestamp with time zone not null,
+    expires_at     timestamp with time zone not null
+);
+
+-- +migrate Down
+drop table if exists cauth_failed_attempts;
@@ -0,0 +1,19 @@
+-- +migrate Up
+create table if not exists cauth_audit_events
+(
+    uuid         text primary key,
+    created_at   timestamp with time zone not null,
+    type         text                     not null,
+    user_uuid    text                     not null default '',
+    session_uuid text                     not null default '',
+    ip           text                     not null default '',
+    user_agent   text                     not null default '',
+    request_id   text                     not null default '',
+    metadata     jsonb                    not null default '{}'
+);
+
+create index if not exists cauth_audit_events_user_uuid_idx on cauth_audit_events (user_uuid, created_at);
+create index if not exists cauth_audit_events_type_idx on cauth_audit_events (type, created_at);
+
+-- +migrate Down
+drop table if exists cauth_audit_events;
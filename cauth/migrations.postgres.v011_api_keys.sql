@@ -0,0 +1,20 @@
+-- +migrate Up
+create table if not exists cauth_api_keys
+(
+    uuid         text primary key,
+    created_at   timestamp with time zone not null,
+    updated_at   timestamp with time zone not null,
+    user_uuid    text                     not null,
+    name         text                     not null,
+    prefix       text                     not null,
+    key_hash     bytea                    not null unique,
+    scopes       text                     not null default '',
+    expires_at   timestamp with time zone,
+    last_used_at timestamp with time zone,
+    revoked_at   timestamp with time zone
+);
+
+create index if not exists cauth_api_keys_user_uuid_idx on cauth_api_keys (user_uuid);
+
+-- +migrate Down
+drop table if exists cauth_api_keys;
@@ -0,0 +1,32 @@
+-- +migrate Up
+create table if not exists cauth_roles
+(
+    uuid        text primary key,
+    created_at  timestamp with time zone not null,
+    updated_at  timestamp with time zone not null,
+    name        text                     not null unique,
+    description text                     not null default ''
+);
+
+create table if not exists cauth_role_permissions
+(
+    role_uuid  text                     not null,
+    permission text                     not null,
+    created_at timestamp with time zone not null,
+    primary key (role_uuid, permission)
+);
+
+create table if not exists cauth_user_roles
+(
+    user_uuid  text                     not null,
+    role_uuid  text                     not null,
+    created_at timestamp with time zone not null,
+    primary key (user_uuid, role_uuid)
+);
+
+create index if not exists cauth_user_roles_role_uuid_idx on cauth_user_roles (role_uuid);
+
+-- +migrate Down
+drop table if exists cauth_user_roles;
+drop table if exists cauth_role_permissions;
+drop table if exists cauth_roles;
@@ -0,0 +1,27 @@
+-- +migrate Up
+create table if not exists cauth_organizations
+(
+    uuid       text primary key,
+    created_at timestamp with time zone not null,
+    updated_at timestamp with time zone not null,
+    name       text                     not null
+);
+
+create table if not exists cauth_memberships
+(
+    organization_uuid text                     not null,
+    user_uuid         text                     not null,
+    created_at        timestamp with time zone not null,
+    updated_at        timestamp with time zone not null,
+    role              text                     not null,
+    primary key (organization_uuid, user_uuid)
+);
+
+create index if not exists cauth_memberships_user_uuid_idx on cauth_memberships (user_uuid);
+
+alter table cauth_sessions add column active_organization_uuid text;
+
+-- +migrate Down
+alter table cauth_sessions drop column active_organization_uuid;
+drop table if exists cauth_memberships;
+drop table if exists cauth_organizations;
@@ -0,0 +1,20 @@
+-- +migrate Up
+create table if not exists cauth_invitations
+(
+    uuid              text primary key,
+    created_at        timestamp with time zone not null,
+    updated_at        timestamp with time zone not null,
+    inviter_uuid      text                     not null,
+    email             text                     not null,
+    organization_uuid text,
+    role              text                     not null default '',
+    token_hash        bytea                    not null unique,
+    expires_at        timestamp with time zone not null,
+    accepted_at       timestamp with time zone,
+    revoked_at        timestamp with time zone
+);
+
+create index if not exists cauth_invitations_inviter_uuid_idx on cauth_invitations (inviter_uuid);
+
+-- +migrate Down
+drop table if exists cauth_invitations;
@@ -0,0 +1,13 @@
+-- +migrate Up
+create table if not exists cauth_email_changes
+(
+    user_uuid         text primary key,
+    created_at        timestamp with time zone not null,
+    updated_at        timestamp with time zone not null,
+    new_email         text                     not null,
+    verification_code text                     not null,
+    expires_at        timestamp with time zone not null
+);
+
+-- +migrate Down
+drop table if exists cauth_email_changes;
@@ -0,0 +1,8 @@
+-- +migrate Up
+alter table cauth_users add column deleted_at timestamp with time zone;
+
+create index if not exists cauth_users_deleted_at_idx on cauth_users (deleted_at);
+
+-- +migrate Down
+drop index if exists cauth_users_deleted_at_idx;
+alter table cauth_users drop column deleted_at;
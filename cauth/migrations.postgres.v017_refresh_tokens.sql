@@ -0,0 +1,17 @@
+-- +migrate Up
+create table if not exists cauth_refresh_tokens
+(
+    uuid         text primary key,
+    created_at   timestamp with time zone not null,
+    updated_at   timestamp with time zone not null,
+    session_uuid text                     not null,
+    user_uuid    text                     not null,
+    token_hash   bytea                    not null unique,
+    expires_at   timestamp with time zone not null,
+    used_at      timestamp with time zone
+);
+
+create index if not exists cauth_refresh_tokens_user_uuid_idx on cauth_refresh_tokens (user_uuid);
+
+-- +migrate Down
+drop table if exists cauth_refresh_tokens;
@@ -0,0 +1,32 @@
+-- +migrate Up
+ALTER TABLE cauth_users ADD COLUMN totp_secret TEXT;
+ALTER TABLE cauth_users ADD COLUMN totp_enabled_at DATETIME;
+ALTER TABLE cauth_users ADD COLUMN totp_last_used_step INTEGER;
+
+CREATE TABLE IF NOT EXISTS cauth_backup_codes
+(
+    uuid       TEXT PRIMARY KEY,
+    created_at DATETIME NOT NULL,
+    user_uuid  TEXT     NOT NULL,
+    code       BLOB     NOT NULL,
+    used_at    DATETIME
+);
+
+CREATE INDEX IF NOT EXISTS cauth_backup_codes_user_uuid_idx ON cauth_backup_codes (user_uuid);
+
+CREATE TABLE IF NOT EXISTS cauth_two_factor_challenges
+(
+    uuid       TEXT PRIMARY KEY,
+    created_at DATETIME NOT NULL,
+    updated_at DATETIME NOT NULL,
+    user_uuid  TEXT     NOT NULL,
+    token      BLOB     NOT NULL,
+    expires_at DATETIME NOT NULL
+);
+
+-- +migrate Down
+DROP TABLE IF EXISTS cauth_two_factor_challenges;
+DROP TABLE IF EXISTS cauth_backup_codes;
+ALTER TABLE cauth_users DROP COLUMN totp_last_used_step;
+ALTER TABLE cauth_users DROP COLUMN totp_enabled_at;
+ALTER TABLE cauth_users DROP COLUMN totp_secret;
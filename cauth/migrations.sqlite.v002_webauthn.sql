@@ -0,0 +1,30 @@
+-- +migrate Up
+CREATE TABLE IF NOT EXISTS cauth_webauthn_credentials
+(
+    uuid          TEXT PRIMARY KEY,
+    created_at    DATETIME NOT NULL,
+    updated_at    DATETIME NOT NULL,
+    user_uuid     TEXT     NOT NULL,
+    name          TEXT     NOT NULL,
+    credential_id BLOB     NOT NULL UNIQUE,
+    public_key    BLOB     NOT NULL,
+    sign_count    INTEGER  NOT NULL,
+    last_used_at  DATETIME
+);
+
+CREATE INDEX IF NOT EXISTS cauth_webauthn_credentials_user_uuid_idx ON cauth_webauthn_credentials (user_uuid);
+
+CREATE TABLE IF NOT EXISTS cauth_webauthn_challenges
+(
+    uuid       TEXT PRIMARY KEY,
+    created_at DATETIME NOT NULL,
+    updated_at DATETIME NOT NULL,
+    user_uuid  TEXT,
+    ceremony   TEXT     NOT NULL,
+    challenge  BLOB     NOT NULL,
+    expires_at DATETIME NOT NULL
+);
+
+-- +migrate Down
+DROP TABLE IF EXISTS cauth_webauthn_challenges;
+DROP TABLE IF EXISTS cauth_webauthn_credentials;
@@ -0,0 +1,29 @@
+-- +migrate Up
+CREATE TABLE IF NOT EXISTS cauth_identities
+(
+    uuid       TEXT PRIMARY KEY,
+    created_at DATETIME NOT NULL,
+    updated_at DATETIME NOT NULL,
+    user_uuid  TEXT     NOT NULL,
+    provider   TEXT     NOT NULL,
+    subject    TEXT     NOT NULL,
+    email      TEXT     NOT NULL,
+    UNIQUE (provider, subject)
+);
+
+CREATE INDEX IF NOT EXISTS cauth_identities_user_uuid_idx ON cauth_identities (user_uuid);
+
+CREATE TABLE IF NOT EXISTS cauth_oauth_states
+(
+    uuid          TEXT PRIMARY KEY,
+    created_at    DATETIME NOT NULL,
+    updated_at    DATETIME NOT NULL,
+    provider      TEXT     NOT NULL,
+    state         TEXT     NOT NULL UNIQUE,
+    code_verifier TEXT     NOT NULL,
+    expires_at    DATETIME NOT NULL
+);
+
+-- +migrate Down
+DROP TABLE IF EXISTS cauth_oauth_states;
+DROP TABLE IF EXISTS cauth_identities;
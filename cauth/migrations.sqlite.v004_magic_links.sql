@@ -0,0 +1,13 @@
+-- +migrate Up
+CREATE TABLE IF NOT EXISTS cauth_magic_links
+(
+    uuid       TEXT PRIMARY KEY,
+    created_at DATETIME NOT NULL,
+    updated_at DATETIME NOT NULL,
+    user_uuid  TEXT     NOT NULL,
+    expires_at DATETIME NOT NULL,
+    used_at    DATETIME
+);
+
+-- +migrate Down
+DROP TABLE IF EXISTS cauth_magic_links;
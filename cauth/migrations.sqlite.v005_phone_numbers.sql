@@ -0,0 +1,76 @@
+-- +migrate Up
+-- Users may signup with either an email or a phone number, so email is no longer nullable and its uniqueness is only
+-- enforced on non-empty values. SQLite can't drop the UNIQUE constraint of a column, so the table is rebuilt.
+CREATE TABLE cauth_users_new
+(
+    uuid                               TEXT PRIMARY KEY,
+    created_at                         DATETIME NOT NULL,
+    updated_at                         DATETIME NOT NULL,
+    email                              TEXT     NOT NULL,
+    email_verified_at                  DATETIME,
+    verification_code                  TEXT,
+    verification_code_expires_at       DATETIME,
+    password                           BLOB,
+    totp_secret                        TEXT,
+    totp_enabled_at                    DATETIME,
+    totp_last_used_step                INTEGER,
+    phone                              TEXT     NOT NULL DEFAULT '',
+    phone_verified_at                  DATETIME,
+    phone_verification_code            TEXT,
+    phone_verification_code_expires_at DATETIME
+);
+
+INSERT INTO cauth_users_new (uuid, created_at, updated_at, email, email_verified_at, verification_code,
+                             verification_code_expires_at, password, totp_secret, totp_enabled_at, totp_last_used_step)
+SELECT uuid,
+       created_at,
+       updated_at,
+       COALESCE(email, ''),
+       email_verified_at,
+       verification_code,
+       verification_code_expires_at,
+       password,
+       totp_secret,
+       totp_enabled_at,
+       totp_last_used_step
+FROM cauth_users;
+
+DROP TABLE cauth_users;
+ALTER TABLE cauth_users_new RENAME TO cauth_users;
+
+CREATE UNIQUE INDEX IF NOT EXISTS cauth_users_email_idx ON cauth_users (email) WHERE email <> '';
+CREATE UNIQUE INDEX IF NOT EXISTS cauth_users_phone_idx ON cauth_users (phone) WHERE phone <> '';
+
+-- +migrate Down
+CREATE TABLE cauth_users_old
+(
+    uuid                         TEXT PRIMARY KEY,
+    created_at                   DATETIME NOT NULL,
+    updated_at                   DATETIME NOT NULL,
+    email                        TEXT UNIQUE,
+    email_verified_at            DATETIME,
+    verification_code            TEXT,
+    verification_code_expires_at DATETIME,
+    password                     BLOB,
+    totp_secret                  TEXT,
+    totp_enabled_at              DATETIME,
+    totp_last_used_step          INTEGER
+);
+
+INSERT INTO cauth_users_old (uuid, created_at, updated_at, email, email_verified_at, verification_code,
+                             verification_code_expires_at, password, totp_secret, totp_enabled_at, totp_last_used_step)
+SELECT uuid,
+       created_at,
+       updated_at,
+       NULLIF(email, ''),
+       email_verified_at,
+       verification_code,
+       verification_code_expires_at,
+       password,
+       totp_secret,
+       totp_enabled_at,
+       totp_last_used_step
+FROM cauth_users;
+
+DROP TABLE cauth_users;
+ALTER TABLE cauth_users_old RENAME TO cauth_users;
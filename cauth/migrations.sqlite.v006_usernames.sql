@@ -0,0 +1,20 @@
+-- +migrate Up
+ALTER TABLE cauth_users ADD COLUMN username TEXT NOT NULL DEFAULT '';
+
+-- Users may also signup with a username, so its uniqueness is only enforced on non-empty values too
+CREATE UNIQUE INDEX IF NOT EXISTS cauth_users_username_idx ON cauth_users (username) WHERE username <> '';
+
+CREATE TABLE IF NOT EXISTS cauth_username_history
+(
+    uuid       TEXT PRIMARY KEY,
+    created_at DATETIME NOT NULL,
+    user_uuid  TEXT     NOT NULL,
+    username   TEXT     NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS cauth_username_history_username_idx ON cauth_username_history (username);
+
+-- +migrate Down
+DROP TABLE IF EXISTS cauth_username_history;
+DROP INDEX IF EXISTS cauth_users_username_idx;
+ALTER TABLE cauth_users DROP COLUMN username;
@@ -0,0 +1,15 @@
+-- +migrate Up
+ALTER TABLE cauth_sessions ADD COLUMN user_agent TEXT NOT NULL DEFAULT '';
+ALTER TABLE cauth_sessions ADD COLUMN ip TEXT NOT NULL DEFAULT '';
+
+-- SQLite needs a constant default to add a NOT NULL column. Existing sessions were last seen when they were updated.
+ALTER TABLE cauth_sessions ADD COLUMN last_seen_at DATETIME NOT NULL DEFAULT '1970-01-01 00:00:00';
+UPDATE cauth_sessions SET last_seen_at = updated_at;
+
+CREATE INDEX IF NOT EXISTS cauth_sessions_user_uuid_idx ON cauth_sessions (user_uuid);
+
+-- +migrate Down
+DROP INDEX IF EXISTS cauth_sessions_user_uuid_idx;
+ALTER TABLE cauth_sessions DROP COLUMN last_seen_at;
+ALTER TABLE cauth_sessions DROP COLUMN ip;
+ALTER TABLE cauth_sessions DROP COLUMN user_agent;
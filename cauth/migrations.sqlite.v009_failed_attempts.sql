@@ -0,0 +1,13 @@
+-- +migrate Up
+CREATE TABLE IF NOT EXISTS cauth_failed_attempts
+(
+    attempt_key    TEXT PRIMARY KEY,
+    created_at     DATETIME NOT NULL,
+    updated_at     DATETIME NOT NULL,
+    count          INTEGER  NOT NULL,
+    last_failed_at DATETIME NOT NULL,
+    expires_at     DATETIME NOT NULL
+);
+
+-- +migrate Down
+DROP TABLE IF EXISTS cauth_failed_attempts;
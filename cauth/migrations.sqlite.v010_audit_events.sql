@@ -0,0 +1,19 @@
+-- +migrate Up
+CREATE TABLE IF NOT EXISTS cauth_audit_events
+(
+    uuid         TEXT PRIMARY KEY,
+    created_at   DATETIME NOT NULL,
+    type         TEXT     NOT NULL,
+    user_uuid    TEXT     NOT NULL DEFAULT '',
+    session_uuid TEXT     NOT NULL DEFAULT '',
+    ip           TEXT     NOT NULL DEFAULT '',
+    user_agent   TEXT     NOT NULL DEFAULT '',
+    request_id   TEXT     NOT NULL DEFAULT '',
+    metadata     TEXT     NOT NULL DEFAULT '{}'
+);
+
+CREATE INDEX IF NOT EXISTS cauth_audit_events_user_uuid_idx ON cauth_audit_events (user_uuid, created_at);
+CREATE INDEX IF NOT EXISTS cauth_audit_events_type_idx ON cauth_audit_events (type, created_at);
+
+-- +migrate Down
+DROP TABLE IF EXISTS cauth_audit_events;
@@ -0,0 +1,20 @@
+-- +migrate Up
+CREATE TABLE IF NOT EXISTS cauth_api_keys
+(
+    uuid         TEXT PRIMARY KEY,
+    created_at   DATETIME NOT NULL,
+    updated_at   DATETIME NOT NULL,
+    user_uuid    TEXT     NOT NULL,
+    name         TEXT     NOT NULL,
+    prefix       TEXT     NOT NULL,
+    key_hash     BLOB     NOT NULL UNIQUE,
+    scopes       TEXT     NOT NULL DEFAULT '',
+    expires_at   DATETIME,
+    last_used_at DATETIME,
+    revoked_at   DATETIME
+);
+
+CREATE INDEX IF NOT EXISTS cauth_api_keys_user_uuid_idx ON cauth_api_keys (user_uuid);
+
+-- +migrate Down
+DROP TABLE IF EXISTS cauth_api_keys;
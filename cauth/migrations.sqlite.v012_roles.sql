@@ -0,0 +1,32 @@
+-- +migrate Up
+CREATE TABLE IF NOT EXISTS cauth_roles
+(
+    uuid        TEXT PRIMARY KEY,
+    created_at  DATETIME NOT NULL,
+    updated_at  DATETIME NOT NULL,
+    name        TEXT     NOT NULL UNIQUE,
+    description TEXT     NOT NULL DEFAULT ''
+);
+
+CREATE TABLE IF NOT EXISTS cauth_role_permissions
+(
+    role_uuid  TEXT     NOT NULL,
+    permission TEXT     NOT NULL,
+    created_at DATETIME NOT NULL,
+    PRIMARY KEY (role_uuid, permission)
+);
+
+CREATE TABLE IF NOT EXISTS cauth_user_roles
+(
+    user_uuid  TEXT     NOT NULL,
+    role_uuid  TEXT     NOT NULL,
+    created_at DATETIME NOT NULL,
+    PRIMARY KEY (user_uuid, role_uuid)
+);
+
+CREATE INDEX IF NOT EXISTS cauth_user_roles_role_uuid_idx ON cauth_user_roles (role_uuid);
+
+-- +migrate Down
+DROP TABLE IF EXISTS cauth_user_roles;
+DROP TABLE IF EXISTS cauth_role_permissions;
+DROP TABLE IF EXISTS cauth_roles;
@@ -0,0 +1,27 @@
+-- +migrate Up
+CREATE TABLE IF NOT EXISTS cauth_organizations
+(
+    uuid       TEXT PRIMARY KEY,
+    created_at DATETIME NOT NULL,
+    updated_at DATETIME NOT NULL,
+    name       TEXT     NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS cauth_memberships
+(
+    organization_uuid TEXT     NOT NULL,
+    user_uuid         TEXT     NOT NULL,
+    created_at        DATETIME NOT NULL,
+    updated_at        DATETIME NOT NULL,
+    role              TEXT     NOT NULL,
+    PRIMARY KEY (organization_uuid, user_uuid)
+);
+
+CREATE INDEX IF NOT EXISTS cauth_memberships_user_uuid_idx ON cauth_memberships (user_uuid);
+
+ALTER TABLE cauth_sessions ADD COLUMN active_organization_uuid TEXT;
+
+-- +migrate Down
+ALTER TABLE cauth_sessions DROP COLUMN active_organization_uuid;
+DROP TABLE IF EXISTS cauth_memberships;
+DROP TABLE IF EXISTS cauth_organizations;
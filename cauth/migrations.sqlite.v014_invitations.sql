@@ -0,0 +1,20 @@
+-- +migrate Up
+CREATE TABLE IF NOT EXISTS cauth_invitations
+(
+    uuid              TEXT PRIMARY KEY,
+    created_at        DATETIME NOT NULL,
+    updated_at        DATETIME NOT NULL,
+    inviter_uuid      TEXT     NOT NULL,
+    email             TEXT     NOT NULL,
+    organization_uuid TEXT,
+    role              TEXT     NOT NULL DEFAULT '',
+    token_hash        BLOB     NOT NULL UNIQUE,
+    expires_at        DATETIME NOT NULL,
+    accepted_at       DATETIME,
+    revoked_at        DATETIME
+);
+
+CREATE INDEX IF NOT EXISTS cauth_invitations_inviter_uuid_idx ON cauth_invitations (inviter_uuid);
+
+-- +migrate Down
+DROP TABLE IF EXISTS cauth_invitations;
@@ -0,0 +1,13 @@
+-- +migrate Up
+CREATE TABLE IF NOT EXISTS cauth_email_changes
+(
+    user_uuid         TEXT PRIMARY KEY,
+    created_at        DATETIME NOT NULL,
+    updated_at        DATETIME NOT NULL,
+    new_email         TEXT     NOT NULL,
+    verification_code TEXT     NOT NULL,
+    expires_at        DATETIME NOT NULL
+);
+
+-- +migrate Down
+DROP TABLE IF EXISTS cauth_email_changes;
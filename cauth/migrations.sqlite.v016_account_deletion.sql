@@ -0,0 +1,8 @@
+-- +migrate Up
+ALTER TABLE cauth_users ADD COLUMN deleted_at DATETIME;
+
+CREATE INDEX IF NOT EXISTS cauth_users_deleted_at_idx ON cauth_users (deleted_at);
+
+-- +migrate Down
+DROP INDEX IF EXISTS cauth_users_deleted_at_idx;
+ALTER TABLE cauth_users DROP COLUMN deleted_at;
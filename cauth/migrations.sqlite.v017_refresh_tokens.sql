@@ -0,0 +1,17 @@
+-- +migrate Up
+CREATE TABLE IF NOT EXISTS cauth_refresh_tokens
+(
+    uuid         TEXT PRIMARY KEY,
+    created_at   DATETIME NOT NULL,
+    updated_at   DATETIME NOT NULL,
+    session_uuid TEXT     NOT NULL,
+    user_uuid    TEXT     NOT NULL,
+    token_hash   BLOB     NOT NULL UNIQUE,
+    expires_at   DATETIME NOT NULL,
+    used_at      DATETIME
+);
+
+CREATE INDEX IF NOT EXISTS cauth_refresh_tokens_user_uuid_idx ON cauth_refresh_tokens (user_uuid);
+
+-- +migrate Down
+DROP TABLE IF EXISTS cauth_refresh_tokens;
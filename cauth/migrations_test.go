@@ -0,0 +1,61 @@
+package cauth_test
+
+import (
+	"embed"
+	"testing"
+
+	"github.com/gocopper/copper/clogger"
+	"github.com/gocopper/copper/csql"
+	"github.com/gocopper/pkg/cauth"
+	"github.com/gocopper/pkg/cauth/cauthtest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMigrationsForDialect(t *testing.T) {
+	t.Parallel()
+
+	for _, dialect := range cauthtest.Dialects() {
+		t.Run(dialect.Name, func(t *testing.T) {
+			t.Parallel()
+
+			db, dbDialect, dbDSN := cauthtest.OpenDB(t, cauthtest.HandlerParams{Dialect: dialect.Name, DSN: dialect.DSN})
+
+			migrations, err := cauth.MigrationsForDialect(dbDialect)
+			assert.NoError(t, err)
+
+			files, err := embed.FS(migrations).ReadDir(".")
+			assert.NoError(t, err)
+
+			migrate := func(direction string) error {
+				return csql.NewMigrator(csql.NewMigratorParams{
+					DB:         db,
+					Migrations: migrations,
+					Config: csql.Config{
+						Dialect:    dbDialect,
+						DSN:        dbDSN,
+						Migrations: csql.ConfigMigrations{Direction: direction},
+					},
+					Logger: clogger.NewNoop(),
+				}).Run()
+			}
+
+			assert.NoError(t, migrate(csql.MigrationsDirectionUp))
+
+			// Down migrations revert one migration at a time
+			for range files {
+				assert.NoError(t, migrate(csql.MigrationsDirectionDown))
+			}
+
+			var applied int
+
+			assert.NoError(t, db.QueryRow("select count(*) from gorp_migrations").Scan(&applied))
+			assert.Equal(t, 0, applied)
+
+			_, err = db.Exec("select * from cauth_users")
+			assert.Error(t, err)
+
+			// The reverted migrations can be applied again
+			assert.NoError(t, migrate(csql.MigrationsDirectionUp))
+		})
+	}
+}
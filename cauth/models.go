@@ -13,11 +13,53 @@ type User struct {
 	UpdatedAt time.Time `db:"updated_at" json:"-"`
 
 	Email    string `db:"email" json:"email"`
+	Username string `db:"username" json:"username"`
 	Password []byte `db:"password" json:"-"`
 
 	EmailVerifiedAt           *time.Time `db:"email_verified_at" json:"-"`
 	VerificationCode          *string    `db:"verification_code" json:"-"`
 	VerificationCodeExpiresAt *time.Time `db:"verification_code_expires_at" json:"-"`
+
+	Phone                          string     `db:"phone" json:"phone"`
+	PhoneVerifiedAt                *time.Time `db:"phone_verified_at" json:"-"`
+	PhoneVerificationCode          *string    `db:"phone_verification_code" json:"-"`
+	PhoneVerificationCodeExpiresAt *time.Time `db:"phone_verification_code_expires_at" json:"-"`
+
+	TOTPSecret       *string    `db:"totp_secret" json:"-"`
+	TOTPEnabledAt    *time.Time `db:"totp_enabled_at" json:"-"`
+	TOTPLastUsedStep *int64     `db:"totp_last_used_step" json:"-"`
+
+	// DeletedAt is set when the user deletes their account. They can't login anymore and their data is purged once
+	// Config.AccountDeletionGracePeriod has passed.
+	DeletedAt *time.Time `db:"deleted_at" json:"-"`
+}
+
+// HasTOTPEnabled returns true if the user has confirmed their TOTP enrollment. Such users need to complete a
+// two-factor challenge during login.
+func (u *User) HasTOTPEnabled() bool {
+	return u.TOTPSecret != nil && u.TOTPEnabledAt != nil
+}
+
+// accountName returns the name that authenticators show for the user's account. Users that signed up without an
+// email are named after their username or phone number instead.
+func (u *User) accountName() string {
+	if u.Email != "" {
+		return u.Email
+	} else if u.Username != "" {
+		return u.Username
+	}
+
+	return u.Phone
+}
+
+// UsernameHistory records a username that a user previously had. It is used to prevent other users from taking
+// over a username soon after it was given up.
+type UsernameHistory struct {
+	UUID      string    `db:"uuid" json:"-"`
+	CreatedAt time.Time `db:"created_at" json:"created_at"`
+
+	UserUUID string `db:"user_uuid" json:"-"`
+	Username string `db:"username" json:"username"`
 }
 
 // Session represents a single logged-in session that a user is able create after providing valid
@@ -31,6 +73,13 @@ type Session struct {
 	ImpersonatedUserUUID *string   `db:"impersonated_user_uuid"`
 	Token                []byte    `db:"token"`
 	ExpiresAt            time.Time `db:"expires_at"`
+
+	UserAgent  string    `db:"user_agent"`
+	IP         string    `db:"ip"`
+	LastSeenAt time.Time `db:"last_seen_at"`
+	RememberMe bool      `db:"remember_me"`
+
+	ActiveOrganizationUUID *string `db:"active_organization_uuid"`
 }
 
 func (s *Session) CurrentUserID() string {
@@ -42,14 +91,241 @@ func (s *Session) CurrentUserID() string {
 
 func (s *Session) MarshalJSON() ([]byte, error) {
 	return json.Marshal(struct {
-		UUID      string    `json:"uuid"`
-		CreatedAt time.Time `json:"created_at"`
-		UserUUID  string    `json:"user_uuid"`
-		ExpiresAt time.Time `json:"expires_at"`
+		UUID       string    `json:"uuid"`
+		CreatedAt  time.Time `json:"created_at"`
+		UserUUID   string    `json:"user_uuid"`
+		ExpiresAt  time.Time `json:"expires_at"`
+		UserAgent  string    `json:"user_agent"`
+		IP         string    `json:"ip"`
+		LastSeenAt time.Time `json:"last_seen_at"`
+
+		ActiveOrganizationUUID *string `json:"active_organization_uuid"`
 	}{
-		UUID:      s.UUID,
-		CreatedAt: s.CreatedAt,
-		UserUUID:  s.CurrentUserID(),
-		ExpiresAt: s.ExpiresAt,
+		UUID:       s.UUID,
+		CreatedAt:  s.CreatedAt,
+		UserUUID:   s.CurrentUserID(),
+		ExpiresAt:  s.ExpiresAt,
+		UserAgent:  s.UserAgent,
+		IP:         s.IP,
+		LastSeenAt: s.LastSeenAt,
+
+		ActiveOrganizationUUID: s.ActiveOrganizationUUID,
 	})
 }
+
+// BackupCode represents a single-use code that a user can provide instead of a TOTP code during a two-factor
+// challenge. Only the hash of the code is stored.
+type BackupCode struct {
+	UUID      string    `db:"uuid"`
+	CreatedAt time.Time `db:"created_at"`
+
+	UserUUID string     `db:"user_uuid"`
+	Code     []byte     `db:"code"`
+	UsedAt   *time.Time `db:"used_at"`
+}
+
+// TwoFactorChallenge represents a pending login for a user with two-factor authentication enabled. It is created
+// after the first factor succeeds and must be completed with a TOTP or backup code before a session is created.
+type TwoFactorChallenge struct {
+	UUID      string    `db:"uuid" json:"uuid"`
+	CreatedAt time.Time `db:"created_at" json:"-"`
+	UpdatedAt time.Time `db:"updated_at" json:"-"`
+
+	UserUUID   string    `db:"user_uuid" json:"-"`
+	Token      []byte    `db:"token" json:"-"`
+	ExpiresAt  time.Time `db:"expires_at" json:"expires_at"`
+	RememberMe bool      `db:"remember_me" json:"-"`
+}
+
+// WebAuthnCredential represents a public key credential (passkey or security key) that a user has registered to
+// login with.
+type WebAuthnCredential struct {
+	UUID      string    `db:"uuid" json:"uuid"`
+	CreatedAt time.Time `db:"created_at" json:"created_at"`
+	UpdatedAt time.Time `db:"updated_at" json:"-"`
+
+	UserUUID     string     `db:"user_uuid" json:"-"`
+	Name         string     `db:"name" json:"name"`
+	CredentialID []byte     `db:"credential_id" json:"-"`
+	PublicKey    []byte     `db:"public_key" json:"-"`
+	SignCount    int64      `db:"sign_count" json:"-"`
+	LastUsedAt   *time.Time `db:"last_used_at" json:"last_used_at"`
+}
+
+// WebAuthnChallenge holds the random challenge issued for a single registration or login ceremony.
+type WebAuthnChallenge struct {
+	UUID      string    `db:"uuid"`
+	CreatedAt time.Time `db:"created_at"`
+	UpdatedAt time.Time `db:"updated_at"`
+
+	UserUUID  *string   `db:"user_uuid"`
+	Ceremony  string    `db:"ceremony"`
+	Challenge []byte    `db:"challenge"`
+	ExpiresAt time.Time `db:"expires_at"`
+}
+
+// MagicLink is a single-use login link that was emailed to a user.
+type MagicLink struct {
+	UUID      string    `db:"uuid"`
+	CreatedAt time.Time `db:"created_at"`
+	UpdatedAt time.Time `db:"updated_at"`
+
+	UserUUID  string     `db:"user_uuid"`
+	ExpiresAt time.Time  `db:"expires_at"`
+	UsedAt    *time.Time `db:"used_at"`
+}
+
+// Identity links a user to an account with an external OAuth provider.
+type Identity struct {
+	UUID      string    `db:"uuid" json:"uuid"`
+	CreatedAt time.Time `db:"created_at" json:"created_at"`
+	UpdatedAt time.Time `db:"updated_at" json:"-"`
+
+	UserUUID string `db:"user_uuid" json:"-"`
+	Provider string `db:"provider" json:"provider"`
+	Subject  string `db:"subject" json:"-"`
+	Email    string `db:"email" json:"email"`
+}
+
+// OAuthState holds the state and PKCE code verifier for a single OAuth authorization code flow.
+type OAuthState struct {
+	UUID      string    `db:"uuid"`
+	CreatedAt time.Time `db:"created_at"`
+	UpdatedAt time.Time `db:"updated_at"`
+
+	Provider     string    `db:"provider"`
+	State        string    `db:"state"`
+	CodeVerifier string    `db:"code_verifier"`
+	ExpiresAt    time.Time `db:"expires_at"`
+}
+
+// FailedAttempt counts the failed attempts (e.g. wrong passwords) for a key such as an email or an IP. It is used by
+// the SQL AttemptStore.
+type FailedAttempt struct {
+	Key          string    `db:"attempt_key"`
+	CreatedAt    time.Time `db:"created_at"`
+	UpdatedAt    time.Time `db:"updated_at"`
+	Count        int       `db:"count"`
+	LastFailedAt time.Time `db:"last_failed_at"`
+	ExpiresAt    time.Time `db:"expires_at"`
+}
+
+// AuditEvent records an authentication related change such as a login or a password change. UserUUID is empty for
+// failed logins of unknown users.
+type AuditEvent struct {
+	UUID      string    `db:"uuid" json:"uuid"`
+	CreatedAt time.Time `db:"created_at" json:"created_at"`
+
+	Type        string        `db:"type" json:"type"`
+	UserUUID    string        `db:"user_uuid" json:"user_uuid"`
+	SessionUUID string        `db:"session_uuid" json:"session_uuid"`
+	IP          string        `db:"ip" json:"ip"`
+	UserAgent   string        `db:"user_agent" json:"user_agent"`
+	RequestID   string        `db:"request_id" json:"request_id"`
+	Metadata    AuditMetadata `db:"metadata" json:"metadata"`
+}
+
+// APIKey is a long-lived credential that a user creates for scripts and integrations. Only the hash of the key is
+// stored. Prefix holds the first characters of the key so that users can tell their keys apart.
+type APIKey struct {
+	UUID      string    `db:"uuid" json:"uuid"`
+	CreatedAt time.Time `db:"created_at" json:"created_at"`
+	UpdatedAt time.Time `db:"updated_at" json:"-"`
+
+	UserUUID   string       `db:"user_uuid" json:"-"`
+	Name       string       `db:"name" json:"name"`
+	Prefix     string       `db:"prefix" json:"prefix"`
+	KeyHash    []byte       `db:"key_hash" json:"-"`
+	Scopes     APIKeyScopes `db:"scopes" json:"scopes"`
+	ExpiresAt  *time.Time   `db:"expires_at" json:"expires_at"`
+	LastUsedAt *time.Time   `db:"last_used_at" json:"last_used_at"`
+	RevokedAt  *time.Time   `db:"revoked_at" json:"-"`
+}
+
+// RefreshToken is a single-use credential that a client exchanges for a new access token and refresh token. Only the
+// digest of the token is stored. Used tokens are kept until they expire so that their reuse can be detected.
+type RefreshToken struct {
+	UUID      string    `db:"uuid"`
+	CreatedAt time.Time `db:"created_at"`
+	UpdatedAt time.Time `db:"updated_at"`
+
+	SessionUUID string     `db:"session_uuid"`
+	UserUUID    string     `db:"user_uuid"`
+	TokenHash   []byte     `db:"token_hash"`
+	ExpiresAt   time.Time  `db:"expires_at"`
+	UsedAt      *time.Time `db:"used_at"`
+}
+
+// Role is a named set of permissions that can be assigned to users.
+type Role struct {
+	UUID      string    `db:"uuid" json:"uuid"`
+	CreatedAt time.Time `db:"created_at" json:"created_at"`
+	UpdatedAt time.Time `db:"updated_at" json:"-"`
+
+	Name        string   `db:"name" json:"name"`
+	Description string   `db:"description" json:"description"`
+	Permissions []string `db:"-" json:"permissions"`
+}
+
+// RolePermission grants a permission to a role.
+type RolePermission struct {
+	RoleUUID   string    `db:"role_uuid"`
+	Permission string    `db:"permission"`
+	CreatedAt  time.Time `db:"created_at"`
+}
+
+// UserRole assigns a role to a user.
+type UserRole struct {
+	UserUUID  string    `db:"user_uuid"`
+	RoleUUID  string    `db:"role_uuid"`
+	CreatedAt time.Time `db:"created_at"`
+}
+
+// Organization is a workspace that users can belong to through memberships.
+type Organization struct {
+	UUID      string    `db:"uuid" json:"uuid"`
+	CreatedAt time.Time `db:"created_at" json:"created_at"`
+	UpdatedAt time.Time `db:"updated_at" json:"-"`
+
+	Name string `db:"name" json:"name"`
+}
+
+// Membership makes a user a member of an organization. Role is the name of the role whose permissions the user has
+// while the organization is active.
+type Membership struct {
+	OrganizationUUID string    `db:"organization_uuid" json:"organization_uuid"`
+	UserUUID         string    `db:"user_uuid" json:"user_uuid"`
+	CreatedAt        time.Time `db:"created_at" json:"created_at"`
+	UpdatedAt        time.Time `db:"updated_at" json:"-"`
+
+	Role string `db:"role" json:"role"`
+}
+
+// Invitation invites a person to sign up by email, optionally into an organization with the given role. Only the
+// hash of the invitation token is stored.
+type Invitation struct {
+	UUID      string    `db:"uuid" json:"uuid"`
+	CreatedAt time.Time `db:"created_at" json:"created_at"`
+	UpdatedAt time.Time `db:"updated_at" json:"-"`
+
+	InviterUUID      string     `db:"inviter_uuid" json:"inviter_uuid"`
+	Email            string     `db:"email" json:"email"`
+	OrganizationUUID *string    `db:"organization_uuid" json:"organization_uuid"`
+	Role             string     `db:"role" json:"role"`
+	TokenHash        []byte     `db:"token_hash" json:"-"`
+	ExpiresAt        time.Time  `db:"expires_at" json:"expires_at"`
+	AcceptedAt       *time.Time `db:"accepted_at" json:"accepted_at"`
+	RevokedAt        *time.Time `db:"revoked_at" json:"-"`
+}
+
+// EmailChange is a pending change of a user's email. The user keeps their current email until the verification code
+// sent to the new email is confirmed.
+type EmailChange struct {
+	UserUUID  string    `db:"user_uuid"`
+	CreatedAt time.Time `db:"created_at"`
+	UpdatedAt time.Time `db:"updated_at"`
+
+	NewEmail         string    `db:"new_email"`
+	VerificationCode string    `db:"verification_code"`
+	ExpiresAt        time.Time `db:"expires_at"`
+}
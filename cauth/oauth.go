@@ -0,0 +1,257 @@
+package cauth
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/gocopper/copper/cerrors"
+	"github.com/gocopper/pkg/crandom"
+	"github.com/google/uuid"
+)
+
+const (
+	oauthStateLen        = 43
+	oauthCodeVerifierLen = 64
+	oauthStateTTL        = 10 * time.Minute
+)
+
+var (
+	// ErrOAuthProviderNotFound is returned when an OAuth login is attempted with a provider that is not registered.
+	ErrOAuthProviderNotFound = errors.New("oauth provider not found")
+
+	// ErrOAuthEmailNotVerified is returned when the identity returned by an OAuth provider does not have a
+	// verified email, so it cannot be linked to a user.
+	ErrOAuthEmailNotVerified = errors.New("oauth email not verified")
+)
+
+// BeginOAuthLoginResult holds the URL that the user should be redirected to and the state that must be
+// presented again on the callback.
+type BeginOAuthLoginResult struct {
+	URL   string `json:"url"`
+	State string `json:"state"`
+}
+
+// CompleteOAuthLoginParams hold the params received on the OAuth callback.
+type CompleteOAuthLoginParams struct {
+	Provider string
+	Code     string
+	State    string
+}
+
+// RegisterOAuthProvider registers a custom OAuthProvider. A provider with the same name that was configured in
+// Config is replaced.
+func (s *Svc) RegisterOAuthProvider(provider OAuthProvider) {
+	s.oauthProvidersMu.Lock()
+	defer s.oauthProvidersMu.Unlock()
+
+	s.oauthProviders[provider.Name()] = provider
+}
+
+func (s *Svc) getOAuthProvider(name string) (OAuthProvider, bool) {
+	s.oauthProvidersMu.RLock()
+	defer s.oauthProvidersMu.RUnlock()
+
+	provider, ok := s.oauthProviders[name]
+
+	return provider, ok
+}
+
+// BeginOAuthLogin starts an authorization code flow with the given provider using a new state and PKCE code
+// verifier.
+func (s *Svc) BeginOAuthLogin(ctx context.Context, providerName string) (*BeginOAuthLoginResult, error) {
+	provider, ok := s.getOAuthProvider(providerName)
+	if !ok {
+		return nil, ErrOAuthProviderNotFound
+	}
+
+	state := &OAuthState{
+		UUID:         uuid.New().String(),
+		CreatedAt:    time.Now(),
+		UpdatedAt:    time.Now(),
+		Provider:     providerName,
+		State:        crandom.GenerateRandomString(oauthStateLen),
+		CodeVerifier: crandom.GenerateRandomString(oauthCodeVerifierLen),
+		ExpiresAt:    time.Now().Add(oauthStateTTL),
+	}
+
+	err := s.queries.InsertOAuthState(ctx, state)
+	if err != nil {
+		return nil, cerrors.New(err, "failed to insert oauth state", map[string]interface{}{
+			"provider": providerName,
+		})
+	}
+
+	codeChallenge := sha256.Sum256([]byte(state.CodeVerifier))
+
+	authURL, err := provider.AuthCodeURL(ctx, OAuthAuthCodeURLParams{
+		RedirectURL:   s.oauthRedirectURL(providerName),
+		State:         state.State,
+		CodeChallenge: base64.RawURLEncoding.EncodeToString(codeChallenge[:]),
+	})
+	if err != nil {
+		return nil, cerrors.New(err, "failed to create auth code url", map[string]interface{}{
+			"provider": providerName,
+		})
+	}
+
+	return &BeginOAuthLoginResult{
+		URL:   authURL,
+		State: state.State,
+	}, nil
+}
+
+// CompleteOAuthLogin exchanges the authorization code with the provider and logs in the user that the external
+// identity belongs to. Identities that have not been seen before are linked to the user with the same verified
+// email, or to a new user if no such user exists. If a user with the same email exists but never verified it,
+// ErrUserAlreadyExists is returned since the account may not belong to the owner of the identity.
+func (s *Svc) CompleteOAuthLogin(ctx context.Context, p CompleteOAuthLoginParams) (*SessionResult, error) {
+	provider, ok := s.getOAuthProvider(p.Provider)
+	if !ok {
+		return nil, ErrOAuthProviderNotFound
+	}
+
+	state, err := s.queries.GetOAuthStateByState(ctx, p.State)
+	if err != nil && errors.Is(err, ErrNotFound) {
+		return nil, ErrInvalidCredentials
+	} else if err != nil {
+		return nil, cerrors.New(err, "failed to get oauth state", map[string]interface{}{
+			"provider": p.Provider,
+		})
+	}
+
+	if state.Provider != p.Provider || time.Now().After(state.ExpiresAt) {
+		return nil, ErrInvalidCredentials
+	}
+
+	state.UpdatedAt = time.Now()
+	state.ExpiresAt = state.UpdatedAt
+
+	err = s.queries.UpdateOAuthState(ctx, state)
+	if err != nil {
+		return nil, cerrors.New(err, "failed to update oauth state", map[string]interface{}{
+			"oauthStateUUID": state.UUID,
+		})
+	}
+
+	identity, err := provider.Exchange(ctx, OAuthExchangeParams{
+		RedirectURL:  s.oauthRedirectURL(p.Provider),
+		Code:         p.Code,
+		CodeVerifier: state.CodeVerifier,
+	})
+	if err != nil {
+		return nil, cerrors.New(err, "failed to exchange authorization code", map[string]interface{}{
+			"provider": p.Provider,
+		})
+	}
+
+	user, newUser, err := s.getOrCreateUserForOAuthIdentity(ctx, p.Provider, identity)
+	if err != nil {
+		return nil, err
+	}
+
+	sessionResult, err := s.loginUser(ctx, user, false)
+	if err != nil {
+		return nil, err
+	}
+
+	sessionResult.NewUser = newUser
+
+	if newUser {
+		err = s.recordAuditEvent(ctx, &AuditEvent{
+			Type:     AuditEventSignup,
+			UserUUID: user.UUID,
+			Metadata: AuditMetadata{"provider": p.Provider},
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = s.recordLogin(ctx, loginMethodOAuth, sessionResult)
+	if err != nil {
+		return nil, err
+	}
+
+	return sessionResult, nil
+}
+
+// ListIdentities returns the external identities linked to the given user.
+func (s *Svc) ListIdentities(ctx context.Context, userUUID string) ([]Identity, error) {
+	return s.queries.ListIdentitiesByUserUUID(ctx, userUUID)
+}
+
+func (s *Svc) getOrCreateUserForOAuthIdentity(ctx context.Context, provider string, identity *OAuthIdentity) (*User, bool, error) {
+	existingIdentity, err := s.queries.GetIdentity(ctx, provider, identity.Subject)
+	if err == nil {
+		user, err := s.queries.GetUserByUUID(ctx, existingIdentity.UserUUID)
+		if err != nil {
+			return nil, false, cerrors.New(err, "failed to get user by uuid", map[string]interface{}{
+				"userUUID": existingIdentity.UserUUID,
+			})
+		}
+
+		return user, false, nil
+	} else if !errors.Is(err, ErrNotFound) {
+		return nil, false, cerrors.New(err, "failed to get identity", map[string]interface{}{
+			"provider": provider,
+		})
+	}
+
+	if !identity.EmailVerified || identity.Email == "" {
+		return nil, false, ErrOAuthEmailNotVerified
+	}
+
+	var newUser = false
+
+	user, err := s.queries.GetUserByEmail(ctx, identity.Email)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return nil, false, cerrors.New(err, "failed to get user by email", map[string]interface{}{
+			"email": identity.Email,
+		})
+	} else if err == nil && user.EmailVerifiedAt == nil {
+		// The existing user never proved that they own this email, so they have to verify it before the identity
+		// can be linked to their account.
+		return nil, false, ErrUserAlreadyExists
+	} else if errors.Is(err, ErrNotFound) {
+		newUser = true
+		now := time.Now()
+		user = &User{
+			UUID:            uuid.New().String(),
+			CreatedAt:       now,
+			UpdatedAt:       now,
+			Email:           identity.Email,
+			EmailVerifiedAt: &now,
+		}
+
+		err = s.queries.InsertUser(ctx, user)
+		if err != nil {
+			return nil, false, cerrors.New(err, "failed to insert user", nil)
+		}
+	}
+
+	err = s.queries.InsertIdentity(ctx, &Identity{
+		UUID:      uuid.New().String(),
+		CreatedAt: time.Now(),
+		UpdatedAt: time.Now(),
+		UserUUID:  user.UUID,
+		Provider:  provider,
+		Subject:   identity.Subject,
+		Email:     identity.Email,
+	})
+	if err != nil {
+		return nil, false, cerrors.New(err, "failed to insert identity", map[string]interface{}{
+			"userUUID": user.UUID,
+			"provider": provider,
+		})
+	}
+
+	return user, newUser, nil
+}
+
+func (s *Svc) oauthRedirectURL(providerName string) string {
+	return strings.TrimSuffix(s.config.OAuthRedirectBaseURL, "/") + "/api/auth/oauth/" + providerName + "/callback"
+}
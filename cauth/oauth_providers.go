@@ -0,0 +1,418 @@
+package cauth
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/gocopper/copper/cerrors"
+)
+
+// OAuth provider types that can be used in OAuthProviderConfig.
+const (
+	OAuthProviderTypeOIDC   = "oidc"
+	OAuthProviderTypeGoogle = "google"
+	OAuthProviderTypeGitHub = "github"
+)
+
+const (
+	googleIssuerURL = "https://accounts.google.com"
+
+	githubAuthURL  = "https://github.com/login/oauth/authorize"
+	githubTokenURL = "https://github.com/login/oauth/access_token" //nolint:gosec
+	githubAPIURL   = "https://api.github.com"
+	maxHTTPRespLen = 1 << 20
+)
+
+// OAuthProvider is implemented by external identity providers that users can login with. Providers only need to
+// implement the authorization code flow. State and PKCE are generated and verified by Svc.
+type OAuthProvider interface {
+	// Name uniquely identifies the provider. It is used in the login and callback routes and is stored with
+	// linked identities.
+	Name() string
+
+	// AuthCodeURL returns the URL that the user should be redirected to in order to authorize the app.
+	AuthCodeURL(ctx context.Context, p OAuthAuthCodeURLParams) (string, error)
+
+	// Exchange exchanges the authorization code received on the callback for the user's identity.
+	Exchange(ctx context.Context, p OAuthExchangeParams) (*OAuthIdentity, error)
+}
+
+// OAuthAuthCodeURLParams hold the params needed to create an authorization URL.
+type OAuthAuthCodeURLParams struct {
+	RedirectURL   string
+	State         string
+	CodeChallenge string
+}
+
+// OAuthExchangeParams hold the params needed to exchange an authorization code.
+type OAuthExchangeParams struct {
+	RedirectURL  string
+	Code         string
+	CodeVerifier string
+}
+
+// OAuthIdentity is the user's identity as reported by an OAuthProvider.
+type OAuthIdentity struct {
+	Subject       string
+	Email         string
+	EmailVerified bool
+}
+
+// NewOAuthProvider creates an OAuthProvider using the given config.
+func NewOAuthProvider(name string, config OAuthProviderConfig) (OAuthProvider, error) {
+	switch config.Type {
+	case OAuthProviderTypeOIDC:
+		if config.IssuerURL == "" {
+			return nil, cerrors.New(nil, "oidc provider requires an issuer url", map[string]interface{}{
+				"name": name,
+			})
+		}
+
+		return NewOIDCProvider(name, config), nil
+	case OAuthProviderTypeGoogle:
+		config.IssuerURL = googleIssuerURL
+
+		return NewOIDCProvider(name, config), nil
+	case OAuthProviderTypeGitHub:
+		return NewGitHubProvider(name, config), nil
+	default:
+		return nil, cerrors.New(nil, "unknown oauth provider type", map[string]interface{}{
+			"name": name,
+			"type": config.Type,
+		})
+	}
+}
+
+// oauth2Client implements the parts of the OAuth 2.0 authorization code flow that are common to all providers.
+type oauth2Client struct {
+	clientID     string
+	clientSecret string
+	scopes       []string
+	httpClient   *http.Client
+}
+
+type oauth2Token struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	Error       string `json:"error"`
+}
+
+func (c *oauth2Client) authCodeURL(authURL string, p OAuthAuthCodeURLParams) (string, error) {
+	u, err := url.Parse(authURL)
+	if err != nil {
+		return "", cerrors.New(err, "failed to parse authorization url", map[string]interface{}{
+			"authURL": authURL,
+		})
+	}
+
+	q := u.Query()
+	q.Set("response_type", "code")
+	q.Set("client_id", c.clientID)
+	q.Set("redirect_uri", p.RedirectURL)
+	q.Set("scope", strings.Join(c.scopes, " "))
+	q.Set("state", p.State)
+	q.Set("code_challenge", p.CodeChallenge)
+	q.Set("code_challenge_method", "S256")
+	u.RawQuery = q.Encode()
+
+	return u.String(), nil
+}
+
+func (c *oauth2Client) exchange(ctx context.Context, tokenURL string, p OAuthExchangeParams) (*oauth2Token, error) {
+	var token oauth2Token
+
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", p.Code)
+	form.Set("redirect_uri", p.RedirectURL)
+	form.Set("code_verifier", p.CodeVerifier)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, tokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, cerrors.New(err, "failed to create token request", nil)
+	}
+
+	req.SetBasicAuth(url.QueryEscape(c.clientID), url.QueryEscape(c.clientSecret))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	err = c.doJSON(req, &token)
+	if err != nil {
+		return nil, cerrors.New(err, "failed to exchange authorization code", map[string]interface{}{
+			"tokenURL": tokenURL,
+		})
+	}
+
+	if token.Error != "" || token.AccessToken == "" {
+		return nil, cerrors.New(nil, "token endpoint did not return an access token", map[string]interface{}{
+			"tokenURL": tokenURL,
+			"error":    token.Error,
+		})
+	}
+
+	return &token, nil
+}
+
+func (c *oauth2Client) getJSON(ctx context.Context, u, accessToken string, dest interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return cerrors.New(err, "failed to create request", map[string]interface{}{
+			"url": u,
+		})
+	}
+
+	if accessToken != "" {
+		req.Header.Set("Authorization", "Bearer "+accessToken)
+	}
+
+	req.Header.Set("Accept", "application/json")
+
+	return c.doJSON(req, dest)
+}
+
+func (c *oauth2Client) doJSON(req *http.Request, dest interface{}) error {
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return cerrors.New(err, "failed to execute request", map[string]interface{}{
+			"url": req.URL.String(),
+		})
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxHTTPRespLen))
+	if err != nil {
+		return cerrors.New(err, "failed to read response body", map[string]interface{}{
+			"url": req.URL.String(),
+		})
+	}
+
+	if resp.StatusCode < http.StatusOK || resp.StatusCode >= http.StatusMultipleChoices {
+		return cerrors.New(nil, "unexpected response status", map[string]interface{}{
+			"url":        req.URL.String(),
+			"statusCode": resp.StatusCode,
+			"body":       string(body),
+		})
+	}
+
+	err = json.Unmarshal(body, dest)
+	if err != nil {
+		return cerrors.New(err, "failed to decode response body", map[string]interface{}{
+			"url": req.URL.String(),
+		})
+	}
+
+	return nil
+}
+
+// NewOIDCProvider creates an OAuthProvider for a generic OpenID Connect provider. The provider's endpoints are
+// discovered using the issuer's /.well-known/openid-configuration document on first use. The user's identity is
+// read from the userinfo endpoint.
+func NewOIDCProvider(name string, config OAuthProviderConfig) *OIDCProvider {
+	scopes := config.Scopes
+	if len(scopes) == 0 {
+		scopes = []string{"openid", "email"}
+	}
+
+	return &OIDCProvider{
+		name:      name,
+		issuerURL: strings.TrimSuffix(config.IssuerURL, "/"),
+		client: oauth2Client{
+			clientID:     config.ClientID,
+			clientSecret: config.ClientSecret,
+			scopes:       scopes,
+			httpClient:   http.DefaultClient,
+		},
+		mu: &sync.Mutex{},
+	}
+}
+
+// OIDCProvider is an OAuthProvider for OpenID Connect providers.
+type OIDCProvider struct {
+	name      string
+	issuerURL string
+	client    oauth2Client
+
+	mu        *sync.Mutex
+	discovery *oidcDiscovery
+}
+
+type oidcDiscovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	UserinfoEndpoint      string `json:"userinfo_endpoint"`
+}
+
+// Name implements OAuthProvider.
+func (p *OIDCProvider) Name() string {
+	return p.name
+}
+
+// AuthCodeURL implements OAuthProvider.
+func (p *OIDCProvider) AuthCodeURL(ctx context.Context, params OAuthAuthCodeURLParams) (string, error) {
+	discovery, err := p.discover(ctx)
+	if err != nil {
+		return "", cerrors.New(err, "failed to discover oidc provider", map[string]interface{}{
+			"issuerURL": p.issuerURL,
+		})
+	}
+
+	return p.client.authCodeURL(discovery.AuthorizationEndpoint, params)
+}
+
+// Exchange implements OAuthProvider.
+func (p *OIDCProvider) Exchange(ctx context.Context, params OAuthExchangeParams) (*OAuthIdentity, error) {
+	var userInfo struct {
+		Subject       string      `json:"sub"`
+		Email         string      `json:"email"`
+		EmailVerified interface{} `json:"email_verified"`
+	}
+
+	discovery, err := p.discover(ctx)
+	if err != nil {
+		return nil, cerrors.New(err, "failed to discover oidc provider", map[string]interface{}{
+			"issuerURL": p.issuerURL,
+		})
+	}
+
+	token, err := p.client.exchange(ctx, discovery.TokenEndpoint, params)
+	if err != nil {
+		return nil, err
+	}
+
+	err = p.client.getJSON(ctx, discovery.UserinfoEndpoint, token.AccessToken, &userInfo)
+	if err != nil {
+		return nil, cerrors.New(err, "failed to get userinfo", map[string]interface{}{
+			"issuerURL": p.issuerURL,
+		})
+	}
+
+	if userInfo.Subject == "" {
+		return nil, cerrors.New(nil, "userinfo response is missing the subject", map[string]interface{}{
+			"issuerURL": p.issuerURL,
+		})
+	}
+
+	return &OAuthIdentity{
+		Subject: userInfo.Subject,
+		Email:   userInfo.Email,
+		// Some providers encode email_verified as a string
+		EmailVerified: userInfo.EmailVerified == true || userInfo.EmailVerified == "true",
+	}, nil
+}
+
+func (p *OIDCProvider) discover(ctx context.Context) (*oidcDiscovery, error) {
+	var discovery oidcDiscovery
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.discovery != nil {
+		return p.discovery, nil
+	}
+
+	err := p.client.getJSON(ctx, p.issuerURL+"/.well-known/openid-configuration", "", &discovery)
+	if err != nil {
+		return nil, err
+	}
+
+	if strings.TrimSuffix(discovery.Issuer, "/") != p.issuerURL {
+		return nil, cerrors.New(nil, "discovered issuer does not match the configured issuer", map[string]interface{}{
+			"issuerURL":        p.issuerURL,
+			"discoveredIssuer": discovery.Issuer,
+		})
+	}
+
+	if discovery.AuthorizationEndpoint == "" || discovery.TokenEndpoint == "" || discovery.UserinfoEndpoint == "" {
+		return nil, cerrors.New(nil, "discovery document is missing required endpoints", map[string]interface{}{
+			"issuerURL": p.issuerURL,
+		})
+	}
+
+	p.discovery = &discovery
+
+	return p.discovery, nil
+}
+
+// NewGitHubProvider creates an OAuthProvider that allows users to login with GitHub. Since GitHub does not support
+// OpenID Connect, the user's identity is read from the GitHub REST API.
+func NewGitHubProvider(name string, config OAuthProviderConfig) *GitHubProvider {
+	scopes := config.Scopes
+	if len(scopes) == 0 {
+		scopes = []string{"read:user", "user:email"}
+	}
+
+	return &GitHubProvider{
+		name: name,
+		client: oauth2Client{
+			clientID:     config.ClientID,
+			clientSecret: config.ClientSecret,
+			scopes:       scopes,
+			httpClient:   http.DefaultClient,
+		},
+	}
+}
+
+// GitHubProvider is an OAuthProvider for GitHub.
+type GitHubProvider struct {
+	name   string
+	client oauth2Client
+}
+
+// Name implements OAuthProvider.
+func (p *GitHubProvider) Name() string {
+	return p.name
+}
+
+// AuthCodeURL implements OAuthProvider.
+func (p *GitHubProvider) AuthCodeURL(_ context.Context, params OAuthAuthCodeURLParams) (string, error) {
+	return p.client.authCodeURL(githubAuthURL, params)
+}
+
+// Exchange implements OAuthProvider. The identity's email is the user's primary email address on GitHub.
+func (p *GitHubProvider) Exchange(ctx context.Context, params OAuthExchangeParams) (*OAuthIdentity, error) {
+	var (
+		user struct {
+			ID int64 `json:"id"`
+		}
+		emails []struct {
+			Email    string `json:"email"`
+			Primary  bool   `json:"primary"`
+			Verified bool   `json:"verified"`
+		}
+	)
+
+	token, err := p.client.exchange(ctx, githubTokenURL, params)
+	if err != nil {
+		return nil, err
+	}
+
+	err = p.client.getJSON(ctx, githubAPIURL+"/user", token.AccessToken, &user)
+	if err != nil {
+		return nil, cerrors.New(err, "failed to get github user", nil)
+	}
+
+	err = p.client.getJSON(ctx, githubAPIURL+"/user/emails", token.AccessToken, &emails)
+	if err != nil {
+		return nil, cerrors.New(err, "failed to get github user emails", nil)
+	}
+
+	identity := OAuthIdentity{
+		Subject: fmt.Sprint(user.ID),
+	}
+
+	for i := range emails {
+		if emails[i].Primary {
+			identity.Email = emails[i].Email
+			identity.EmailVerified = emails[i].Verified
+		}
+	}
+
+	return &identity, nil
+}
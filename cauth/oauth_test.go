@@ -0,0 +1,223 @@
+package cauth_test
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+
+	"github.com/gocopper/pkg/cauth"
+	"github.com/gocopper/pkg/cauth/cauthtest"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeOIDCProvider is a minimal OpenID Connect provider that issues a single authorization code. The token endpoint
+// verifies the PKCE code verifier against the challenge that was sent to the authorization endpoint.
+type fakeOIDCProvider struct {
+	*httptest.Server
+
+	mu            sync.Mutex
+	code          string
+	codeChallenge string
+}
+
+func newFakeOIDCProvider(t *testing.T) *fakeOIDCProvider {
+	t.Helper()
+
+	p := &fakeOIDCProvider{code: "test-code"}
+
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 p.URL,
+			"authorization_endpoint": p.URL + "/authorize",
+			"token_endpoint":         p.URL + "/token",
+			"userinfo_endpoint":      p.URL + "/userinfo",
+		})
+	})
+
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+
+		clientID, clientSecret, ok := r.BasicAuth()
+		verifierHash := sha256.Sum256([]byte(r.FormValue("code_verifier")))
+
+		if !ok || clientID != "test-client" || clientSecret != "test-secret" ||
+			r.FormValue("code") != p.code ||
+			base64.RawURLEncoding.EncodeToString(verifierHash[:]) != p.codeChallenge {
+			w.WriteHeader(http.StatusBadRequest)
+			_ = json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+
+		_ = json.NewEncoder(w).Encode(map[string]string{
+			"access_token": "test-access-token",
+			"token_type":   "Bearer",
+		})
+	})
+
+	mux.HandleFunc("/userinfo", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer test-access-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"sub":            "test-subject",
+			"email":          "oauth-user@example.com",
+			"email_verified": true,
+		})
+	})
+
+	p.Server = httptest.NewServer(mux)
+	t.Cleanup(p.Close)
+
+	return p
+}
+
+func TestRouter_OAuth(t *testing.T) {
+	t.Parallel()
+
+	provider := newFakeOIDCProvider(t)
+
+	server := httptest.NewServer(cauthtest.NewHandlerWithParams(t, cauthtest.HandlerParams{Config: fmt.Sprintf(`
+[cauth.oauth_providers.test]
+type = "oidc"
+issuer_url = "%s"
+client_id = "test-client"
+client_secret = "test-secret"
+`, provider.URL)}))
+	defer server.Close()
+
+	client := &http.Client{
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	get := func(u string, cookie *http.Cookie) *http.Response {
+		req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, u, nil)
+		assert.NoError(t, err)
+
+		if cookie != nil {
+			req.AddCookie(cookie)
+		}
+
+		resp, err := client.Do(req)
+		assert.NoError(t, err)
+		t.Cleanup(func() { _ = resp.Body.Close() })
+
+		return resp
+	}
+
+	resp := get(server.URL+"/api/auth/oauth/unknown/login", nil)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	resp = get(server.URL+"/api/auth/oauth/test/login", nil)
+	assert.Equal(t, http.StatusSeeOther, resp.StatusCode)
+
+	authURL, err := url.Parse(resp.Header.Get("Location"))
+	assert.NoError(t, err)
+	assert.Equal(t, provider.URL+"/authorize", authURL.Scheme+"://"+authURL.Host+authURL.Path)
+	assert.Equal(t, "S256", authURL.Query().Get("code_challenge_method"))
+	assert.Equal(t, "http://localhost:7501/api/auth/oauth/test/callback", authURL.Query().Get("redirect_uri"))
+
+	provider.mu.Lock()
+	provider.codeChallenge = authURL.Query().Get("code_challenge")
+	provider.mu.Unlock()
+
+	var stateCookie *http.Cookie
+	for _, c := range resp.Cookies() {
+		if c.Name == "OAuthState" {
+			stateCookie = c
+		}
+	}
+
+	assert.NotNil(t, stateCookie)
+	assert.Equal(t, authURL.Query().Get("state"), stateCookie.Value)
+
+	callbackURL := server.URL + "/api/auth/oauth/test/callback?" + url.Values{
+		"code":  []string{"test-code"},
+		"state": []string{stateCookie.Value},
+	}.Encode()
+
+	// The state must be presented in the cookie as well as the query
+	resp = get(callbackURL, nil)
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	resp = get(callbackURL, stateCookie)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	cookies := make(map[string]string)
+	for _, c := range resp.Cookies() {
+		cookies[c.Name] = c.Value
+	}
+
+	assert.NotEmpty(t, cookies["SessionUUID"])
+	assert.NotEmpty(t, cookies["SessionToken"])
+
+	// The state cannot be reused
+	resp = get(callbackURL, stateCookie)
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+}
+
+// staticOAuthProvider is an OAuthProvider that returns the same identity for every authorization code.
+type staticOAuthProvider struct {
+	identity cauth.OAuthIdentity
+}
+
+func (p *staticOAuthProvider) Name() string {
+	return "static"
+}
+
+func (p *staticOAuthProvider) AuthCodeURL(_ context.Context, _ cauth.OAuthAuthCodeURLParams) (string, error) {
+	return "https://example.com/authorize", nil
+}
+
+func (p *staticOAuthProvider) Exchange(_ context.Context, _ cauth.OAuthExchangeParams) (*cauth.OAuthIdentity, error) {
+	identity := p.identity
+
+	return &identity, nil
+}
+
+func TestSvc_CompleteOAuthLogin_UnverifiedUser(t *testing.T) {
+	t.Parallel()
+
+	var (
+		ctx      = context.Background()
+		password = "test-pass"
+	)
+
+	_, svc := cauthtest.NewHandlerAndSvc(t, cauthtest.HandlerParams{})
+
+	svc.RegisterOAuthProvider(&staticOAuthProvider{identity: cauth.OAuthIdentity{
+		Subject:       "test-subject",
+		Email:         "user@example.com",
+		EmailVerified: true,
+	}})
+
+	_, err := svc.Signup(ctx, cauth.SignupParams{Email: "user@example.com", Password: &password})
+	assert.NoError(t, err)
+
+	login, err := svc.BeginOAuthLogin(ctx, "static")
+	assert.NoError(t, err)
+
+	// The identity is not linked to a user that never verified the email
+	_, err = svc.CompleteOAuthLogin(ctx, cauth.CompleteOAuthLoginParams{
+		Provider: "static",
+		Code:     "test-code",
+		State:    login.State,
+	})
+	assert.ErrorIs(t, err, cauth.ErrUserAlreadyExists)
+
+	_, err = svc.Login(ctx, cauth.LoginParams{Email: "user@example.com", Password: &password})
+	assert.NoError(t, err)
+}
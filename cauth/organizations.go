@@ -0,0 +1,370 @@
+package cauth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gocopper/copper/cerrors"
+	"github.com/gocopper/copper/chttp"
+	"github.com/gocopper/copper/clogger"
+	"github.com/google/uuid"
+)
+
+var (
+	// ErrInvalidOrganizationName is returned when an organization is created without a name.
+	ErrInvalidOrganizationName = errors.New("invalid organization name")
+
+	// ErrAlreadyMember is returned when a user is added to an organization that they are already a member of.
+	ErrAlreadyMember = errors.New("already a member of the organization")
+
+	// ErrLastOrganizationOwner is returned when removing the only member of an organization with the
+	// Config.OrganizationOwnerRole role.
+	ErrLastOrganizationOwner = errors.New("cannot remove the last owner of the organization")
+)
+
+// CreateOrganizationParams hold the params needed to create an organization. The user identified by UserUUID becomes
+// its first member with the Config.OrganizationOwnerRole role.
+type CreateOrganizationParams struct {
+	UserUUID string `json:"-"`
+	Name     string `json:"name"`
+}
+
+// UserOrganization is an organization that a user is a member of along with their role in it.
+type UserOrganization struct {
+	Organization
+	Role string `db:"role" json:"role"`
+}
+
+// CreateOrganization creates an organization and makes the given user its owner.
+func (s *Svc) CreateOrganization(ctx context.Context, p CreateOrganizationParams) (*Organization, error) {
+	name := strings.TrimSpace(p.Name)
+	if name == "" {
+		return nil, ErrInvalidOrganizationName
+	}
+
+	org := &Organization{
+		UUID:      uuid.New().String(),
+		CreatedAt: time.Now(),
+		UpdatedAt: time.Now(),
+		Name:      name,
+	}
+
+	err := s.queries.InsertOrganization(ctx, org)
+	if err != nil {
+		return nil, cerrors.New(err, "failed to insert organization", map[string]interface{}{
+			"userUUID": p.UserUUID,
+		})
+	}
+
+	err = s.recordAuditEvent(ctx, &AuditEvent{
+		Type:     AuditEventOrganizationCreated,
+		UserUUID: p.UserUUID,
+		Metadata: AuditMetadata{"organization_uuid": org.UUID},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = s.AddOrganizationMember(ctx, org.UUID, p.UserUUID, s.config.OrganizationOwnerRole)
+	if err != nil {
+		return nil, err
+	}
+
+	return org, nil
+}
+
+// ListOrganizations returns the organizations that the given user is a member of along with their role in each.
+func (s *Svc) ListOrganizations(ctx context.Context, userUUID string) ([]UserOrganization, error) {
+	orgs, err := s.queries.ListOrganizationsByUserUUID(ctx, userUUID)
+	if err != nil {
+		return nil, cerrors.New(err, "failed to list organizations", map[string]interface{}{
+			"userUUID": userUUID,
+		})
+	}
+
+	return orgs, nil
+}
+
+// GetOrganizationByUUID returns the organization with the given uuid.
+func (s *Svc) GetOrganizationByUUID(ctx context.Context, orgUUID string) (*Organization, error) {
+	org, err := s.queries.GetOrganization(ctx, orgUUID)
+	if err != nil {
+		return nil, cerrors.New(err, "failed to get organization", map[string]interface{}{
+			"organizationUUID": orgUUID,
+		})
+	}
+
+	return org, nil
+}
+
+// AddOrganizationMember adds the given user to an organization with the given role. The role is the name of a role
+// created with CreateRole whose permissions the user has while the organization is active. ErrInvalidRole is returned
+// if no such role exists and ErrAlreadyMember is returned if the user is already a member.
+func (s *Svc) AddOrganizationMember(ctx context.Context, orgUUID, userUUID, role string) (*Membership, error) {
+	_, err := s.queries.GetRoleByName(ctx, role)
+	if err != nil && errors.Is(err, ErrNotFound) {
+		return nil, ErrInvalidRole
+	} else if err != nil {
+		return nil, cerrors.New(err, "failed to get role by name", map[string]interface{}{
+			"name": role,
+		})
+	}
+
+	_, err = s.queries.GetMembership(ctx, orgUUID, userUUID)
+	if err == nil {
+		return nil, ErrAlreadyMember
+	} else if !errors.Is(err, ErrNotFound) {
+		return nil, cerrors.New(err, "failed to get membership", map[string]interface{}{
+			"organizationUUID": orgUUID,
+			"userUUID":         userUUID,
+		})
+	}
+
+	membership := &Membership{
+		OrganizationUUID: orgUUID,
+		UserUUID:         userUUID,
+		CreatedAt:        time.Now(),
+		UpdatedAt:        time.Now(),
+		Role:             role,
+	}
+
+	err = s.queries.InsertMembership(ctx, membership)
+	if err != nil {
+		return nil, cerrors.New(err, "failed to insert membership", map[string]interface{}{
+			"organizationUUID": orgUUID,
+			"userUUID":         userUUID,
+		})
+	}
+
+	err = s.recordAuditEvent(ctx, &AuditEvent{
+		Type:     AuditEventOrganizationMemberAdded,
+		UserUUID: userUUID,
+		Metadata: AuditMetadata{"organization_uuid": orgUUID, "role": role},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return membership, nil
+}
+
+// ListOrganizationMembers returns the memberships of the given organization.
+func (s *Svc) ListOrganizationMembers(ctx context.Context, orgUUID string) ([]Membership, error) {
+	memberships, err := s.queries.ListMembershipsByOrganizationUUID(ctx, orgUUID)
+	if err != nil {
+		return nil, cerrors.New(err, "failed to list memberships", map[string]interface{}{
+			"organizationUUID": orgUUID,
+		})
+	}
+
+	return memberships, nil
+}
+
+// RemoveOrganizationMember removes the given user from an organization. Sessions that have the organization active
+// can no longer access it. ErrLastOrganizationOwner is returned if the user is the organization's only owner so that
+// there is always someone left to manage it.
+func (s *Svc) RemoveOrganizationMember(ctx context.Context, orgUUID, userUUID string) error {
+	membership, err := s.queries.GetMembership(ctx, orgUUID, userUUID)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return cerrors.New(err, "failed to get membership", map[string]interface{}{
+			"organizationUUID": orgUUID,
+			"userUUID":         userUUID,
+		})
+	}
+
+	if err == nil && membership.Role == s.config.OrganizationOwnerRole {
+		memberships, err := s.queries.ListMembershipsByOrganizationUUID(ctx, orgUUID)
+		if err != nil {
+			return cerrors.New(err, "failed to list memberships", map[string]interface{}{
+				"organizationUUID": orgUUID,
+			})
+		}
+
+		owners := 0
+		for i := range memberships {
+			if memberships[i].Role == s.config.OrganizationOwnerRole {
+				owners++
+			}
+		}
+
+		if owners <= 1 {
+			return ErrLastOrganizationOwner
+		}
+	}
+
+	err = s.queries.DeleteMembership(ctx, orgUUID, userUUID)
+	if err != nil {
+		return cerrors.New(err, "failed to delete membership", map[string]interface{}{
+			"organizationUUID": orgUUID,
+			"userUUID":         userUUID,
+		})
+	}
+
+	return s.recordAuditEvent(ctx, &AuditEvent{
+		Type:     AuditEventOrganizationMemberRemoved,
+		UserUUID: userUUID,
+		Metadata: AuditMetadata{"organization_uuid": orgUUID},
+	})
+}
+
+// SwitchOrganization makes the given organization the active organization of the session identified by sessionUUID.
+// ErrNotFound is returned if the session's user is not a member of the organization.
+func (s *Svc) SwitchOrganization(ctx context.Context, sessionUUID, orgUUID string) error {
+	session, err := s.queries.GetSession(ctx, sessionUUID)
+	if err != nil {
+		return cerrors.New(err, "failed to get session", map[string]interface{}{
+			"sessionUUID": sessionUUID,
+		})
+	}
+
+	_, err = s.queries.GetMembership(ctx, orgUUID, session.CurrentUserID())
+	if err != nil && errors.Is(err, ErrNotFound) {
+		return ErrNotFound
+	} else if err != nil {
+		return cerrors.New(err, "failed to get membership", map[string]interface{}{
+			"organizationUUID": orgUUID,
+			"userUUID":         session.CurrentUserID(),
+		})
+	}
+
+	session.UpdatedAt = time.Now()
+	session.ActiveOrganizationUUID = &orgUUID
+
+	err = s.updateSession(ctx, session)
+	if err != nil {
+		return cerrors.New(err, "failed to update session", map[string]interface{}{
+			"sessionUUID": sessionUUID,
+		})
+	}
+
+	return s.recordAuditEvent(ctx, &AuditEvent{
+		Type:        AuditEventOrganizationSwitched,
+		UserUUID:    session.CurrentUserID(),
+		SessionUUID: session.UUID,
+		Metadata:    AuditMetadata{"organization_uuid": orgUUID},
+	})
+}
+
+// ctxWithOrganization returns a copy of ctx that holds the given organization and membership. The user's
+// permissions are extended with the permissions of their role in the organization.
+func (s *Svc) ctxWithOrganization(ctx context.Context, org *Organization, membership *Membership) context.Context {
+	parent, _ := ctx.Value(ctxKeyPermissions).(*userPermissions)
+
+	permissions := &userPermissions{
+		load: func() ([]string, error) {
+			var permissions []string
+
+			if parent != nil {
+				parentPermissions, err := parent.get()
+				if err != nil {
+					return nil, err
+				}
+
+				permissions = append(permissions, parentPermissions...)
+			}
+
+			rolePermissions, err := s.queries.ListPermissionsByRoleName(ctx, membership.Role)
+			if err != nil {
+				return nil, cerrors.New(err, "failed to list role permissions", map[string]interface{}{
+					"role": membership.Role,
+				})
+			}
+
+			for _, permission := range rolePermissions {
+				if !slices.Contains(permissions, permission) {
+					permissions = append(permissions, permission)
+				}
+			}
+
+			return permissions, nil
+		},
+	}
+
+	ctxWithOrg := context.WithValue(ctx, ctxKeyOrganization, org)
+	ctxWithOrgAndMembership := context.WithValue(ctxWithOrg, ctxKeyMembership, membership)
+
+	return context.WithValue(ctxWithOrgAndMembership, ctxKeyPermissions, permissions)
+}
+
+// NewVerifyOrganizationMiddleware instantiates and creates a new VerifyOrganizationMiddleware
+func NewVerifyOrganizationMiddleware(auth *Svc, rw *chttp.HTMLReaderWriter, logger clogger.Logger) *VerifyOrganizationMiddleware {
+	return &VerifyOrganizationMiddleware{
+		auth:   auth,
+		rw:     rw,
+		logger: logger,
+	}
+}
+
+// VerifyOrganizationMiddleware is a middleware that resolves the active organization of the current session. It must
+// be used after the VerifySessionMiddleware. If the session has an active organization that the user is still a
+// member of, the organization and membership are saved in the request ctx, the user's permissions are extended with
+// the permissions of their role in the organization, and the next handler is called. Otherwise, a forbidden response
+// is sent back.
+type VerifyOrganizationMiddleware struct {
+	auth   *Svc
+	rw     *chttp.HTMLReaderWriter
+	logger clogger.Logger
+}
+
+// Handle implements the middleware for VerifyOrganizationMiddleware.
+func (mw *VerifyOrganizationMiddleware) Handle(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		session, ok := r.Context().Value(ctxKeySession).(*Session)
+		if !ok || session == nil {
+			mw.rw.Unauthorized(w, r)
+			return
+		}
+
+		if session.ActiveOrganizationUUID == nil {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+
+		membership, err := mw.auth.queries.GetMembership(r.Context(), *session.ActiveOrganizationUUID, session.CurrentUserID())
+		if err != nil && errors.Is(err, ErrNotFound) {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		} else if err != nil {
+			mw.rw.WriteHTMLError(w, r, cerrors.New(err, "failed to get membership", map[string]interface{}{
+				"organizationUUID": *session.ActiveOrganizationUUID,
+			}))
+			return
+		}
+
+		org, err := mw.auth.GetOrganizationByUUID(r.Context(), membership.OrganizationUUID)
+		if err != nil {
+			mw.rw.WriteHTMLError(w, r, err)
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(mw.auth.ctxWithOrganization(r.Context(), org, membership)))
+	})
+}
+
+// GetCurrentOrganization returns the organization in the HTTP request context. It should only be used in HTTP request
+// handlers that have the VerifyOrganizationMiddleware on them. If an organization is not found, this method will
+// panic.
+func GetCurrentOrganization(ctx context.Context) *Organization {
+	org, ok := ctx.Value(ctxKeyOrganization).(*Organization)
+	if !ok || org == nil {
+		panic("organization not found in context")
+	}
+
+	return org
+}
+
+// GetCurrentMembership returns the current user's membership in the organization in the HTTP request context. It
+// should only be used in HTTP request handlers that have the VerifyOrganizationMiddleware on them. If a membership is
+// not found, this method will panic.
+func GetCurrentMembership(ctx context.Context) *Membership {
+	membership, ok := ctx.Value(ctxKeyMembership).(*Membership)
+	if !ok || membership == nil {
+		panic("membership not found in context")
+	}
+
+	return membership
+}
@@ -0,0 +1,161 @@
+package cauth_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gocopper/pkg/cauth"
+	"github.com/gocopper/pkg/cauth/cauthtest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRouter_Organizations(t *testing.T) {
+	t.Parallel()
+
+	var (
+		ctx     = context.Background()
+		created cauth.Organization
+		current struct {
+			OrganizationUUID string `json:"organization_uuid"`
+			Role             string `json:"role"`
+			CanWrite         bool   `json:"can_write"`
+		}
+		list struct {
+			Organizations []struct {
+				UUID string `json:"uuid"`
+				Name string `json:"name"`
+				Role string `json:"role"`
+			} `json:"organizations"`
+			ActiveOrganizationUUID string `json:"active_organization_uuid"`
+		}
+	)
+
+	handler, svc := cauthtest.NewHandlerAndSvc(t, cauthtest.HandlerParams{})
+
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	get := func(url string, session *cauth.SessionResult) *http.Response {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+		assert.NoError(t, err)
+
+		req.SetBasicAuth(session.Session.UUID, session.PlainSessionToken)
+
+		resp, err := http.DefaultClient.Do(req)
+		assert.NoError(t, err)
+		t.Cleanup(func() { _ = resp.Body.Close() })
+
+		return resp
+	}
+
+	session := cauthtest.CreateNewUserSession(t, server)
+
+	_, err := svc.CreateRole(ctx, cauth.CreateRoleParams{
+		Name:        "owner",
+		Permissions: []string{"test.write"},
+	})
+	assert.NoError(t, err)
+
+	_, err = svc.CreateRole(ctx, cauth.CreateRoleParams{Name: "member"})
+	assert.NoError(t, err)
+
+	// The session has no active organization yet
+	assert.Equal(t, http.StatusForbidden, get(server.URL+"/api/test/organization", session).StatusCode)
+
+	resp := postJSON(t, server.URL+"/api/auth/organizations", `{"name": " "}`, session)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	resp = postJSON(t, server.URL+"/api/auth/organizations", `{"name": "Acme"}`, session)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&created))
+
+	// Creating an organization makes it active and the creator gets the owner role's permissions in it
+	resp = get(server.URL+"/api/test/organization", session)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&current))
+	assert.Equal(t, created.UUID, current.OrganizationUUID)
+	assert.Equal(t, "owner", current.Role)
+	assert.True(t, current.CanWrite)
+
+	other, err := svc.CreateOrganization(ctx, cauth.CreateOrganizationParams{UserUUID: "other-user", Name: "Beta"})
+	assert.NoError(t, err)
+
+	notMember, err := svc.CreateOrganization(ctx, cauth.CreateOrganizationParams{UserUUID: "other-user", Name: "Gamma"})
+	assert.NoError(t, err)
+
+	_, err = svc.AddOrganizationMember(ctx, other.UUID, session.User.UUID, "member")
+	assert.NoError(t, err)
+
+	_, err = svc.AddOrganizationMember(ctx, other.UUID, session.User.UUID, "member")
+	assert.ErrorIs(t, err, cauth.ErrAlreadyMember)
+
+	resp = get(server.URL+"/api/auth/organizations", session)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&list))
+	assert.Equal(t, created.UUID, list.ActiveOrganizationUUID)
+
+	if assert.Len(t, list.Organizations, 2) {
+		assert.Equal(t, "Acme", list.Organizations[0].Name)
+		assert.Equal(t, "owner", list.Organizations[0].Role)
+		assert.Equal(t, "Beta", list.Organizations[1].Name)
+		assert.Equal(t, "member", list.Organizations[1].Role)
+	}
+
+	resp = postJSON(t, server.URL+"/api/auth/organizations/"+notMember.UUID+"/switch", `{}`, session)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	resp = postJSON(t, server.URL+"/api/auth/organizations/"+other.UUID+"/switch", `{}`, session)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	resp = get(server.URL+"/api/test/organization", session)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&current))
+	assert.Equal(t, other.UUID, current.OrganizationUUID)
+	assert.Equal(t, "member", current.Role)
+	assert.False(t, current.CanWrite)
+
+	// Removed members lose access even if the organization is still active on their session
+	assert.NoError(t, svc.RemoveOrganizationMember(ctx, other.UUID, session.User.UUID))
+	assert.Equal(t, http.StatusForbidden, get(server.URL+"/api/test/organization", session).StatusCode)
+}
+
+func TestSvc_OrganizationMembers(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	_, svc := cauthtest.NewHandlerAndSvc(t, cauthtest.HandlerParams{})
+
+	_, err := svc.CreateRole(ctx, cauth.CreateRoleParams{Name: "owner"})
+	assert.NoError(t, err)
+
+	org, err := svc.CreateOrganization(ctx, cauth.CreateOrganizationParams{UserUUID: "alice", Name: "Acme"})
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	_, err = svc.AddOrganizationMember(ctx, org.UUID, "bob", "")
+	assert.ErrorIs(t, err, cauth.ErrInvalidRole)
+
+	_, err = svc.AddOrganizationMember(ctx, org.UUID, "bob", "unknown")
+	assert.ErrorIs(t, err, cauth.ErrInvalidRole)
+
+	assert.ErrorIs(t, svc.RemoveOrganizationMember(ctx, org.UUID, "alice"), cauth.ErrLastOrganizationOwner)
+
+	_, err = svc.AddOrganizationMember(ctx, org.UUID, "bob", "owner")
+	assert.NoError(t, err)
+
+	// With a second owner, either one can be removed but not both
+	assert.NoError(t, svc.RemoveOrganizationMember(ctx, org.UUID, "alice"))
+	assert.ErrorIs(t, svc.RemoveOrganizationMember(ctx, org.UUID, "bob"), cauth.ErrLastOrganizationOwner)
+
+	members, err := svc.ListOrganizationMembers(ctx, org.UUID)
+	assert.NoError(t, err)
+
+	if assert.Len(t, members, 1) {
+		assert.Equal(t, "bob", members[0].UserUUID)
+	}
+}
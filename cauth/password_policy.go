@@ -0,0 +1,219 @@
+package cauth
+
+import (
+	"bufio"
+	"crypto/sha1" //nolint:gosec // SHA-1 is the hash used by breached password datasets
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/gocopper/copper/cerrors"
+)
+
+// bcryptMaxPasswordLen is the number of bytes after which bcrypt ignores the rest of a password.
+const bcryptMaxPasswordLen = 72
+
+// Reasons that a password can be rejected for by the password policy.
+const (
+	PasswordReasonTooShort         = "too_short"
+	PasswordReasonTooLong          = "too_long"
+	PasswordReasonMatchesEmail     = "matches_email"
+	PasswordReasonMatchesUsername  = "matches_username"
+	PasswordReasonMissingLowercase = "missing_lowercase"
+	PasswordReasonMissingUppercase = "missing_uppercase"
+	PasswordReasonMissingDigit     = "missing_digit"
+	PasswordReasonMissingSymbol    = "missing_symbol"
+	PasswordReasonBreached         = "breached"
+)
+
+// ErrWeakPassword is returned when a password does not satisfy the password policy. The reasons are available
+// through WeakPasswordError.
+var ErrWeakPassword = errors.New("weak password")
+
+// WeakPasswordError lists the reasons that a password was rejected for. It matches ErrWeakPassword with errors.Is.
+type WeakPasswordError struct {
+	Reasons []string
+}
+
+// Error implements the error interface.
+func (e *WeakPasswordError) Error() string {
+	return ErrWeakPassword.Error() + ": " + strings.Join(e.Reasons, ", ")
+}
+
+// Is makes errors.Is(err, ErrWeakPassword) true for a WeakPasswordError.
+func (e *WeakPasswordError) Is(target error) bool {
+	return target == ErrWeakPassword
+}
+
+// checkPassword returns a WeakPasswordError if the given password does not satisfy the password policy set in
+// Config. The password may not match the user's email, the local part of the email or their username.
+func (s *Svc) checkPassword(password, email, username string) error {
+	var (
+		reasons []string
+		length  = utf8.RuneCountInString(password)
+	)
+
+	if length < s.config.PasswordMinLength {
+		reasons = append(reasons, PasswordReasonTooShort)
+	}
+
+	if (s.config.PasswordMaxLength > 0 && length > s.config.PasswordMaxLength) ||
+		(s.config.PasswordHashAlgorithm == PasswordHashBcrypt && len(password) > bcryptMaxPasswordLen) {
+		reasons = append(reasons, PasswordReasonTooLong)
+	}
+
+	localPart, _, _ := strings.Cut(email, "@")
+
+	if email != "" && (strings.EqualFold(password, email) || strings.EqualFold(password, localPart)) {
+		reasons = append(reasons, PasswordReasonMatchesEmail)
+	}
+
+	if username != "" && strings.EqualFold(password, username) {
+		reasons = append(reasons, PasswordReasonMatchesUsername)
+	}
+
+	reasons = append(reasons, s.missingPasswordCharacterClasses(password)...)
+
+	if len(reasons) == 0 && s.config.PasswordBreachedHashesFile != "" {
+		breached, err := s.isBreachedPassword(password)
+		if err != nil {
+			return err
+		}
+
+		if breached {
+			reasons = append(reasons, PasswordReasonBreached)
+		}
+	}
+
+	if len(reasons) > 0 {
+		return &WeakPasswordError{Reasons: reasons}
+	}
+
+	return nil
+}
+
+func (s *Svc) missingPasswordCharacterClasses(password string) []string {
+	var (
+		reasons                                         []string
+		hasLowercase, hasUppercase, hasDigit, hasSymbol bool
+	)
+
+	for _, r := range password {
+		switch {
+		case unicode.IsLower(r):
+			hasLowercase = true
+		case unicode.IsUpper(r):
+			hasUppercase = true
+		case unicode.IsDigit(r):
+			hasDigit = true
+		case unicode.IsPunct(r) || unicode.IsSymbol(r):
+			hasSymbol = true
+		}
+	}
+
+	if s.config.PasswordRequireLowercase && !hasLowercase {
+		reasons = append(reasons, PasswordReasonMissingLowercase)
+	}
+
+	if s.config.PasswordRequireUppercase && !hasUppercase {
+		reasons = append(reasons, PasswordReasonMissingUppercase)
+	}
+
+	if s.config.PasswordRequireDigit && !hasDigit {
+		reasons = append(reasons, PasswordReasonMissingDigit)
+	}
+
+	if s.config.PasswordRequireSymbol && !hasSymbol {
+		reasons = append(reasons, PasswordReasonMissingSymbol)
+	}
+
+	return reasons
+}
+
+// isBreachedPassword checks if the SHA-1 hash of the given password is listed in Config.PasswordBreachedHashesFile.
+// The file is binary searched so that large datasets don't need to be loaded in memory.
+func (s *Svc) isBreachedPassword(password string) (bool, error) {
+	sum := sha1.Sum([]byte(password)) //nolint:gosec
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+
+	f, err := os.Open(s.config.PasswordBreachedHashesFile)
+	if err != nil {
+		return false, cerrors.New(err, "failed to open breached password hashes file", map[string]interface{}{
+			"path": s.config.PasswordBreachedHashesFile,
+		})
+	}
+	defer func() { _ = f.Close() }()
+
+	info, err := f.Stat()
+	if err != nil {
+		return false, cerrors.New(err, "failed to stat breached password hashes file", map[string]interface{}{
+			"path": s.config.PasswordBreachedHashesFile,
+		})
+	}
+
+	// lo and hi bound the offsets that the line with the hash can start at
+	lo, hi := int64(0), info.Size()
+
+	for lo < hi {
+		mid := lo + (hi-lo)/2
+
+		start, line, err := readLineFrom(f, mid, info.Size())
+		if err != nil {
+			return false, cerrors.New(err, "failed to read breached password hashes file", map[string]interface{}{
+				"path": s.config.PasswordBreachedHashesFile,
+			})
+		}
+
+		if start >= hi {
+			hi = mid
+			continue
+		}
+
+		lineHash, _, _ := strings.Cut(strings.TrimSpace(line), ":")
+
+		switch strings.Compare(strings.ToUpper(lineHash), hash) {
+		case 0:
+			return true, nil
+		case -1:
+			lo = start + int64(len(line))
+		default:
+			hi = mid
+		}
+	}
+
+	return false, nil
+}
+
+// readLineFrom returns the first line in f that starts at or after the given offset along with the offset that it
+// starts at. If there is no such line, the returned offset is size.
+func readLineFrom(f *os.File, offset, size int64) (int64, string, error) {
+	if offset == 0 {
+		line, err := bufio.NewReader(io.NewSectionReader(f, 0, size)).ReadString('\n')
+		if err != nil && !errors.Is(err, io.EOF) {
+			return 0, "", err
+		}
+
+		return 0, line, nil
+	}
+
+	// Start reading one byte early so that a line starting right at offset is not skipped
+	r := bufio.NewReader(io.NewSectionReader(f, offset-1, size-offset+1))
+
+	skipped, err := r.ReadString('\n')
+	if errors.Is(err, io.EOF) {
+		return size, "", nil
+	} else if err != nil {
+		return 0, "", err
+	}
+
+	line, err := r.ReadString('\n')
+	if err != nil && !errors.Is(err, io.EOF) {
+		return 0, "", err
+	}
+
+	return offset - 1 + int64(len(skipped)), line, nil
+}
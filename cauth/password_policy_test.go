@@ -0,0 +1,97 @@
+package cauth_test
+
+import (
+	"context"
+	"crypto/sha1" //nolint:gosec
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/gocopper/pkg/cauth"
+	"github.com/gocopper/pkg/cauth/cauthtest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRouter_Signup_PasswordPolicy(t *testing.T) {
+	t.Parallel()
+
+	var (
+		breached = []string{"Password1!", "Qwerty123!", "Letmein99!", "Dragon2024?"}
+		hashes   = make([]string, 0, len(breached))
+	)
+
+	for _, password := range breached {
+		sum := sha1.Sum([]byte(password)) //nolint:gosec
+		hashes = append(hashes, fmt.Sprintf("%s:%d", strings.ToUpper(hex.EncodeToString(sum[:])), len(password)))
+	}
+
+	slices.Sort(hashes)
+
+	hashesFile := filepath.Join(t.TempDir(), "pwned-passwords.txt")
+	assert.NoError(t, os.WriteFile(hashesFile, []byte(strings.Join(hashes, "\r\n")+"\r\n"), 0o600))
+
+	handler, svc := cauthtest.NewHandlerAndSvc(t, cauthtest.HandlerParams{
+		Config: fmt.Sprintf(`
+[cauth]
+password_require_digit = true
+password_require_symbol = true
+password_breached_hashes_file = %q
+`, hashesFile),
+	})
+
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	signup := func(email, password string) []string {
+		var body struct {
+			Error   string   `json:"error"`
+			Reasons []string `json:"reasons"`
+		}
+
+		resp := postJSON(t, server.URL+"/api/auth/signup",
+			fmt.Sprintf(`{"email": %q, "password": %q}`, email, password), nil)
+
+		if resp.StatusCode == http.StatusOK {
+			return nil
+		}
+
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+		assert.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+		assert.Equal(t, cauth.ErrWeakPassword.Error(), body.Error)
+
+		return body.Reasons
+	}
+
+	assert.Equal(t, []string{
+		cauth.PasswordReasonTooShort,
+		cauth.PasswordReasonMissingDigit,
+		cauth.PasswordReasonMissingSymbol,
+	}, signup("weak@example.com", ""))
+	assert.Equal(t, []string{cauth.PasswordReasonTooLong}, signup("weak@example.com", strings.Repeat("ü", 40)+"1!"))
+	assert.Equal(t, []string{cauth.PasswordReasonMatchesEmail}, signup("weak1!@example.com", "WEAK1!@example.com"))
+
+	for _, password := range breached {
+		assert.Equal(t, []string{cauth.PasswordReasonBreached}, signup("weak@example.com", password))
+	}
+
+	assert.Nil(t, signup("strong@example.com", "Horse-Battery-7"))
+
+	password := "Horse-Battery-7"
+
+	login, err := svc.Login(context.Background(), cauth.LoginParams{Email: "strong@example.com", Password: &password})
+	assert.NoError(t, err)
+
+	err = svc.UpdatePassword(context.Background(), cauth.UpdatePasswordParams{
+		UserUUID:        login.User.UUID,
+		CurrentPassword: "Horse-Battery-7",
+		NewPassword:     "Password1!",
+	})
+	assert.ErrorIs(t, err, cauth.ErrWeakPassword)
+}
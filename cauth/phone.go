@@ -0,0 +1,321 @@
+package cauth
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/gocopper/copper/cerrors"
+	"github.com/gocopper/pkg/crandom"
+	"github.com/gocopper/pkg/cvars"
+	"github.com/google/uuid"
+)
+
+const (
+	e164MinDigits = 8
+	e164MaxDigits = 15
+)
+
+// ErrInvalidPhoneNumber is returned when a phone number cannot be normalized to the E.164 format.
+var ErrInvalidPhoneNumber = errors.New("invalid phone number")
+
+// VerifyPhoneParams hold the params needed to verify a phone number.
+type VerifyPhoneParams struct {
+	Phone            string `json:"phone"`
+	VerificationCode string `json:"verification_code"`
+}
+
+// NormalizePhoneNumber normalizes the given phone number to the E.164 format (e.g. +14155550123). Spaces, dashes,
+// dots and parentheses are removed and a leading 00 is treated as the international call prefix. The number must
+// include the country code.
+func NormalizePhoneNumber(phone string) (string, error) {
+	var digits strings.Builder
+
+	phone = strings.TrimSpace(phone)
+
+	switch {
+	case strings.HasPrefix(phone, "+"):
+		phone = phone[1:]
+	case strings.HasPrefix(phone, "00"):
+		phone = phone[2:]
+	default:
+		return "", ErrInvalidPhoneNumber
+	}
+
+	for _, r := range phone {
+		switch {
+		case r >= '0' && r <= '9':
+			digits.WriteRune(r)
+		case r == ' ' || r == '-' || r == '.' || r == '(' || r == ')':
+		default:
+			return "", ErrInvalidPhoneNumber
+		}
+	}
+
+	normalized := digits.String()
+	if len(normalized) < e164MinDigits || len(normalized) > e164MaxDigits || normalized[0] == '0' {
+		return "", ErrInvalidPhoneNumber
+	}
+
+	return "+" + normalized, nil
+}
+
+// ResendPhoneVerificationCode sends a new verification code to the given phone number.
+func (s *Svc) ResendPhoneVerificationCode(ctx context.Context, phone string) error {
+	phone, err := NormalizePhoneNumber(phone)
+	if err != nil {
+		return err
+	}
+
+	user, err := s.queries.GetUserByPhone(ctx, phone)
+	if err != nil && errors.Is(err, ErrNotFound) {
+		return ErrInvalidCredentials
+	} else if err != nil {
+		return cerrors.New(err, "failed to get user by phone", map[string]interface{}{
+			"phone": phone,
+		})
+	}
+
+	return s.sendVerificationCodeSMS(ctx, user)
+}
+
+// VerifyPhone verifies the phone number of a user with the given verification code. If the verification succeeds,
+// it updates the user's phone verification status and returns the user.
+func (s *Svc) VerifyPhone(ctx context.Context, p VerifyPhoneParams) (*User, error) {
+	phone, err := NormalizePhoneNumber(p.Phone)
+	if err != nil {
+		return nil, err
+	}
+
+	accountKey := attemptKeyPhone(phone)
+
+	err = s.checkAttempts(ctx, accountKey)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := s.queries.GetUserByPhone(ctx, phone)
+	if err != nil && errors.Is(err, ErrNotFound) {
+		return nil, s.recordFailedAttempt(ctx, ErrInvalidCredentials, accountKey)
+	} else if err != nil {
+		return nil, cerrors.New(err, "failed to get user by phone", map[string]interface{}{
+			"phone": phone,
+		})
+	}
+
+	codeKey := attemptKeyPhoneVerificationCode(user.UUID)
+
+	err = s.checkVerificationCodeAttempts(ctx, codeKey)
+	if err != nil {
+		return nil, err
+	}
+
+	if user.PhoneVerificationCodeExpiresAt == nil || time.Now().UTC().After(*user.PhoneVerificationCodeExpiresAt) {
+		return nil, ErrVerificationCodeExpired
+	} else if user.PhoneVerificationCode == nil || *user.PhoneVerificationCode != p.VerificationCode {
+		return nil, s.recordFailedAttempt(ctx, ErrInvalidCredentials, accountKey, codeKey)
+	}
+
+	err = s.resetAttempts(ctx, accountKey)
+	if err != nil {
+		return nil, err
+	}
+
+	user.UpdatedAt = time.Now()
+	user.PhoneVerifiedAt = &user.UpdatedAt
+	user.PhoneVerificationCodeExpiresAt = &user.UpdatedAt
+
+	err = s.queries.UpdateUser(ctx, user)
+	if err != nil {
+		return nil, cerrors.New(err, "failed to update user", map[string]interface{}{
+			"userUUID": user.UUID,
+		})
+	}
+
+	err = s.recordAuditEvent(ctx, &AuditEvent{
+		Type:     AuditEventPhoneVerified,
+		UserUUID: user.UUID,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+func (s *Svc) sendVerificationCodeSMS(ctx context.Context, user *User) error {
+	var bodySb strings.Builder
+
+	user.UpdatedAt = time.Now()
+	user.PhoneVerificationCode = cvars.Ptr(strconv.Itoa(int(crandom.GenerateRandomNumericalCode(s.config.VerificationCodeLen))))
+	user.PhoneVerificationCodeExpiresAt = cvars.Ptr(time.Now().UTC().Add(verificationCodeTTL))
+
+	err := s.queries.UpdateUser(ctx, user)
+	if err != nil {
+		return cerrors.New(err, "failed to update user with new phone verification code", map[string]interface{}{
+			"userUUID": user.UUID,
+		})
+	}
+
+	err = s.resetAttempts(ctx, attemptKeyPhoneVerificationCode(user.UUID))
+	if err != nil {
+		return err
+	}
+
+	tmpl, err := template.New("sms_verification_code").Parse(s.config.VerificationSMSBody)
+	if err != nil {
+		return cerrors.New(err, "failed to parse verification code sms template", nil)
+	}
+
+	err = tmpl.Execute(&bodySb, map[string]string{
+		"VerificationCode": *user.PhoneVerificationCode,
+	})
+	if err != nil {
+		return cerrors.New(err, "failed to execute verification code sms template", nil)
+	}
+
+	err = s.smsSender.Send(ctx, SendSMSParams{
+		To:   user.Phone,
+		Body: bodySb.String(),
+	})
+	if err != nil {
+		return cerrors.New(err, "failed to send verification code sms", map[string]interface{}{
+			"to": user.Phone,
+		})
+	}
+
+	return nil
+}
+
+func (s *Svc) signupWithPhone(ctx context.Context, phone string, password *string) (*SessionResult, error) {
+	var newUser = false
+
+	phone, err := NormalizePhoneNumber(phone)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := s.queries.GetUserByPhone(ctx, phone)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return nil, cerrors.New(err, "failed to get user by phone", map[string]interface{}{
+			"phone": phone,
+		})
+	} else if err == nil && len(user.Password) > 0 {
+		// User should not be able to signup with a phone number that already exists
+		return nil, ErrUserAlreadyExists
+	} else if err == nil && len(user.Password) == 0 {
+		user.UpdatedAt = time.Now()
+		user.PhoneVerifiedAt = nil
+
+		err = s.queries.UpdateUser(ctx, user)
+		if err != nil {
+			return nil, cerrors.New(err, "failed to update user", nil)
+		}
+	} else if errors.Is(err, ErrNotFound) {
+		newUser = true
+		user = &User{
+			UUID:      uuid.New().String(),
+			CreatedAt: time.Now(),
+			UpdatedAt: time.Now(),
+			Phone:     phone,
+		}
+
+		if password != nil {
+			hp, err := s.hasher.Hash(*password)
+			if err != nil {
+				return nil, cerrors.New(err, "failed to hash password", nil)
+			}
+			user.Password = hp
+		}
+
+		err = s.queries.InsertUser(ctx, user)
+		if err != nil {
+			return nil, cerrors.New(err, "failed to insert user", nil)
+		}
+	}
+
+	err = s.sendVerificationCodeSMS(ctx, user)
+	if err != nil {
+		return nil, cerrors.New(err, "failed to send verification code sms", map[string]interface{}{
+			"userID": user.UUID,
+		})
+	}
+
+	if password == nil {
+		// If no password is provided, we don't create a session
+		// because the user will login with the verification code
+		return &SessionResult{
+			User:    user,
+			NewUser: newUser,
+		}, nil
+	}
+
+	sessionResult, err := s.createSessionResult(ctx, user, false)
+	if err != nil {
+		return nil, err
+	}
+
+	sessionResult.NewUser = newUser
+
+	return sessionResult, nil
+}
+
+func (s *Svc) loginWithPhoneVerificationCode(ctx context.Context, phone, code string, rememberMe bool) (*SessionResult, error) {
+	user, err := s.VerifyPhone(ctx, VerifyPhoneParams{
+		Phone:            phone,
+		VerificationCode: code,
+	})
+	if err != nil {
+		return nil, cerrors.New(err, "failed to verify phone", map[string]interface{}{
+			"phone": phone,
+		})
+	}
+
+	if len(user.Password) > 0 {
+		return nil, cerrors.New(nil, "user cannot login with verification code because they have a password", map[string]interface{}{
+			"userUUID": user.UUID,
+		})
+	}
+
+	return s.loginUser(ctx, user, rememberMe)
+}
+
+func (s *Svc) loginWithPhonePassword(ctx context.Context, phone, password string, rememberMe bool) (*SessionResult, error) {
+	phone, err := NormalizePhoneNumber(phone)
+	if err != nil {
+		return nil, err
+	}
+
+	accountKey := attemptKeyPhone(phone)
+
+	err = s.checkAttempts(ctx, accountKey)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := s.queries.GetUserByPhone(ctx, phone)
+	if err != nil && errors.Is(err, ErrNotFound) {
+		return nil, s.recordFailedAttempt(ctx, ErrInvalidCredentials, accountKey)
+	} else if err != nil {
+		return nil, cerrors.New(err, "failed to get user by phone", map[string]interface{}{
+			"phone": phone,
+		})
+	}
+
+	ok, err := s.verifyPassword(ctx, user, password)
+	if err != nil {
+		return nil, err
+	} else if !ok {
+		return nil, s.recordFailedAttempt(ctx, ErrInvalidCredentials, accountKey)
+	}
+
+	err = s.resetAttempts(ctx, accountKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.loginUser(ctx, user, rememberMe)
+}
@@ -0,0 +1,93 @@
+package cauth_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+
+	"github.com/gocopper/pkg/cauth"
+	"github.com/gocopper/pkg/cauth/cauthtest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNormalizePhoneNumber(t *testing.T) {
+	t.Parallel()
+
+	valid := map[string]string{
+		"+14155550123":       "+14155550123",
+		"+1 (415) 555-0123":  "+14155550123",
+		" +44 20 7946 0958 ": "+442079460958",
+		"0049.30.901820":     "+4930901820",
+	}
+
+	for input, want := range valid {
+		got, err := cauth.NormalizePhoneNumber(input)
+		assert.NoError(t, err, input)
+		assert.Equal(t, want, got)
+	}
+
+	invalid := []string{
+		"",
+		"4155550123",
+		"+1415555012a",
+		"+0415550123",
+		"+1234567",
+		"+1234567890123456",
+	}
+
+	for _, input := range invalid {
+		_, err := cauth.NormalizePhoneNumber(input)
+		assert.ErrorIs(t, err, cauth.ErrInvalidPhoneNumber, input)
+	}
+}
+
+func TestRouter_Phone(t *testing.T) {
+	t.Parallel()
+
+	var (
+		sessionResult cauth.SessionResult
+
+		smsSender  = cauthtest.NewSMSSender()
+		codeRegexp = regexp.MustCompile(`\d+`)
+	)
+
+	server := httptest.NewServer(cauthtest.NewHandlerWithParams(t, cauthtest.HandlerParams{
+		SMSSender: smsSender,
+	}))
+	defer server.Close()
+
+	resp := postJSON(t, server.URL+"/api/auth/signup", `{"phone": "415-555-0123"}`, nil)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	resp = postJSON(t, server.URL+"/api/auth/signup", `{"phone": "+1 (415) 555-0123"}`, nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&sessionResult))
+	assert.Equal(t, "+14155550123", sessionResult.User.Phone)
+	assert.Nil(t, sessionResult.Session)
+
+	// A second user can signup with a different phone number even though neither has an email
+	resp = postJSON(t, server.URL+"/api/auth/signup", `{"phone": "+14155550199", "password": "test-pass"}`, nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	resp = postJSON(t, server.URL+"/api/auth/signup", `{"phone": "+14155550199", "password": "test-pass"}`, nil)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	resp = postJSON(t, server.URL+"/api/auth/login", `{"phone": "+14155550199", "password": "test-pass"}`, nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	sms := smsSender.Sent()[0]
+	assert.Equal(t, "+14155550123", sms.To)
+
+	code := codeRegexp.FindString(sms.Body)
+	assert.NotEmpty(t, code)
+
+	resp = postJSON(t, server.URL+"/api/auth/login", `{"phone": "+14155550123", "verification_code": "wrong"}`, nil)
+	assert.NotEqual(t, http.StatusOK, resp.StatusCode)
+
+	resp = postJSON(t, server.URL+"/api/auth/login", `{"phone": "+14155550123", "verification_code": "`+code+`"}`, nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&sessionResult))
+	assert.NotEmpty(t, sessionResult.PlainSessionToken)
+}
@@ -11,14 +11,41 @@ import (
 // ErrNotFound is returned when a model does not exist in the repository
 var ErrNotFound = sql.ErrNoRows
 
-// NewQueries instantiates and returns Queries.
-func NewQueries(querier csql.Querier) *Queries {
-	return &Queries{querier: querier}
+// NewQueries instantiates and returns Queries. The csql config's dialect decides which flavor of SQL is used for
+// the queries that differ between databases.
+func NewQueries(querier csql.Querier, config csql.Config) *Queries {
+	return &Queries{
+		querier: querier,
+		dialect: config.Dialect,
+	}
 }
 
 // Queries holds the SQL queries used by cauth
 type Queries struct {
 	querier csql.Querier
+	dialect string
+}
+
+// OnCommit runs the given callback once the transaction in ctx commits, or right away if ctx has no transaction.
+// The callback runs in the background and is not run if the transaction is rolled back.
+func (q *Queries) OnCommit(ctx context.Context, cb func(context.Context) error) error {
+	return q.querier.OnCommit(ctx, cb)
+}
+
+// insertReturning runs the given INSERT query and scans the inserted row into dest. MySQL does not support
+// RETURNING, so the row is read back with the given select query instead.
+func (q *Queries) insertReturning(ctx context.Context, dest interface{}, query string, args []interface{},
+	selectQuery string, selectArgs ...interface{}) error {
+	if q.dialect != dialectMySQL {
+		return q.querier.Get(ctx, dest, query+" RETURNING *", args...)
+	}
+
+	_, err := q.querier.Exec(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+
+	return q.querier.Get(ctx, dest, selectQuery, selectArgs...)
 }
 
 // GetUserByUUID queries the users table for a user with the given uuid.
@@ -35,10 +62,15 @@ func (q *Queries) GetUserByUUID(ctx context.Context, uuid string) (*User, error)
 	return &user, nil
 }
 
-// GetUserByEmail queries the users table for a user with the given email.
+// GetUserByEmail queries the users table for a user with the given email. Users that signed up without an email
+// have an empty one, so ErrNotFound is returned for an empty email instead of one of those users.
 func (q *Queries) GetUserByEmail(ctx context.Context, email string) (*User, error) {
 	const query = `select * from cauth_users where email=?`
 
+	if email == "" {
+		return nil, ErrNotFound
+	}
+
 	var user User
 
 	err := q.querier.Get(ctx, &user, query, email)
@@ -49,16 +81,44 @@ func (q *Queries) GetUserByEmail(ctx context.Context, email string) (*User, erro
 	return &user, nil
 }
 
+// GetUserByUsername queries the users table for a user with the given normalized username.
+func (q *Queries) GetUserByUsername(ctx context.Context, username string) (*User, error) {
+	const query = `select * from cauth_users where username=?`
+
+	var user User
+
+	err := q.querier.Get(ctx, &user, query, username)
+	if err != nil {
+		return nil, err
+	}
+
+	return &user, nil
+}
+
+// GetUserByPhone queries the users table for a user with the given phone number.
+func (q *Queries) GetUserByPhone(ctx context.Context, phone string) (*User, error) {
+	const query = `select * from cauth_users where phone=?`
+
+	var user User
+
+	err := q.querier.Get(ctx, &user, query, phone)
+	if err != nil {
+		return nil, err
+	}
+
+	return &user, nil
+}
+
 // InsertUser creates the given user in cauth_users.
 func (q *Queries) InsertUser(ctx context.Context, user *User) error {
 	const query = `
-	INSERT INTO cauth_users (uuid, created_at, updated_at, email, password, email_verified_at, verification_code, verification_code_expires_at)
-	VALUES (?, ?, ?, ?, ?, ?, ?, ?)
-	RETURNING *`
+	INSERT INTO cauth_users (uuid, created_at, updated_at, email, password, email_verified_at, verification_code, verification_code_expires_at,
+		phone, phone_verified_at, phone_verification_code, phone_verification_code_expires_at, username)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
 
 	var now = time.Now()
 
-	return q.querier.Get(ctx, user, query,
+	return q.insertReturning(ctx, user, query, []interface{}{
 		user.UUID,
 		now,
 		now,
@@ -67,26 +127,61 @@ func (q *Queries) InsertUser(ctx context.Context, user *User) error {
 		user.EmailVerifiedAt,
 		user.VerificationCode,
 		user.VerificationCodeExpiresAt,
-	)
+		user.Phone,
+		user.PhoneVerifiedAt,
+		user.PhoneVerificationCode,
+		user.PhoneVerificationCodeExpiresAt,
+		user.Username,
+	}, `select * from cauth_users where uuid=?`, user.UUID)
 }
 
 // UpdateUser updates the given user in cauth_users.
 func (q *Queries) UpdateUser(ctx context.Context, user *User) error {
 	const query = `
-	UPDATE cauth_users SET updated_at=?, password=?, email_verified_at=?, verification_code=?, verification_code_expires_at=?
+	UPDATE cauth_users SET updated_at=?, email=?, username=?, password=?, email_verified_at=?, verification_code=?, verification_code_expires_at=?,
+		phone_verified_at=?, phone_verification_code=?, phone_verification_code_expires_at=?,
+		totp_secret=?, totp_enabled_at=?, totp_last_used_step=?
 	WHERE uuid=?`
 
 	_, err := q.querier.Exec(ctx, query,
 		user.UpdatedAt,
+		user.Email,
+		user.Username,
 		user.Password,
 		user.EmailVerifiedAt,
 		user.VerificationCode,
 		user.VerificationCodeExpiresAt,
+		user.PhoneVerifiedAt,
+		user.PhoneVerificationCode,
+		user.PhoneVerificationCodeExpiresAt,
+		user.TOTPSecret,
+		user.TOTPEnabledAt,
+		user.TOTPLastUsedStep,
 		user.UUID,
 	)
 	return err
 }
 
+// MarkTOTPStepUsed records the given TOTP step as the last one used by the user if no later or equal step was used
+// yet. It returns false if the step was already used.
+func (q *Queries) MarkTOTPStepUsed(ctx context.Context, userUUID string, step int64, updatedAt time.Time) (bool, error) {
+	const query = `
+	UPDATE cauth_users SET updated_at=?, totp_last_used_step=?
+	WHERE uuid=? AND (totp_last_used_step IS NULL OR totp_last_used_step < ?)`
+
+	result, err := q.querier.Exec(ctx, query, updatedAt, step, userUUID, step)
+	if err != nil {
+		return false, err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return n == 1, nil
+}
+
 // GetSession queries the sessions table for a session with the given uuid.
 func (q *Queries) GetSession(ctx context.Context, uuid string) (*Session, error) {
 	const query = `select * from cauth_sessions where uuid=?`
@@ -104,31 +199,1380 @@ func (q *Queries) GetSession(ctx context.Context, uuid string) (*Session, error)
 // InsertSession creates a new session in cauth_sessions
 func (q *Queries) InsertSession(ctx context.Context, session *Session) error {
 	const query = `
-	INSERT INTO cauth_sessions (uuid, created_at, updated_at, user_uuid, token, expires_at)
-	VALUES (?, ?, ?, ?, ?, ?)
-	RETURNING *`
+	INSERT INTO cauth_sessions (uuid, created_at, updated_at, user_uuid, token, expires_at, user_agent, ip, last_seen_at,
+	                            remember_me)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
 
-	return q.querier.Get(ctx, session, query,
+	return q.insertReturning(ctx, session, query, []interface{}{
 		session.UUID,
 		session.CreatedAt,
 		session.UpdatedAt,
 		session.UserUUID,
 		session.Token,
 		session.ExpiresAt,
-	)
+		session.UserAgent,
+		session.IP,
+		session.LastSeenAt,
+		session.RememberMe,
+	}, `select * from cauth_sessions where uuid=?`, session.UUID)
 }
 
 // UpdateSession updates the given session in cauth_sessions.
 func (q *Queries) UpdateSession(ctx context.Context, session *Session) error {
 	const query = `
-	UPDATE cauth_sessions SET updated_at=?, expires_at=?, impersonated_user_uuid=?
+	UPDATE cauth_sessions SET updated_at=?, expires_at=?, impersonated_user_uuid=?, last_seen_at=?, active_organization_uuid=?
 	WHERE uuid=?`
 
 	_, err := q.querier.Exec(ctx, query,
 		session.UpdatedAt,
 		session.ExpiresAt,
 		session.ImpersonatedUserUUID,
+		session.LastSeenAt,
+		session.ActiveOrganizationUUID,
 		session.UUID,
 	)
 	return err
 }
+
+// UpdateSessionToken replaces the token of the session identified by the given uuid.
+func (q *Queries) UpdateSessionToken(ctx context.Context, uuid string, token []byte) error {
+	const query = `UPDATE cauth_sessions SET token=? WHERE uuid=?`
+
+	_, err := q.querier.Exec(ctx, query, token, uuid)
+	return err
+}
+
+// ListActiveSessionsByUserUUID queries the sessions table for all sessions of the given user that expire after
+// now.
+func (q *Queries) ListActiveSessionsByUserUUID(ctx context.Context, userUUID string, now time.Time) ([]Session, error) {
+	const query = `select * from cauth_sessions where user_uuid=? and expires_at>? order by last_seen_at desc`
+
+	sessions := make([]Session, 0)
+
+	err := q.querier.Select(ctx, &sessions, query, userUUID, now)
+	if err != nil {
+		return nil, err
+	}
+
+	return sessions, nil
+}
+
+// ExpireSessionsByUserUUID sets the expiry of all active sessions of the given user to now, except for the session
+// identified by exceptSessionUUID.
+func (q *Queries) ExpireSessionsByUserUUID(ctx context.Context, userUUID, exceptSessionUUID string, now time.Time) error {
+	const query = `
+	UPDATE cauth_sessions SET updated_at=?, expires_at=?
+	WHERE user_uuid=? AND uuid<>? AND expires_at>?`
+
+	_, err := q.querier.Exec(ctx, query, now, now, userUUID, exceptSessionUUID, now)
+	return err
+}
+
+// InsertBackupCode creates the given backup code in cauth_backup_codes.
+func (q *Queries) InsertBackupCode(ctx context.Context, code *BackupCode) error {
+	const query = `
+	INSERT INTO cauth_backup_codes (uuid, created_at, user_uuid, code, used_at)
+	VALUES (?, ?, ?, ?, ?)`
+
+	_, err := q.querier.Exec(ctx, query,
+		code.UUID,
+		code.CreatedAt,
+		code.UserUUID,
+		code.Code,
+		code.UsedAt,
+	)
+	return err
+}
+
+// GetUnusedBackupCode queries the backup codes table for an unused code with the given hash that belongs to the
+// given user.
+func (q *Queries) GetUnusedBackupCode(ctx context.Context, userUUID string, code []byte) (*BackupCode, error) {
+	const query = `select * from cauth_backup_codes where user_uuid=? and code=? and used_at is null`
+
+	var backupCode BackupCode
+
+	err := q.querier.Get(ctx, &backupCode, query, userUUID, code)
+	if err != nil {
+		return nil, err
+	}
+
+	return &backupCode, nil
+}
+
+// MarkBackupCodeUsed sets the used_at timestamp on the backup code with the given uuid if it has not been used yet.
+// It returns false if the code was already used.
+func (q *Queries) MarkBackupCodeUsed(ctx context.Context, uuid string, usedAt time.Time) (bool, error) {
+	const query = `UPDATE cauth_backup_codes SET used_at=? WHERE uuid=? AND used_at IS NULL`
+
+	result, err := q.querier.Exec(ctx, query, usedAt, uuid)
+	if err != nil {
+		return false, err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return n == 1, nil
+}
+
+// DeleteBackupCodesByUserUUID deletes all backup codes that belong to the given user.
+func (q *Queries) DeleteBackupCodesByUserUUID(ctx context.Context, userUUID string) error {
+	const query = `DELETE FROM cauth_backup_codes WHERE user_uuid=?`
+
+	_, err := q.querier.Exec(ctx, query, userUUID)
+	return err
+}
+
+// GetTwoFactorChallenge queries the two-factor challenges table for a challenge with the given uuid.
+func (q *Queries) GetTwoFactorChallenge(ctx context.Context, uuid string) (*TwoFactorChallenge, error) {
+	const query = `select * from cauth_two_factor_challenges where uuid=?`
+
+	var challenge TwoFactorChallenge
+
+	err := q.querier.Get(ctx, &challenge, query, uuid)
+	if err != nil {
+		return nil, err
+	}
+
+	return &challenge, nil
+}
+
+// InsertTwoFactorChallenge creates the given challenge in cauth_two_factor_challenges.
+func (q *Queries) InsertTwoFactorChallenge(ctx context.Context, challenge *TwoFactorChallenge) error {
+	const query = `
+	INSERT INTO cauth_two_factor_challenges (uuid, created_at, updated_at, user_uuid, token, expires_at, remember_me)
+	VALUES (?, ?, ?, ?, ?, ?, ?)`
+
+	_, err := q.querier.Exec(ctx, query,
+		challenge.UUID,
+		challenge.CreatedAt,
+		challenge.UpdatedAt,
+		challenge.UserUUID,
+		challenge.Token,
+		challenge.ExpiresAt,
+		challenge.RememberMe,
+	)
+	return err
+}
+
+// ConsumeTwoFactorChallenge expires the challenge with the given uuid if it has not expired or been consumed yet. It
+// returns false if the challenge was already consumed.
+func (q *Queries) ConsumeTwoFactorChallenge(ctx context.Context, uuid string, now time.Time) (bool, error) {
+	const query = `UPDATE cauth_two_factor_challenges SET updated_at=?, expires_at=? WHERE uuid=? AND expires_at > ?`
+
+	result, err := q.querier.Exec(ctx, query, now, now, uuid, now)
+	if err != nil {
+		return false, err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return n == 1, nil
+}
+
+// ListWebAuthnCredentialsByUserUUID queries the webauthn credentials table for all credentials registered by the
+// given user.
+func (q *Queries) ListWebAuthnCredentialsByUserUUID(ctx context.Context, userUUID string) ([]WebAuthnCredential, error) {
+	const query = `select * from cauth_webauthn_credentials where user_uuid=? order by created_at`
+
+	credentials := make([]WebAuthnCredential, 0)
+
+	err := q.querier.Select(ctx, &credentials, query, userUUID)
+	if err != nil {
+		return nil, err
+	}
+
+	return credentials, nil
+}
+
+// GetWebAuthnCredentialByCredentialID queries the webauthn credentials table for a credential with the given
+// authenticator-assigned credential id.
+func (q *Queries) GetWebAuthnCredentialByCredentialID(ctx context.Context, credentialID []byte) (*WebAuthnCredential, error) {
+	const query = `select * from cauth_webauthn_credentials where credential_id=?`
+
+	var credential WebAuthnCredential
+
+	err := q.querier.Get(ctx, &credential, query, credentialID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &credential, nil
+}
+
+// InsertWebAuthnCredential creates the given credential in cauth_webauthn_credentials.
+func (q *Queries) InsertWebAuthnCredential(ctx context.Context, credential *WebAuthnCredential) error {
+	const query = `
+	INSERT INTO cauth_webauthn_credentials (uuid, created_at, updated_at, user_uuid, name, credential_id, public_key, sign_count, last_used_at)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`
+
+	_, err := q.querier.Exec(ctx, query,
+		credential.UUID,
+		credential.CreatedAt,
+		credential.UpdatedAt,
+		credential.UserUUID,
+		credential.Name,
+		credential.CredentialID,
+		credential.PublicKey,
+		credential.SignCount,
+		credential.LastUsedAt,
+	)
+	return err
+}
+
+// UpdateWebAuthnCredential updates the given credential in cauth_webauthn_credentials.
+func (q *Queries) UpdateWebAuthnCredential(ctx context.Context, credential *WebAuthnCredential) error {
+	const query = `
+	UPDATE cauth_webauthn_credentials SET updated_at=?, name=?, sign_count=?, last_used_at=?
+	WHERE uuid=?`
+
+	_, err := q.querier.Exec(ctx, query,
+		credential.UpdatedAt,
+		credential.Name,
+		credential.SignCount,
+		credential.LastUsedAt,
+		credential.UUID,
+	)
+	return err
+}
+
+// DeleteWebAuthnCredential deletes the credential with the given uuid if it belongs to the given user.
+func (q *Queries) DeleteWebAuthnCredential(ctx context.Context, userUUID, uuid string) error {
+	const query = `DELETE FROM cauth_webauthn_credentials WHERE user_uuid=? AND uuid=?`
+
+	_, err := q.querier.Exec(ctx, query, userUUID, uuid)
+	return err
+}
+
+// GetWebAuthnChallenge queries the webauthn challenges table for a challenge with the given uuid.
+func (q *Queries) GetWebAuthnChallenge(ctx context.Context, uuid string) (*WebAuthnChallenge, error) {
+	const query = `select * from cauth_webauthn_challenges where uuid=?`
+
+	var challenge WebAuthnChallenge
+
+	err := q.querier.Get(ctx, &challenge, query, uuid)
+	if err != nil {
+		return nil, err
+	}
+
+	return &challenge, nil
+}
+
+// InsertWebAuthnChallenge creates the given challenge in cauth_webauthn_challenges.
+func (q *Queries) InsertWebAuthnChallenge(ctx context.Context, challenge *WebAuthnChallenge) error {
+	const query = `
+	INSERT INTO cauth_webauthn_challenges (uuid, created_at, updated_at, user_uuid, ceremony, challenge, expires_at)
+	VALUES (?, ?, ?, ?, ?, ?, ?)`
+
+	_, err := q.querier.Exec(ctx, query,
+		challenge.UUID,
+		challenge.CreatedAt,
+		challenge.UpdatedAt,
+		challenge.UserUUID,
+		challenge.Ceremony,
+		challenge.Challenge,
+		challenge.ExpiresAt,
+	)
+	return err
+}
+
+// UpdateWebAuthnChallenge updates the given challenge in cauth_webauthn_challenges.
+func (q *Queries) UpdateWebAuthnChallenge(ctx context.Context, challenge *WebAuthnChallenge) error {
+	const query = `
+	UPDATE cauth_webauthn_challenges SET updated_at=?, expires_at=?
+	WHERE uuid=?`
+
+	_, err := q.querier.Exec(ctx, query,
+		challenge.UpdatedAt,
+		challenge.ExpiresAt,
+		challenge.UUID,
+	)
+	return err
+}
+
+// GetIdentity queries the identities table for the identity with the given provider and subject.
+func (q *Queries) GetIdentity(ctx context.Context, provider, subject string) (*Identity, error) {
+	const query = `select * from cauth_identities where provider=? and subject=?`
+
+	var identity Identity
+
+	err := q.querier.Get(ctx, &identity, query, provider, subject)
+	if err != nil {
+		return nil, err
+	}
+
+	return &identity, nil
+}
+
+// ListIdentitiesByUserUUID queries the identities table for all identities linked to the given user.
+func (q *Queries) ListIdentitiesByUserUUID(ctx context.Context, userUUID string) ([]Identity, error) {
+	const query = `select * from cauth_identities where user_uuid=? order by created_at`
+
+	identities := make([]Identity, 0)
+
+	err := q.querier.Select(ctx, &identities, query, userUUID)
+	if err != nil {
+		return nil, err
+	}
+
+	return identities, nil
+}
+
+// InsertIdentity creates the given identity in cauth_identities.
+func (q *Queries) InsertIdentity(ctx context.Context, identity *Identity) error {
+	const query = `
+	INSERT INTO cauth_identities (uuid, created_at, updated_at, user_uuid, provider, subject, email)
+	VALUES (?, ?, ?, ?, ?, ?, ?)`
+
+	_, err := q.querier.Exec(ctx, query,
+		identity.UUID,
+		identity.CreatedAt,
+		identity.UpdatedAt,
+		identity.UserUUID,
+		identity.Provider,
+		identity.Subject,
+		identity.Email,
+	)
+	return err
+}
+
+// GetOAuthStateByState queries the oauth states table for the given state value.
+func (q *Queries) GetOAuthStateByState(ctx context.Context, state string) (*OAuthState, error) {
+	const query = `select * from cauth_oauth_states where state=?`
+
+	var oauthState OAuthState
+
+	err := q.querier.Get(ctx, &oauthState, query, state)
+	if err != nil {
+		return nil, err
+	}
+
+	return &oauthState, nil
+}
+
+// InsertOAuthState creates the given state in cauth_oauth_states.
+func (q *Queries) InsertOAuthState(ctx context.Context, state *OAuthState) error {
+	const query = `
+	INSERT INTO cauth_oauth_states (uuid, created_at, updated_at, provider, state, code_verifier, expires_at)
+	VALUES (?, ?, ?, ?, ?, ?, ?)`
+
+	_, err := q.querier.Exec(ctx, query,
+		state.UUID,
+		state.CreatedAt,
+		state.UpdatedAt,
+		state.Provider,
+		state.State,
+		state.CodeVerifier,
+		state.ExpiresAt,
+	)
+	return err
+}
+
+// UpdateOAuthState updates the given state in cauth_oauth_states.
+func (q *Queries) UpdateOAuthState(ctx context.Context, state *OAuthState) error {
+	const query = `
+	UPDATE cauth_oauth_states SET updated_at=?, expires_at=?
+	WHERE uuid=?`
+
+	_, err := q.querier.Exec(ctx, query,
+		state.UpdatedAt,
+		state.ExpiresAt,
+		state.UUID,
+	)
+	return err
+}
+
+// GetMagicLink queries the magic links table for a link with the given uuid.
+func (q *Queries) GetMagicLink(ctx context.Context, uuid string) (*MagicLink, error) {
+	const query = `select * from cauth_magic_links where uuid=?`
+
+	var magicLink MagicLink
+
+	err := q.querier.Get(ctx, &magicLink, query, uuid)
+	if err != nil {
+		return nil, err
+	}
+
+	return &magicLink, nil
+}
+
+// InsertMagicLink creates the given link in cauth_magic_links.
+func (q *Queries) InsertMagicLink(ctx context.Context, magicLink *MagicLink) error {
+	const query = `
+	INSERT INTO cauth_magic_links (uuid, created_at, updated_at, user_uuid, expires_at, used_at)
+	VALUES (?, ?, ?, ?, ?, ?)`
+
+	_, err := q.querier.Exec(ctx, query,
+		magicLink.UUID,
+		magicLink.CreatedAt,
+		magicLink.UpdatedAt,
+		magicLink.UserUUID,
+		magicLink.ExpiresAt,
+		magicLink.UsedAt,
+	)
+	return err
+}
+
+// MarkMagicLinkUsed sets the used_at timestamp on the magic link with the given uuid if it has not been used yet.
+// It returns false if the link was already used.
+func (q *Queries) MarkMagicLinkUsed(ctx context.Context, uuid string, usedAt time.Time) (bool, error) {
+	const query = `UPDATE cauth_magic_links SET updated_at=?, used_at=? WHERE uuid=? AND used_at IS NULL`
+
+	result, err := q.querier.Exec(ctx, query, usedAt, usedAt, uuid)
+	if err != nil {
+		return false, err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return n == 1, nil
+}
+
+// ListUsernameHistorySince queries the username history table for all users that gave up the given username
+// after since.
+func (q *Queries) ListUsernameHistorySince(ctx context.Context, username string, since time.Time) ([]UsernameHistory, error) {
+	const query = `select * from cauth_username_history where username=? and created_at>?`
+
+	history := make([]UsernameHistory, 0)
+
+	err := q.querier.Select(ctx, &history, query, username, since)
+	if err != nil {
+		return nil, err
+	}
+
+	return history, nil
+}
+
+// InsertUsernameHistory creates the given history entry in cauth_username_history.
+func (q *Queries) InsertUsernameHistory(ctx context.Context, history *UsernameHistory) error {
+	const query = `
+	INSERT INTO cauth_username_history (uuid, created_at, user_uuid, username)
+	VALUES (?, ?, ?, ?)`
+
+	_, err := q.querier.Exec(ctx, query,
+		history.UUID,
+		history.CreatedAt,
+		history.UserUUID,
+		history.Username,
+	)
+	return err
+}
+
+// GetFailedAttempt queries the failed attempts table for the counter with the given key that expires after now.
+func (q *Queries) GetFailedAttempt(ctx context.Context, key string, now time.Time) (*FailedAttempt, error) {
+	const query = `select * from cauth_failed_attempts where attempt_key=? and expires_at>?`
+
+	var attempt FailedAttempt
+
+	err := q.querier.Get(ctx, &attempt, query, key, now)
+	if err != nil {
+		return nil, err
+	}
+
+	return &attempt, nil
+}
+
+// IncrementFailedAttempt adds a failed attempt to the counter with the given key and returns the updated counter.
+// Expired counters start over from 1.
+func (q *Queries) IncrementFailedAttempt(ctx context.Context, key string, now, expiresAt time.Time) (*FailedAttempt, error) {
+	const query = `
+	INSERT INTO cauth_failed_attempts (attempt_key, created_at, updated_at, count, last_failed_at, expires_at)
+	VALUES (?, ?, ?, 1, ?, ?)
+	ON CONFLICT (attempt_key) DO UPDATE SET
+		updated_at=excluded.updated_at,
+		count=CASE WHEN cauth_failed_attempts.expires_at>? THEN cauth_failed_attempts.count+1 ELSE 1 END,
+		last_failed_at=excluded.last_failed_at,
+		expires_at=excluded.expires_at`
+
+	// MySQL assigns the columns in order, so count must be computed before expires_at is overwritten.
+	const mysqlQuery = `
+	INSERT INTO cauth_failed_attempts (attempt_key, created_at, updated_at, count, last_failed_at, expires_at)
+	VALUES (?, ?, ?, 1, ?, ?) AS excluded
+	ON DUPLICATE KEY UPDATE
+		updated_at=excluded.updated_at,
+		count=CASE WHEN cauth_failed_attempts.expires_at>? THEN cauth_failed_attempts.count+1 ELSE 1 END,
+		last_failed_at=excluded.last_failed_at,
+		expires_at=excluded.expires_at`
+
+	var attempt FailedAttempt
+
+	insertQuery := query
+	if q.dialect == dialectMySQL {
+		insertQuery = mysqlQuery
+	}
+
+	err := q.insertReturning(ctx, &attempt, insertQuery, []interface{}{key, now, now, now, expiresAt, now},
+		`select * from cauth_failed_attempts where attempt_key=?`, key)
+	if err != nil {
+		return nil, err
+	}
+
+	return &attempt, nil
+}
+
+// DeleteFailedAttempt deletes the counter with the given key.
+func (q *Queries) DeleteFailedAttempt(ctx context.Context, key string) error {
+	const query = `DELETE FROM cauth_failed_attempts WHERE attempt_key=?`
+
+	_, err := q.querier.Exec(ctx, query, key)
+	return err
+}
+
+// InsertAuditEvent creates the given event in cauth_audit_events.
+func (q *Queries) InsertAuditEvent(ctx context.Context, event *AuditEvent) error {
+	const query = `
+	INSERT INTO cauth_audit_events (uuid, created_at, type, user_uuid, session_uuid, ip, user_agent, request_id, metadata)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`
+
+	_, err := q.querier.Exec(ctx, query,
+		event.UUID,
+		event.CreatedAt,
+		event.Type,
+		event.UserUUID,
+		event.SessionUUID,
+		event.IP,
+		event.UserAgent,
+		event.RequestID,
+		event.Metadata,
+	)
+	return err
+}
+
+// ListAuditEvents queries the audit events table for events of the given user and type, most recent first. Empty
+// filters match all events.
+func (q *Queries) ListAuditEvents(ctx context.Context, userUUID, eventType string, limit, offset int) ([]AuditEvent, error) {
+	const query = `
+	select * from cauth_audit_events
+	where (?='' or user_uuid=?) and (?='' or type=?)
+	order by created_at desc, uuid desc
+	limit ? offset ?`
+
+	events := make([]AuditEvent, 0)
+
+	err := q.querier.Select(ctx, &events, query, userUUID, userUUID, eventType, eventType, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+
+	return events, nil
+}
+
+// ListAPIKeysByUserUUID queries the api keys table for the keys of the given user that have not been revoked.
+func (q *Queries) ListAPIKeysByUserUUID(ctx context.Context, userUUID string) ([]APIKey, error) {
+	const query = `select * from cauth_api_keys where user_uuid=? and revoked_at is null order by created_at`
+
+	apiKeys := make([]APIKey, 0)
+
+	err := q.querier.Select(ctx, &apiKeys, query, userUUID)
+	if err != nil {
+		return nil, err
+	}
+
+	return apiKeys, nil
+}
+
+// GetAPIKey queries the api keys table for a key with the given uuid.
+func (q *Queries) GetAPIKey(ctx context.Context, uuid string) (*APIKey, error) {
+	const query = `select * from cauth_api_keys where uuid=?`
+
+	var apiKey APIKey
+
+	err := q.querier.Get(ctx, &apiKey, query, uuid)
+	if err != nil {
+		return nil, err
+	}
+
+	return &apiKey, nil
+}
+
+// GetAPIKeyByKeyHash queries the api keys table for a key with the given hash.
+func (q *Queries) GetAPIKeyByKeyHash(ctx context.Context, keyHash []byte) (*APIKey, error) {
+	const query = `select * from cauth_api_keys where key_hash=?`
+
+	var apiKey APIKey
+
+	err := q.querier.Get(ctx, &apiKey, query, keyHash)
+	if err != nil {
+		return nil, err
+	}
+
+	return &apiKey, nil
+}
+
+// InsertAPIKey creates the given key in cauth_api_keys.
+func (q *Queries) InsertAPIKey(ctx context.Context, apiKey *APIKey) error {
+	const query = `
+	INSERT INTO cauth_api_keys (uuid, created_at, updated_at, user_uuid, name, prefix, key_hash, scopes, expires_at, last_used_at, revoked_at)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+
+	_, err := q.querier.Exec(ctx, query,
+		apiKey.UUID,
+		apiKey.CreatedAt,
+		apiKey.UpdatedAt,
+		apiKey.UserUUID,
+		apiKey.Name,
+		apiKey.Prefix,
+		apiKey.KeyHash,
+		apiKey.Scopes,
+		apiKey.ExpiresAt,
+		apiKey.LastUsedAt,
+		apiKey.RevokedAt,
+	)
+	return err
+}
+
+// UpdateAPIKey updates the given key in cauth_api_keys.
+func (q *Queries) UpdateAPIKey(ctx context.Context, apiKey *APIKey) error {
+	const query = `
+	UPDATE cauth_api_keys SET updated_at=?, name=?, last_used_at=?, revoked_at=?
+	WHERE uuid=?`
+
+	_, err := q.querier.Exec(ctx, query,
+		apiKey.UpdatedAt,
+		apiKey.Name,
+		apiKey.LastUsedAt,
+		apiKey.RevokedAt,
+		apiKey.UUID,
+	)
+	return err
+}
+
+// GetRefreshTokenByTokenHash queries the refresh tokens table for a token with the given hash.
+func (q *Queries) GetRefreshTokenByTokenHash(ctx context.Context, tokenHash []byte) (*RefreshToken, error) {
+	const query = `select * from cauth_refresh_tokens where token_hash=?`
+
+	var refreshToken RefreshToken
+
+	err := q.querier.Get(ctx, &refreshToken, query, tokenHash)
+	if err != nil {
+		return nil, err
+	}
+
+	return &refreshToken, nil
+}
+
+// InsertRefreshToken creates the given token in cauth_refresh_tokens.
+func (q *Queries) InsertRefreshToken(ctx context.Context, refreshToken *RefreshToken) error {
+	const query = `
+	INSERT INTO cauth_refresh_tokens (uuid, created_at, updated_at, session_uuid, user_uuid, token_hash, expires_at, used_at)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?)`
+
+	_, err := q.querier.Exec(ctx, query,
+		refreshToken.UUID,
+		refreshToken.CreatedAt,
+		refreshToken.UpdatedAt,
+		refreshToken.SessionUUID,
+		refreshToken.UserUUID,
+		refreshToken.TokenHash,
+		refreshToken.ExpiresAt,
+		refreshToken.UsedAt,
+	)
+	return err
+}
+
+// MarkRefreshTokenUsed sets the used_at timestamp on the refresh token with the given uuid if it has not been used
+// yet. It returns false if the token was already used.
+func (q *Queries) MarkRefreshTokenUsed(ctx context.Context, uuid string, usedAt time.Time) (bool, error) {
+	const query = `UPDATE cauth_refresh_tokens SET updated_at=?, used_at=? WHERE uuid=? AND used_at IS NULL`
+
+	result, err := q.querier.Exec(ctx, query, usedAt, usedAt, uuid)
+	if err != nil {
+		return false, err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return n == 1, nil
+}
+
+// ListRoles queries the roles table for all roles ordered by name.
+func (q *Queries) ListRoles(ctx context.Context) ([]Role, error) {
+	const query = `select * from cauth_roles order by name`
+
+	roles := make([]Role, 0)
+
+	err := q.querier.Select(ctx, &roles, query)
+	if err != nil {
+		return nil, err
+	}
+
+	return roles, nil
+}
+
+// GetRoleByName queries the roles table for a role with the given name.
+func (q *Queries) GetRoleByName(ctx context.Context, name string) (*Role, error) {
+	const query = `select * from cauth_roles where name=?`
+
+	var role Role
+
+	err := q.querier.Get(ctx, &role, query, name)
+	if err != nil {
+		return nil, err
+	}
+
+	return &role, nil
+}
+
+// InsertRole creates the given role in cauth_roles.
+func (q *Queries) InsertRole(ctx context.Context, role *Role) error {
+	const query = `
+	INSERT INTO cauth_roles (uuid, created_at, updated_at, name, description)
+	VALUES (?, ?, ?, ?, ?)`
+
+	_, err := q.querier.Exec(ctx, query,
+		role.UUID,
+		role.CreatedAt,
+		role.UpdatedAt,
+		role.Name,
+		role.Description,
+	)
+	return err
+}
+
+// DeleteRole deletes the role with the given uuid along with its permissions and assignments.
+func (q *Queries) DeleteRole(ctx context.Context, uuid string) error {
+	for _, query := range []string{
+		`DELETE FROM cauth_user_roles WHERE role_uuid=?`,
+		`DELETE FROM cauth_role_permissions WHERE role_uuid=?`,
+		`DELETE FROM cauth_roles WHERE uuid=?`,
+	} {
+		_, err := q.querier.Exec(ctx, query, uuid)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ListRolePermissions queries the role permissions table for the permissions of the given role.
+func (q *Queries) ListRolePermissions(ctx context.Context, roleUUID string) ([]string, error) {
+	const query = `select permission from cauth_role_permissions where role_uuid=? order by permission`
+
+	permissions := make([]string, 0)
+
+	err := q.querier.Select(ctx, &permissions, query, roleUUID)
+	if err != nil {
+		return nil, err
+	}
+
+	return permissions, nil
+}
+
+// InsertRolePermission creates the given role permission in cauth_role_permissions.
+func (q *Queries) InsertRolePermission(ctx context.Context, rolePermission *RolePermission) error {
+	const query = `
+	INSERT INTO cauth_role_permissions (role_uuid, permission, created_at)
+	VALUES (?, ?, ?)`
+
+	_, err := q.querier.Exec(ctx, query,
+		rolePermission.RoleUUID,
+		rolePermission.Permission,
+		rolePermission.CreatedAt,
+	)
+	return err
+}
+
+// DeleteRolePermission deletes the given permission from the given role.
+func (q *Queries) DeleteRolePermission(ctx context.Context, roleUUID, permission string) error {
+	const query = `DELETE FROM cauth_role_permissions WHERE role_uuid=? AND permission=?`
+
+	_, err := q.querier.Exec(ctx, query, roleUUID, permission)
+	return err
+}
+
+// ListRolesByUserUUID queries the roles table for the roles assigned to the given user.
+func (q *Queries) ListRolesByUserUUID(ctx context.Context, userUUID string) ([]Role, error) {
+	const query = `
+	select r.* from cauth_roles r
+	join cauth_user_roles ur on ur.role_uuid=r.uuid
+	where ur.user_uuid=?
+	order by r.name`
+
+	roles := make([]Role, 0)
+
+	err := q.querier.Select(ctx, &roles, query, userUUID)
+	if err != nil {
+		return nil, err
+	}
+
+	return roles, nil
+}
+
+// ListPermissionsByUserUUID queries the role permissions table for the permissions of all roles assigned to the
+// given user.
+func (q *Queries) ListPermissionsByUserUUID(ctx context.Context, userUUID string) ([]string, error) {
+	const query = `
+	select distinct rp.permission from cauth_role_permissions rp
+	join cauth_user_roles ur on ur.role_uuid=rp.role_uuid
+	where ur.user_uuid=?
+	order by rp.permission`
+
+	permissions := make([]string, 0)
+
+	err := q.querier.Select(ctx, &permissions, query, userUUID)
+	if err != nil {
+		return nil, err
+	}
+
+	return permissions, nil
+}
+
+// InsertUserRole creates the given user role in cauth_user_roles.
+func (q *Queries) InsertUserRole(ctx context.Context, userRole *UserRole) error {
+	const query = `
+	INSERT INTO cauth_user_roles (user_uuid, role_uuid, created_at)
+	VALUES (?, ?, ?)`
+
+	_, err := q.querier.Exec(ctx, query,
+		userRole.UserUUID,
+		userRole.RoleUUID,
+		userRole.CreatedAt,
+	)
+	return err
+}
+
+// DeleteUserRole deletes the given role from the given user.
+func (q *Queries) DeleteUserRole(ctx context.Context, userUUID, roleUUID string) error {
+	const query = `DELETE FROM cauth_user_roles WHERE user_uuid=? AND role_uuid=?`
+
+	_, err := q.querier.Exec(ctx, query, userUUID, roleUUID)
+	return err
+}
+
+// GetOrganization queries the organizations table for an organization with the given uuid.
+func (q *Queries) GetOrganization(ctx context.Context, uuid string) (*Organization, error) {
+	const query = `select * from cauth_organizations where uuid=?`
+
+	var org Organization
+
+	err := q.querier.Get(ctx, &org, query, uuid)
+	if err != nil {
+		return nil, err
+	}
+
+	return &org, nil
+}
+
+// InsertOrganization creates the given organization in cauth_organizations.
+func (q *Queries) InsertOrganization(ctx context.Context, org *Organization) error {
+	const query = `
+	INSERT INTO cauth_organizations (uuid, created_at, updated_at, name)
+	VALUES (?, ?, ?, ?)`
+
+	_, err := q.querier.Exec(ctx, query,
+		org.UUID,
+		org.CreatedAt,
+		org.UpdatedAt,
+		org.Name,
+	)
+	return err
+}
+
+// ListOrganizationsByUserUUID queries the organizations table for the organizations that the given user is a member
+// of along with their role.
+func (q *Queries) ListOrganizationsByUserUUID(ctx context.Context, userUUID string) ([]UserOrganization, error) {
+	const query = `
+	select o.*, m.role from cauth_organizations o
+	join cauth_memberships m on m.organization_uuid=o.uuid
+	where m.user_uuid=?
+	order by o.name`
+
+	orgs := make([]UserOrganization, 0)
+
+	err := q.querier.Select(ctx, &orgs, query, userUUID)
+	if err != nil {
+		return nil, err
+	}
+
+	return orgs, nil
+}
+
+// GetMembership queries the memberships table for the membership of the given user in the given organization.
+func (q *Queries) GetMembership(ctx context.Context, orgUUID, userUUID string) (*Membership, error) {
+	const query = `select * from cauth_memberships where organization_uuid=? and user_uuid=?`
+
+	var membership Membership
+
+	err := q.querier.Get(ctx, &membership, query, orgUUID, userUUID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &membership, nil
+}
+
+// ListMembershipsByOrganizationUUID queries the memberships table for the members of the given organization.
+func (q *Queries) ListMembershipsByOrganizationUUID(ctx context.Context, orgUUID string) ([]Membership, error) {
+	const query = `select * from cauth_memberships where organization_uuid=? order by created_at`
+
+	memberships := make([]Membership, 0)
+
+	err := q.querier.Select(ctx, &memberships, query, orgUUID)
+	if err != nil {
+		return nil, err
+	}
+
+	return memberships, nil
+}
+
+// InsertMembership creates the given membership in cauth_memberships.
+func (q *Queries) InsertMembership(ctx context.Context, membership *Membership) error {
+	const query = `
+	INSERT INTO cauth_memberships (organization_uuid, user_uuid, created_at, updated_at, role)
+	VALUES (?, ?, ?, ?, ?)`
+
+	_, err := q.querier.Exec(ctx, query,
+		membership.OrganizationUUID,
+		membership.UserUUID,
+		membership.CreatedAt,
+		membership.UpdatedAt,
+		membership.Role,
+	)
+	return err
+}
+
+// DeleteMembership deletes the membership of the given user in the given organization.
+func (q *Queries) DeleteMembership(ctx context.Context, orgUUID, userUUID string) error {
+	const query = `DELETE FROM cauth_memberships WHERE organization_uuid=? AND user_uuid=?`
+
+	_, err := q.querier.Exec(ctx, query, orgUUID, userUUID)
+	return err
+}
+
+// ListPermissionsByRoleName queries the role permissions table for the permissions of the role with the given name.
+func (q *Queries) ListPermissionsByRoleName(ctx context.Context, roleName string) ([]string, error) {
+	const query = `
+	select rp.permission from cauth_role_permissions rp
+	join cauth_roles r on r.uuid=rp.role_uuid
+	where r.name=?
+	order by rp.permission`
+
+	permissions := make([]string, 0)
+
+	err := q.querier.Select(ctx, &permissions, query, roleName)
+	if err != nil {
+		return nil, err
+	}
+
+	return permissions, nil
+}
+
+// GetInvitation queries the invitations table for an invitation with the given uuid.
+func (q *Queries) GetInvitation(ctx context.Context, uuid string) (*Invitation, error) {
+	const query = `select * from cauth_invitations where uuid=?`
+
+	var invitation Invitation
+
+	err := q.querier.Get(ctx, &invitation, query, uuid)
+	if err != nil {
+		return nil, err
+	}
+
+	return &invitation, nil
+}
+
+// GetInvitationByTokenHash queries the invitations table for an invitation with the given token hash.
+func (q *Queries) GetInvitationByTokenHash(ctx context.Context, tokenHash []byte) (*Invitation, error) {
+	const query = `select * from cauth_invitations where token_hash=?`
+
+	var invitation Invitation
+
+	err := q.querier.Get(ctx, &invitation, query, tokenHash)
+	if err != nil {
+		return nil, err
+	}
+
+	return &invitation, nil
+}
+
+// ListPendingInvitationsByInviterUUID queries the invitations table for the invitations sent by the given user that
+// have not been accepted or revoked.
+func (q *Queries) ListPendingInvitationsByInviterUUID(ctx context.Context, inviterUUID string) ([]Invitation, error) {
+	const query = `
+	select * from cauth_invitations
+	where inviter_uuid=? and accepted_at is null and revoked_at is null
+	order by created_at`
+
+	invitations := make([]Invitation, 0)
+
+	err := q.querier.Select(ctx, &invitations, query, inviterUUID)
+	if err != nil {
+		return nil, err
+	}
+
+	return invitations, nil
+}
+
+// InsertInvitation creates the given invitation in cauth_invitations.
+func (q *Queries) InsertInvitation(ctx context.Context, invitation *Invitation) error {
+	const query = `
+	INSERT INTO cauth_invitations (uuid, created_at, updated_at, inviter_uuid, email, organization_uuid, role, token_hash, expires_at, accepted_at, revoked_at)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+
+	_, err := q.querier.Exec(ctx, query,
+		invitation.UUID,
+		invitation.CreatedAt,
+		invitation.UpdatedAt,
+		invitation.InviterUUID,
+		invitation.Email,
+		invitation.OrganizationUUID,
+		invitation.Role,
+		invitation.TokenHash,
+		invitation.ExpiresAt,
+		invitation.AcceptedAt,
+		invitation.RevokedAt,
+	)
+	return err
+}
+
+// UpdateInvitation updates the given invitation in cauth_invitations.
+func (q *Queries) UpdateInvitation(ctx context.Context, invitation *Invitation) error {
+	const query = `
+	UPDATE cauth_invitations SET updated_at=?, token_hash=?, expires_at=?, revoked_at=?
+	WHERE uuid=?`
+
+	_, err := q.querier.Exec(ctx, query,
+		invitation.UpdatedAt,
+		invitation.TokenHash,
+		invitation.ExpiresAt,
+		invitation.RevokedAt,
+		invitation.UUID,
+	)
+	return err
+}
+
+// MarkInvitationAccepted sets the accepted_at timestamp on the invitation with the given uuid if it has not been
+// accepted or revoked yet. It returns false otherwise.
+func (q *Queries) MarkInvitationAccepted(ctx context.Context, uuid string, acceptedAt time.Time) (bool, error) {
+	const query = `
+	UPDATE cauth_invitations SET updated_at=?, accepted_at=?
+	WHERE uuid=? AND accepted_at IS NULL AND revoked_at IS NULL`
+
+	result, err := q.querier.Exec(ctx, query, acceptedAt, acceptedAt, uuid)
+	if err != nil {
+		return false, err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return n == 1, nil
+}
+
+// ChangeUserEmail sets the email of the given user and marks it as verified, unless another user already has the
+// email. It returns false in that case.
+func (q *Queries) ChangeUserEmail(ctx context.Context, userUUID, email string, verifiedAt time.Time) (bool, error) {
+	const query = `
+	UPDATE cauth_users SET updated_at=?, email=?, email_verified_at=?
+	WHERE uuid=? AND NOT EXISTS (SELECT 1 FROM cauth_users WHERE email=?)`
+
+	// MySQL does not allow a subquery on the table that is being updated, so the other user is joined instead.
+	const mysqlQuery = `
+	UPDATE cauth_users u LEFT JOIN cauth_users taken ON taken.email=?
+	SET u.updated_at=?, u.email=?, u.email_verified_at=?
+	WHERE u.uuid=? AND taken.uuid IS NULL`
+
+	var (
+		result sql.Result
+		err    error
+	)
+
+	if q.dialect == dialectMySQL {
+		result, err = q.querier.Exec(ctx, mysqlQuery, email, verifiedAt, email, verifiedAt, userUUID)
+	} else {
+		result, err = q.querier.Exec(ctx, query, verifiedAt, email, verifiedAt, userUUID, email)
+	}
+	if err != nil {
+		return false, err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return n == 1, nil
+}
+
+// GetEmailChange queries the email changes table for the pending email change of the given user.
+func (q *Queries) GetEmailChange(ctx context.Context, userUUID string) (*EmailChange, error) {
+	const query = `select * from cauth_email_changes where user_uuid=?`
+
+	var emailChange EmailChange
+
+	err := q.querier.Get(ctx, &emailChange, query, userUUID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &emailChange, nil
+}
+
+// InsertEmailChange creates the given email change in cauth_email_changes.
+func (q *Queries) InsertEmailChange(ctx context.Context, emailChange *EmailChange) error {
+	const query = `
+	INSERT INTO cauth_email_changes (user_uuid, created_at, updated_at, new_email, verification_code, expires_at)
+	VALUES (?, ?, ?, ?, ?, ?)`
+
+	_, err := q.querier.Exec(ctx, query,
+		emailChange.UserUUID,
+		emailChange.CreatedAt,
+		emailChange.UpdatedAt,
+		emailChange.NewEmail,
+		emailChange.VerificationCode,
+		emailChange.ExpiresAt,
+	)
+	return err
+}
+
+// DeleteEmailChange deletes the pending email change of the given user.
+func (q *Queries) DeleteEmailChange(ctx context.Context, userUUID string) error {
+	const query = `DELETE FROM cauth_email_changes WHERE user_uuid=?`
+
+	_, err := q.querier.Exec(ctx, query, userUUID)
+	return err
+}
+
+// SetUserDeletedAt marks the given user as deleted, or restores them if deletedAt is nil.
+func (q *Queries) SetUserDeletedAt(ctx context.Context, userUUID string, deletedAt *time.Time) error {
+	const query = `UPDATE cauth_users SET updated_at=?, deleted_at=? WHERE uuid=?`
+
+	_, err := q.querier.Exec(ctx, query, time.Now(), deletedAt, userUUID)
+	return err
+}
+
+// ListUserUUIDsDeletedBefore queries the users table for up to limit users that were deleted before the given time.
+func (q *Queries) ListUserUUIDsDeletedBefore(ctx context.Context, before time.Time, limit int) ([]string, error) {
+	const query = `select uuid from cauth_users where deleted_at is not null and deleted_at<? order by deleted_at limit ?`
+
+	userUUIDs := make([]string, 0)
+
+	err := q.querier.Select(ctx, &userUUIDs, query, before, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	return userUUIDs, nil
+}
+
+// ListUsernameHistoryByUserUUID queries the username history table for the usernames that the given user previously
+// had.
+func (q *Queries) ListUsernameHistoryByUserUUID(ctx context.Context, userUUID string) ([]UsernameHistory, error) {
+	const query = `select * from cauth_username_history where user_uuid=? order by created_at`
+
+	history := make([]UsernameHistory, 0)
+
+	err := q.querier.Select(ctx, &history, query, userUUID)
+	if err != nil {
+		return nil, err
+	}
+
+	return history, nil
+}
+
+// ListInvitationsByInviterUUID queries the invitations table for all invitations sent by the given user.
+func (q *Queries) ListInvitationsByInviterUUID(ctx context.Context, inviterUUID string) ([]Invitation, error) {
+	const query = `select * from cauth_invitations where inviter_uuid=? order by created_at`
+
+	invitations := make([]Invitation, 0)
+
+	err := q.querier.Select(ctx, &invitations, query, inviterUUID)
+	if err != nil {
+		return nil, err
+	}
+
+	return invitations, nil
+}
+
+// DeleteUser permanently deletes the given user along with their sessions, credentials, memberships and other rows
+// that reference them. The user is deleted last so that a partially deleted user can be deleted again.
+func (q *Queries) DeleteUser(ctx context.Context, userUUID string) error {
+	queries := []string{
+		`DELETE FROM cauth_sessions WHERE user_uuid=?`,
+		`DELETE FROM cauth_sessions WHERE impersonated_user_uuid=?`,
+		`DELETE FROM cauth_username_history WHERE user_uuid=?`,
+		`DELETE FROM cauth_backup_codes WHERE user_uuid=?`,
+		`DELETE FROM cauth_two_factor_challenges WHERE user_uuid=?`,
+		`DELETE FROM cauth_webauthn_credentials WHERE user_uuid=?`,
+		`DELETE FROM cauth_webauthn_challenges WHERE user_uuid=?`,
+		`DELETE FROM cauth_identities WHERE user_uuid=?`,
+		`DELETE FROM cauth_magic_links WHERE user_uuid=?`,
+		`DELETE FROM cauth_audit_events WHERE user_uuid=?`,
+		`DELETE FROM cauth_api_keys WHERE user_uuid=?`,
+		`DELETE FROM cauth_refresh_tokens WHERE user_uuid=?`,
+		`DELETE FROM cauth_user_roles WHERE user_uuid=?`,
+		`DELETE FROM cauth_memberships WHERE user_uuid=?`,
+		`DELETE FROM cauth_invitations WHERE inviter_uuid=?`,
+		`DELETE FROM cauth_email_changes WHERE user_uuid=?`,
+		`DELETE FROM cauth_users WHERE uuid=?`,
+	}
+
+	for _, query := range queries {
+		_, err := q.querier.Exec(ctx, query, userUUID)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ListSessionUUIDsExpiredBefore queries the sessions table for up to limit sessions that expired before the given
+// time. Logged out sessions expire when they are logged out of.
+func (q *Queries) ListSessionUUIDsExpiredBefore(ctx context.Context, before time.Time, limit int) ([]string, error) {
+	const query = `select uuid from cauth_sessions where expires_at<? limit ?`
+
+	sessionUUIDs := make([]string, 0)
+
+	err := q.querier.Select(ctx, &sessionUUIDs, query, before, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	return sessionUUIDs, nil
+}
+
+// DeleteSessions deletes the sessions with the given uuids.
+func (q *Queries) DeleteSessions(ctx context.Context, sessionUUIDs []string) error {
+	const query = `DELETE FROM cauth_sessions WHERE uuid IN (?)`
+
+	_, err := q.querier.WithIn().Exec(ctx, query, sessionUUIDs)
+	return err
+}
+
+// ListUserUUIDsWithExpiredVerificationCodes queries the users table for up to limit users whose email or phone
+// verification code expired before the given time.
+func (q *Queries) ListUserUUIDsWithExpiredVerificationCodes(ctx context.Context, before time.Time, limit int) ([]string, error) {
+	const query = `
+	select uuid from cauth_users
+	where verification_code_expires_at<? or phone_verification_code_expires_at<?
+	limit ?`
+
+	userUUIDs := make([]string, 0)
+
+	err := q.querier.Select(ctx, &userUUIDs, query, before, before, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	return userUUIDs, nil
+}
+
+// ClearExpiredVerificationCodes removes the email and phone verification codes of the given users that expired
+// before the given time.
+func (q *Queries) ClearExpiredVerificationCodes(ctx context.Context, userUUIDs []string, before time.Time) error {
+	queries := []string{
+		`UPDATE cauth_users SET verification_code=NULL, verification_code_expires_at=NULL
+		WHERE uuid IN (?) AND verification_code_expires_at<?`,
+		`UPDATE cauth_users SET phone_verification_code=NULL, phone_verification_code_expires_at=NULL
+		WHERE uuid IN (?) AND phone_verification_code_expires_at<?`,
+	}
+
+	for _, query := range queries {
+		_, err := q.querier.WithIn().Exec(ctx, query, userUUIDs, before)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ListUnverifiedUserUUIDsCreatedBefore queries the users table for up to limit users that signed up before the given
+// time but have no password, have not verified their email or phone and have no other way to login.
+func (q *Queries) ListUnverifiedUserUUIDsCreatedBefore(ctx context.Context, before time.Time, limit int) ([]string, error) {
+	const query = `
+	select uuid from cauth_users u
+	where u.created_at<? and u.password is null and u.email_verified_at is null and u.phone_verified_at is null
+	and u.deleted_at is null
+	and not exists (select 1 from cauth_identities i where i.user_uuid=u.uuid)
+	and not exists (select 1 from cauth_webauthn_credentials c where c.user_uuid=u.uuid)
+	order by u.created_at
+	limit ?`
+
+	userUUIDs := make([]string, 0)
+
+	err := q.querier.Select(ctx, &userUUIDs, query, before, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	return userUUIDs, nil
+}
+
+// DeleteExpiredTokens deletes up to limit rows that expired before the given time from each of the tables that hold
+// short-lived tokens, i.e. two-factor and WebAuthn challenges, OAuth states, magic links, pending email changes,
+// refresh tokens and failed attempt counters. It returns the number of rows that were deleted.
+func (q *Queries) DeleteExpiredTokens(ctx context.Context, before time.Time, limit int) (int, error) {
+	tables := []struct {
+		list   string
+		delete string
+	}{
+		{
+			list:   `select uuid from cauth_two_factor_challenges where expires_at<? limit ?`,
+			delete: `DELETE FROM cauth_two_factor_challenges WHERE uuid IN (?)`,
+		},
+		{
+			list:   `select uuid from cauth_webauthn_challenges where expires_at<? limit ?`,
+			delete: `DELETE FROM cauth_webauthn_challenges WHERE uuid IN (?)`,
+		},
+		{
+			list:   `select uuid from cauth_oauth_states where expires_at<? limit ?`,
+			delete: `DELETE FROM cauth_oauth_states WHERE uuid IN (?)`,
+		},
+		{
+			list:   `select uuid from cauth_magic_links where expires_at<? limit ?`,
+			delete: `DELETE FROM cauth_magic_links WHERE uuid IN (?)`,
+		},
+		{
+			list:   `select user_uuid from cauth_email_changes where expires_at<? limit ?`,
+			delete: `DELETE FROM cauth_email_changes WHERE user_uuid IN (?)`,
+		},
+		{
+			list:   `select uuid from cauth_refresh_tokens where expires_at<? limit ?`,
+			delete: `DELETE FROM cauth_refresh_tokens WHERE uuid IN (?)`,
+		},
+		{
+			list:   `select attempt_key from cauth_failed_attempts where expires_at<? limit ?`,
+			delete: `DELETE FROM cauth_failed_attempts WHERE attempt_key IN (?)`,
+		},
+	}
+
+	var deleted int
+
+	for _, table := range tables {
+		keys := make([]string, 0)
+
+		err := q.querier.Select(ctx, &keys, table.list, before, limit)
+		if err != nil {
+			return deleted, err
+		}
+
+		if len(keys) == 0 {
+			continue
+		}
+
+		_, err = q.querier.WithIn().Exec(ctx, table.delete, keys)
+		if err != nil {
+			return deleted, err
+		}
+
+		deleted += len(keys)
+	}
+
+	return deleted, nil
+}
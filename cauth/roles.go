@@ -0,0 +1,376 @@
+package cauth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gocopper/copper/cerrors"
+	"github.com/gocopper/copper/chttp"
+	"github.com/google/uuid"
+)
+
+// PermissionImpersonateUsers allows a user to impersonate other users with ImpersonateUser.
+const PermissionImpersonateUsers = "cauth.impersonate_users"
+
+var (
+	// ErrPermissionDenied is returned when a user does not have the permission needed for an action.
+	ErrPermissionDenied = errors.New("permission denied")
+
+	// ErrRoleAlreadyExists is returned when a role is created with the name of an existing role.
+	ErrRoleAlreadyExists = errors.New("role already exists")
+
+	// ErrInvalidRole is returned when a role is created without a name or with an invalid permission, or when a user
+	// is added to an organization with a role that does not exist.
+	ErrInvalidRole = errors.New("invalid role")
+)
+
+// CreateRoleParams hold the params needed to create a role.
+type CreateRoleParams struct {
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Permissions []string `json:"permissions"`
+}
+
+// CreateRole creates a role with the given permissions. Permissions are free-form strings such as "posts.publish"
+// that the app checks with Can or RequirePermission.
+func (s *Svc) CreateRole(ctx context.Context, p CreateRoleParams) (*Role, error) {
+	name := strings.TrimSpace(p.Name)
+	if name == "" {
+		return nil, ErrInvalidRole
+	}
+
+	_, err := s.queries.GetRoleByName(ctx, name)
+	if err == nil {
+		return nil, ErrRoleAlreadyExists
+	} else if !errors.Is(err, ErrNotFound) {
+		return nil, cerrors.New(err, "failed to get role by name", map[string]interface{}{
+			"name": name,
+		})
+	}
+
+	role := &Role{
+		UUID:        uuid.New().String(),
+		CreatedAt:   time.Now(),
+		UpdatedAt:   time.Now(),
+		Name:        name,
+		Description: p.Description,
+		Permissions: make([]string, 0, len(p.Permissions)),
+	}
+
+	err = s.queries.InsertRole(ctx, role)
+	if err != nil {
+		return nil, cerrors.New(err, "failed to insert role", map[string]interface{}{
+			"name": name,
+		})
+	}
+
+	for _, permission := range p.Permissions {
+		if slices.Contains(role.Permissions, permission) {
+			continue
+		}
+
+		err = s.grantPermission(ctx, role.UUID, permission)
+		if err != nil {
+			return nil, err
+		}
+
+		role.Permissions = append(role.Permissions, permission)
+	}
+
+	err = s.recordAuditEvent(ctx, &AuditEvent{
+		Type:     AuditEventRoleCreated,
+		Metadata: AuditMetadata{"role_uuid": role.UUID, "role": role.Name},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return role, nil
+}
+
+// ListRoles returns all roles along with their permissions.
+func (s *Svc) ListRoles(ctx context.Context) ([]Role, error) {
+	roles, err := s.queries.ListRoles(ctx)
+	if err != nil {
+		return nil, cerrors.New(err, "failed to list roles", nil)
+	}
+
+	return s.withRolePermissions(ctx, roles)
+}
+
+// GetRoleByName returns the role with the given name along with its permissions.
+func (s *Svc) GetRoleByName(ctx context.Context, name string) (*Role, error) {
+	role, err := s.queries.GetRoleByName(ctx, name)
+	if err != nil {
+		return nil, cerrors.New(err, "failed to get role by name", map[string]interface{}{
+			"name": name,
+		})
+	}
+
+	role.Permissions, err = s.queries.ListRolePermissions(ctx, role.UUID)
+	if err != nil {
+		return nil, cerrors.New(err, "failed to list role permissions", map[string]interface{}{
+			"roleUUID": role.UUID,
+		})
+	}
+
+	return role, nil
+}
+
+// DeleteRole deletes the given role. Users that were assigned the role lose its permissions.
+func (s *Svc) DeleteRole(ctx context.Context, roleUUID string) error {
+	err := s.queries.DeleteRole(ctx, roleUUID)
+	if err != nil {
+		return cerrors.New(err, "failed to delete role", map[string]interface{}{
+			"roleUUID": roleUUID,
+		})
+	}
+
+	return s.recordAuditEvent(ctx, &AuditEvent{
+		Type:     AuditEventRoleDeleted,
+		Metadata: AuditMetadata{"role_uuid": roleUUID},
+	})
+}
+
+// GrantPermission adds the given permission to a role. Granting a permission that the role already has is a no-op.
+func (s *Svc) GrantPermission(ctx context.Context, roleUUID, permission string) error {
+	permissions, err := s.queries.ListRolePermissions(ctx, roleUUID)
+	if err != nil {
+		return cerrors.New(err, "failed to list role permissions", map[string]interface{}{
+			"roleUUID": roleUUID,
+		})
+	}
+
+	if slices.Contains(permissions, permission) {
+		return nil
+	}
+
+	err = s.grantPermission(ctx, roleUUID, permission)
+	if err != nil {
+		return err
+	}
+
+	return s.recordAuditEvent(ctx, &AuditEvent{
+		Type:     AuditEventPermissionGranted,
+		Metadata: AuditMetadata{"role_uuid": roleUUID, "permission": permission},
+	})
+}
+
+// RevokePermission removes the given permission from a role.
+func (s *Svc) RevokePermission(ctx context.Context, roleUUID, permission string) error {
+	err := s.queries.DeleteRolePermission(ctx, roleUUID, permission)
+	if err != nil {
+		return cerrors.New(err, "failed to delete role permission", map[string]interface{}{
+			"roleUUID":   roleUUID,
+			"permission": permission,
+		})
+	}
+
+	return s.recordAuditEvent(ctx, &AuditEvent{
+		Type:     AuditEventPermissionRevoked,
+		Metadata: AuditMetadata{"role_uuid": roleUUID, "permission": permission},
+	})
+}
+
+// AssignRole gives the given role to a user. Assigning a role that the user already has is a no-op.
+func (s *Svc) AssignRole(ctx context.Context, userUUID, roleUUID string) error {
+	roles, err := s.queries.ListRolesByUserUUID(ctx, userUUID)
+	if err != nil {
+		return cerrors.New(err, "failed to list user roles", map[string]interface{}{
+			"userUUID": userUUID,
+		})
+	}
+
+	for i := range roles {
+		if roles[i].UUID == roleUUID {
+			return nil
+		}
+	}
+
+	err = s.queries.InsertUserRole(ctx, &UserRole{
+		UserUUID:  userUUID,
+		RoleUUID:  roleUUID,
+		CreatedAt: time.Now(),
+	})
+	if err != nil {
+		return cerrors.New(err, "failed to insert user role", map[string]interface{}{
+			"userUUID": userUUID,
+			"roleUUID": roleUUID,
+		})
+	}
+
+	return s.recordAuditEvent(ctx, &AuditEvent{
+		Type:     AuditEventRoleAssigned,
+		UserUUID: userUUID,
+		Metadata: AuditMetadata{"role_uuid": roleUUID},
+	})
+}
+
+// UnassignRole takes the given role away from a user.
+func (s *Svc) UnassignRole(ctx context.Context, userUUID, roleUUID string) error {
+	err := s.queries.DeleteUserRole(ctx, userUUID, roleUUID)
+	if err != nil {
+		return cerrors.New(err, "failed to delete user role", map[string]interface{}{
+			"userUUID": userUUID,
+			"roleUUID": roleUUID,
+		})
+	}
+
+	return s.recordAuditEvent(ctx, &AuditEvent{
+		Type:     AuditEventRoleUnassigned,
+		UserUUID: userUUID,
+		Metadata: AuditMetadata{"role_uuid": roleUUID},
+	})
+}
+
+// ListUserRoles returns the roles assigned to the given user along with their permissions.
+func (s *Svc) ListUserRoles(ctx context.Context, userUUID string) ([]Role, error) {
+	roles, err := s.queries.ListRolesByUserUUID(ctx, userUUID)
+	if err != nil {
+		return nil, cerrors.New(err, "failed to list user roles", map[string]interface{}{
+			"userUUID": userUUID,
+		})
+	}
+
+	return s.withRolePermissions(ctx, roles)
+}
+
+// ListUserPermissions returns the permissions that the given user has through their roles.
+func (s *Svc) ListUserPermissions(ctx context.Context, userUUID string) ([]string, error) {
+	permissions, err := s.queries.ListPermissionsByUserUUID(ctx, userUUID)
+	if err != nil {
+		return nil, cerrors.New(err, "failed to list user permissions", map[string]interface{}{
+			"userUUID": userUUID,
+		})
+	}
+
+	return permissions, nil
+}
+
+// HasPermission checks if the given user has the permission through one of their roles. HTTP handlers should
+// prefer Can, which loads the current user's permissions once per request.
+func (s *Svc) HasPermission(ctx context.Context, userUUID, permission string) (bool, error) {
+	permissions, err := s.ListUserPermissions(ctx, userUUID)
+	if err != nil {
+		return false, err
+	}
+
+	return slices.Contains(permissions, permission), nil
+}
+
+func (s *Svc) grantPermission(ctx context.Context, roleUUID, permission string) error {
+	if strings.TrimSpace(permission) == "" {
+		return ErrInvalidRole
+	}
+
+	err := s.queries.InsertRolePermission(ctx, &RolePermission{
+		RoleUUID:   roleUUID,
+		Permission: permission,
+		CreatedAt:  time.Now(),
+	})
+	if err != nil {
+		return cerrors.New(err, "failed to insert role permission", map[string]interface{}{
+			"roleUUID":   roleUUID,
+			"permission": permission,
+		})
+	}
+
+	return nil
+}
+
+func (s *Svc) withRolePermissions(ctx context.Context, roles []Role) ([]Role, error) {
+	for i := range roles {
+		permissions, err := s.queries.ListRolePermissions(ctx, roles[i].UUID)
+		if err != nil {
+			return nil, cerrors.New(err, "failed to list role permissions", map[string]interface{}{
+				"roleUUID": roles[i].UUID,
+			})
+		}
+
+		roles[i].Permissions = permissions
+	}
+
+	return roles, nil
+}
+
+// userPermissions loads the permissions of the current user the first time they are needed in a request.
+type userPermissions struct {
+	once        sync.Once
+	load        func() ([]string, error)
+	permissions []string
+	err         error
+}
+
+func (p *userPermissions) get() ([]string, error) {
+	p.once.Do(func() {
+		p.permissions, p.err = p.load()
+	})
+
+	return p.permissions, p.err
+}
+
+// ctxWithUser returns a copy of ctx that holds the given user. The user's permissions are loaded lazily so that
+// requests that don't check permissions don't query them.
+func (s *Svc) ctxWithUser(ctx context.Context, user *User) context.Context {
+	permissions := &userPermissions{
+		load: func() ([]string, error) {
+			return s.ListUserPermissions(ctx, user.UUID)
+		},
+	}
+
+	ctxWithUser := context.WithValue(ctx, ctxKeyUser, user)
+
+	return context.WithValue(ctxWithUser, ctxKeyPermissions, permissions)
+}
+
+// Can checks if the current user has the given permission. It should be used in HTTP request handlers that have the
+// VerifySessionMiddleware, SetSessionIfAnyMiddleware or VerifyAPIKeyMiddleware on them. It returns false if there is
+// no user in the context or if their permissions could not be loaded.
+func Can(ctx context.Context, permission string) bool {
+	p, ok := ctx.Value(ctxKeyPermissions).(*userPermissions)
+	if !ok || p == nil {
+		return false
+	}
+
+	permissions, err := p.get()
+	if err != nil {
+		return false
+	}
+
+	return slices.Contains(permissions, permission)
+}
+
+// RequirePermission returns a middleware that only calls the next handler if the current user has all the given
+// permissions. It must be used after a middleware that sets the current user, such as VerifySessionMiddleware. An
+// unauthorized response is sent back if there is no user, and a forbidden response if a permission is missing.
+func RequirePermission(rw *chttp.HTMLReaderWriter, permissions ...string) chttp.Middleware {
+	return chttp.HandleMiddleware(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			p, ok := r.Context().Value(ctxKeyPermissions).(*userPermissions)
+			if !ok || p == nil {
+				rw.Unauthorized(w, r)
+				return
+			}
+
+			userPermissions, err := p.get()
+			if err != nil {
+				rw.WriteHTMLError(w, r, cerrors.New(err, "failed to get user permissions", nil))
+				return
+			}
+
+			for _, permission := range permissions {
+				if !slices.Contains(userPermissions, permission) {
+					w.WriteHeader(http.StatusForbidden)
+					return
+				}
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	})
+}
@@ -0,0 +1,117 @@
+package cauth_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gocopper/pkg/cauth"
+	"github.com/gocopper/pkg/cauth/cauthtest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRouter_RequirePermission(t *testing.T) {
+	t.Parallel()
+
+	var (
+		ctx    = context.Background()
+		result struct {
+			CanWrite bool `json:"can_write"`
+		}
+	)
+
+	handler, svc := cauthtest.NewHandlerAndSvc(t, cauthtest.HandlerParams{})
+
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	get := func(session *cauth.SessionResult) *http.Response {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, server.URL+"/api/test/permission", nil)
+		assert.NoError(t, err)
+
+		if session != nil {
+			req.SetBasicAuth(session.Session.UUID, session.PlainSessionToken)
+		}
+
+		resp, err := http.DefaultClient.Do(req)
+		assert.NoError(t, err)
+		t.Cleanup(func() { _ = resp.Body.Close() })
+
+		return resp
+	}
+
+	session := cauthtest.CreateNewUserSession(t, server)
+
+	assert.Equal(t, http.StatusUnauthorized, get(nil).StatusCode)
+	assert.Equal(t, http.StatusForbidden, get(session).StatusCode)
+
+	role, err := svc.CreateRole(ctx, cauth.CreateRoleParams{
+		Name:        "reader",
+		Permissions: []string{"test.read", "test.read"},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"test.read"}, role.Permissions)
+
+	_, err = svc.CreateRole(ctx, cauth.CreateRoleParams{Name: "reader"})
+	assert.ErrorIs(t, err, cauth.ErrRoleAlreadyExists)
+
+	assert.NoError(t, svc.AssignRole(ctx, session.User.UUID, role.UUID))
+	assert.NoError(t, svc.AssignRole(ctx, session.User.UUID, role.UUID))
+
+	resp := get(session)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&result))
+	assert.False(t, result.CanWrite)
+
+	assert.NoError(t, svc.GrantPermission(ctx, role.UUID, "test.write"))
+
+	resp = get(session)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&result))
+	assert.True(t, result.CanWrite)
+
+	roles, err := svc.ListUserRoles(ctx, session.User.UUID)
+	assert.NoError(t, err)
+
+	if assert.Len(t, roles, 1) {
+		assert.Equal(t, []string{"test.read", "test.write"}, roles[0].Permissions)
+	}
+
+	assert.NoError(t, svc.UnassignRole(ctx, session.User.UUID, role.UUID))
+	assert.Equal(t, http.StatusForbidden, get(session).StatusCode)
+
+	assert.NoError(t, svc.AssignRole(ctx, session.User.UUID, role.UUID))
+	assert.NoError(t, svc.DeleteRole(ctx, role.UUID))
+	assert.Equal(t, http.StatusForbidden, get(session).StatusCode)
+}
+
+func TestSvc_ImpersonateUser_RequiresPermission(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	handler, svc := cauthtest.NewHandlerAndSvc(t, cauthtest.HandlerParams{})
+
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	session := cauthtest.CreateNewUserSession(t, server)
+
+	resp := postJSON(t, server.URL+"/api/auth/signup", `{"email": "other@example.com", "password": "other-pass"}`, nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	err := svc.ImpersonateUser(ctx, session.Session.UUID, "other@example.com")
+	assert.ErrorIs(t, err, cauth.ErrPermissionDenied)
+
+	role, err := svc.CreateRole(ctx, cauth.CreateRoleParams{
+		Name:        "support",
+		Permissions: []string{cauth.PermissionImpersonateUsers},
+	})
+	assert.NoError(t, err)
+	assert.NoError(t, svc.AssignRole(ctx, session.User.UUID, role.UUID))
+
+	assert.NoError(t, svc.ImpersonateUser(ctx, session.Session.UUID, "other@example.com"))
+	assert.NoError(t, svc.StopImpersonatingUser(ctx, session.Session.UUID))
+}
@@ -1,8 +1,13 @@
 package cauth
 
 import (
+	"crypto/subtle"
 	"errors"
+	"html/template"
 	"net/http"
+	"net/url"
+	"strconv"
+	"time"
 
 	"github.com/gocopper/copper/cerrors"
 	"github.com/gocopper/copper/chttp"
@@ -13,6 +18,7 @@ import (
 type NewRouterParams struct {
 	Auth      *Svc
 	SessionMW *VerifySessionMiddleware
+	CSRFMW    *CSRFMiddleware
 	JSON      *chttp.JSONReaderWriter
 	HTML      *chttp.HTMLReaderWriter
 	Logger    clogger.Logger
@@ -25,14 +31,18 @@ func NewRouter(p NewRouterParams) *Router {
 		json:      p.JSON,
 		html:      p.HTML,
 		sessionMW: p.SessionMW,
+		csrfMW:    p.CSRFMW,
 		logger:    p.Logger,
 	}
 }
 
-// Router handles incoming HTTP requests related the cauth package.
+// Router handles incoming HTTP requests related the cauth package. API handlers respond to failed requests with a JSON
+// body of the form {"error": "..."}, while the OAuth and magic link callbacks, which are opened in the browser, respond
+// with HTML.
 type Router struct {
 	svc       *Svc
 	sessionMW chttp.Middleware
+	csrfMW    *CSRFMiddleware
 	json      *chttp.JSONReaderWriter
 	html      *chttp.HTMLReaderWriter
 	logger    clogger.Logger
@@ -40,7 +50,7 @@ type Router struct {
 
 // Routes returns the routes managed by this router.
 func (ro *Router) Routes() []chttp.Route {
-	return []chttp.Route{
+	routes := []chttp.Route{
 		{
 			Path:    "/api/auth/signup",
 			Methods: []string{http.MethodPost},
@@ -51,18 +61,290 @@ func (ro *Router) Routes() []chttp.Route {
 			Methods: []string{http.MethodPost},
 			Handler: ro.HandleVerifyEmail,
 		},
+		{
+			Path:    "/api/auth/verify-phone",
+			Methods: []string{http.MethodPost},
+			Handler: ro.HandleVerifyPhone,
+		},
 		{
 			Path:    "/api/auth/login",
 			Methods: []string{http.MethodPost},
 			Handler: ro.HandleLogin,
 		},
+		{
+			Path:    "/api/auth/login/two-factor",
+			Methods: []string{http.MethodPost},
+			Handler: ro.HandleVerifyTwoFactor,
+		},
+		{
+			Middlewares: []chttp.Middleware{ro.sessionMW},
+			Path:        "/api/auth/totp/enroll",
+			Methods:     []string{http.MethodPost},
+			Handler:     ro.HandleEnrollTOTP,
+		},
+		{
+			Middlewares: []chttp.Middleware{ro.sessionMW},
+			Path:        "/api/auth/totp/confirm",
+			Methods:     []string{http.MethodPost},
+			Handler:     ro.HandleConfirmTOTP,
+		},
+		{
+			Middlewares: []chttp.Middleware{ro.sessionMW},
+			Path:        "/api/auth/totp/disable",
+			Methods:     []string{http.MethodPost},
+			Handler:     ro.HandleDisableTOTP,
+		},
+		{
+			Middlewares: []chttp.Middleware{ro.sessionMW},
+			Path:        "/api/auth/webauthn/register/begin",
+			Methods:     []string{http.MethodPost},
+			Handler:     ro.HandleBeginWebAuthnRegistration,
+		},
+		{
+			Middlewares: []chttp.Middleware{ro.sessionMW},
+			Path:        "/api/auth/webauthn/register/finish",
+			Methods:     []string{http.MethodPost},
+			Handler:     ro.HandleFinishWebAuthnRegistration,
+		},
+		{
+			Path:    "/api/auth/webauthn/login/begin",
+			Methods: []string{http.MethodPost},
+			Handler: ro.HandleBeginWebAuthnLogin,
+		},
+		{
+			Path:    "/api/auth/webauthn/login/finish",
+			Methods: []string{http.MethodPost},
+			Handler: ro.HandleFinishWebAuthnLogin,
+		},
+		{
+			Middlewares: []chttp.Middleware{ro.sessionMW},
+			Path:        "/api/auth/webauthn/credentials",
+			Methods:     []string{http.MethodGet},
+			Handler:     ro.HandleListWebAuthnCredentials,
+		},
+		{
+			Middlewares: []chttp.Middleware{ro.sessionMW},
+			Path:        "/api/auth/webauthn/credentials/{uuid}",
+			Methods:     []string{http.MethodDelete},
+			Handler:     ro.HandleDeleteWebAuthnCredential,
+		},
+		{
+			Path:    "/api/auth/oauth/{provider}/login",
+			Methods: []string{http.MethodGet},
+			Handler: ro.HandleOAuthLogin,
+		},
+		{
+			Path:    "/api/auth/oauth/{provider}/callback",
+			Methods: []string{http.MethodGet},
+			Handler: ro.HandleOAuthCallback,
+		},
+		{
+			Middlewares: []chttp.Middleware{ro.sessionMW},
+			Path:        "/api/auth/username",
+			Methods:     []string{http.MethodPost},
+			Handler:     ro.HandleChangeUsername,
+		},
+		{
+			Middlewares: []chttp.Middleware{ro.sessionMW},
+			Path:        "/api/auth/email/change",
+			Methods:     []string{http.MethodPost},
+			Handler:     ro.HandleRequestEmailChange,
+		},
+		{
+			Middlewares: []chttp.Middleware{ro.sessionMW},
+			Path:        "/api/auth/email/change/confirm",
+			Methods:     []string{http.MethodPost},
+			Handler:     ro.HandleConfirmEmailChange,
+		},
+		{
+			Path:    "/api/auth/magic-link",
+			Methods: []string{http.MethodPost},
+			Handler: ro.HandleSendMagicLink,
+		},
+		{
+			Path:    "/api/auth/magic-link/callback",
+			Methods: []string{http.MethodGet},
+			Handler: ro.HandleMagicLinkCallback,
+		},
+		{
+			Middlewares: []chttp.Middleware{ro.sessionMW},
+			Path:        "/api/auth/me",
+			Methods:     []string{http.MethodGet},
+			Handler:     ro.HandleMe,
+		},
+		{
+			Path:    "/api/auth/password/forgot",
+			Methods: []string{http.MethodPost},
+			Handler: ro.HandleForgotPassword,
+		},
+		{
+			Path:    "/api/auth/password/reset",
+			Methods: []string{http.MethodPost},
+			Handler: ro.HandleResetPassword,
+		},
+		{
+			Middlewares: []chttp.Middleware{ro.sessionMW},
+			Path:        "/api/auth/password/update",
+			Methods:     []string{http.MethodPost},
+			Handler:     ro.HandleUpdatePassword,
+		},
+		{
+			Path:    "/api/auth/verification-code/resend",
+			Methods: []string{http.MethodPost},
+			Handler: ro.HandleResendVerificationCode,
+		},
+		{
+			Middlewares: []chttp.Middleware{ro.sessionMW},
+			Path:        "/api/auth/impersonate",
+			Methods:     []string{http.MethodPost},
+			Handler:     ro.HandleImpersonateUser,
+		},
+		{
+			Middlewares: []chttp.Middleware{ro.sessionMW},
+			Path:        "/api/auth/impersonate/stop",
+			Methods:     []string{http.MethodPost},
+			Handler:     ro.HandleStopImpersonatingUser,
+		},
+		{
+			Middlewares: []chttp.Middleware{ro.sessionMW},
+			Path:        "/api/auth/account/delete",
+			Methods:     []string{http.MethodPost},
+			Handler:     ro.HandleDeleteAccount,
+		},
+		{
+			Middlewares: []chttp.Middleware{ro.sessionMW},
+			Path:        "/api/auth/account/export",
+			Methods:     []string{http.MethodGet},
+			Handler:     ro.HandleExportAccount,
+		},
 		{
 			Middlewares: []chttp.Middleware{ro.sessionMW},
 			Path:        "/api/auth/logout",
 			Methods:     []string{http.MethodPost},
 			Handler:     ro.HandleLogout,
 		},
+		{
+			Middlewares: []chttp.Middleware{ro.sessionMW},
+			Path:        "/api/auth/sessions",
+			Methods:     []string{http.MethodGet},
+			Handler:     ro.HandleListSessions,
+		},
+		{
+			Middlewares: []chttp.Middleware{ro.sessionMW},
+			Path:        "/api/auth/sessions/revoke-others",
+			Methods:     []string{http.MethodPost},
+			Handler:     ro.HandleRevokeAllOtherSessions,
+		},
+		{
+			Middlewares: []chttp.Middleware{ro.sessionMW},
+			Path:        "/api/auth/sessions/{uuid}",
+			Methods:     []string{http.MethodDelete},
+			Handler:     ro.HandleRevokeSession,
+		},
+		{
+			Middlewares: []chttp.Middleware{ro.sessionMW},
+			Path:        "/api/auth/audit-events",
+			Methods:     []string{http.MethodGet},
+			Handler:     ro.HandleListAuditEvents,
+		},
+		{
+			Middlewares: []chttp.Middleware{ro.sessionMW},
+			Path:        "/api/auth/api-keys",
+			Methods:     []string{http.MethodPost},
+			Handler:     ro.HandleCreateAPIKey,
+		},
+		{
+			Middlewares: []chttp.Middleware{ro.sessionMW},
+			Path:        "/api/auth/api-keys",
+			Methods:     []string{http.MethodGet},
+			Handler:     ro.HandleListAPIKeys,
+		},
+		{
+			Middlewares: []chttp.Middleware{ro.sessionMW},
+			Path:        "/api/auth/api-keys/{uuid}",
+			Methods:     []string{http.MethodDelete},
+			Handler:     ro.HandleRevokeAPIKey,
+		},
+		{
+			Middlewares: []chttp.Middleware{ro.sessionMW},
+			Path:        "/api/auth/organizations",
+			Methods:     []string{http.MethodPost},
+			Handler:     ro.HandleCreateOrganization,
+		},
+		{
+			Middlewares: []chttp.Middleware{ro.sessionMW},
+			Path:        "/api/auth/organizations",
+			Methods:     []string{http.MethodGet},
+			Handler:     ro.HandleListOrganizations,
+		},
+		{
+			Middlewares: []chttp.Middleware{ro.sessionMW},
+			Path:        "/api/auth/organizations/{uuid}/switch",
+			Methods:     []string{http.MethodPost},
+			Handler:     ro.HandleSwitchOrganization,
+		},
+		{
+			Middlewares: []chttp.Middleware{ro.sessionMW},
+			Path:        "/api/auth/invitations",
+			Methods:     []string{http.MethodPost},
+			Handler:     ro.HandleCreateInvitation,
+		},
+		{
+			Middlewares: []chttp.Middleware{ro.sessionMW},
+			Path:        "/api/auth/invitations",
+			Methods:     []string{http.MethodGet},
+			Handler:     ro.HandleListInvitations,
+		},
+		{
+			Middlewares: []chttp.Middleware{ro.sessionMW},
+			Path:        "/api/auth/invitations/{uuid}",
+			Methods:     []string{http.MethodDelete},
+			Handler:     ro.HandleRevokeInvitation,
+		},
+		{
+			Middlewares: []chttp.Middleware{ro.sessionMW},
+			Path:        "/api/auth/invitations/{uuid}/resend",
+			Methods:     []string{http.MethodPost},
+			Handler:     ro.HandleResendInvitation,
+		},
+		{
+			Path:    "/api/auth/invitations/accept",
+			Methods: []string{http.MethodPost},
+			Handler: ro.HandleAcceptInvitation,
+		},
+	}
+
+	if ro.svc.AccessTokensEnabled() {
+		routes = append(routes,
+			chttp.Route{
+				Middlewares: []chttp.Middleware{ro.sessionMW},
+				Path:        "/api/auth/token",
+				Methods:     []string{http.MethodPost},
+				Handler:     ro.HandleCreateAccessToken,
+			},
+			chttp.Route{
+				Path:    "/api/auth/token/refresh",
+				Methods: []string{http.MethodPost},
+				Handler: ro.HandleRefreshAccessToken,
+			},
+			chttp.Route{
+				Path:    "/api/auth/jwks",
+				Methods: []string{http.MethodGet},
+				Handler: ro.HandleJWKS,
+			},
+		)
+	}
+
+	globalMWs := []chttp.Middleware{&clientInfoMiddleware{auth: ro.svc}}
+	if ro.csrfMW != nil {
+		globalMWs = append(globalMWs, ro.csrfMW)
+	}
+
+	for i := range routes {
+		routes[i].Middlewares = append(append([]chttp.Middleware{}, globalMWs...), routes[i].Middlewares...)
 	}
+
+	return routes
 }
 
 // HandleSignup handles a user signup request.
@@ -75,14 +357,22 @@ func (ro *Router) HandleSignup(w http.ResponseWriter, r *http.Request) {
 
 	sessionResult, err := ro.svc.Signup(r.Context(), params)
 	if err != nil && errors.Is(err, ErrUserAlreadyExists) {
+		ro.writeJSONError(w, http.StatusBadRequest, err)
+		return
+	} else if err != nil && (errors.Is(err, ErrInvalidPhoneNumber) ||
+		errors.Is(err, ErrInvalidUsername) ||
+		errors.Is(err, ErrUsernameUnavailable) ||
+		errors.Is(err, ErrPasswordRequired)) {
+		ro.writeJSONError(w, http.StatusBadRequest, err)
+		return
+	} else if err != nil && errors.Is(err, ErrWeakPassword) {
 		ro.json.WriteJSON(w, chttp.WriteJSONParams{
 			StatusCode: http.StatusBadRequest,
-			Data:       map[string]string{"error": "user already exists"},
+			Data:       weakPasswordErrorData(err),
 		})
+		return
 	} else if err != nil {
-		ro.logger.Error("Failed to signup", err)
-		w.WriteHeader(http.StatusInternalServerError)
-
+		ro.writeJSONInternalError(w, cerrors.New(err, "failed to signup", nil))
 		return
 	}
 
@@ -100,11 +390,14 @@ func (ro *Router) HandleVerifyEmail(w http.ResponseWriter, r *http.Request) {
 	}
 
 	_, err := ro.svc.VerifyEmail(r.Context(), params)
-	if err != nil && errors.Is(err, ErrInvalidCredentials) {
-		ro.html.Unauthorized(w, r)
+	if err != nil && (errors.Is(err, ErrInvalidCredentials) || errors.Is(err, ErrVerificationCodeExpired)) {
+		ro.writeJSONError(w, http.StatusUnauthorized, err)
+		return
+	} else if err != nil && errors.Is(err, ErrTooManyAttempts) {
+		ro.writeJSONError(w, http.StatusTooManyRequests, err)
 		return
 	} else if err != nil {
-		ro.html.WriteHTMLError(w, r, cerrors.New(err, "failed to verify email", map[string]interface{}{
+		ro.writeJSONInternalError(w, cerrors.New(err, "failed to verify email", map[string]interface{}{
 			"email": params.Email,
 		}))
 		return
@@ -113,6 +406,34 @@ func (ro *Router) HandleVerifyEmail(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 }
 
+// HandleVerifyPhone handles a user phone verification request.
+func (ro *Router) HandleVerifyPhone(w http.ResponseWriter, r *http.Request) {
+	var params VerifyPhoneParams
+
+	if !ro.json.ReadJSON(w, r, &params) {
+		return
+	}
+
+	_, err := ro.svc.VerifyPhone(r.Context(), params)
+	if err != nil && (errors.Is(err, ErrInvalidCredentials) || errors.Is(err, ErrVerificationCodeExpired)) {
+		ro.writeJSONError(w, http.StatusUnauthorized, err)
+		return
+	} else if err != nil && errors.Is(err, ErrTooManyAttempts) {
+		ro.writeJSONError(w, http.StatusTooManyRequests, err)
+		return
+	} else if err != nil && errors.Is(err, ErrInvalidPhoneNumber) {
+		ro.writeJSONError(w, http.StatusBadRequest, err)
+		return
+	} else if err != nil {
+		ro.writeJSONInternalError(w, cerrors.New(err, "failed to verify phone", map[string]interface{}{
+			"phone": params.Phone,
+		}))
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
 // HandleLogin handles a user login request.
 func (ro *Router) HandleLogin(w http.ResponseWriter, r *http.Request) {
 	var params LoginParams
@@ -122,11 +443,17 @@ func (ro *Router) HandleLogin(w http.ResponseWriter, r *http.Request) {
 	}
 
 	sessionResult, err := ro.svc.Login(r.Context(), params)
-	if err != nil && errors.Is(err, ErrInvalidCredentials) {
-		ro.html.Unauthorized(w, r)
+	if err != nil && (errors.Is(err, ErrInvalidCredentials) || errors.Is(err, ErrVerificationCodeExpired)) {
+		ro.writeJSONError(w, http.StatusUnauthorized, err)
+		return
+	} else if err != nil && errors.Is(err, ErrTooManyAttempts) {
+		ro.writeJSONError(w, http.StatusTooManyRequests, err)
+		return
+	} else if err != nil && errors.Is(err, ErrInvalidPhoneNumber) {
+		ro.writeJSONError(w, http.StatusBadRequest, err)
 		return
 	} else if err != nil {
-		ro.html.WriteHTMLError(w, r, cerrors.New(err, "failed to login", map[string]interface{}{
+		ro.writeJSONInternalError(w, cerrors.New(err, "failed to login", map[string]interface{}{
 			"email": params.Email,
 		}))
 		return
@@ -137,18 +464,1199 @@ func (ro *Router) HandleLogin(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
-// HandleLogout handles a user logout request.
-func (ro *Router) HandleLogout(w http.ResponseWriter, r *http.Request) {
+// HandleVerifyTwoFactor handles the second step of a login for users with two-factor authentication enabled.
+func (ro *Router) HandleVerifyTwoFactor(w http.ResponseWriter, r *http.Request) {
+	var params VerifyTwoFactorParams
+
+	if !ro.json.ReadJSON(w, r, &params) {
+		return
+	}
+
+	sessionResult, err := ro.svc.VerifyTwoFactor(r.Context(), params)
+	if err != nil && (errors.Is(err, ErrInvalidCredentials) || errors.Is(err, ErrVerificationCodeExpired)) {
+		ro.writeJSONError(w, http.StatusUnauthorized, err)
+		return
+	} else if err != nil && errors.Is(err, ErrTooManyAttempts) {
+		ro.writeJSONError(w, http.StatusTooManyRequests, err)
+		return
+	} else if err != nil {
+		ro.writeJSONInternalError(w, cerrors.New(err, "failed to verify two factor challenge", map[string]interface{}{
+			"challengeUUID": params.ChallengeUUID,
+		}))
+		return
+	}
+
+	ro.json.WriteJSON(w, chttp.WriteJSONParams{
+		Data: sessionResult,
+	})
+}
+
+// HandleEnrollTOTP handles a request to start TOTP enrollment for the current user.
+func (ro *Router) HandleEnrollTOTP(w http.ResponseWriter, r *http.Request) {
+	user := GetCurrentUser(r.Context())
+
+	if ro.refuseImpersonation(w, r) {
+		return
+	}
+
+	enrollment, err := ro.svc.EnrollTOTP(r.Context(), user.UUID)
+	if err != nil && errors.Is(err, ErrTOTPAlreadyEnabled) {
+		ro.writeJSONError(w, http.StatusBadRequest, err)
+		return
+	} else if err != nil {
+		ro.writeJSONInternalError(w, cerrors.New(err, "failed to enroll totp", map[string]interface{}{
+			"userUUID": user.UUID,
+		}))
+		return
+	}
+
+	ro.json.WriteJSON(w, chttp.WriteJSONParams{
+		Data: enrollment,
+	})
+}
+
+// HandleConfirmTOTP handles a request to confirm TOTP enrollment for the current user. It responds with the
+// user's backup codes.
+func (ro *Router) HandleConfirmTOTP(w http.ResponseWriter, r *http.Request) {
 	var (
-		ctx     = r.Context()
-		session = GetCurrentSession(ctx)
+		params ConfirmTOTPParams
+		user   = GetCurrentUser(r.Context())
 	)
 
-	err := ro.svc.Logout(ctx, session.UUID)
-	if err != nil {
-		ro.html.WriteHTMLError(w, r, cerrors.New(err, "failed to logout", map[string]interface{}{
-			"session": session.UUID,
+	if ro.refuseImpersonation(w, r) {
+		return
+	}
+
+	if !ro.json.ReadJSON(w, r, &params) {
+		return
+	}
+
+	params.UserUUID = user.UUID
+
+	backupCodes, err := ro.svc.ConfirmTOTP(r.Context(), params)
+	if err != nil && errors.Is(err, ErrInvalidCredentials) {
+		ro.writeJSONError(w, http.StatusUnauthorized, err)
+		return
+	} else if err != nil && errors.Is(err, ErrTooManyAttempts) {
+		ro.writeJSONError(w, http.StatusTooManyRequests, err)
+		return
+	} else if err != nil && (errors.Is(err, ErrTOTPAlreadyEnabled) || errors.Is(err, ErrTOTPNotEnrolled)) {
+		ro.writeJSONError(w, http.StatusBadRequest, err)
+		return
+	} else if err != nil {
+		ro.writeJSONInternalError(w, cerrors.New(err, "failed to confirm totp", map[string]interface{}{
+			"userUUID": user.UUID,
+		}))
+		return
+	}
+
+	ro.json.WriteJSON(w, chttp.WriteJSONParams{
+		Data: map[string][]string{"backup_codes": backupCodes},
+	})
+}
+
+// HandleDisableTOTP handles a request to disable TOTP for the current user.
+func (ro *Router) HandleDisableTOTP(w http.ResponseWriter, r *http.Request) {
+	var (
+		params DisableTOTPParams
+		user   = GetCurrentUser(r.Context())
+	)
+
+	if ro.refuseImpersonation(w, r) {
+		return
+	}
+
+	if !ro.json.ReadJSON(w, r, &params) {
+		return
+	}
+
+	params.UserUUID = user.UUID
+
+	err := ro.svc.DisableTOTP(r.Context(), params)
+	if err != nil && errors.Is(err, ErrInvalidCredentials) {
+		ro.writeJSONError(w, http.StatusUnauthorized, err)
+		return
+	} else if err != nil && errors.Is(err, ErrTooManyAttempts) {
+		ro.writeJSONError(w, http.StatusTooManyRequests, err)
+		return
+	} else if err != nil && errors.Is(err, ErrTOTPNotEnrolled) {
+		ro.writeJSONError(w, http.StatusBadRequest, err)
+		return
+	} else if err != nil {
+		ro.writeJSONInternalError(w, cerrors.New(err, "failed to disable totp", map[string]interface{}{
+			"userUUID": user.UUID,
 		}))
 		return
 	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// HandleBeginWebAuthnRegistration handles a request to start registering a new WebAuthn credential for the
+// current user.
+func (ro *Router) HandleBeginWebAuthnRegistration(w http.ResponseWriter, r *http.Request) {
+	user := GetCurrentUser(r.Context())
+
+	if ro.refuseImpersonation(w, r) {
+		return
+	}
+
+	options, err := ro.svc.BeginWebAuthnRegistration(r.Context(), user.UUID)
+	if err != nil {
+		ro.writeJSONInternalError(w, cerrors.New(err, "failed to begin webauthn registration", map[string]interface{}{
+			"userUUID": user.UUID,
+		}))
+		return
+	}
+
+	ro.json.WriteJSON(w, chttp.WriteJSONParams{
+		Data: options,
+	})
+}
+
+// HandleFinishWebAuthnRegistration handles the authenticator's response to a registration ceremony.
+func (ro *Router) HandleFinishWebAuthnRegistration(w http.ResponseWriter, r *http.Request) {
+	var (
+		params FinishWebAuthnRegistrationParams
+		user   = GetCurrentUser(r.Context())
+	)
+
+	if ro.refuseImpersonation(w, r) {
+		return
+	}
+
+	if !ro.json.ReadJSON(w, r, &params) {
+		return
+	}
+
+	params.UserUUID = user.UUID
+
+	credential, err := ro.svc.FinishWebAuthnRegistration(r.Context(), params)
+	if err != nil && errors.Is(err, ErrInvalidCredentials) {
+		ro.writeJSONError(w, http.StatusUnauthorized, err)
+		return
+	} else if err != nil && errors.Is(err, ErrWebAuthnCredentialExists) {
+		ro.writeJSONError(w, http.StatusBadRequest, err)
+		return
+	} else if err != nil {
+		ro.writeJSONInternalError(w, cerrors.New(err, "failed to finish webauthn registration", map[string]interface{}{
+			"userUUID": user.UUID,
+		}))
+		return
+	}
+
+	ro.json.WriteJSON(w, chttp.WriteJSONParams{
+		Data: credential,
+	})
+}
+
+// HandleBeginWebAuthnLogin handles a request to start a WebAuthn login ceremony.
+func (ro *Router) HandleBeginWebAuthnLogin(w http.ResponseWriter, r *http.Request) {
+	var params BeginWebAuthnLoginParams
+
+	if !ro.json.ReadJSON(w, r, &params) {
+		return
+	}
+
+	options, err := ro.svc.BeginWebAuthnLogin(r.Context(), params)
+	if err != nil && errors.Is(err, ErrInvalidCredentials) {
+		ro.writeJSONError(w, http.StatusUnauthorized, err)
+		return
+	} else if err != nil {
+		ro.writeJSONInternalError(w, cerrors.New(err, "failed to begin webauthn login", map[string]interface{}{
+			"email": params.Email,
+		}))
+		return
+	}
+
+	ro.json.WriteJSON(w, chttp.WriteJSONParams{
+		Data: options,
+	})
+}
+
+// HandleFinishWebAuthnLogin handles the authenticator's assertion for a login ceremony.
+func (ro *Router) HandleFinishWebAuthnLogin(w http.ResponseWriter, r *http.Request) {
+	var params FinishWebAuthnLoginParams
+
+	if !ro.json.ReadJSON(w, r, &params) {
+		return
+	}
+
+	sessionResult, err := ro.svc.FinishWebAuthnLogin(r.Context(), params)
+	if err != nil && errors.Is(err, ErrInvalidCredentials) {
+		ro.writeJSONError(w, http.StatusUnauthorized, err)
+		return
+	} else if err != nil {
+		ro.writeJSONInternalError(w, cerrors.New(err, "failed to finish webauthn login", map[string]interface{}{
+			"challengeUUID": params.ChallengeUUID,
+		}))
+		return
+	}
+
+	ro.json.WriteJSON(w, chttp.WriteJSONParams{
+		Data: sessionResult,
+	})
+}
+
+// HandleListWebAuthnCredentials handles a request to list the WebAuthn credentials of the current user.
+func (ro *Router) HandleListWebAuthnCredentials(w http.ResponseWriter, r *http.Request) {
+	user := GetCurrentUser(r.Context())
+
+	credentials, err := ro.svc.ListWebAuthnCredentials(r.Context(), user.UUID)
+	if err != nil {
+		ro.writeJSONInternalError(w, cerrors.New(err, "failed to list webauthn credentials", map[string]interface{}{
+			"userUUID": user.UUID,
+		}))
+		return
+	}
+
+	ro.json.WriteJSON(w, chttp.WriteJSONParams{
+		Data: credentials,
+	})
+}
+
+// HandleDeleteWebAuthnCredential handles a request to delete one of the current user's WebAuthn credentials.
+func (ro *Router) HandleDeleteWebAuthnCredential(w http.ResponseWriter, r *http.Request) {
+	var (
+		user           = GetCurrentUser(r.Context())
+		credentialUUID = chttp.URLParams(r)["uuid"]
+	)
+
+	err := ro.svc.DeleteWebAuthnCredential(r.Context(), user.UUID, credentialUUID)
+	if err != nil {
+		ro.writeJSONInternalError(w, cerrors.New(err, "failed to delete webauthn credential", map[string]interface{}{
+			"userUUID":       user.UUID,
+			"credentialUUID": credentialUUID,
+		}))
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// HandleOAuthLogin redirects the user to the OAuth provider to start a login.
+func (ro *Router) HandleOAuthLogin(w http.ResponseWriter, r *http.Request) {
+	provider := chttp.URLParams(r)["provider"]
+
+	result, err := ro.svc.BeginOAuthLogin(r.Context(), provider)
+	if err != nil && errors.Is(err, ErrOAuthProviderNotFound) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	} else if err != nil {
+		ro.html.WriteHTMLError(w, r, cerrors.New(err, "failed to begin oauth login", map[string]interface{}{
+			"provider": provider,
+		}))
+		return
+	}
+
+	stateCookie := getOAuthStateHTTPCookie(result.State, int(oauthStateTTL/time.Second))
+	http.SetCookie(w, &stateCookie)
+
+	http.Redirect(w, r, result.URL, http.StatusSeeOther)
+}
+
+// HandleOAuthCallback handles the redirect back from the OAuth provider. On success, the session cookies are set
+// and the user is redirected to the configured success URL.
+func (ro *Router) HandleOAuthCallback(w http.ResponseWriter, r *http.Request) {
+	var (
+		provider = chttp.URLParams(r)["provider"]
+		state    = r.URL.Query().Get("state")
+	)
+
+	// The state must match the cookie set when the login was started, so that an attacker cannot complete a login
+	// into their account in the user's browser.
+	stateCookie, err := r.Cookie(oauthStateCookieName)
+	if err != nil || state == "" || subtle.ConstantTimeCompare([]byte(stateCookie.Value), []byte(state)) != 1 {
+		ro.html.Unauthorized(w, r)
+		return
+	}
+
+	clearStateCookie := getOAuthStateHTTPCookie("", -1)
+	http.SetCookie(w, &clearStateCookie)
+
+	if r.URL.Query().Get("error") != "" {
+		ro.html.Unauthorized(w, r)
+		return
+	}
+
+	sessionResult, err := ro.svc.CompleteOAuthLogin(r.Context(), CompleteOAuthLoginParams{
+		Provider: provider,
+		Code:     r.URL.Query().Get("code"),
+		State:    state,
+	})
+	if err != nil && (errors.Is(err, ErrInvalidCredentials) ||
+		errors.Is(err, ErrOAuthEmailNotVerified) ||
+		errors.Is(err, ErrUserAlreadyExists)) {
+		ro.html.Unauthorized(w, r)
+		return
+	} else if err != nil && errors.Is(err, ErrOAuthProviderNotFound) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	} else if err != nil {
+		ro.html.WriteHTMLError(w, r, cerrors.New(err, "failed to complete oauth login", map[string]interface{}{
+			"provider": provider,
+		}))
+		return
+	}
+
+	ro.writeLoginRedirect(w, sessionResult, ro.svc.config.OAuthSuccessRedirectURL)
+}
+
+// HandleChangeUsername handles a request to change the current user's username.
+func (ro *Router) HandleChangeUsername(w http.ResponseWriter, r *http.Request) {
+	var (
+		params ChangeUsernameParams
+		user   = GetCurrentUser(r.Context())
+	)
+
+	if !ro.json.ReadJSON(w, r, &params) {
+		return
+	}
+
+	params.UserUUID = user.UUID
+
+	updatedUser, err := ro.svc.ChangeUsername(r.Context(), params)
+	if err != nil && (errors.Is(err, ErrInvalidUsername) || errors.Is(err, ErrUsernameUnavailable)) {
+		ro.writeJSONError(w, http.StatusBadRequest, err)
+		return
+	} else if err != nil {
+		ro.writeJSONInternalError(w, cerrors.New(err, "failed to change username", map[string]interface{}{
+			"userUUID": user.UUID,
+		}))
+		return
+	}
+
+	ro.json.WriteJSON(w, chttp.WriteJSONParams{
+		Data: updatedUser,
+	})
+}
+
+// HandleRequestEmailChange handles a request to change the current user's email. It sends a verification code to
+// the new email.
+func (ro *Router) HandleRequestEmailChange(w http.ResponseWriter, r *http.Request) {
+	var (
+		params RequestEmailChangeParams
+		user   = GetCurrentUser(r.Context())
+	)
+
+	if ro.refuseImpersonation(w, r) {
+		return
+	}
+
+	if !ro.json.ReadJSON(w, r, &params) {
+		return
+	}
+
+	params.UserUUID = user.UUID
+
+	err := ro.svc.RequestEmailChange(r.Context(), params)
+	if err != nil && (errors.Is(err, ErrInvalidEmail) || errors.Is(err, ErrUserAlreadyExists)) {
+		ro.writeJSONError(w, http.StatusBadRequest, err)
+		return
+	} else if err != nil {
+		ro.writeJSONInternalError(w, cerrors.New(err, "failed to request email change", map[string]interface{}{
+			"userUUID": user.UUID,
+		}))
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// HandleConfirmEmailChange handles a request to confirm a pending change of the current user's email with the
+// verification code that was sent to the new email.
+func (ro *Router) HandleConfirmEmailChange(w http.ResponseWriter, r *http.Request) {
+	var (
+		params ConfirmEmailChangeParams
+		user   = GetCurrentUser(r.Context())
+	)
+
+	if ro.refuseImpersonation(w, r) {
+		return
+	}
+
+	if !ro.json.ReadJSON(w, r, &params) {
+		return
+	}
+
+	params.UserUUID = user.UUID
+
+	updatedUser, err := ro.svc.ConfirmEmailChange(r.Context(), params)
+	if err != nil && (errors.Is(err, ErrInvalidCredentials) || errors.Is(err, ErrVerificationCodeExpired)) {
+		ro.writeJSONError(w, http.StatusUnauthorized, err)
+		return
+	} else if err != nil && errors.Is(err, ErrUserAlreadyExists) {
+		ro.writeJSONError(w, http.StatusBadRequest, err)
+		return
+	} else if err != nil {
+		ro.writeJSONInternalError(w, cerrors.New(err, "failed to confirm email change", map[string]interface{}{
+			"userUUID": user.UUID,
+		}))
+		return
+	}
+
+	ro.json.WriteJSON(w, chttp.WriteJSONParams{
+		Data: updatedUser,
+	})
+}
+
+// HandleMe responds with the current user and session. While an admin impersonates a user, the impersonated user is
+// returned and impersonating is true.
+func (ro *Router) HandleMe(w http.ResponseWriter, r *http.Request) {
+	session := GetCurrentSession(r.Context())
+
+	ro.json.WriteJSON(w, chttp.WriteJSONParams{
+		Data: map[string]interface{}{
+			"user":          GetCurrentUser(r.Context()),
+			"session":       session,
+			"impersonating": session.ImpersonatedUserUUID != nil,
+		},
+	})
+}
+
+// HandleForgotPassword handles a request to email a verification code that can be used to reset a forgotten
+// password. It responds with 200 OK even if there is no user with the email so that emails can't be enumerated.
+func (ro *Router) HandleForgotPassword(w http.ResponseWriter, r *http.Request) {
+	ro.handleSendVerificationCode(w, r, "failed to send password reset code")
+}
+
+// HandleResendVerificationCode handles a request to email a new verification code. It responds with 200 OK even if
+// there is no user with the email so that emails can't be enumerated.
+func (ro *Router) HandleResendVerificationCode(w http.ResponseWriter, r *http.Request) {
+	ro.handleSendVerificationCode(w, r, "failed to resend verification code")
+}
+
+func (ro *Router) handleSendVerificationCode(w http.ResponseWriter, r *http.Request, errMsg string) {
+	var params ResendVerificationCodeParams
+
+	if !ro.json.ReadJSON(w, r, &params) {
+		return
+	}
+
+	err := ro.svc.ResendVerificationCode(r.Context(), params.Email)
+	if err != nil && !errors.Is(err, ErrInvalidCredentials) {
+		ro.writeJSONInternalError(w, cerrors.New(err, errMsg, map[string]interface{}{
+			"email": params.Email,
+		}))
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// HandleResetPassword handles a request to reset a forgotten password with the verification code that was sent to
+// the user's email.
+func (ro *Router) HandleResetPassword(w http.ResponseWriter, r *http.Request) {
+	var params ResetPasswordParams
+
+	if !ro.json.ReadJSON(w, r, &params) {
+		return
+	}
+
+	err := ro.svc.ResetPassword(r.Context(), params)
+	if err != nil && (errors.Is(err, ErrInvalidCredentials) || errors.Is(err, ErrVerificationCodeExpired)) {
+		ro.writeJSONError(w, http.StatusUnauthorized, err)
+		return
+	} else if err != nil && errors.Is(err, ErrTooManyAttempts) {
+		ro.writeJSONError(w, http.StatusTooManyRequests, err)
+		return
+	} else if err != nil && errors.Is(err, ErrWeakPassword) {
+		ro.json.WriteJSON(w, chttp.WriteJSONParams{
+			StatusCode: http.StatusBadRequest,
+			Data:       weakPasswordErrorData(err),
+		})
+		return
+	} else if err != nil {
+		ro.writeJSONInternalError(w, cerrors.New(err, "failed to reset password", map[string]interface{}{
+			"email": params.Email,
+		}))
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// HandleUpdatePassword handles a request to change the current user's password. All of the user's sessions,
+// including the current one, are revoked.
+func (ro *Router) HandleUpdatePassword(w http.ResponseWriter, r *http.Request) {
+	var (
+		params UpdatePasswordParams
+		user   = GetCurrentUser(r.Context())
+	)
+
+	if !ro.json.ReadJSON(w, r, &params) {
+		return
+	}
+
+	params.UserUUID = user.UUID
+
+	err := ro.svc.UpdatePassword(r.Context(), params)
+	if err != nil && errors.Is(err, ErrInvalidCredentials) {
+		ro.writeJSONError(w, http.StatusUnauthorized, err)
+		return
+	} else if err != nil && errors.Is(err, ErrWeakPassword) {
+		ro.json.WriteJSON(w, chttp.WriteJSONParams{
+			StatusCode: http.StatusBadRequest,
+			Data:       weakPasswordErrorData(err),
+		})
+		return
+	} else if err != nil {
+		ro.writeJSONInternalError(w, cerrors.New(err, "failed to update password", map[string]interface{}{
+			"userUUID": user.UUID,
+		}))
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// HandleImpersonateUser handles a request to make the current session act as the user with the given email. The
+// session's user must have the PermissionImpersonateUsers permission.
+func (ro *Router) HandleImpersonateUser(w http.ResponseWriter, r *http.Request) {
+	var (
+		params  ImpersonateUserParams
+		session = GetCurrentSession(r.Context())
+	)
+
+	if !ro.json.ReadJSON(w, r, &params) {
+		return
+	}
+
+	err := ro.svc.ImpersonateUser(r.Context(), session.UUID, params.Email)
+	if err != nil && errors.Is(err, ErrPermissionDenied) {
+		ro.writeJSONError(w, http.StatusForbidden, err)
+		return
+	} else if err != nil && errors.Is(err, ErrNotFound) {
+		ro.writeJSONError(w, http.StatusNotFound, errors.New("user not found"))
+		return
+	} else if err != nil {
+		ro.writeJSONInternalError(w, cerrors.New(err, "failed to impersonate user", map[string]interface{}{
+			"sessionUUID": session.UUID,
+		}))
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// HandleStopImpersonatingUser handles a request to make the current session act as its own user again.
+func (ro *Router) HandleStopImpersonatingUser(w http.ResponseWriter, r *http.Request) {
+	session := GetCurrentSession(r.Context())
+
+	err := ro.svc.StopImpersonatingUser(r.Context(), session.UUID)
+	if err != nil {
+		ro.writeJSONInternalError(w, cerrors.New(err, "failed to stop impersonating user", map[string]interface{}{
+			"sessionUUID": session.UUID,
+		}))
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// HandleDeleteAccount handles a request to delete the current user's account. Users that have a password must
+// confirm the deletion with it.
+func (ro *Router) HandleDeleteAccount(w http.ResponseWriter, r *http.Request) {
+	var (
+		params DeleteUserParams
+		user   = GetCurrentUser(r.Context())
+	)
+
+	if ro.refuseImpersonation(w, r) {
+		return
+	}
+
+	if !ro.json.ReadJSON(w, r, &params) {
+		return
+	}
+
+	params.UserUUID = user.UUID
+
+	err := ro.svc.DeleteUser(r.Context(), params)
+	if err != nil && errors.Is(err, ErrInvalidCredentials) {
+		ro.writeJSONError(w, http.StatusUnauthorized, err)
+		return
+	} else if err != nil && errors.Is(err, ErrTooManyAttempts) {
+		ro.writeJSONError(w, http.StatusTooManyRequests, err)
+		return
+	} else if err != nil {
+		ro.writeJSONInternalError(w, cerrors.New(err, "failed to delete user", map[string]interface{}{
+			"userUUID": user.UUID,
+		}))
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// HandleExportAccount handles a request to download everything that is stored about the current user as a JSON
+// file.
+func (ro *Router) HandleExportAccount(w http.ResponseWriter, r *http.Request) {
+	user := GetCurrentUser(r.Context())
+
+	export, err := ro.svc.ExportUserData(r.Context(), user.UUID)
+	if err != nil {
+		ro.writeJSONInternalError(w, cerrors.New(err, "failed to export user data", map[string]interface{}{
+			"userUUID": user.UUID,
+		}))
+		return
+	}
+
+	w.Header().Set("Content-Disposition", `attachment; filename="account-data.json"`)
+
+	ro.json.WriteJSON(w, chttp.WriteJSONParams{
+		Data: export,
+	})
+}
+
+// HandleSendMagicLink handles a request to email a magic link to a user. Unknown emails get the same response as
+// known ones so that the route cannot be used to find out who has an account.
+func (ro *Router) HandleSendMagicLink(w http.ResponseWriter, r *http.Request) {
+	var params SendMagicLinkParams
+
+	if !ro.json.ReadJSON(w, r, &params) {
+		return
+	}
+
+	err := ro.svc.SendMagicLink(r.Context(), params)
+	if err != nil && errors.Is(err, ErrTooManyAttempts) {
+		ro.writeJSONError(w, http.StatusTooManyRequests, err)
+		return
+	} else if err != nil && !errors.Is(err, ErrInvalidCredentials) {
+		ro.writeJSONInternalError(w, cerrors.New(err, "failed to send magic link", map[string]interface{}{
+			"email": params.Email,
+		}))
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// HandleMagicLinkCallback handles a user opening a magic link. On success, the session cookies are set and the
+// user is redirected to the configured success URL.
+func (ro *Router) HandleMagicLinkCallback(w http.ResponseWriter, r *http.Request) {
+	sessionResult, err := ro.svc.LoginWithMagicLink(r.Context(), r.URL.Query().Get("token"))
+	if err != nil && (errors.Is(err, ErrInvalidCredentials) || errors.Is(err, ErrVerificationCodeExpired)) {
+		ro.html.Unauthorized(w, r)
+		return
+	} else if err != nil {
+		ro.html.WriteHTMLError(w, r, cerrors.New(err, "failed to login with magic link", nil))
+		return
+	}
+
+	ro.writeLoginRedirect(w, sessionResult, ro.svc.config.MagicLinkSuccessRedirectURL)
+}
+
+// writeLoginRedirect sets the session cookies and redirects to successURL. If the user still has to complete a
+// two-factor challenge, they are redirected to the two-factor page instead.
+func (ro *Router) writeLoginRedirect(w http.ResponseWriter, sessionResult *SessionResult, successURL string) {
+	if sessionResult.TwoFactorChallenge != nil {
+		q := url.Values{}
+		q.Set("challenge_uuid", sessionResult.TwoFactorChallenge.Challenge.UUID)
+		q.Set("challenge_token", sessionResult.TwoFactorChallenge.PlainChallengeToken)
+
+		writeClientRedirect(w, ro.svc.config.TwoFactorRedirectURL+"?"+q.Encode())
+		return
+	}
+
+	for i := range sessionResult.HTTPCookies {
+		http.SetCookie(w, &sessionResult.HTTPCookies[i])
+	}
+
+	writeClientRedirect(w, successURL)
+}
+
+// writeClientRedirect redirects using an HTML page instead of a 3xx response. Browsers do not send SameSite=Strict
+// cookies on requests in a redirect chain that started on another site (e.g. an OAuth provider or an email
+// client), so a server-side redirect would land the user on the next page without their new session.
+func writeClientRedirect(w http.ResponseWriter, redirectURL string) {
+	w.Header().Set("Content-Type", "text/html")
+	w.WriteHeader(http.StatusOK)
+
+	_ = clientRedirectTmpl.Execute(w, redirectURL)
+}
+
+var clientRedirectTmpl = template.Must(template.New("cauth/redirect").Parse( //nolint:gochecknoglobals
+	`<!DOCTYPE html><html><head><meta http-equiv="refresh" content="0;url={{.}}"></head>` +
+		`<body><a href="{{.}}">Continue</a></body></html>`,
+))
+
+// HandleLogout handles a user logout request.
+func (ro *Router) HandleLogout(w http.ResponseWriter, r *http.Request) {
+	var (
+		ctx     = r.Context()
+		session = GetCurrentSession(ctx)
+	)
+
+	err := ro.svc.Logout(ctx, session.UUID)
+	if err != nil {
+		ro.writeJSONInternalError(w, cerrors.New(err, "failed to logout", map[string]interface{}{
+			"session": session.UUID,
+		}))
+		return
+	}
+}
+
+// HandleListSessions responds with the current user's active sessions.
+func (ro *Router) HandleListSessions(w http.ResponseWriter, r *http.Request) {
+	session := GetCurrentSession(r.Context())
+
+	sessions, err := ro.svc.ListSessions(r.Context(), session.UserUUID)
+	if err != nil {
+		ro.writeJSONInternalError(w, cerrors.New(err, "failed to list sessions", map[string]interface{}{
+			"userUUID": session.UserUUID,
+		}))
+		return
+	}
+
+	ro.json.WriteJSON(w, chttp.WriteJSONParams{
+		Data: map[string]interface{}{
+			"sessions":             sessions,
+			"current_session_uuid": session.UUID,
+		},
+	})
+}
+
+// HandleRevokeSession handles a request to revoke one of the current user's sessions.
+func (ro *Router) HandleRevokeSession(w http.ResponseWriter, r *http.Request) {
+	var (
+		session     = GetCurrentSession(r.Context())
+		sessionUUID = chttp.URLParams(r)["uuid"]
+	)
+
+	err := ro.svc.RevokeSession(r.Context(), session.UserUUID, sessionUUID)
+	if err != nil && errors.Is(err, ErrNotFound) {
+		ro.writeJSONError(w, http.StatusNotFound, errors.New("session not found"))
+		return
+	} else if err != nil {
+		ro.writeJSONInternalError(w, cerrors.New(err, "failed to revoke session", map[string]interface{}{
+			"sessionUUID": sessionUUID,
+		}))
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// HandleRevokeAllOtherSessions handles a request to revoke all of the current user's sessions except for the
+// current one.
+func (ro *Router) HandleRevokeAllOtherSessions(w http.ResponseWriter, r *http.Request) {
+	session := GetCurrentSession(r.Context())
+
+	err := ro.svc.RevokeAllOtherSessions(r.Context(), session.UserUUID, session.UUID)
+	if err != nil {
+		ro.writeJSONInternalError(w, cerrors.New(err, "failed to revoke other sessions", map[string]interface{}{
+			"sessionUUID": session.UUID,
+		}))
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// HandleListAuditEvents responds with the current user's audit events, most recent first. The events can be filtered
+// with the type query param and paginated with the limit and offset query params.
+func (ro *Router) HandleListAuditEvents(w http.ResponseWriter, r *http.Request) {
+	var (
+		session = GetCurrentSession(r.Context())
+		query   = r.URL.Query()
+	)
+
+	limit, _ := strconv.Atoi(query.Get("limit"))
+	offset, _ := strconv.Atoi(query.Get("offset"))
+
+	events, err := ro.svc.ListAuditEvents(r.Context(), ListAuditEventsParams{
+		UserUUID: session.UserUUID,
+		Type:     query.Get("type"),
+		Limit:    limit,
+		Offset:   offset,
+	})
+	if err != nil {
+		ro.writeJSONInternalError(w, cerrors.New(err, "failed to list audit events", map[string]interface{}{
+			"userUUID": session.UserUUID,
+		}))
+		return
+	}
+
+	ro.json.WriteJSON(w, chttp.WriteJSONParams{
+		Data: map[string]interface{}{
+			"events": events,
+		},
+	})
+}
+
+// HandleCreateAPIKey handles a request to create an API key for the current user. The plain key is only included in
+// this response. Sessions that impersonate a user cannot create API keys since the key would outlive the
+// impersonation.
+func (ro *Router) HandleCreateAPIKey(w http.ResponseWriter, r *http.Request) {
+	var (
+		params CreateAPIKeyParams
+		user   = GetCurrentUser(r.Context())
+	)
+
+	if ro.refuseImpersonation(w, r) {
+		return
+	}
+
+	if !ro.json.ReadJSON(w, r, &params) {
+		return
+	}
+
+	params.UserUUID = user.UUID
+
+	result, err := ro.svc.CreateAPIKey(r.Context(), params)
+	if err != nil && (errors.Is(err, ErrAPIKeyNameRequired) ||
+		errors.Is(err, ErrInvalidAPIKeyScope) ||
+		errors.Is(err, ErrInvalidAPIKeyExpiry)) {
+		ro.writeJSONError(w, http.StatusBadRequest, err)
+		return
+	} else if err != nil {
+		ro.writeJSONInternalError(w, cerrors.New(err, "failed to create api key", map[string]interface{}{
+			"userUUID": user.UUID,
+		}))
+		return
+	}
+
+	ro.json.WriteJSON(w, chttp.WriteJSONParams{
+		Data: result,
+	})
+}
+
+// HandleListAPIKeys handles a request to list the current user's API keys.
+func (ro *Router) HandleListAPIKeys(w http.ResponseWriter, r *http.Request) {
+	user := GetCurrentUser(r.Context())
+
+	apiKeys, err := ro.svc.ListAPIKeys(r.Context(), user.UUID)
+	if err != nil {
+		ro.writeJSONInternalError(w, cerrors.New(err, "failed to list api keys", map[string]interface{}{
+			"userUUID": user.UUID,
+		}))
+		return
+	}
+
+	ro.json.WriteJSON(w, chttp.WriteJSONParams{
+		Data: map[string]interface{}{
+			"api_keys": apiKeys,
+		},
+	})
+}
+
+// HandleRevokeAPIKey handles a request to revoke one of the current user's API keys.
+func (ro *Router) HandleRevokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	var (
+		user       = GetCurrentUser(r.Context())
+		apiKeyUUID = chttp.URLParams(r)["uuid"]
+	)
+
+	err := ro.svc.RevokeAPIKey(r.Context(), user.UUID, apiKeyUUID)
+	if err != nil && errors.Is(err, ErrNotFound) {
+		ro.writeJSONError(w, http.StatusNotFound, errors.New("api key not found"))
+		return
+	} else if err != nil {
+		ro.writeJSONInternalError(w, cerrors.New(err, "failed to revoke api key", map[string]interface{}{
+			"apiKeyUUID": apiKeyUUID,
+		}))
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// HandleCreateOrganization handles a request to create an organization. The current user becomes its owner and the
+// new organization becomes the active organization of the current session.
+func (ro *Router) HandleCreateOrganization(w http.ResponseWriter, r *http.Request) {
+	var (
+		params  CreateOrganizationParams
+		session = GetCurrentSession(r.Context())
+		user    = GetCurrentUser(r.Context())
+	)
+
+	if !ro.json.ReadJSON(w, r, &params) {
+		return
+	}
+
+	params.UserUUID = user.UUID
+
+	org, err := ro.svc.CreateOrganization(r.Context(), params)
+	if err != nil && errors.Is(err, ErrInvalidOrganizationName) {
+		ro.writeJSONError(w, http.StatusBadRequest, err)
+		return
+	} else if err != nil {
+		ro.writeJSONInternalError(w, cerrors.New(err, "failed to create organization", map[string]interface{}{
+			"userUUID": user.UUID,
+		}))
+		return
+	}
+
+	err = ro.svc.SwitchOrganization(r.Context(), session.UUID, org.UUID)
+	if err != nil {
+		ro.writeJSONInternalError(w, cerrors.New(err, "failed to switch organization", map[string]interface{}{
+			"organizationUUID": org.UUID,
+		}))
+		return
+	}
+
+	ro.json.WriteJSON(w, chttp.WriteJSONParams{
+		Data: org,
+	})
+}
+
+// HandleListOrganizations responds with the organizations that the current user is a member of.
+func (ro *Router) HandleListOrganizations(w http.ResponseWriter, r *http.Request) {
+	var (
+		session = GetCurrentSession(r.Context())
+		user    = GetCurrentUser(r.Context())
+	)
+
+	orgs, err := ro.svc.ListOrganizations(r.Context(), user.UUID)
+	if err != nil {
+		ro.writeJSONInternalError(w, cerrors.New(err, "failed to list organizations", map[string]interface{}{
+			"userUUID": user.UUID,
+		}))
+		return
+	}
+
+	ro.json.WriteJSON(w, chttp.WriteJSONParams{
+		Data: map[string]interface{}{
+			"organizations":            orgs,
+			"active_organization_uuid": session.ActiveOrganizationUUID,
+		},
+	})
+}
+
+// HandleSwitchOrganization handles a request to change the active organization of the current session.
+func (ro *Router) HandleSwitchOrganization(w http.ResponseWriter, r *http.Request) {
+	var (
+		session = GetCurrentSession(r.Context())
+		orgUUID = chttp.URLParams(r)["uuid"]
+	)
+
+	err := ro.svc.SwitchOrganization(r.Context(), session.UUID, orgUUID)
+	if err != nil && errors.Is(err, ErrNotFound) {
+		ro.writeJSONError(w, http.StatusNotFound, errors.New("organization not found"))
+		return
+	} else if err != nil {
+		ro.writeJSONInternalError(w, cerrors.New(err, "failed to switch organization", map[string]interface{}{
+			"organizationUUID": orgUUID,
+		}))
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// HandleCreateInvitation handles a request to invite a person by email.
+func (ro *Router) HandleCreateInvitation(w http.ResponseWriter, r *http.Request) {
+	var (
+		params CreateInvitationParams
+		user   = GetCurrentUser(r.Context())
+	)
+
+	if !ro.json.ReadJSON(w, r, &params) {
+		return
+	}
+
+	params.InviterUUID = user.UUID
+
+	invitation, err := ro.svc.CreateInvitation(r.Context(), params)
+	if err != nil && errors.Is(err, ErrInvalidInvitation) {
+		ro.writeJSONError(w, http.StatusBadRequest, err)
+		return
+	} else if err != nil && errors.Is(err, ErrPermissionDenied) {
+		ro.writeJSONError(w, http.StatusForbidden, err)
+		return
+	} else if err != nil {
+		ro.writeJSONInternalError(w, cerrors.New(err, "failed to create invitation", map[string]interface{}{
+			"inviterUUID": user.UUID,
+		}))
+		return
+	}
+
+	ro.json.WriteJSON(w, chttp.WriteJSONParams{
+		Data: invitation,
+	})
+}
+
+// HandleListInvitations responds with the pending invitations sent by the current user.
+func (ro *Router) HandleListInvitations(w http.ResponseWriter, r *http.Request) {
+	user := GetCurrentUser(r.Context())
+
+	invitations, err := ro.svc.ListInvitations(r.Context(), user.UUID)
+	if err != nil {
+		ro.writeJSONInternalError(w, cerrors.New(err, "failed to list invitations", map[string]interface{}{
+			"inviterUUID": user.UUID,
+		}))
+		return
+	}
+
+	ro.json.WriteJSON(w, chttp.WriteJSONParams{
+		Data: map[string]interface{}{
+			"invitations": invitations,
+		},
+	})
+}
+
+// HandleRevokeInvitation handles a request to revoke one of the current user's pending invitations.
+func (ro *Router) HandleRevokeInvitation(w http.ResponseWriter, r *http.Request) {
+	var (
+		user           = GetCurrentUser(r.Context())
+		invitationUUID = chttp.URLParams(r)["uuid"]
+	)
+
+	err := ro.svc.RevokeInvitation(r.Context(), user.UUID, invitationUUID)
+	if err != nil && errors.Is(err, ErrNotFound) {
+		ro.writeJSONError(w, http.StatusNotFound, errors.New("invitation not found"))
+		return
+	} else if err != nil {
+		ro.writeJSONInternalError(w, cerrors.New(err, "failed to revoke invitation", map[string]interface{}{
+			"invitationUUID": invitationUUID,
+		}))
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// HandleResendInvitation handles a request to email a new link for one of the current user's pending invitations.
+func (ro *Router) HandleResendInvitation(w http.ResponseWriter, r *http.Request) {
+	var (
+		user           = GetCurrentUser(r.Context())
+		invitationUUID = chttp.URLParams(r)["uuid"]
+	)
+
+	err := ro.svc.ResendInvitation(r.Context(), user.UUID, invitationUUID)
+	if err != nil && errors.Is(err, ErrNotFound) {
+		ro.writeJSONError(w, http.StatusNotFound, errors.New("invitation not found"))
+		return
+	} else if err != nil {
+		ro.writeJSONInternalError(w, cerrors.New(err, "failed to resend invitation", map[string]interface{}{
+			"invitationUUID": invitationUUID,
+		}))
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// HandleAcceptInvitation handles a request to accept an invitation. It responds with a new session for the invited
+// user, or a pending two-factor challenge.
+func (ro *Router) HandleAcceptInvitation(w http.ResponseWriter, r *http.Request) {
+	var params AcceptInvitationParams
+
+	if !ro.json.ReadJSON(w, r, &params) {
+		return
+	}
+
+	sessionResult, err := ro.svc.AcceptInvitation(r.Context(), params)
+	if err != nil && (errors.Is(err, ErrInvalidCredentials) || errors.Is(err, ErrVerificationCodeExpired)) {
+		ro.writeJSONError(w, http.StatusUnauthorized, err)
+		return
+	} else if err != nil && errors.Is(err, ErrWeakPassword) {
+		ro.json.WriteJSON(w, chttp.WriteJSONParams{
+			StatusCode: http.StatusBadRequest,
+			Data:       weakPasswordErrorData(err),
+		})
+		return
+	} else if err != nil {
+		ro.writeJSONInternalError(w, cerrors.New(err, "failed to accept invitation", nil))
+		return
+	}
+
+	ro.json.WriteJSON(w, chttp.WriteJSONParams{
+		Data: sessionResult,
+	})
+}
+
+// HandleCreateAccessToken issues an access token and a refresh token for the current session.
+func (ro *Router) HandleCreateAccessToken(w http.ResponseWriter, r *http.Request) {
+	session := GetCurrentSession(r.Context())
+
+	result, err := ro.svc.CreateAccessToken(r.Context(), session)
+	if err != nil {
+		ro.writeJSONInternalError(w, cerrors.New(err, "failed to create access token", map[string]interface{}{
+			"sessionUUID": session.UUID,
+		}))
+		return
+	}
+
+	ro.json.WriteJSON(w, chttp.WriteJSONParams{
+		Data: result,
+	})
+}
+
+// HandleRefreshAccessToken exchanges a refresh token for a new access token and refresh token.
+func (ro *Router) HandleRefreshAccessToken(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+
+	if !ro.json.ReadJSON(w, r, &body) {
+		return
+	}
+
+	result, err := ro.svc.RefreshAccessToken(r.Context(), body.RefreshToken)
+	if err != nil && errors.Is(err, ErrInvalidCredentials) {
+		ro.writeJSONError(w, http.StatusUnauthorized, err)
+		return
+	} else if err != nil {
+		ro.writeJSONInternalError(w, cerrors.New(err, "failed to refresh access token", nil))
+		return
+	}
+
+	ro.json.WriteJSON(w, chttp.WriteJSONParams{
+		Data: result,
+	})
+}
+
+// HandleJWKS responds with the public keys that verify access tokens. Clients may cache the response for a few
+// minutes, so new keys should be published before they are used for signing.
+func (ro *Router) HandleJWKS(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "public, max-age=300")
+
+	ro.json.WriteJSON(w, chttp.WriteJSONParams{
+		Data: ro.svc.AccessTokenJWKS(),
+	})
+}
+
+// weakPasswordErrorData returns the response body for a password that was rejected by the password policy. It
+// includes the reasons so that clients can tell users how to pick a stronger password.
+func weakPasswordErrorData(err error) map[string]interface{} {
+	var weakPasswordErr *WeakPasswordError
+
+	reasons := make([]string, 0)
+	if errors.As(err, &weakPasswordErr) {
+		reasons = weakPasswordErr.Reasons
+	}
+
+	return map[string]interface{}{
+		"error":   ErrWeakPassword.Error(),
+		"reasons": reasons,
+	}
+}
+
+// refuseImpersonation responds with ErrImpersonating and returns true if the current session impersonates a user, so
+// that the impersonated user's credentials and account settings cannot be changed on someone else's behalf.
+func (ro *Router) refuseImpersonation(w http.ResponseWriter, r *http.Request) bool {
+	if GetCurrentSession(r.Context()).ImpersonatedUserUUID == nil {
+		return false
+	}
+
+	ro.writeJSONError(w, http.StatusForbidden, ErrImpersonating)
+
+	return true
+}
+
+// writeJSONError responds with the given status code and a JSON body that holds the error's message.
+func (ro *Router) writeJSONError(w http.ResponseWriter, statusCode int, err error) {
+	ro.json.WriteJSON(w, chttp.WriteJSONParams{
+		StatusCode: statusCode,
+		Data:       map[string]string{"error": err.Error()},
+	})
+}
+
+// writeJSONInternalError logs the given error and responds with a generic JSON error so that internal details are
+// not leaked to clients.
+func (ro *Router) writeJSONInternalError(w http.ResponseWriter, err error) {
+	ro.logger.Error("Failed to handle request", err)
+	ro.writeJSONError(w, http.StatusInternalServerError, errors.New("internal error"))
 }
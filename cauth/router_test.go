@@ -6,8 +6,10 @@ import (
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"regexp"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/gocopper/pkg/cauth"
 	"github.com/gocopper/pkg/cauth/cauthtest"
@@ -66,6 +68,10 @@ func TestRouter_HandleSignup(t *testing.T) {
 func TestRouter_HandleLogin_Invalid(t *testing.T) {
 	t.Parallel()
 
+	var errBody struct {
+		Error string `json:"error"`
+	}
+
 	server := httptest.NewServer(cauthtest.NewHandler(t))
 	defer server.Close()
 
@@ -86,6 +92,8 @@ func TestRouter_HandleLogin_Invalid(t *testing.T) {
 	defer func() { _ = resp.Body.Close() }()
 
 	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&errBody))
+	assert.Equal(t, cauth.ErrInvalidCredentials.Error(), errBody.Error)
 }
 
 func TestRouter_HandleLogin(t *testing.T) {
@@ -173,3 +181,288 @@ func TestRouter_HandleLogout(t *testing.T) {
 
 	assert.Equal(t, http.StatusOK, resp.StatusCode)
 }
+
+func TestRouter_TOTP(t *testing.T) {
+	t.Parallel()
+
+	var (
+		enrollment    cauth.TOTPEnrollment
+		confirmation  map[string][]string
+		sessionResult cauth.SessionResult
+	)
+
+	server := httptest.NewServer(cauthtest.NewHandler(t))
+	defer server.Close()
+
+	session := cauthtest.CreateNewUserSession(t, server)
+
+	resp := postJSON(t, server.URL+"/api/auth/totp/enroll", `{}`, session)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&enrollment))
+	assert.Contains(t, enrollment.URI, "otpauth://totp/")
+
+	code, err := cauth.TOTPCode(enrollment.Secret, time.Now())
+	assert.NoError(t, err)
+
+	resp = postJSON(t, server.URL+"/api/auth/totp/confirm", `{"code": "`+code+`"}`, session)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&confirmation))
+	assert.Len(t, confirmation["backup_codes"], 10)
+
+	resp = postJSON(t, server.URL+"/api/auth/login", `{"username": "test-user", "password": "test-pass"}`, nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&sessionResult))
+	assert.Nil(t, sessionResult.Session)
+	assert.NotNil(t, sessionResult.TwoFactorChallenge)
+
+	challenge := sessionResult.TwoFactorChallenge
+	verifyBody := func(code string) string {
+		return `{
+			"challenge_uuid": "` + challenge.Challenge.UUID + `",
+			"challenge_token": "` + challenge.PlainChallengeToken + `",
+			"code": "` + code + `"
+		}`
+	}
+
+	// The code used during confirmation cannot be replayed
+	resp = postJSON(t, server.URL+"/api/auth/login/two-factor", verifyBody(code), nil)
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	backupCode := confirmation["backup_codes"][0]
+
+	resp = postJSON(t, server.URL+"/api/auth/login/two-factor", verifyBody(backupCode), nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&sessionResult))
+	assert.NotNil(t, sessionResult.Session)
+	assert.NotEmpty(t, sessionResult.PlainSessionToken)
+
+	// The challenge and backup code are single use
+	resp = postJSON(t, server.URL+"/api/auth/login/two-factor", verifyBody(backupCode), nil)
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+}
+
+func TestRouter_Passwords(t *testing.T) {
+	t.Parallel()
+
+	var (
+		mailer     = cauthtest.NewMailer()
+		codeRegexp = regexp.MustCompile(`<b>(\d+)</b>`)
+		session    cauth.SessionResult
+		errBody    struct {
+			Error string `json:"error"`
+		}
+	)
+
+	server := httptest.NewServer(cauthtest.NewHandlerWithParams(t, cauthtest.HandlerParams{Mailer: mailer}))
+	defer server.Close()
+
+	lastCode := func() string {
+		match := codeRegexp.FindStringSubmatch(*mailer.Last().HTMLBody)
+		if !assert.Len(t, match, 2) {
+			return ""
+		}
+
+		return match[1]
+	}
+
+	resp := postJSON(t, server.URL+"/api/auth/signup", `{"email": "user@example.com", "password": "test-pass"}`, nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&session))
+
+	// Unknown emails are not revealed
+	sentBefore := len(mailer.Sent())
+
+	resp = postJSON(t, server.URL+"/api/auth/password/forgot", `{"email": "unknown@example.com"}`, nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Len(t, mailer.Sent(), sentBefore)
+
+	resp = postJSON(t, server.URL+"/api/auth/password/forgot", `{"email": "user@example.com"}`, nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Len(t, mailer.Sent(), sentBefore+1)
+
+	code := lastCode()
+
+	resp = postJSON(t, server.URL+"/api/auth/password/reset",
+		`{"email": "user@example.com", "password": "new-pass-1", "verification_code": "wrong"}`, nil)
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&errBody))
+	assert.Equal(t, cauth.ErrInvalidCredentials.Error(), errBody.Error)
+
+	resp = postJSON(t, server.URL+"/api/auth/password/reset",
+		`{"email": "user@example.com", "password": "short", "verification_code": "`+code+`"}`, nil)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&errBody))
+	assert.Equal(t, cauth.ErrWeakPassword.Error(), errBody.Error)
+
+	resp = postJSON(t, server.URL+"/api/auth/password/reset",
+		`{"email": "user@example.com", "password": "new-pass-1", "verification_code": "`+code+`"}`, nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	// Resetting the password revokes all sessions
+	assert.Equal(t, http.StatusUnauthorized, getJSON(t, server.URL+"/api/auth/me", &session).StatusCode)
+
+	resp = postJSON(t, server.URL+"/api/auth/login", `{"email": "user@example.com", "password": "new-pass-1"}`, nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&session))
+
+	resp = postJSON(t, server.URL+"/api/auth/password/update",
+		`{"current_password": "wrong-pass", "new_password": "new-pass-2"}`, &session)
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&errBody))
+	assert.Equal(t, cauth.ErrInvalidCredentials.Error(), errBody.Error)
+
+	resp = postJSON(t, server.URL+"/api/auth/password/update",
+		`{"current_password": "new-pass-1", "new_password": "new-pass-2"}`, &session)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	resp = postJSON(t, server.URL+"/api/auth/login", `{"email": "user@example.com", "password": "new-pass-2"}`, nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	resp = postJSON(t, server.URL+"/api/auth/verification-code/resend", `{"email": "user@example.com"}`, nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Len(t, mailer.Sent(), sentBefore+2)
+
+	// Users without an email only change their own password
+	usernameSession := cauthtest.CreateNewUserSession(t, server)
+
+	resp = postJSON(t, server.URL+"/api/auth/signup", `{"username": "other-user", "password": "test-pass"}`, nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	resp = postJSON(t, server.URL+"/api/auth/password/update",
+		`{"current_password": "test-pass", "new_password": "new-pass-3"}`, usernameSession)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	resp = postJSON(t, server.URL+"/api/auth/login", `{"username": "test-user", "password": "new-pass-3"}`, nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	resp = postJSON(t, server.URL+"/api/auth/login", `{"username": "other-user", "password": "test-pass"}`, nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	resp = postJSON(t, server.URL+"/api/auth/verification-code/resend", `{"email": ""}`, nil)
+	assert.Len(t, mailer.Sent(), sentBefore+2)
+}
+
+func TestRouter_Impersonation(t *testing.T) {
+	t.Parallel()
+
+	var (
+		ctx    = context.Background()
+		target cauth.SessionResult
+		me     struct {
+			User          cauth.User `json:"user"`
+			Impersonating bool       `json:"impersonating"`
+		}
+	)
+
+	handler, svc := cauthtest.NewHandlerAndSvc(t, cauthtest.HandlerParams{})
+
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	admin := cauthtest.CreateNewUserSession(t, server)
+
+	resp := postJSON(t, server.URL+"/api/auth/signup", `{"email": "target@example.com", "password": "test-pass"}`, nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&target))
+
+	resp = postJSON(t, server.URL+"/api/auth/impersonate", `{"email": "target@example.com"}`, admin)
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+	role, err := svc.CreateRole(ctx, cauth.CreateRoleParams{
+		Name:        "support",
+		Permissions: []string{cauth.PermissionImpersonateUsers},
+	})
+	assert.NoError(t, err)
+	assert.NoError(t, svc.AssignRole(ctx, admin.User.UUID, role.UUID))
+
+	resp = postJSON(t, server.URL+"/api/auth/impersonate", `{"email": "unknown@example.com"}`, admin)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	resp = postJSON(t, server.URL+"/api/auth/impersonate", `{"email": "target@example.com"}`, admin)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	resp = getJSON(t, server.URL+"/api/auth/me", admin)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&me))
+	assert.Equal(t, target.User.UUID, me.User.UUID)
+	assert.True(t, me.Impersonating)
+
+	// Credentials cannot be added to the impersonated user
+	resp = postJSON(t, server.URL+"/api/auth/webauthn/register/begin", `{}`, admin)
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+	resp = postJSON(t, server.URL+"/api/auth/webauthn/register/finish", `{}`, admin)
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+	resp = postJSON(t, server.URL+"/api/auth/api-keys", `{"name": "test"}`, admin)
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+	// Nor can their two-factor settings or email be changed
+	resp = postJSON(t, server.URL+"/api/auth/totp/enroll", `{}`, admin)
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+	resp = postJSON(t, server.URL+"/api/auth/totp/confirm", `{"code": "000000"}`, admin)
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+	resp = postJSON(t, server.URL+"/api/auth/totp/disable", `{"code": "000000"}`, admin)
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+	resp = postJSON(t, server.URL+"/api/auth/email/change", `{"new_email": "admin-owned@example.com"}`, admin)
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+	resp = postJSON(t, server.URL+"/api/auth/email/change/confirm", `{"verification_code": "000000"}`, admin)
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+	// Nor can their account be deleted
+	resp = postJSON(t, server.URL+"/api/auth/account/delete", `{}`, admin)
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+	resp = postJSON(t, server.URL+"/api/auth/impersonate/stop", `{}`, admin)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	resp = getJSON(t, server.URL+"/api/auth/me", admin)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&me))
+	assert.Equal(t, admin.User.UUID, me.User.UUID)
+	assert.False(t, me.Impersonating)
+}
+
+func postJSON(t *testing.T, url, body string, session *cauth.SessionResult) *http.Response {
+	t.Helper()
+
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodPost, url, strings.NewReader(body))
+	assert.NoError(t, err)
+
+	if session != nil {
+		req.SetBasicAuth(session.Session.UUID, session.PlainSessionToken)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+
+	t.Cleanup(func() {
+		_ = resp.Body.Close()
+	})
+
+	return resp
+}
+
+func getJSON(t *testing.T, url string, session *cauth.SessionResult) *http.Response {
+	t.Helper()
+
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, url, nil)
+	assert.NoError(t, err)
+
+	if session != nil {
+		req.SetBasicAuth(session.Session.UUID, session.PlainSessionToken)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+
+	t.Cleanup(func() {
+		_ = resp.Body.Close()
+	})
+
+	return resp
+}
@@ -15,6 +15,13 @@ type ctxKey string
 const (
 	ctxKeySession = ctxKey("cauth/session")
 	ctxKeyUser    = ctxKey("cauth/user")
+	ctxKeyAPIKey  = ctxKey("cauth/api_key")
+
+	ctxKeyPermissions  = ctxKey("cauth/permissions")
+	ctxKeyOrganization = ctxKey("cauth/organization")
+	ctxKeyMembership   = ctxKey("cauth/membership")
+
+	ctxKeyClientInfo = ctxKey("cauth/client_info")
 )
 
 // NewVerifySessionMiddleware instantiates and creates a new VerifySessionMiddleware
@@ -40,8 +47,8 @@ func NewSetSessionIfAnyMiddleware(auth *Svc, rw *chttp.HTMLReaderWriter, logger
 //  2. SessionUUID and SessionToken cookies
 //
 // If the session is present, it is validated, saved in the request ctx along with the user,
-// and the next handler is called. If the session is invalid, an unauthorized response is sent
-// back.
+// and the next handler is called. Active sessions are renewed as described in Config.SessionIdleTimeout.
+// If the session is invalid, an unauthorized response is sent back.
 // To ensure verified session, use in conjunction with VerifySessionMiddleware.
 type VerifySessionMiddleware struct {
 	auth   *Svc
@@ -61,7 +68,7 @@ type SetSessionIfAnyMiddleware struct {
 // Handle implements the middleware for VerifySessionMiddleware.
 func (mw *VerifySessionMiddleware) Handle(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		session, user, err := mw.auth.getSessionAndUserFromHTTPRequest(r.Context(), r)
+		session, user, cookies, err := mw.auth.getSessionAndUserFromHTTPRequest(r.Context(), r)
 		if err != nil && errors.Is(err, ErrInvalidCredentials) {
 			mw.rw.Unauthorized(w, r)
 			return
@@ -70,7 +77,11 @@ func (mw *VerifySessionMiddleware) Handle(next http.Handler) http.Handler {
 			return
 		}
 
-		ctxWithUser := context.WithValue(r.Context(), ctxKeyUser, user)
+		for i := range cookies {
+			http.SetCookie(w, &cookies[i])
+		}
+
+		ctxWithUser := mw.auth.ctxWithUser(r.Context(), user)
 		ctxWithUserAndSession := context.WithValue(ctxWithUser, ctxKeySession, session)
 
 		next.ServeHTTP(w, r.WithContext(ctxWithUserAndSession))
@@ -79,7 +90,7 @@ func (mw *VerifySessionMiddleware) Handle(next http.Handler) http.Handler {
 
 func (mw *SetSessionIfAnyMiddleware) Handle(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		session, user, err := mw.auth.getSessionAndUserFromHTTPRequest(r.Context(), r)
+		session, user, cookies, err := mw.auth.getSessionAndUserFromHTTPRequest(r.Context(), r)
 		if err != nil && errors.Is(err, ErrInvalidCredentials) {
 			next.ServeHTTP(w, r)
 
@@ -89,13 +100,31 @@ func (mw *SetSessionIfAnyMiddleware) Handle(next http.Handler) http.Handler {
 			return
 		}
 
-		ctxWithUser := context.WithValue(r.Context(), ctxKeyUser, user)
+		for i := range cookies {
+			http.SetCookie(w, &cookies[i])
+		}
+
+		ctxWithUser := mw.auth.ctxWithUser(r.Context(), user)
 		ctxWithUserAndSession := context.WithValue(ctxWithUser, ctxKeySession, session)
 
 		next.ServeHTTP(w, r.WithContext(ctxWithUserAndSession))
 	})
 }
 
+// clientInfoMiddleware stores the client's user agent and IP in the request ctx so that they can be recorded on
+// new sessions.
+type clientInfoMiddleware struct {
+	auth *Svc
+}
+
+func (mw *clientInfoMiddleware) Handle(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := ContextWithClientInfo(r.Context(), mw.auth.clientInfoFromHTTPRequest(r))
+
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
 // GetCurrentSession returns the session in the HTTP request context. It should only be used in HTTP request
 // handlers that have the VerifySessionMiddleware on them. If a session is not found, this method will panic. To avoid
 // panics, verify that a session exists either with the VerifySessionMiddleware or the HasVerifiedSession function.
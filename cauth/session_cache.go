@@ -0,0 +1,126 @@
+package cauth
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// newSessionCache creates a sessionCache that holds up to size sessions for the given ttl. It returns nil, which
+// is a valid cache that holds nothing, if size or ttl is zero.
+func newSessionCache(size int, ttl time.Duration) *sessionCache {
+	if size <= 0 || ttl <= 0 {
+		return nil
+	}
+
+	return &sessionCache{
+		size:    size,
+		ttl:     ttl,
+		entries: make(map[string]*list.Element, size),
+		lru:     list.New(),
+	}
+}
+
+// sessionCache is a bounded in-process cache of validated sessions so that ValidateSession does not query the
+// database on every request. Entries are evicted after the ttl, when the cache is full (least recently used first)
+// and when their session is changed or revoked on this instance. Other instances may keep serving a revoked session
+// until the ttl passes, so it should be short.
+type sessionCache struct {
+	mu      sync.Mutex
+	size    int
+	ttl     time.Duration
+	entries map[string]*list.Element
+	lru     *list.List
+}
+
+type sessionCacheEntry struct {
+	session  Session
+	cachedAt time.Time
+}
+
+// get returns a copy of the cached session with the given uuid. It returns false if the session is not cached or
+// its entry has expired.
+func (c *sessionCache) get(sessionUUID string) (*Session, bool) {
+	if c == nil {
+		return nil, false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.entries[sessionUUID]
+	if !ok {
+		return nil, false
+	}
+
+	entry := elem.Value.(*sessionCacheEntry) //nolint:forcetypeassert
+	if time.Since(entry.cachedAt) > c.ttl {
+		c.remove(elem)
+		return nil, false
+	}
+
+	c.lru.MoveToFront(elem)
+
+	session := entry.session
+
+	return &session, true
+}
+
+// set caches a copy of the given session.
+func (c *sessionCache) set(session *Session) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.entries[session.UUID]; ok {
+		c.remove(elem)
+	}
+
+	c.entries[session.UUID] = c.lru.PushFront(&sessionCacheEntry{
+		session:  *session,
+		cachedAt: time.Now(),
+	})
+
+	for c.lru.Len() > c.size {
+		c.remove(c.lru.Back())
+	}
+}
+
+// delete evicts the session with the given uuid.
+func (c *sessionCache) delete(sessionUUID string) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.entries[sessionUUID]; ok {
+		c.remove(elem)
+	}
+}
+
+// deleteUser evicts the sessions of the given user, including the sessions that impersonate them.
+func (c *sessionCache) deleteUser(userUUID string) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, elem := range c.entries {
+		session := elem.Value.(*sessionCacheEntry).session //nolint:forcetypeassert
+		if session.UserUUID == userUUID || session.CurrentUserID() == userUUID {
+			c.remove(elem)
+		}
+	}
+}
+
+func (c *sessionCache) remove(elem *list.Element) {
+	c.lru.Remove(elem)
+	delete(c.entries, elem.Value.(*sessionCacheEntry).session.UUID) //nolint:forcetypeassert
+}
@@ -0,0 +1,204 @@
+package cauth
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gocopper/copper/cerrors"
+)
+
+// ClientInfo describes the client that made a request. It is stored on sessions when they are created.
+type ClientInfo struct {
+	UserAgent string
+	IP        string
+}
+
+// ContextWithClientInfo returns a copy of ctx that holds the given client info. The auth router does this for all
+// of its routes. Apps that call Svc methods such as Login directly can use it to record the client on new sessions.
+func ContextWithClientInfo(ctx context.Context, info ClientInfo) context.Context {
+	return context.WithValue(ctx, ctxKeyClientInfo, info)
+}
+
+func clientInfoFromContext(ctx context.Context) ClientInfo {
+	info, _ := ctx.Value(ctxKeyClientInfo).(ClientInfo)
+
+	return info
+}
+
+// clientInfoFromHTTPRequest reads the client's user agent and IP from the request. The IP is read from the header
+// configured in Config.ClientIPHeader (e.g. X-Forwarded-For) when the app runs behind a proxy. Proxies append the IP
+// they received the request from to the header, so the entries on the left are set by the client and cannot be
+// trusted. The IP is the entry that the outermost of the Config.ClientIPTrustedHops proxies appended.
+func (s *Svc) clientInfoFromHTTPRequest(r *http.Request) ClientInfo {
+	var ip string
+
+	if s.config.ClientIPHeader != "" {
+		entries := strings.Split(strings.Join(r.Header.Values(s.config.ClientIPHeader), ","), ",")
+		i := max(len(entries)-max(s.config.ClientIPTrustedHops, 1), 0)
+
+		ip = strings.TrimSpace(entries[i])
+	}
+
+	if ip == "" {
+		host, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			host = r.RemoteAddr
+		}
+
+		ip = host
+	}
+
+	return ClientInfo{
+		UserAgent: r.UserAgent(),
+		IP:        ip,
+	}
+}
+
+// ListSessions returns the active sessions of the given user, most recently seen first.
+func (s *Svc) ListSessions(ctx context.Context, userUUID string) ([]Session, error) {
+	sessions, err := s.queries.ListActiveSessionsByUserUUID(ctx, userUUID, time.Now())
+	if err != nil {
+		return nil, cerrors.New(err, "failed to list active sessions", map[string]interface{}{
+			"userUUID": userUUID,
+		})
+	}
+
+	return sessions, nil
+}
+
+// RevokeSession ends the session identified by sessionUUID. ErrNotFound is returned if the session does not belong
+// to the given user.
+func (s *Svc) RevokeSession(ctx context.Context, userUUID, sessionUUID string) error {
+	session, err := s.queries.GetSession(ctx, sessionUUID)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return cerrors.New(err, "failed to get session", map[string]interface{}{
+			"sessionUUID": sessionUUID,
+		})
+	} else if err != nil || session.UserUUID != userUUID {
+		return ErrNotFound
+	}
+
+	session.UpdatedAt = time.Now()
+	session.ExpiresAt = session.UpdatedAt
+
+	err = s.updateSession(ctx, session)
+	if err != nil {
+		return cerrors.New(err, "failed to update session", map[string]interface{}{
+			"sessionUUID": sessionUUID,
+		})
+	}
+
+	return s.recordAuditEvent(ctx, &AuditEvent{
+		Type:        AuditEventSessionRevoked,
+		UserUUID:    userUUID,
+		SessionUUID: session.UUID,
+	})
+}
+
+// RevokeAllOtherSessions ends all sessions of the given user except for the session identified by
+// currentSessionUUID.
+func (s *Svc) RevokeAllOtherSessions(ctx context.Context, userUUID, currentSessionUUID string) error {
+	err := s.revokeAllSessionsExcept(ctx, userUUID, currentSessionUUID)
+	if err != nil {
+		return err
+	}
+
+	return s.recordAuditEvent(ctx, &AuditEvent{
+		Type:        AuditEventOtherSessionsRevoked,
+		UserUUID:    userUUID,
+		SessionUUID: currentSessionUUID,
+	})
+}
+
+// revokeAllSessions ends all sessions of the given user. It is used when the user's credentials change, which is
+// recorded in the audit log by the caller.
+func (s *Svc) revokeAllSessions(ctx context.Context, userUUID string) error {
+	return s.revokeAllSessionsExcept(ctx, userUUID, "")
+}
+
+func (s *Svc) revokeAllSessionsExcept(ctx context.Context, userUUID, exceptSessionUUID string) error {
+	err := s.queries.ExpireSessionsByUserUUID(ctx, userUUID, exceptSessionUUID, time.Now())
+	if err != nil {
+		return cerrors.New(err, "failed to expire sessions", map[string]interface{}{
+			"userUUID": userUUID,
+		})
+	}
+
+	s.sessionCache.deleteUser(userUUID)
+
+	return nil
+}
+
+// touchSession updates the session's last seen time and slides its expiry forward by the idle timeout. The update
+// is throttled by Config.SessionRenewalInterval so that authenticated requests don't each cause a write. It returns
+// true if the session was renewed.
+func (s *Svc) touchSession(ctx context.Context, session *Session) (bool, error) {
+	if time.Since(session.LastSeenAt) < s.config.SessionRenewalInterval {
+		return false, nil
+	}
+
+	session.UpdatedAt = time.Now()
+	session.LastSeenAt = session.UpdatedAt
+	session.ExpiresAt = s.sessionExpiresAt(session, session.LastSeenAt)
+
+	err := s.updateSession(ctx, session)
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// sessionExpiresAt returns when the given session expires if it was last used at lastUsedAt. It is the earlier of
+// the idle timeout after lastUsedAt and the absolute timeout after the session was created.
+func (s *Svc) sessionExpiresAt(session *Session, lastUsedAt time.Time) time.Time {
+	idleTimeout := s.config.SessionIdleTimeout
+	if session.RememberMe {
+		idleTimeout = s.config.SessionRememberMeIdleTimeout
+	}
+
+	expiresAt := lastUsedAt.Add(idleTimeout)
+	if absoluteExpiresAt := session.CreatedAt.Add(s.config.SessionAbsoluteTimeout); absoluteExpiresAt.Before(expiresAt) {
+		return absoluteExpiresAt
+	}
+
+	return expiresAt
+}
+
+// updateSession saves the given session and evicts it from the session cache so that the change is seen by the next
+// ValidateSession call.
+func (s *Svc) updateSession(ctx context.Context, session *Session) error {
+	err := s.queries.UpdateSession(ctx, session)
+	if err != nil {
+		return err
+	}
+
+	s.sessionCache.delete(session.UUID)
+
+	return nil
+}
+
+// sessionTokenDigest returns the HMAC-SHA256 digest of the given session or refresh token that is stored in the
+// database. Unlike passwords, these tokens are long random strings, so a fast keyed hash is enough to protect them and
+// saves authenticated requests from running bcrypt.
+func (s *Svc) sessionTokenDigest(plainToken string) []byte {
+	mac := hmac.New(sha256.New, []byte(s.config.SessionTokenKey))
+	mac.Write([]byte(plainToken))
+
+	return mac.Sum(nil)
+}
+
+// isBcryptHash returns true if the given session token was hashed with bcrypt, as sessions were before their tokens
+// were stored as HMAC digests.
+func isBcryptHash(token []byte) bool {
+	const bcryptHashLen = 60
+
+	return len(token) == bcryptHashLen && bytes.HasPrefix(token, []byte("$2"))
+}
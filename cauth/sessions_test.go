@@ -0,0 +1,380 @@
+package cauth_test
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gocopper/pkg/cauth"
+	"github.com/gocopper/pkg/cauth/cauthtest"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestRouter_Sessions(t *testing.T) {
+	t.Parallel()
+
+	var list struct {
+		Sessions []struct {
+			UUID      string `json:"uuid"`
+			UserAgent string `json:"user_agent"`
+			IP        string `json:"ip"`
+		} `json:"sessions"`
+		CurrentSessionUUID string `json:"current_session_uuid"`
+	}
+
+	server := httptest.NewServer(cauthtest.NewHandler(t))
+	defer server.Close()
+
+	do := func(method, url string, session *cauth.SessionResult) *http.Response {
+		req, err := http.NewRequestWithContext(context.Background(), method, url, nil)
+		assert.NoError(t, err)
+
+		req.SetBasicAuth(session.Session.UUID, session.PlainSessionToken)
+
+		resp, err := http.DefaultClient.Do(req)
+		assert.NoError(t, err)
+		t.Cleanup(func() { _ = resp.Body.Close() })
+
+		return resp
+	}
+
+	login := func(userAgent string) *cauth.SessionResult {
+		var sessionResult cauth.SessionResult
+
+		req, err := http.NewRequestWithContext(context.Background(), http.MethodPost, server.URL+"/api/auth/login",
+			strings.NewReader(`{"username": "test-user", "password": "test-pass"}`))
+		assert.NoError(t, err)
+
+		req.Header.Set("User-Agent", userAgent)
+
+		resp, err := http.DefaultClient.Do(req)
+		assert.NoError(t, err)
+		t.Cleanup(func() { _ = resp.Body.Close() })
+
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.NoError(t, json.NewDecoder(resp.Body).Decode(&sessionResult))
+
+		return &sessionResult
+	}
+
+	first := cauthtest.CreateNewUserSession(t, server)
+	second := login("test-browser")
+	third := login("test-phone")
+
+	resp := do(http.MethodGet, server.URL+"/api/auth/sessions", second)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&list))
+	assert.Len(t, list.Sessions, 3)
+	assert.Equal(t, second.Session.UUID, list.CurrentSessionUUID)
+
+	userAgents := make([]string, 0, len(list.Sessions))
+	for _, s := range list.Sessions {
+		userAgents = append(userAgents, s.UserAgent)
+		assert.Equal(t, "127.0.0.1", s.IP)
+	}
+
+	assert.Contains(t, userAgents, "test-browser")
+	assert.Contains(t, userAgents, "test-phone")
+
+	resp = do(http.MethodDelete, server.URL+"/api/auth/sessions/"+third.Session.UUID, second)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	resp = do(http.MethodGet, server.URL+"/api/auth/sessions", third)
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	resp = do(http.MethodPost, server.URL+"/api/auth/sessions/revoke-others", second)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	resp = do(http.MethodGet, server.URL+"/api/auth/sessions", first)
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	resp = do(http.MethodGet, server.URL+"/api/auth/sessions", second)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&list))
+	assert.Len(t, list.Sessions, 1)
+
+	// Sessions of other users cannot be revoked
+	other := postJSON(t, server.URL+"/api/auth/signup", `{"username": "other-user", "password": "test-pass"}`, nil)
+	assert.Equal(t, http.StatusOK, other.StatusCode)
+
+	var otherSession cauth.SessionResult
+	assert.NoError(t, json.NewDecoder(other.Body).Decode(&otherSession))
+
+	resp = do(http.MethodDelete, server.URL+"/api/auth/sessions/"+second.Session.UUID, &otherSession)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	resp = do(http.MethodPost, server.URL+"/api/auth/logout", second)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	resp = do(http.MethodGet, server.URL+"/api/auth/sessions", second)
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+}
+
+func TestRouter_Sessions_ClientIPHeader(t *testing.T) {
+	t.Parallel()
+
+	var (
+		sessionResult cauth.SessionResult
+		list          struct {
+			Sessions []struct {
+				UUID string `json:"uuid"`
+				IP   string `json:"ip"`
+			} `json:"sessions"`
+		}
+	)
+
+	server := httptest.NewServer(cauthtest.NewHandlerWithParams(t, cauthtest.HandlerParams{
+		Config: `
+[cauth]
+client_ip_header = "X-Forwarded-For"
+client_ip_trusted_hops = 2
+`,
+	}))
+	defer server.Close()
+
+	cauthtest.CreateNewUserSession(t, server)
+
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodPost, server.URL+"/api/auth/login",
+		strings.NewReader(`{"username": "test-user", "password": "test-pass"}`))
+	assert.NoError(t, err)
+
+	// The client sets the leftmost entry, and the two proxies append the client's IP and their own
+	req.Header.Set("X-Forwarded-For", "198.51.100.1, 203.0.113.7, 10.0.0.1")
+
+	resp, err := http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	t.Cleanup(func() { _ = resp.Body.Close() })
+
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&sessionResult))
+
+	resp = getJSON(t, server.URL+"/api/auth/sessions", &sessionResult)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&list))
+	assert.Len(t, list.Sessions, 2)
+
+	for _, s := range list.Sessions {
+		if s.UUID == sessionResult.Session.UUID {
+			assert.Equal(t, "203.0.113.7", s.IP)
+		} else {
+			assert.Equal(t, "127.0.0.1", s.IP)
+		}
+	}
+}
+
+func TestRouter_SessionRenewal(t *testing.T) {
+	t.Parallel()
+
+	server := httptest.NewServer(cauthtest.NewHandlerWithParams(t, cauthtest.HandlerParams{
+		Config: `
+[cauth]
+session_absolute_timeout = "2h"
+session_idle_timeout = "1h"
+session_remember_me_idle_timeout = "3h"
+session_renewal_interval = "0s"
+`,
+	}))
+	defer server.Close()
+
+	login := func(rememberMe bool) (*cauth.SessionResult, time.Time) {
+		var (
+			sessionResult cauth.SessionResult
+			expiry        struct {
+				Session struct {
+					ExpiresAt time.Time `json:"expires_at"`
+				} `json:"session"`
+			}
+		)
+
+		body := `{"username": "test-user", "password": "test-pass", "remember_me": false}`
+		if rememberMe {
+			body = `{"username": "test-user", "password": "test-pass", "remember_me": true}`
+		}
+
+		resp := postJSON(t, server.URL+"/api/auth/login", body, nil)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+		respBody, err := io.ReadAll(resp.Body)
+		assert.NoError(t, err)
+		assert.NoError(t, json.Unmarshal(respBody, &sessionResult))
+		assert.NoError(t, json.Unmarshal(respBody, &expiry))
+
+		return &sessionResult, expiry.Session.ExpiresAt
+	}
+
+	getSessionWithCookies := func(session *cauth.SessionResult) *http.Response {
+		req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, server.URL+"/api/auth/sessions", nil)
+		assert.NoError(t, err)
+
+		req.AddCookie(&http.Cookie{Name: "SessionUUID", Value: session.Session.UUID})
+		req.AddCookie(&http.Cookie{Name: "SessionToken", Value: session.PlainSessionToken})
+
+		resp, err := http.DefaultClient.Do(req)
+		assert.NoError(t, err)
+		t.Cleanup(func() { _ = resp.Body.Close() })
+
+		return resp
+	}
+
+	// The CSRF cookie is also set on responses, but only the session cookies are renewed
+	sessionCookies := func(resp *http.Response) []*http.Cookie {
+		cookies := make([]*http.Cookie, 0)
+
+		for _, cookie := range resp.Cookies() {
+			if cookie.Name != cauth.CSRFCookieName {
+				cookies = append(cookies, cookie)
+			}
+		}
+
+		return cookies
+	}
+
+	cauthtest.CreateNewUserSession(t, server)
+
+	session, expiresAt := login(false)
+	assert.WithinDuration(t, time.Now().Add(time.Hour), expiresAt, time.Minute)
+
+	// Browser session cookies are re-issued without a max age
+	resp := getSessionWithCookies(session)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Len(t, sessionCookies(resp), 2)
+
+	for _, cookie := range sessionCookies(resp) {
+		assert.Equal(t, 0, cookie.MaxAge)
+	}
+
+	// Remember me sessions use the longer idle timeout but are capped by the absolute timeout
+	rememberedSession, expiresAt := login(true)
+	assert.WithinDuration(t, time.Now().Add(2*time.Hour), expiresAt, time.Minute)
+
+	resp = getSessionWithCookies(rememberedSession)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Len(t, sessionCookies(resp), 2)
+
+	for _, cookie := range sessionCookies(resp) {
+		assert.InDelta(t, (2 * time.Hour).Seconds(), cookie.MaxAge, time.Minute.Seconds())
+	}
+}
+
+func TestNewSvc_SessionTokenKeyRequired(t *testing.T) {
+	t.Parallel()
+
+	_, err := cauth.NewSvc(nil, nil, nil, nil, nil, cauth.Config{PasswordHashAlgorithm: cauth.PasswordHashBcrypt})
+	assert.Error(t, err)
+}
+
+func TestSvc_ValidateSession_Cache(t *testing.T) {
+	t.Parallel()
+
+	var (
+		ctx      = context.Background()
+		password = "test-pass"
+	)
+
+	_, svc := cauthtest.NewHandlerAndSvc(t, cauthtest.HandlerParams{
+		Config: `
+[cauth]
+session_token_key = "test-key"
+session_cache_size = 1
+session_cache_ttl = "1h"
+`,
+	})
+
+	signup, err := svc.Signup(ctx, cauth.SignupParams{Username: "test-user", Password: &password})
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	login, err := svc.Login(ctx, cauth.LoginParams{Username: "test-user", Password: &password})
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	for _, session := range []*cauth.SessionResult{signup, login, signup} {
+		ok, validated, err := svc.ValidateSession(ctx, session.Session.UUID, session.PlainSessionToken)
+		assert.NoError(t, err)
+		assert.True(t, ok)
+		assert.Equal(t, session.Session.UUID, validated.UUID)
+
+		// Cached sessions still check the token
+		ok, _, err = svc.ValidateSession(ctx, session.Session.UUID, "wrong-token")
+		assert.NoError(t, err)
+		assert.False(t, ok)
+	}
+
+	// Logging out evicts the session so that it is rejected right away
+	assert.NoError(t, svc.Logout(ctx, signup.Session.UUID))
+
+	ok, _, err := svc.ValidateSession(ctx, signup.Session.UUID, signup.PlainSessionToken)
+	assert.NoError(t, err)
+	assert.False(t, ok)
+
+	// So does revoking all of the user's sessions
+	ok, _, err = svc.ValidateSession(ctx, login.Session.UUID, login.PlainSessionToken)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+
+	assert.NoError(t, svc.RevokeAllOtherSessions(ctx, login.User.UUID, signup.Session.UUID))
+
+	ok, _, err = svc.ValidateSession(ctx, login.Session.UUID, login.PlainSessionToken)
+	assert.NoError(t, err)
+	assert.False(t, ok)
+}
+
+func TestSvc_ValidateSession_BcryptToken(t *testing.T) {
+	t.Parallel()
+
+	var (
+		ctx      = context.Background()
+		password = "test-pass"
+		dbPath   = path.Join(t.TempDir(), "cauth.db")
+	)
+
+	_, svc := cauthtest.NewHandlerAndSvc(t, cauthtest.HandlerParams{DBPath: dbPath, Dialect: "sqlite3"})
+
+	session, err := svc.Signup(ctx, cauth.SignupParams{Username: "test-user", Password: &password})
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	// Store the token like sessions created before tokens were stored as HMAC digests
+	hashedToken, err := bcrypt.GenerateFromPassword([]byte(session.PlainSessionToken), bcrypt.MinCost)
+	assert.NoError(t, err)
+
+	db, err := sql.Open("sqlite3", "file:"+dbPath+"?_busy_timeout=5000")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer db.Close()
+
+	_, err = db.ExecContext(ctx, `UPDATE cauth_sessions SET token=? WHERE uuid=?`, hashedToken, session.Session.UUID)
+	assert.NoError(t, err)
+
+	ok, _, err := svc.ValidateSession(ctx, session.Session.UUID, "wrong-token")
+	assert.NoError(t, err)
+	assert.False(t, ok)
+
+	ok, _, err = svc.ValidateSession(ctx, session.Session.UUID, session.PlainSessionToken)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+
+	// The bcrypt hash is replaced with the digest once the session is validated
+	var token []byte
+
+	assert.NoError(t, db.QueryRowContext(ctx, `SELECT token FROM cauth_sessions WHERE uuid=?`,
+		session.Session.UUID).Scan(&token))
+	assert.NotEqual(t, hashedToken, token)
+	assert.Len(t, token, 32)
+
+	ok, _, err = svc.ValidateSession(ctx, session.Session.UUID, session.PlainSessionToken)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+}
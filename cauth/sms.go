@@ -0,0 +1,113 @@
+package cauth
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/gocopper/copper/cconfig"
+	"github.com/gocopper/copper/cerrors"
+	"github.com/gocopper/copper/clogger"
+)
+
+const twilioDefaultBaseURL = "https://api.twilio.com"
+
+// SMSSender provides methods to send text messages. It is used to send verification codes to phone numbers.
+type SMSSender interface {
+	Send(ctx context.Context, p SendSMSParams) error
+}
+
+// SendSMSParams holds data needed to send a text message using SMSSender.
+type SendSMSParams struct {
+	To   string
+	Body string
+}
+
+// NewLogSMSSender creates an implementation of SMSSender that logs all sends with the provided logger. Useful
+// during dev as it requires no configuration.
+func NewLogSMSSender(logger clogger.Logger) SMSSender {
+	return &logSMSSender{logger: logger}
+}
+
+type logSMSSender struct {
+	logger clogger.Logger
+}
+
+func (s *logSMSSender) Send(ctx context.Context, p SendSMSParams) error {
+	s.logger.WithTags(map[string]interface{}{
+		"to":   p.To,
+		"body": p.Body,
+	}).Info("Send sms")
+
+	return nil
+}
+
+// TwilioConfig is used to configure the Twilio SMS sender.
+type TwilioConfig struct {
+	AccountSID string `toml:"account_sid"`
+	AuthToken  string `toml:"auth_token"`
+	From       string `toml:"from"`
+
+	// BaseURL can be used to point the sender at a Twilio compatible API. Defaults to https://api.twilio.com
+	BaseURL string `toml:"base_url"`
+}
+
+// NewTwilioSMSSender creates an implementation of SMSSender that uses the Twilio Messages API. It is configured
+// using the [twilio] table in the app config.
+func NewTwilioSMSSender(appConfig cconfig.Loader) (SMSSender, error) {
+	var config = TwilioConfig{
+		BaseURL: twilioDefaultBaseURL,
+	}
+
+	err := appConfig.Load("twilio", &config)
+	if err != nil {
+		return nil, cerrors.New(err, "failed to load twilio config", nil)
+	}
+
+	return &twilioSMSSender{
+		config:     config,
+		httpClient: http.DefaultClient,
+	}, nil
+}
+
+type twilioSMSSender struct {
+	config     TwilioConfig
+	httpClient *http.Client
+}
+
+func (s *twilioSMSSender) Send(ctx context.Context, p SendSMSParams) error {
+	form := url.Values{}
+	form.Set("To", p.To)
+	form.Set("From", s.config.From)
+	form.Set("Body", p.Body)
+
+	messagesURL := strings.TrimSuffix(s.config.BaseURL, "/") +
+		"/2010-04-01/Accounts/" + url.PathEscape(s.config.AccountSID) + "/Messages.json"
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, messagesURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return cerrors.New(err, "failed to create twilio request", nil)
+	}
+
+	req.SetBasicAuth(s.config.AccountSID, s.config.AuthToken)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return cerrors.New(err, "failed to execute twilio request", nil)
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode < http.StatusOK || resp.StatusCode >= http.StatusMultipleChoices {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, maxHTTPRespLen))
+
+		return cerrors.New(nil, "twilio returned an unexpected response status", map[string]interface{}{
+			"statusCode": resp.StatusCode,
+			"body":       string(body),
+		})
+	}
+
+	return nil
+}
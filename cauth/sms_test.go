@@ -0,0 +1,82 @@
+package cauth_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"testing"
+
+	"github.com/gocopper/copper/cconfig"
+	"github.com/gocopper/copper/cconfig/cconfigtest"
+	"github.com/gocopper/copper/clogger"
+	"github.com/gocopper/pkg/cauth"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLogSMSSender_Send(t *testing.T) {
+	t.Parallel()
+
+	var (
+		logs      = make([]clogger.RecordedLog, 0)
+		logger    = clogger.NewRecorder(&logs)
+		smsSender = cauth.NewLogSMSSender(logger)
+	)
+
+	err := smsSender.Send(context.Background(), cauth.SendSMSParams{
+		To:   "+14155550123",
+		Body: "test body",
+	})
+
+	assert.NoError(t, err)
+	assert.Equal(t, "Send sms", logs[0].Msg)
+	assert.Equal(t, map[string]interface{}{
+		"to":   "+14155550123",
+		"body": "test body",
+	}, logs[0].Tags)
+}
+
+func TestTwilioSMSSender_Send(t *testing.T) {
+	t.Parallel()
+
+	var received = make(chan *http.Request, 1)
+
+	twilio := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.NoError(t, r.ParseForm())
+		received <- r
+
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer twilio.Close()
+
+	configDir := cconfigtest.SetupDirWithConfigs(t, map[string]string{"test.toml": `
+[twilio]
+account_sid = "test-sid"
+auth_token = "test-token"
+from = "+14155550100"
+base_url = "` + twilio.URL + `"
+`})
+
+	configLoader, err := cconfig.New(cconfig.Path(path.Join(configDir, "test.toml")), "")
+	assert.NoError(t, err)
+
+	smsSender, err := cauth.NewTwilioSMSSender(configLoader)
+	assert.NoError(t, err)
+
+	err = smsSender.Send(context.Background(), cauth.SendSMSParams{
+		To:   "+14155550123",
+		Body: "test body",
+	})
+	assert.NoError(t, err)
+
+	r := <-received
+	sid, token, ok := r.BasicAuth()
+
+	assert.True(t, ok)
+	assert.Equal(t, "test-sid", sid)
+	assert.Equal(t, "test-token", token)
+	assert.Equal(t, "/2010-04-01/Accounts/test-sid/Messages.json", r.URL.Path)
+	assert.Equal(t, "+14155550123", r.PostForm.Get("To"))
+	assert.Equal(t, "+14155550100", r.PostForm.Get("From"))
+	assert.Equal(t, "test body", r.PostForm.Get("Body"))
+}
@@ -2,22 +2,27 @@ package cauth
 
 import (
 	"context"
+	"crypto/hmac"
 	"errors"
 	"github.com/gocopper/pkg/cvars"
 	"net/http"
 	"strconv"
 	"strings"
+	"sync"
 	"text/template"
 	"time"
 
 	"github.com/gocopper/pkg/cmailer"
 
 	"github.com/gocopper/copper/cerrors"
+	"github.com/gocopper/pkg/cpubsub"
 	"github.com/gocopper/pkg/crandom"
 	"github.com/google/uuid"
 	"golang.org/x/crypto/bcrypt"
 )
 
+const verificationCodeTTL = 10 * time.Minute
+
 var (
 	// ErrInvalidCredentials is returned when a credential check fails. This usually happens during the login process.
 	ErrInvalidCredentials = errors.New("invalid credentials")
@@ -27,22 +32,78 @@ var (
 
 	// ErrVerificationCodeExpired is returned when the verification code has expired.
 	ErrVerificationCodeExpired = errors.New("verification code expired")
+
+	// ErrImpersonating is returned when a session that impersonates a user tries to add credentials to that user.
+	ErrImpersonating = errors.New("not allowed while impersonating a user")
 )
 
-// NewSvc instantiates and returns a new Svc.
-func NewSvc(queries *Queries, mailer cmailer.Mailer, config Config) (*Svc, error) {
+// NewSvc instantiates and returns a new Svc. If pubSub is not nil, Svc publishes events on the well-known topics.
+func NewSvc(
+	queries *Queries,
+	mailer cmailer.Mailer,
+	smsSender SMSSender,
+	attempts AttemptStore,
+	pubSub cpubsub.PubSub,
+	config Config,
+) (*Svc, error) {
+	if config.SessionTokenKey == "" {
+		return nil, cerrors.New(nil, "session token key is required", nil)
+	}
+
+	oauthProviders := make(map[string]OAuthProvider, len(config.OAuthProviders))
+
+	for name, providerConfig := range config.OAuthProviders {
+		provider, err := NewOAuthProvider(name, providerConfig)
+		if err != nil {
+			return nil, cerrors.New(err, "failed to create oauth provider", map[string]interface{}{
+				"name": name,
+			})
+		}
+
+		oauthProviders[name] = provider
+	}
+
+	hasher, err := NewPasswordHasher(config)
+	if err != nil {
+		return nil, err
+	}
+
+	accessTokenKeys, err := newAccessTokenKeys(config.AccessTokenSigningKeys)
+	if err != nil {
+		return nil, err
+	}
+
 	return &Svc{
-		queries: queries,
-		mailer:  mailer,
-		config:  config,
+		queries:         queries,
+		mailer:          mailer,
+		smsSender:       smsSender,
+		attempts:        attempts,
+		pubSub:          pubSub,
+		config:          config,
+		oauthProviders:  oauthProviders,
+		hasher:          hasher,
+		sessionCache:    newSessionCache(config.SessionCacheSize, config.SessionCacheTTL),
+		accessTokenKeys: accessTokenKeys,
 	}, nil
 }
 
 // Svc provides methods to manage users and sessions.
 type Svc struct {
-	queries *Queries
-	mailer  cmailer.Mailer
-	config  Config
+	queries         *Queries
+	mailer          cmailer.Mailer
+	smsSender       SMSSender
+	attempts        AttemptStore
+	pubSub          cpubsub.PubSub
+	config          Config
+	hasher          PasswordHasher
+	sessionCache    *sessionCache
+	accessTokenKeys []accessTokenKey
+
+	oauthProviders   map[string]OAuthProvider
+	oauthProvidersMu sync.RWMutex
+
+	userDataHooks   []UserDataHook
+	userDataHooksMu sync.RWMutex
 }
 
 // SessionResult is usually used when a new session is created. It holds the plain session token that can be used
@@ -53,19 +114,33 @@ type SessionResult struct {
 	PlainSessionToken string        `json:"plain_session_token"`
 	NewUser           bool          `json:"new_user"`
 	HTTPCookies       []http.Cookie `json:"-"`
+
+	// TwoFactorChallenge is set instead of Session when the user has two-factor authentication enabled. The login
+	// must be completed using VerifyTwoFactor.
+	TwoFactorChallenge *TwoFactorChallengeResult `json:"two_factor_challenge,omitempty"`
 }
 
-// SignupParams hold the params needed to signup a new user.
+// SignupParams hold the params needed to signup a new user. If Phone is provided, the user signs up with their
+// phone number instead of their email. Username is optional unless neither email nor phone are provided.
 type SignupParams struct {
 	Email    string  `json:"email"`
+	Phone    string  `json:"phone"`
+	Username string  `json:"username"`
 	Password *string `json:"password"`
 }
 
-// LoginParams hold the params needed to login a user.
+// LoginParams hold the params needed to login a user. The user is identified by exactly one of Email, Phone or
+// Username. Users that are identified by their username must login with a password.
 type LoginParams struct {
 	Email            string  `json:"email"`
+	Phone            string  `json:"phone"`
+	Username         string  `json:"username"`
 	Password         *string `json:"password"`
 	VerificationCode *string `json:"verification_code"`
+
+	// RememberMe keeps the session cookies across browser restarts and uses Config.SessionRememberMeIdleTimeout
+	// instead of Config.SessionIdleTimeout.
+	RememberMe bool `json:"remember_me"`
 }
 
 // VerifyEmailParams hold the params needed to verify an email.
@@ -81,9 +156,20 @@ type ResetPasswordParams struct {
 }
 
 type UpdatePasswordParams struct {
-	Email           string
-	CurrentPassword string
-	NewPassword     string
+	UserUUID        string `json:"-"`
+	CurrentPassword string `json:"current_password"`
+	NewPassword     string `json:"new_password"`
+}
+
+// ResendVerificationCodeParams hold the params needed to send a new verification code to an email, e.g. to reset
+// a forgotten password.
+type ResendVerificationCodeParams struct {
+	Email string `json:"email"`
+}
+
+// ImpersonateUserParams hold the params needed to impersonate the user with the given email.
+type ImpersonateUserParams struct {
+	Email string `json:"email"`
 }
 
 func (s *Svc) StopImpersonatingUser(ctx context.Context, sessionID string) error {
@@ -94,19 +180,32 @@ func (s *Svc) StopImpersonatingUser(ctx context.Context, sessionID string) error
 		})
 	}
 
+	if session.ImpersonatedUserUUID == nil {
+		return nil
+	}
+
+	impersonatedUserUUID := *session.ImpersonatedUserUUID
+
 	session.UpdatedAt = time.Now()
 	session.ImpersonatedUserUUID = nil
 
-	err = s.queries.UpdateSession(ctx, session)
+	err = s.updateSession(ctx, session)
 	if err != nil {
 		return cerrors.New(err, "failed to update session", map[string]interface{}{
 			"sessionID": session.UUID,
 		})
 	}
 
-	return nil
+	return s.recordAuditEvent(ctx, &AuditEvent{
+		Type:        AuditEventImpersonationStopped,
+		UserUUID:    session.UserUUID,
+		SessionUUID: session.UUID,
+		Metadata:    AuditMetadata{"impersonated_user_uuid": impersonatedUserUUID},
+	})
 }
 
+// ImpersonateUser makes the given session act as the user with the given email until StopImpersonatingUser is called.
+// The session's user must have the PermissionImpersonateUsers permission, otherwise ErrPermissionDenied is returned.
 func (s *Svc) ImpersonateUser(ctx context.Context, sessionID, userEmail string) error {
 	session, err := s.queries.GetSession(ctx, sessionID)
 	if err != nil {
@@ -115,6 +214,13 @@ func (s *Svc) ImpersonateUser(ctx context.Context, sessionID, userEmail string)
 		})
 	}
 
+	ok, err := s.HasPermission(ctx, session.UserUUID, PermissionImpersonateUsers)
+	if err != nil {
+		return err
+	} else if !ok {
+		return ErrPermissionDenied
+	}
+
 	impersonatedUser, err := s.queries.GetUserByEmail(ctx, userEmail)
 	if err != nil {
 		return cerrors.New(err, "failed to get user by email", map[string]interface{}{
@@ -125,32 +231,43 @@ func (s *Svc) ImpersonateUser(ctx context.Context, sessionID, userEmail string)
 	session.UpdatedAt = time.Now()
 	session.ImpersonatedUserUUID = &impersonatedUser.UUID
 
-	err = s.queries.UpdateSession(ctx, session)
+	err = s.updateSession(ctx, session)
 	if err != nil {
 		return cerrors.New(err, "failed to update session", map[string]interface{}{
 			"sessionID": session.UUID,
 		})
 	}
 
-	return nil
+	return s.recordAuditEvent(ctx, &AuditEvent{
+		Type:        AuditEventImpersonationStarted,
+		UserUUID:    session.UserUUID,
+		SessionUUID: session.UUID,
+		Metadata:    AuditMetadata{"impersonated_user_uuid": impersonatedUser.UUID},
+	})
 }
 
+// UpdatePassword changes the user's password after checking their current password. All of the user's sessions
+// are revoked. ErrWeakPassword is returned if the new password does not satisfy the password policy.
 func (s *Svc) UpdatePassword(ctx context.Context, p UpdatePasswordParams) error {
-	user, err := s.queries.GetUserByEmail(ctx, p.Email)
+	user, err := s.queries.GetUserByUUID(ctx, p.UserUUID)
 	if err != nil && errors.Is(err, ErrNotFound) {
 		return ErrInvalidCredentials
 	} else if err != nil {
-		return cerrors.New(err, "failed to get user by email", map[string]interface{}{
-			"email": p.Email,
+		return cerrors.New(err, "failed to get user by uuid", map[string]interface{}{
+			"userUUID": p.UserUUID,
 		})
 	}
 
-	err = bcrypt.CompareHashAndPassword(user.Password, []byte(p.CurrentPassword))
+	err = s.checkPassword(p.NewPassword, user.Email, user.Username)
 	if err != nil {
+		return err
+	}
+
+	if !s.hasher.Verify(user.Password, p.CurrentPassword) {
 		return ErrInvalidCredentials
 	}
 
-	hp, err := bcrypt.GenerateFromPassword([]byte(p.NewPassword), bcrypt.DefaultCost)
+	hp, err := s.hasher.Hash(p.NewPassword)
 	if err != nil {
 		return cerrors.New(err, "failed to hash password", nil)
 	}
@@ -165,7 +282,17 @@ func (s *Svc) UpdatePassword(ctx context.Context, p UpdatePasswordParams) error
 		})
 	}
 
-	return nil
+	err = s.revokeAllSessions(ctx, user.UUID)
+	if err != nil {
+		return cerrors.New(err, "failed to revoke sessions", map[string]interface{}{
+			"userUUID": user.UUID,
+		})
+	}
+
+	return s.recordAuditEvent(ctx, &AuditEvent{
+		Type:     AuditEventPasswordChanged,
+		UserUUID: user.UUID,
+	})
 }
 
 func (s *Svc) ResendVerificationCode(ctx context.Context, email string) error {
@@ -186,7 +313,7 @@ func (s *Svc) sendVerificationCodeEmail(ctx context.Context, user *User) error {
 
 	user.UpdatedAt = time.Now()
 	user.VerificationCode = cvars.Ptr(strconv.Itoa(int(crandom.GenerateRandomNumericalCode(s.config.VerificationCodeLen))))
-	user.VerificationCodeExpiresAt = cvars.Ptr(time.Now().UTC().Add(time.Minute * 10))
+	user.VerificationCodeExpiresAt = cvars.Ptr(time.Now().UTC().Add(verificationCodeTTL))
 
 	err := s.queries.UpdateUser(ctx, user)
 	if err != nil {
@@ -195,6 +322,11 @@ func (s *Svc) sendVerificationCodeEmail(ctx context.Context, user *User) error {
 		})
 	}
 
+	err = s.resetAttempts(ctx, attemptKeyEmailVerificationCode(user.UUID))
+	if err != nil {
+		return err
+	}
+
 	tmpl, err := template.New("email_verification_code").Parse(s.config.VerificationEmailBodyHTML)
 	if err != nil {
 		return cerrors.New(err, "failed to parse verification code email template", nil)
@@ -224,29 +356,44 @@ func (s *Svc) sendVerificationCodeEmail(ctx context.Context, user *User) error {
 	return nil
 }
 
+// ResetPassword sets a new password for the user using the verification code that was sent to their email. All of
+// the user's sessions are revoked. ErrWeakPassword is returned if the new password does not satisfy the password
+// policy.
 func (s *Svc) ResetPassword(ctx context.Context, p ResetPasswordParams) error {
+	accountKey := attemptKeyEmail(p.Email)
+
+	err := s.checkPassword(p.Password, p.Email, "")
+	if err != nil {
+		return err
+	}
+
+	err = s.checkAttempts(ctx, accountKey)
+	if err != nil {
+		return err
+	}
+
 	user, err := s.queries.GetUserByEmail(ctx, p.Email)
 	if err != nil && errors.Is(err, ErrNotFound) {
-		return ErrInvalidCredentials
+		return s.recordFailedAttempt(ctx, ErrInvalidCredentials, accountKey)
 	} else if err != nil {
 		return cerrors.New(err, "failed to get user by email", map[string]interface{}{
 			"email": p.Email,
 		})
 	}
 
-	if user.VerificationCodeExpiresAt == nil || time.Now().UTC().After(*user.VerificationCodeExpiresAt) {
-		return ErrVerificationCodeExpired
-	} else if user.VerificationCode == nil || *user.VerificationCode != p.VerificationCode {
-		return ErrInvalidCredentials
+	err = s.checkEmailVerificationCode(ctx, user, accountKey, p.VerificationCode)
+	if err != nil {
+		return err
 	}
 
-	hp, err := bcrypt.GenerateFromPassword([]byte(p.Password), bcrypt.DefaultCost)
+	hp, err := s.hasher.Hash(p.Password)
 	if err != nil {
 		return cerrors.New(err, "failed to hash password", nil)
 	}
 
 	user.UpdatedAt = time.Now()
 	user.Password = hp
+	user.VerificationCodeExpiresAt = &user.UpdatedAt
 
 	err = s.queries.UpdateUser(ctx, user)
 	if err != nil {
@@ -255,17 +402,103 @@ func (s *Svc) ResetPassword(ctx context.Context, p ResetPasswordParams) error {
 		})
 	}
 
-	return nil
+	err = s.revokeAllSessions(ctx, user.UUID)
+	if err != nil {
+		return cerrors.New(err, "failed to revoke sessions", map[string]interface{}{
+			"userUUID": user.UUID,
+		})
+	}
+
+	return s.recordAuditEvent(ctx, &AuditEvent{
+		Type:     AuditEventPasswordReset,
+		UserUUID: user.UUID,
+	})
 }
 
 // Signup creates a new user. If contact methods such as email or phone are provided, it will send verification
-// codes so them. It creates a new session for this newly created user and returns that.
+// codes so them. It creates a new session for this newly created user and returns that. Users that only provide a
+// username must also provide a password. ErrWeakPassword is returned if the password does not satisfy the password
+// policy.
 func (s *Svc) Signup(ctx context.Context, p SignupParams) (*SessionResult, error) {
-	return s.signupWithEmail(ctx, p.Email, p.Password)
+	var (
+		sessionResult *SessionResult
+		username      string
+		err           error
+	)
+
+	if p.Username != "" {
+		username, err = NormalizeUsername(p.Username)
+		if err != nil {
+			return nil, err
+		}
+
+		err = s.checkUsernameAvailable(ctx, "", username)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if p.Password != nil {
+		err = s.checkPassword(*p.Password, p.Email, username)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	switch {
+	case p.Phone != "":
+		sessionResult, err = s.signupWithPhone(ctx, p.Phone, p.Password)
+	case p.Email != "" || username == "":
+		sessionResult, err = s.signupWithEmail(ctx, p.Email, p.Password, false)
+	default:
+		sessionResult, err = s.signupWithUsername(ctx, username, p.Password)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if username != "" && sessionResult.User.Username == "" {
+		sessionResult.User.UpdatedAt = time.Now()
+		sessionResult.User.Username = username
+
+		err = s.queries.UpdateUser(ctx, sessionResult.User)
+		if err != nil {
+			return nil, cerrors.New(err, "failed to update user", map[string]interface{}{
+				"userUUID": sessionResult.User.UUID,
+			})
+		}
+	}
+
+	event := &AuditEvent{
+		Type:     AuditEventSignup,
+		UserUUID: sessionResult.User.UUID,
+	}
+
+	if sessionResult.Session != nil {
+		event.SessionUUID = sessionResult.Session.UUID
+	}
+
+	err = s.recordAuditEvent(ctx, event)
+	if err != nil {
+		return nil, err
+	}
+
+	return sessionResult, nil
 }
 
-func (s *Svc) signupWithEmail(ctx context.Context, email string, password *string) (*SessionResult, error) {
-	var newUser = false
+// signupWithEmail creates a user with the given email or reuses an existing user that has no password. Unless
+// emailVerified is set, the email is marked as unverified and a verification code is sent to it.
+func (s *Svc) signupWithEmail(ctx context.Context, email string, password *string, emailVerified bool) (*SessionResult, error) {
+	var (
+		newUser         = false
+		emailVerifiedAt *time.Time
+	)
+
+	if emailVerified {
+		now := time.Now()
+		emailVerifiedAt = &now
+	}
 
 	user, err := s.queries.GetUserByEmail(ctx, email)
 	if err != nil && !errors.Is(err, ErrNotFound) {
@@ -277,7 +510,7 @@ func (s *Svc) signupWithEmail(ctx context.Context, email string, password *strin
 		return nil, ErrUserAlreadyExists
 	} else if err == nil && len(user.Password) == 0 {
 		user.UpdatedAt = time.Now()
-		user.EmailVerifiedAt = nil
+		user.EmailVerifiedAt = emailVerifiedAt
 
 		err = s.queries.UpdateUser(ctx, user)
 		if err != nil {
@@ -286,14 +519,15 @@ func (s *Svc) signupWithEmail(ctx context.Context, email string, password *strin
 	} else if errors.Is(err, ErrNotFound) {
 		newUser = true
 		user = &User{
-			UUID:      uuid.New().String(),
-			CreatedAt: time.Now(),
-			UpdatedAt: time.Now(),
-			Email:     email,
+			UUID:            uuid.New().String(),
+			CreatedAt:       time.Now(),
+			UpdatedAt:       time.Now(),
+			Email:           email,
+			EmailVerifiedAt: emailVerifiedAt,
 		}
 
 		if password != nil {
-			hp, err := bcrypt.GenerateFromPassword([]byte(*password), bcrypt.DefaultCost)
+			hp, err := s.hasher.Hash(*password)
 			if err != nil {
 				return nil, cerrors.New(err, "failed to hash password", nil)
 			}
@@ -306,11 +540,13 @@ func (s *Svc) signupWithEmail(ctx context.Context, email string, password *strin
 		}
 	}
 
-	err = s.sendVerificationCodeEmail(ctx, user)
-	if err != nil {
-		return nil, cerrors.New(err, "failed to send verification code email", map[string]interface{}{
-			"userID": user.UUID,
-		})
+	if !emailVerified {
+		err = s.sendVerificationCodeEmail(ctx, user)
+		if err != nil {
+			return nil, cerrors.New(err, "failed to send verification code email", map[string]interface{}{
+				"userID": user.UUID,
+			})
+		}
 	}
 
 	if password == nil {
@@ -322,29 +558,117 @@ func (s *Svc) signupWithEmail(ctx context.Context, email string, password *strin
 		}, nil
 	}
 
-	session, plainSessionToken, err := s.createSession(ctx, user.UUID)
+	sessionResult, err := s.createSessionResult(ctx, user, false)
 	if err != nil {
-		return nil, cerrors.New(err, "failed to create session", nil)
+		return nil, err
 	}
 
-	return &SessionResult{
-		User:              user,
-		Session:           session,
-		PlainSessionToken: plainSessionToken,
-		NewUser:           newUser,
-		HTTPCookies:       getHTTPCookies(session.UUID, plainSessionToken),
-	}, nil
+	sessionResult.NewUser = newUser
+
+	return sessionResult, nil
 }
 
 // Login logs in an existing user with the given credentials. If the login succeeds, it creates a new session
-// and returns it.
+// and returns it. If the user has two-factor authentication enabled, a pending TwoFactorChallenge is returned
+// instead that must be completed with VerifyTwoFactor.
 func (s *Svc) Login(ctx context.Context, p LoginParams) (*SessionResult, error) {
+	var (
+		method     = loginMethodPassword
+		identifier = p.Email
+	)
+
+	if p.Password == nil {
+		method = loginMethodVerificationCode
+	}
+
+	if p.Username != "" && p.Password != nil {
+		identifier = p.Username
+	} else if p.Phone != "" {
+		identifier = p.Phone
+	}
+
+	var (
+		user     = s.loginTargetUser(ctx, p)
+		userUUID string
+	)
+
+	if user != nil {
+		userUUID = user.UUID
+	}
+
+	// Deleted users are rejected before any credentials are checked so that nothing is written for them
+	if user != nil && user.DeletedAt != nil {
+		return nil, s.recordLoginFailure(ctx, ErrInvalidCredentials, userUUID, method, identifier)
+	}
+
+	sessionResult, err := s.login(ctx, p)
+	if err != nil {
+		return nil, s.recordLoginFailure(ctx, err, userUUID, method, identifier)
+	}
+
+	err = s.recordLogin(ctx, method, sessionResult)
+	if err != nil {
+		return nil, err
+	}
+
+	return sessionResult, nil
+}
+
+// loginTargetUser returns the user that the given login params identify so that failed logins can be attributed to
+// the targeted account. It returns nil if there is no such user.
+func (s *Svc) loginTargetUser(ctx context.Context, p LoginParams) *User {
+	var (
+		user *User
+		err  error
+	)
+
+	switch {
+	case p.Username != "" && p.Password != nil:
+		username, normErr := NormalizeUsername(p.Username)
+		if normErr != nil {
+			return nil
+		}
+
+		user, err = s.queries.GetUserByUsername(ctx, username)
+	case p.Phone != "":
+		phone, normErr := NormalizePhoneNumber(p.Phone)
+		if normErr != nil {
+			return nil
+		}
+
+		user, err = s.queries.GetUserByPhone(ctx, phone)
+	case p.Email != "":
+		user, err = s.queries.GetUserByEmail(ctx, p.Email)
+	default:
+		return nil
+	}
+
+	if err != nil {
+		return nil
+	}
+
+	return user
+}
+
+func (s *Svc) login(ctx context.Context, p LoginParams) (*SessionResult, error) {
+	if p.Username != "" && p.Password != nil {
+		return s.loginWithUsernamePassword(ctx, p.Username, *p.Password, p.RememberMe)
+	}
+
+	if p.Phone != "" && p.Password != nil {
+		return s.loginWithPhonePassword(ctx, p.Phone, *p.Password, p.RememberMe)
+	}
+
+	if p.Phone != "" && p.VerificationCode != nil {
+		return s.loginWithPhoneVerificationCode(ctx, p.Phone, *p.VerificationCode, p.RememberMe)
+	}
+
 	if p.Password != nil {
-		return s.loginWithEmailPassword(ctx, p.Email, *p.Password)
+		return s.loginWithEmailPassword(ctx, p.Email, *p.Password, p.RememberMe)
 	}
 
 	if p.VerificationCode != nil {
-		return s.loginWithEmailVerificationCode(ctx, p.Email, *p.VerificationCode)
+		return s.loginWithEmailVerificationCode(ctx, p.Email, *p.VerificationCode, p.RememberMe)
 	}
 
 	return nil, cerrors.New(nil, "invalid login params", nil)
@@ -353,19 +677,25 @@ func (s *Svc) Login(ctx context.Context, p LoginParams) (*SessionResult, error)
 // VerifyEmail verifies the email of a user with the given verification code. If the verification succeeds,
 // it updates the user's email verification status and returns the user.
 func (s *Svc) VerifyEmail(ctx context.Context, p VerifyEmailParams) (*User, error) {
+	accountKey := attemptKeyEmail(p.Email)
+
+	err := s.checkAttempts(ctx, accountKey)
+	if err != nil {
+		return nil, err
+	}
+
 	user, err := s.queries.GetUserByEmail(ctx, p.Email)
 	if err != nil && errors.Is(err, ErrNotFound) {
-		return nil, ErrInvalidCredentials
+		return nil, s.recordFailedAttempt(ctx, ErrInvalidCredentials, accountKey)
 	} else if err != nil {
 		return nil, cerrors.New(err, "failed to get user by email", map[string]interface{}{
 			"email": p.Email,
 		})
 	}
 
-	if user.VerificationCodeExpiresAt == nil || time.Now().UTC().After(*user.VerificationCodeExpiresAt) {
-		return nil, ErrVerificationCodeExpired
-	} else if user.VerificationCode == nil || *user.VerificationCode != p.VerificationCode {
-		return nil, ErrInvalidCredentials
+	err = s.checkEmailVerificationCode(ctx, user, accountKey, p.VerificationCode)
+	if err != nil {
+		return nil, err
 	}
 
 	user.UpdatedAt = time.Now()
@@ -379,10 +709,37 @@ func (s *Svc) VerifyEmail(ctx context.Context, p VerifyEmailParams) (*User, erro
 		})
 	}
 
+	err = s.recordAuditEvent(ctx, &AuditEvent{
+		Type:     AuditEventEmailVerified,
+		UserUUID: user.UUID,
+	})
+	if err != nil {
+		return nil, err
+	}
+
 	return user, nil
 }
 
-func (s *Svc) loginWithEmailVerificationCode(ctx context.Context, email, code string) (*SessionResult, error) {
+// checkEmailVerificationCode checks the given code against the user's email verification code. Wrong codes count as
+// failed attempts of the account and of the code itself, so that the code is invalidated after too many guesses.
+func (s *Svc) checkEmailVerificationCode(ctx context.Context, user *User, accountKey, code string) error {
+	codeKey := attemptKeyEmailVerificationCode(user.UUID)
+
+	err := s.checkVerificationCodeAttempts(ctx, codeKey)
+	if err != nil {
+		return err
+	}
+
+	if user.VerificationCodeExpiresAt == nil || time.Now().UTC().After(*user.VerificationCodeExpiresAt) {
+		return ErrVerificationCodeExpired
+	} else if user.VerificationCode == nil || *user.VerificationCode != code {
+		return s.recordFailedAttempt(ctx, ErrInvalidCredentials, accountKey, codeKey)
+	}
+
+	return s.resetAttempts(ctx, accountKey)
+}
+
+func (s *Svc) loginWithEmailVerificationCode(ctx context.Context, email, code string, rememberMe bool) (*SessionResult, error) {
 	user, err := s.VerifyEmail(ctx, VerifyEmailParams{
 		Email:            email,
 		VerificationCode: code,
@@ -399,38 +756,96 @@ func (s *Svc) loginWithEmailVerificationCode(ctx context.Context, email, code st
 		})
 	}
 
-	session, plainSessionToken, err := s.createSession(ctx, user.UUID)
-	if err != nil {
-		return nil, cerrors.New(err, "failed to create session", map[string]interface{}{
-			"userUUID": user.UUID,
-		})
+	return s.loginUser(ctx, user, rememberMe)
+}
+
+func (s *Svc) loginWithEmailPassword(ctx context.Context, email, password string, rememberMe bool) (*SessionResult, error) {
+	if email == "" {
+		// Users that signed up with a phone number or username have an empty email
+		return nil, ErrInvalidCredentials
 	}
 
-	return &SessionResult{
-		User:              user,
-		Session:           session,
-		PlainSessionToken: plainSessionToken,
-		HTTPCookies:       getHTTPCookies(session.UUID, plainSessionToken),
-	}, nil
+	accountKey := attemptKeyEmail(email)
 
-}
+	err := s.checkAttempts(ctx, accountKey)
+	if err != nil {
+		return nil, err
+	}
 
-func (s *Svc) loginWithEmailPassword(ctx context.Context, email, password string) (*SessionResult, error) {
 	user, err := s.queries.GetUserByEmail(ctx, email)
 	if err != nil && errors.Is(err, ErrNotFound) {
-		return nil, ErrInvalidCredentials
+		return nil, s.recordFailedAttempt(ctx, ErrInvalidCredentials, accountKey)
 	} else if err != nil {
 		return nil, cerrors.New(err, "failed to get user by email", map[string]interface{}{
 			"email": email,
 		})
 	}
 
-	err = bcrypt.CompareHashAndPassword(user.Password, []byte(password))
+	ok, err := s.verifyPassword(ctx, user, password)
 	if err != nil {
+		return nil, err
+	} else if !ok {
+		return nil, s.recordFailedAttempt(ctx, ErrInvalidCredentials, accountKey)
+	}
+
+	err = s.resetAttempts(ctx, accountKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.loginUser(ctx, user, rememberMe)
+}
+
+// verifyPassword checks the given password against the user's password hash. If the hash was created with another
+// algorithm or cost than the ones set in Config, it is replaced with a new hash so that hashes are migrated as users
+// login.
+func (s *Svc) verifyPassword(ctx context.Context, user *User, password string) (bool, error) {
+	if !s.hasher.Verify(user.Password, password) {
+		return false, nil
+	}
+
+	if !s.hasher.NeedsRehash(user.Password) {
+		return true, nil
+	}
+
+	hp, err := s.hasher.Hash(password)
+	if err != nil {
+		return false, cerrors.New(err, "failed to hash password", nil)
+	}
+
+	user.UpdatedAt = time.Now()
+	user.Password = hp
+
+	err = s.queries.UpdateUser(ctx, user)
+	if err != nil {
+		return false, cerrors.New(err, "failed to update user", map[string]interface{}{
+			"userUUID": user.UUID,
+		})
+	}
+
+	return true, nil
+}
+
+// loginUser creates a new session for a user that has passed the first factor of authentication. If the user has
+// two-factor authentication enabled, a pending challenge is returned instead.
+func (s *Svc) loginUser(ctx context.Context, user *User, rememberMe bool) (*SessionResult, error) {
+	if user.DeletedAt != nil {
+		return nil, ErrInvalidCredentials
+	}
+
+	if user.HasTOTPEnabled() {
+		return s.createTwoFactorChallenge(ctx, user, rememberMe)
+	}
+
+	return s.createSessionResult(ctx, user, rememberMe)
+}
+
+func (s *Svc) createSessionResult(ctx context.Context, user *User, rememberMe bool) (*SessionResult, error) {
+	if user.DeletedAt != nil {
 		return nil, ErrInvalidCredentials
 	}
 
-	session, plainSessionToken, err := s.createSession(ctx, user.UUID)
+	session, plainSessionToken, err := s.createSession(ctx, user.UUID, rememberMe)
 	if err != nil {
 		return nil, cerrors.New(err, "failed to create session", map[string]interface{}{
 			"userUUID": user.UUID,
@@ -441,30 +856,32 @@ func (s *Svc) loginWithEmailPassword(ctx context.Context, email, password string
 		User:              user,
 		Session:           session,
 		PlainSessionToken: plainSessionToken,
-		HTTPCookies:       getHTTPCookies(session.UUID, plainSessionToken),
+		HTTPCookies:       getSessionHTTPCookies(session, plainSessionToken),
 	}, nil
 }
 
-func (s *Svc) createSession(ctx context.Context, userUUID string) (*Session, string, error) {
+func (s *Svc) createSession(ctx context.Context, userUUID string, rememberMe bool) (*Session, string, error) {
 	const tokenLen = 72
 
 	plainToken := crandom.GenerateRandomString(tokenLen)
 
-	hashedToken, err := bcrypt.GenerateFromPassword([]byte(plainToken), bcrypt.DefaultCost)
-	if err != nil {
-		return nil, "", cerrors.New(err, "failed to hash session token", nil)
-	}
+	clientInfo := clientInfoFromContext(ctx)
 
 	session := &Session{
-		UUID:      uuid.New().String(),
-		CreatedAt: time.Now(),
-		UpdatedAt: time.Now(),
-		UserUUID:  userUUID,
-		Token:     hashedToken,
-		ExpiresAt: time.Now().Add(30 * 24 * time.Hour),
+		UUID:       uuid.New().String(),
+		CreatedAt:  time.Now(),
+		UpdatedAt:  time.Now(),
+		UserUUID:   userUUID,
+		Token:      s.sessionTokenDigest(plainToken),
+		UserAgent:  clientInfo.UserAgent,
+		IP:         clientInfo.IP,
+		LastSeenAt: time.Now(),
+		RememberMe: rememberMe,
 	}
 
-	err = s.queries.InsertSession(ctx, session)
+	session.ExpiresAt = s.sessionExpiresAt(session, session.CreatedAt)
+
+	err := s.queries.InsertSession(ctx, session)
 	if err != nil {
 		return nil, "", cerrors.New(err, "failed to create a new session", nil)
 	}
@@ -473,8 +890,19 @@ func (s *Svc) createSession(ctx context.Context, userUUID string) (*Session, str
 }
 
 // ValidateSession validates whether the provided plainToken is valid for the session identified by the given
-// sessionUUID.
+// sessionUUID and that the session has not expired. Sessions created before tokens were stored as HMAC digests hold
+// a bcrypt hash of their token instead. It is replaced with the digest the first time they are validated.
 func (s *Svc) ValidateSession(ctx context.Context, sessionUUID, plainToken string) (bool, *Session, error) {
+	digest := s.sessionTokenDigest(plainToken)
+
+	if session, ok := s.sessionCache.get(sessionUUID); ok {
+		if !hmac.Equal(session.Token, digest) || time.Now().After(session.ExpiresAt) {
+			return false, nil, nil
+		}
+
+		return true, session, nil
+	}
+
 	session, err := s.queries.GetSession(ctx, sessionUUID)
 	if err != nil && errors.Is(err, ErrNotFound) {
 		return false, nil, nil
@@ -484,11 +912,36 @@ func (s *Svc) ValidateSession(ctx context.Context, sessionUUID, plainToken strin
 		})
 	}
 
+	if time.Now().After(session.ExpiresAt) {
+		return false, nil, nil
+	}
+
+	if !isBcryptHash(session.Token) {
+		if !hmac.Equal(session.Token, digest) {
+			return false, nil, nil
+		}
+
+		s.sessionCache.set(session)
+
+		return true, session, nil
+	}
+
 	err = bcrypt.CompareHashAndPassword(session.Token, []byte(plainToken))
 	if err != nil {
 		return false, nil, nil
 	}
 
+	err = s.queries.UpdateSessionToken(ctx, session.UUID, digest)
+	if err != nil {
+		return false, nil, cerrors.New(err, "failed to update session token", map[string]interface{}{
+			"sessionUUID": sessionUUID,
+		})
+	}
+
+	session.Token = digest
+
+	s.sessionCache.set(session)
+
 	return true, session, nil
 }
 
@@ -509,14 +962,18 @@ func (s *Svc) Logout(ctx context.Context, sessionUUID string) error {
 	session.ExpiresAt = time.Now()
 	session.UpdatedAt = time.Now()
 
-	err = s.queries.UpdateSession(ctx, session)
+	err = s.updateSession(ctx, session)
 	if err != nil {
 		return cerrors.New(err, "failed to save session", map[string]interface{}{
 			"sessionUUID": sessionUUID,
 		})
 	}
 
-	return nil
+	return s.recordAuditEvent(ctx, &AuditEvent{
+		Type:        AuditEventLogout,
+		UserUUID:    session.UserUUID,
+		SessionUUID: session.UUID,
+	})
 }
 
 // getSessionAndUserFromHTTPRequest gets the session and user from the request. It checks for the session uuid and
@@ -525,55 +982,75 @@ func (s *Svc) Logout(ctx context.Context, sessionUUID string) error {
 //     and the password is the session token
 //  2. SessionUUID and SessionToken cookies
 //
-// If the validation fails, ErrInvalidCredentials is returned.
-func (s *Svc) getSessionAndUserFromHTTPRequest(_ context.Context, r *http.Request) (*Session, *User, error) {
+// If the validation fails, ErrInvalidCredentials is returned. If the session was renewed and it came from cookies,
+// the renewed cookies are returned so that their expiry matches the session's.
+func (s *Svc) getSessionAndUserFromHTTPRequest(_ context.Context, r *http.Request) (*Session, *User, []http.Cookie, error) {
 	var (
 		sessionUUID string
 		plainToken  string
+		fromCookies bool
+		cookies     []http.Cookie
 	)
 
 	sessionUUIDCookie, err := r.Cookie("SessionUUID")
 	if err != nil && !errors.Is(err, http.ErrNoCookie) {
-		return nil, nil, cerrors.New(err, "failed to get session uuid cookie", nil)
+		return nil, nil, nil, cerrors.New(err, "failed to get session uuid cookie", nil)
 	}
 
 	sessionTokenCookie, err := r.Cookie("SessionToken")
 	if err != nil && !errors.Is(err, http.ErrNoCookie) {
-		return nil, nil, cerrors.New(err, "failed to get session token cookie", nil)
+		return nil, nil, nil, cerrors.New(err, "failed to get session token cookie", nil)
 	}
 
 	if sessionTokenCookie != nil && sessionUUIDCookie != nil {
 		sessionUUID = sessionUUIDCookie.Value
 		plainToken = sessionTokenCookie.Value
+		fromCookies = true
 	}
 
 	basicAuthUsername, basicAuthPass, ok := r.BasicAuth()
 	if ok && basicAuthUsername != "" && basicAuthPass != "" {
 		sessionUUID = basicAuthUsername
 		plainToken = basicAuthPass
+		fromCookies = false
 	}
 
 	if sessionUUID == "" || plainToken == "" {
-		return nil, nil, ErrInvalidCredentials
+		return nil, nil, nil, ErrInvalidCredentials
 	}
 
 	ok, session, err := s.ValidateSession(r.Context(), sessionUUID, plainToken)
 	if err != nil {
-		return nil, nil, cerrors.New(err, "failed to validate session", map[string]interface{}{
+		return nil, nil, nil, cerrors.New(err, "failed to validate session", map[string]interface{}{
 			"sessionUUID": sessionUUID,
 		})
 	}
 
 	if !ok {
-		return nil, nil, ErrInvalidCredentials
+		return nil, nil, nil, ErrInvalidCredentials
+	}
+
+	renewed, err := s.touchSession(r.Context(), session)
+	if err != nil {
+		return nil, nil, nil, cerrors.New(err, "failed to update session last seen", map[string]interface{}{
+			"sessionUUID": sessionUUID,
+		})
+	}
+
+	if renewed && fromCookies {
+		cookies = getSessionHTTPCookies(session, plainToken)
 	}
 
 	user, err := s.GetUserByUUID(r.Context(), session.CurrentUserID())
 	if err != nil {
-		return nil, nil, cerrors.New(err, "failed to get user by uuid", map[string]interface{}{
+		return nil, nil, nil, cerrors.New(err, "failed to get user by uuid", map[string]interface{}{
 			"userUUID": session.CurrentUserID(),
 		})
 	}
 
-	return session, user, nil
+	if user.DeletedAt != nil {
+		return nil, nil, nil, ErrInvalidCredentials
+	}
+
+	return session, user, cookies, nil
 }
@@ -0,0 +1,529 @@
+package cauth
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1" //nolint:gosec // RFC 6238 uses HMAC-SHA1 by default and authenticator apps expect it
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gocopper/copper/cerrors"
+	"github.com/gocopper/pkg/crandom"
+	"github.com/google/uuid"
+)
+
+const (
+	totpSecretLen  = 20
+	totpDigits     = 6
+	totpPeriod     = 30 * time.Second
+	totpSkewSteps  = 1
+	backupCodesLen = 10
+	backupCodesNum = 10
+
+	twoFactorChallengeTokenLen = 72
+	twoFactorChallengeTTL      = 5 * time.Minute
+)
+
+var (
+	// ErrTOTPAlreadyEnabled is returned when a user tries to enroll in TOTP while they already have it enabled.
+	ErrTOTPAlreadyEnabled = errors.New("totp already enabled")
+
+	// ErrTOTPNotEnrolled is returned when a TOTP operation requires an enrollment that does not exist.
+	ErrTOTPNotEnrolled = errors.New("totp not enrolled")
+)
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding) //nolint:gochecknoglobals
+
+// TOTPEnrollment holds the secret generated for a user during TOTP enrollment. The URI can be rendered as a QR code
+// that authenticator apps can scan.
+type TOTPEnrollment struct {
+	Secret string `json:"secret"`
+	URI    string `json:"uri"`
+}
+
+// ConfirmTOTPParams hold the params needed to confirm a TOTP enrollment.
+type ConfirmTOTPParams struct {
+	UserUUID string `json:"-"`
+	Code     string `json:"code"`
+}
+
+// DisableTOTPParams hold the params needed to disable TOTP for a user.
+type DisableTOTPParams struct {
+	UserUUID string `json:"-"`
+	Code     string `json:"code"`
+}
+
+// VerifyTwoFactorParams hold the params needed to complete a two-factor challenge. Code can either be a TOTP code
+// or one of the user's backup codes.
+type VerifyTwoFactorParams struct {
+	ChallengeUUID  string `json:"challenge_uuid"`
+	ChallengeToken string `json:"challenge_token"`
+	Code           string `json:"code"`
+}
+
+// TwoFactorChallengeResult is returned in SessionResult when the user has passed the first factor, but needs to
+// provide a TOTP or backup code to complete the login.
+type TwoFactorChallengeResult struct {
+	Challenge           *TwoFactorChallenge `json:"challenge"`
+	PlainChallengeToken string              `json:"plain_challenge_token"`
+}
+
+// TOTPCode generates the RFC 6238 code for the given base32-encoded secret at the given time.
+func TOTPCode(secret string, at time.Time) (string, error) {
+	key, err := totpEncoding.DecodeString(strings.ToUpper(secret))
+	if err != nil {
+		return "", cerrors.New(err, "failed to decode totp secret", nil)
+	}
+
+	return totpCodeForStep(key, totpStep(at)), nil
+}
+
+func totpStep(at time.Time) int64 {
+	return at.Unix() / int64(totpPeriod/time.Second)
+}
+
+func totpCodeForStep(key []byte, step int64) string {
+	var msg [8]byte
+
+	binary.BigEndian.PutUint64(msg[:], uint64(step))
+
+	mac := hmac.New(sha1.New, key)
+	_, _ = mac.Write(msg[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	code := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	return fmt.Sprintf("%0*d", totpDigits, code%uint32(math.Pow10(totpDigits)))
+}
+
+// validateTOTPCode checks the code against the secret allowing for a small clock skew. It returns the matched
+// step so callers can reject replays of the same code.
+func validateTOTPCode(secret, code string, at time.Time, lastUsedStep *int64) (int64, bool) {
+	key, err := totpEncoding.DecodeString(strings.ToUpper(secret))
+	if err != nil {
+		return 0, false
+	}
+
+	code = strings.TrimSpace(code)
+	current := totpStep(at)
+
+	for step := current - totpSkewSteps; step <= current+totpSkewSteps; step++ {
+		if lastUsedStep != nil && step <= *lastUsedStep {
+			continue
+		}
+
+		if subtle.ConstantTimeCompare([]byte(totpCodeForStep(key, step)), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+
+	return 0, false
+}
+
+func normalizeBackupCode(code string) string {
+	return strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+}
+
+func hashBackupCode(code string) []byte {
+	sum := sha256.Sum256([]byte(normalizeBackupCode(code)))
+	return sum[:]
+}
+
+// EnrollTOTP generates a new TOTP secret for the user. The secret is not enforced during login until it is
+// confirmed with ConfirmTOTP.
+func (s *Svc) EnrollTOTP(ctx context.Context, userUUID string) (*TOTPEnrollment, error) {
+	user, err := s.queries.GetUserByUUID(ctx, userUUID)
+	if err != nil {
+		return nil, cerrors.New(err, "failed to get user by uuid", map[string]interface{}{
+			"userUUID": userUUID,
+		})
+	}
+
+	if user.HasTOTPEnabled() {
+		return nil, ErrTOTPAlreadyEnabled
+	}
+
+	key := make([]byte, totpSecretLen)
+
+	_, err = rand.Read(key)
+	if err != nil {
+		return nil, cerrors.New(err, "failed to generate totp secret", nil)
+	}
+
+	secret := totpEncoding.EncodeToString(key)
+
+	user.UpdatedAt = time.Now()
+	user.TOTPSecret = &secret
+	user.TOTPEnabledAt = nil
+	user.TOTPLastUsedStep = nil
+
+	err = s.queries.UpdateUser(ctx, user)
+	if err != nil {
+		return nil, cerrors.New(err, "failed to update user", map[string]interface{}{
+			"userUUID": user.UUID,
+		})
+	}
+
+	return &TOTPEnrollment{
+		Secret: secret,
+		URI:    s.totpURI(user, secret),
+	}, nil
+}
+
+func (s *Svc) totpURI(user *User, secret string) string {
+	label := s.config.TOTPIssuer + ":" + user.accountName()
+
+	q := url.Values{}
+	q.Set("secret", secret)
+	q.Set("issuer", s.config.TOTPIssuer)
+	q.Set("algorithm", "SHA1")
+	q.Set("digits", fmt.Sprint(totpDigits))
+	q.Set("period", fmt.Sprint(int(totpPeriod/time.Second)))
+
+	return (&url.URL{
+		Scheme:   "otpauth",
+		Host:     "totp",
+		Path:     "/" + label,
+		RawQuery: q.Encode(),
+	}).String()
+}
+
+// ConfirmTOTP verifies the first code from the user's authenticator app and enables TOTP for them. It returns a
+// fresh set of plain backup codes that should be shown to the user exactly once.
+func (s *Svc) ConfirmTOTP(ctx context.Context, p ConfirmTOTPParams) ([]string, error) {
+	user, err := s.queries.GetUserByUUID(ctx, p.UserUUID)
+	if err != nil {
+		return nil, cerrors.New(err, "failed to get user by uuid", map[string]interface{}{
+			"userUUID": p.UserUUID,
+		})
+	}
+
+	if user.HasTOTPEnabled() {
+		return nil, ErrTOTPAlreadyEnabled
+	} else if user.TOTPSecret == nil {
+		return nil, ErrTOTPNotEnrolled
+	}
+
+	accountKey := attemptKeyTwoFactor(user.UUID)
+
+	err = s.checkAttempts(ctx, accountKey)
+	if err != nil {
+		return nil, err
+	}
+
+	step, ok := validateTOTPCode(*user.TOTPSecret, p.Code, time.Now(), nil)
+	if !ok {
+		return nil, s.recordFailedAttempt(ctx, ErrInvalidCredentials, accountKey)
+	}
+
+	err = s.resetAttempts(ctx, accountKey)
+	if err != nil {
+		return nil, err
+	}
+
+	// The step is recorded with a conditional update so that two concurrent requests with the same code cannot both
+	// pass.
+	used, err := s.queries.MarkTOTPStepUsed(ctx, user.UUID, step, time.Now())
+	if err != nil {
+		return nil, cerrors.New(err, "failed to mark totp step as used", map[string]interface{}{
+			"userUUID": user.UUID,
+		})
+	}
+
+	if !used {
+		return nil, ErrInvalidCredentials
+	}
+
+	user.UpdatedAt = time.Now()
+	user.TOTPEnabledAt = &user.UpdatedAt
+	user.TOTPLastUsedStep = &step
+
+	err = s.queries.UpdateUser(ctx, user)
+	if err != nil {
+		return nil, cerrors.New(err, "failed to update user", map[string]interface{}{
+			"userUUID": user.UUID,
+		})
+	}
+
+	err = s.recordAuditEvent(ctx, &AuditEvent{
+		Type:     AuditEventTOTPEnabled,
+		UserUUID: user.UUID,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return s.regenerateBackupCodes(ctx, user.UUID)
+}
+
+// RegenerateBackupCodes invalidates all existing backup codes for the user and returns a new set of plain codes.
+func (s *Svc) RegenerateBackupCodes(ctx context.Context, userUUID string) ([]string, error) {
+	codes, err := s.regenerateBackupCodes(ctx, userUUID)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.recordAuditEvent(ctx, &AuditEvent{
+		Type:     AuditEventBackupCodesRegenerated,
+		UserUUID: userUUID,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return codes, nil
+}
+
+func (s *Svc) regenerateBackupCodes(ctx context.Context, userUUID string) ([]string, error) {
+	err := s.queries.DeleteBackupCodesByUserUUID(ctx, userUUID)
+	if err != nil {
+		return nil, cerrors.New(err, "failed to delete backup codes", map[string]interface{}{
+			"userUUID": userUUID,
+		})
+	}
+
+	codes := make([]string, backupCodesNum)
+
+	for i := range codes {
+		plain := strings.ToLower(crandom.GenerateRandomString(backupCodesLen))
+		codes[i] = plain[:backupCodesLen/2] + "-" + plain[backupCodesLen/2:]
+
+		err = s.queries.InsertBackupCode(ctx, &BackupCode{
+			UUID:      uuid.New().String(),
+			CreatedAt: time.Now(),
+			UserUUID:  userUUID,
+			Code:      hashBackupCode(plain),
+		})
+		if err != nil {
+			return nil, cerrors.New(err, "failed to insert backup code", map[string]interface{}{
+				"userUUID": userUUID,
+			})
+		}
+	}
+
+	return codes, nil
+}
+
+// DisableTOTP turns off TOTP for the user after verifying a current TOTP or backup code. All backup codes are
+// deleted as well.
+func (s *Svc) DisableTOTP(ctx context.Context, p DisableTOTPParams) error {
+	user, err := s.queries.GetUserByUUID(ctx, p.UserUUID)
+	if err != nil {
+		return cerrors.New(err, "failed to get user by uuid", map[string]interface{}{
+			"userUUID": p.UserUUID,
+		})
+	}
+
+	if !user.HasTOTPEnabled() {
+		return ErrTOTPNotEnrolled
+	}
+
+	accountKey := attemptKeyTwoFactor(user.UUID)
+
+	err = s.checkAttempts(ctx, accountKey)
+	if err != nil {
+		return err
+	}
+
+	ok, err := s.verifySecondFactor(ctx, user, p.Code)
+	if err != nil {
+		return cerrors.New(err, "failed to verify second factor", map[string]interface{}{
+			"userUUID": user.UUID,
+		})
+	}
+
+	if !ok {
+		return s.recordFailedAttempt(ctx, ErrInvalidCredentials, accountKey)
+	}
+
+	err = s.resetAttempts(ctx, accountKey)
+	if err != nil {
+		return err
+	}
+
+	user.UpdatedAt = time.Now()
+	user.TOTPSecret = nil
+	user.TOTPEnabledAt = nil
+	user.TOTPLastUsedStep = nil
+
+	err = s.queries.UpdateUser(ctx, user)
+	if err != nil {
+		return cerrors.New(err, "failed to update user", map[string]interface{}{
+			"userUUID": user.UUID,
+		})
+	}
+
+	err = s.queries.DeleteBackupCodesByUserUUID(ctx, user.UUID)
+	if err != nil {
+		return cerrors.New(err, "failed to delete backup codes", map[string]interface{}{
+			"userUUID": user.UUID,
+		})
+	}
+
+	return s.recordAuditEvent(ctx, &AuditEvent{
+		Type:     AuditEventTOTPDisabled,
+		UserUUID: user.UUID,
+	})
+}
+
+// VerifyTwoFactor completes a pending two-factor challenge created during login. If the code is valid, the
+// challenge is consumed and a new session is created.
+func (s *Svc) VerifyTwoFactor(ctx context.Context, p VerifyTwoFactorParams) (*SessionResult, error) {
+	challenge, err := s.queries.GetTwoFactorChallenge(ctx, p.ChallengeUUID)
+	if err != nil && errors.Is(err, ErrNotFound) {
+		return nil, ErrInvalidCredentials
+	} else if err != nil {
+		return nil, cerrors.New(err, "failed to get two factor challenge", map[string]interface{}{
+			"challengeUUID": p.ChallengeUUID,
+		})
+	}
+
+	tokenHash := sha256.Sum256([]byte(p.ChallengeToken))
+	if subtle.ConstantTimeCompare(challenge.Token, tokenHash[:]) != 1 {
+		return nil, ErrInvalidCredentials
+	}
+
+	if time.Now().After(challenge.ExpiresAt) {
+		return nil, ErrVerificationCodeExpired
+	}
+
+	user, err := s.queries.GetUserByUUID(ctx, challenge.UserUUID)
+	if err != nil {
+		return nil, cerrors.New(err, "failed to get user by uuid", map[string]interface{}{
+			"userUUID": challenge.UserUUID,
+		})
+	}
+
+	accountKey := attemptKeyTwoFactor(user.UUID)
+
+	err = s.checkAttempts(ctx, accountKey)
+	if err != nil {
+		return nil, s.recordLoginFailure(ctx, err, user.UUID, loginMethodTwoFactor, "")
+	}
+
+	ok, err := s.verifySecondFactor(ctx, user, p.Code)
+	if err != nil {
+		return nil, cerrors.New(err, "failed to verify second factor", map[string]interface{}{
+			"userUUID": user.UUID,
+		})
+	}
+
+	if !ok {
+		err = s.recordFailedAttempt(ctx, ErrInvalidCredentials, accountKey)
+		return nil, s.recordLoginFailure(ctx, err, user.UUID, loginMethodTwoFactor, "")
+	}
+
+	err = s.resetAttempts(ctx, accountKey)
+	if err != nil {
+		return nil, err
+	}
+
+	// The challenge is consumed with a conditional update so that two concurrent requests with different codes cannot
+	// both create a session.
+	consumed, err := s.queries.ConsumeTwoFactorChallenge(ctx, challenge.UUID, time.Now())
+	if err != nil {
+		return nil, cerrors.New(err, "failed to consume two factor challenge", map[string]interface{}{
+			"challengeUUID": challenge.UUID,
+		})
+	}
+
+	if !consumed {
+		return nil, ErrInvalidCredentials
+	}
+
+	sessionResult, err := s.createSessionResult(ctx, user, challenge.RememberMe)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.recordLogin(ctx, loginMethodTwoFactor, sessionResult)
+	if err != nil {
+		return nil, err
+	}
+
+	return sessionResult, nil
+}
+
+// verifySecondFactor checks the code as a TOTP code first, and then as a backup code. Successful TOTP codes are
+// recorded so that they cannot be replayed, and successful backup codes are marked as used.
+func (s *Svc) verifySecondFactor(ctx context.Context, user *User, code string) (bool, error) {
+	step, ok := validateTOTPCode(*user.TOTPSecret, code, time.Now(), user.TOTPLastUsedStep)
+	if ok {
+		// The step is recorded with a conditional update so that two concurrent requests with the same code cannot
+		// both pass.
+		used, err := s.queries.MarkTOTPStepUsed(ctx, user.UUID, step, time.Now())
+		if err != nil {
+			return false, cerrors.New(err, "failed to mark totp step as used", map[string]interface{}{
+				"userUUID": user.UUID,
+			})
+		}
+
+		if used {
+			user.TOTPLastUsedStep = &step
+		}
+
+		return used, nil
+	}
+
+	backupCode, err := s.queries.GetUnusedBackupCode(ctx, user.UUID, hashBackupCode(code))
+	if err != nil && errors.Is(err, ErrNotFound) {
+		return false, nil
+	} else if err != nil {
+		return false, cerrors.New(err, "failed to get backup code", map[string]interface{}{
+			"userUUID": user.UUID,
+		})
+	}
+
+	// The code is marked as used with a conditional update so that two concurrent requests with the same backup code
+	// cannot both pass.
+	used, err := s.queries.MarkBackupCodeUsed(ctx, backupCode.UUID, time.Now())
+	if err != nil {
+		return false, cerrors.New(err, "failed to mark backup code as used", map[string]interface{}{
+			"backupCodeUUID": backupCode.UUID,
+		})
+	}
+
+	return used, nil
+}
+
+// createTwoFactorChallenge creates a challenge that the user must complete with VerifyTwoFactor before a session is
+// created for them.
+func (s *Svc) createTwoFactorChallenge(ctx context.Context, user *User, rememberMe bool) (*SessionResult, error) {
+	plainToken := crandom.GenerateRandomString(twoFactorChallengeTokenLen)
+	tokenHash := sha256.Sum256([]byte(plainToken))
+
+	challenge := &TwoFactorChallenge{
+		UUID:       uuid.New().String(),
+		CreatedAt:  time.Now(),
+		UpdatedAt:  time.Now(),
+		UserUUID:   user.UUID,
+		Token:      tokenHash[:],
+		ExpiresAt:  time.Now().Add(twoFactorChallengeTTL),
+		RememberMe: rememberMe,
+	}
+
+	err := s.queries.InsertTwoFactorChallenge(ctx, challenge)
+	if err != nil {
+		return nil, cerrors.New(err, "failed to insert two factor challenge", map[string]interface{}{
+			"userUUID": user.UUID,
+		})
+	}
+
+	return &SessionResult{
+		User: user,
+		TwoFactorChallenge: &TwoFactorChallengeResult{
+			Challenge:           challenge,
+			PlainChallengeToken: plainToken,
+		},
+	}, nil
+}
@@ -0,0 +1,38 @@
+package cauth_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gocopper/pkg/cauth"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTOTPCode(t *testing.T) {
+	t.Parallel()
+
+	// Test vectors from RFC 6238 Appendix B, truncated to 6 digits
+	const secret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+	tests := map[int64]string{
+		59:          "287082",
+		1111111109:  "081804",
+		1111111111:  "050471",
+		1234567890:  "005924",
+		2000000000:  "279037",
+		20000000000: "353130",
+	}
+
+	for unix, want := range tests {
+		code, err := cauth.TOTPCode(secret, time.Unix(unix, 0))
+		assert.NoError(t, err)
+		assert.Equal(t, want, code)
+	}
+}
+
+func TestTOTPCode_InvalidSecret(t *testing.T) {
+	t.Parallel()
+
+	_, err := cauth.TOTPCode("not base32!", time.Now())
+	assert.Error(t, err)
+}
@@ -0,0 +1,346 @@
+package cauth
+
+import (
+	"context"
+	"time"
+
+	"github.com/gocopper/copper/cerrors"
+)
+
+const (
+	purgeDeletedUsersBatchSize = 100
+	exportAuditEventsPageSize  = 1000
+)
+
+// UserDataHook lets application packages include their own data in user data exports and delete it along with
+// users. Hooks are registered with Svc.RegisterUserDataHook.
+type UserDataHook interface {
+	// Name is the key under which the hook's data is included in the export's "apps" object.
+	Name() string
+
+	// ExportUserData returns the data that the application stores about the given user. It must be serializable
+	// to JSON.
+	ExportUserData(ctx context.Context, userUUID string) (interface{}, error)
+
+	// DeleteUserData permanently deletes the data that the application stores about the given user. It is called
+	// before cauth deletes its own rows, so the user can still be looked up.
+	DeleteUserData(ctx context.Context, userUUID string) error
+}
+
+// DeleteUserParams hold the params needed to delete a user. Users that have a password must confirm the deletion
+// with it. Wrong passwords count as failed attempts, like failed logins.
+type DeleteUserParams struct {
+	UserUUID string  `json:"-"`
+	Password *string `json:"password"`
+}
+
+// ExportedUser holds the profile of a user in a UserDataExport.
+type ExportedUser struct {
+	UUID            string     `json:"uuid"`
+	CreatedAt       time.Time  `json:"created_at"`
+	Email           string     `json:"email"`
+	EmailVerifiedAt *time.Time `json:"email_verified_at"`
+	Username        string     `json:"username"`
+	Phone           string     `json:"phone"`
+	PhoneVerifiedAt *time.Time `json:"phone_verified_at"`
+	TOTPEnabledAt   *time.Time `json:"totp_enabled_at"`
+	DeletedAt       *time.Time `json:"deleted_at"`
+}
+
+// UserDataExport holds everything that cauth stores about a user, except for secrets such as password hashes, along
+// with the data added by UserDataHooks.
+type UserDataExport struct {
+	ExportedAt time.Time `json:"exported_at"`
+
+	User                ExportedUser         `json:"user"`
+	UsernameHistory     []UsernameHistory    `json:"username_history"`
+	Sessions            []Session            `json:"sessions"`
+	Identities          []Identity           `json:"identities"`
+	WebAuthnCredentials []WebAuthnCredential `json:"webauthn_credentials"`
+	APIKeys             []APIKey             `json:"api_keys"`
+	Roles               []Role               `json:"roles"`
+	Organizations       []UserOrganization   `json:"organizations"`
+	InvitationsSent     []Invitation         `json:"invitations_sent"`
+	AuditEvents         []AuditEvent         `json:"audit_events"`
+
+	Apps map[string]interface{} `json:"apps"`
+}
+
+// RegisterUserDataHook adds a hook whose data is included in user data exports and deleted along with users. Hooks
+// should be registered when the application starts, before any users are exported or deleted.
+func (s *Svc) RegisterUserDataHook(hook UserDataHook) {
+	s.userDataHooksMu.Lock()
+	defer s.userDataHooksMu.Unlock()
+
+	s.userDataHooks = append(s.userDataHooks, hook)
+}
+
+func (s *Svc) getUserDataHooks() []UserDataHook {
+	s.userDataHooksMu.RLock()
+	defer s.userDataHooksMu.RUnlock()
+
+	return append([]UserDataHook(nil), s.userDataHooks...)
+}
+
+// DeleteUser deletes the given user. Their sessions are revoked and they can't login anymore, but their data is
+// kept for Config.AccountDeletionGracePeriod so that the deletion can be undone with RestoreUser. Once the grace
+// period has passed, PurgeDeletedUsers deletes the user permanently. If the grace period is zero, the user is
+// deleted permanently right away.
+func (s *Svc) DeleteUser(ctx context.Context, p DeleteUserParams) error {
+	user, err := s.queries.GetUserByUUID(ctx, p.UserUUID)
+	if err != nil {
+		return cerrors.New(err, "failed to get user by uuid", map[string]interface{}{
+			"userUUID": p.UserUUID,
+		})
+	}
+
+	if user.Password != nil {
+		accountKey := attemptKeyPassword(user.UUID)
+
+		err = s.checkAttempts(ctx, accountKey)
+		if err != nil {
+			return err
+		}
+
+		if p.Password == nil || !s.hasher.Verify(user.Password, *p.Password) {
+			return s.recordFailedAttempt(ctx, ErrInvalidCredentials, accountKey)
+		}
+
+		err = s.resetAttempts(ctx, accountKey)
+		if err != nil {
+			return err
+		}
+	}
+
+	if s.config.AccountDeletionGracePeriod <= 0 {
+		return s.purgeUser(ctx, user.UUID)
+	}
+
+	if user.DeletedAt != nil {
+		return nil
+	}
+
+	now := time.Now()
+
+	err = s.queries.SetUserDeletedAt(ctx, user.UUID, &now)
+	if err != nil {
+		return cerrors.New(err, "failed to set user deleted at", map[string]interface{}{
+			"userUUID": user.UUID,
+		})
+	}
+
+	err = s.queries.DeleteEmailChange(ctx, user.UUID)
+	if err != nil {
+		return cerrors.New(err, "failed to delete email change", map[string]interface{}{
+			"userUUID": user.UUID,
+		})
+	}
+
+	err = s.revokeAllSessions(ctx, user.UUID)
+	if err != nil {
+		return err
+	}
+
+	return s.recordAuditEvent(ctx, &AuditEvent{
+		Type:     AuditEventUserDeleted,
+		UserUUID: user.UUID,
+		Metadata: AuditMetadata{
+			"purge_after": now.Add(s.config.AccountDeletionGracePeriod).UTC().Format(time.RFC3339),
+		},
+	})
+}
+
+// RestoreUser undoes the deletion of a user whose grace period has not passed yet. The user can login again, but
+// their revoked sessions are not restored.
+func (s *Svc) RestoreUser(ctx context.Context, userUUID string) error {
+	user, err := s.queries.GetUserByUUID(ctx, userUUID)
+	if err != nil {
+		return cerrors.New(err, "failed to get user by uuid", map[string]interface{}{
+			"userUUID": userUUID,
+		})
+	}
+
+	if user.DeletedAt == nil {
+		return nil
+	}
+
+	err = s.queries.SetUserDeletedAt(ctx, user.UUID, nil)
+	if err != nil {
+		return cerrors.New(err, "failed to set user deleted at", map[string]interface{}{
+			"userUUID": user.UUID,
+		})
+	}
+
+	return s.recordAuditEvent(ctx, &AuditEvent{
+		Type:     AuditEventUserRestored,
+		UserUUID: user.UUID,
+	})
+}
+
+// PurgeDeletedUsers permanently deletes the users whose deletion grace period has passed, along with their data
+// and the data added by UserDataHooks. It returns the number of users that were deleted.
+func (s *Svc) PurgeDeletedUsers(ctx context.Context) (int, error) {
+	var purged int
+
+	for {
+		userUUIDs, err := s.queries.ListUserUUIDsDeletedBefore(ctx,
+			time.Now().Add(-s.config.AccountDeletionGracePeriod), purgeDeletedUsersBatchSize)
+		if err != nil {
+			return purged, cerrors.New(err, "failed to list deleted users", nil)
+		}
+
+		for _, userUUID := range userUUIDs {
+			err = s.purgeUser(ctx, userUUID)
+			if err != nil {
+				return purged, err
+			}
+
+			purged++
+		}
+
+		if len(userUUIDs) < purgeDeletedUsersBatchSize {
+			return purged, nil
+		}
+	}
+}
+
+func (s *Svc) purgeUser(ctx context.Context, userUUID string) error {
+	for _, hook := range s.getUserDataHooks() {
+		err := hook.DeleteUserData(ctx, userUUID)
+		if err != nil {
+			return cerrors.New(err, "failed to delete user data", map[string]interface{}{
+				"userUUID": userUUID,
+				"hook":     hook.Name(),
+			})
+		}
+	}
+
+	err := s.queries.DeleteUser(ctx, userUUID)
+	if err != nil {
+		return cerrors.New(err, "failed to delete user", map[string]interface{}{
+			"userUUID": userUUID,
+		})
+	}
+
+	s.sessionCache.deleteUser(userUUID)
+
+	// The user's other audit events are deleted along with them, so this event only records that the user existed.
+	return s.recordAuditEvent(ctx, &AuditEvent{
+		Type:     AuditEventUserPurged,
+		UserUUID: userUUID,
+	})
+}
+
+// ExportUserData returns everything that cauth and the registered UserDataHooks store about the given user.
+func (s *Svc) ExportUserData(ctx context.Context, userUUID string) (*UserDataExport, error) {
+	var export UserDataExport
+
+	user, err := s.queries.GetUserByUUID(ctx, userUUID)
+	if err != nil {
+		return nil, cerrors.New(err, "failed to get user by uuid", map[string]interface{}{
+			"userUUID": userUUID,
+		})
+	}
+
+	export.ExportedAt = time.Now()
+	export.User = ExportedUser{
+		UUID:            user.UUID,
+		CreatedAt:       user.CreatedAt,
+		Email:           user.Email,
+		EmailVerifiedAt: user.EmailVerifiedAt,
+		Username:        user.Username,
+		Phone:           user.Phone,
+		PhoneVerifiedAt: user.PhoneVerifiedAt,
+		TOTPEnabledAt:   user.TOTPEnabledAt,
+		DeletedAt:       user.DeletedAt,
+	}
+
+	export.UsernameHistory, err = s.queries.ListUsernameHistoryByUserUUID(ctx, user.UUID)
+	if err != nil {
+		return nil, cerrors.New(err, "failed to list username history", map[string]interface{}{
+			"userUUID": user.UUID,
+		})
+	}
+
+	export.Sessions, err = s.queries.ListActiveSessionsByUserUUID(ctx, user.UUID, time.Now())
+	if err != nil {
+		return nil, cerrors.New(err, "failed to list active sessions", map[string]interface{}{
+			"userUUID": user.UUID,
+		})
+	}
+
+	export.Identities, err = s.queries.ListIdentitiesByUserUUID(ctx, user.UUID)
+	if err != nil {
+		return nil, cerrors.New(err, "failed to list identities", map[string]interface{}{
+			"userUUID": user.UUID,
+		})
+	}
+
+	export.WebAuthnCredentials, err = s.queries.ListWebAuthnCredentialsByUserUUID(ctx, user.UUID)
+	if err != nil {
+		return nil, cerrors.New(err, "failed to list webauthn credentials", map[string]interface{}{
+			"userUUID": user.UUID,
+		})
+	}
+
+	export.APIKeys, err = s.queries.ListAPIKeysByUserUUID(ctx, user.UUID)
+	if err != nil {
+		return nil, cerrors.New(err, "failed to list api keys", map[string]interface{}{
+			"userUUID": user.UUID,
+		})
+	}
+
+	export.Roles, err = s.queries.ListRolesByUserUUID(ctx, user.UUID)
+	if err != nil {
+		return nil, cerrors.New(err, "failed to list roles", map[string]interface{}{
+			"userUUID": user.UUID,
+		})
+	}
+
+	export.Organizations, err = s.queries.ListOrganizationsByUserUUID(ctx, user.UUID)
+	if err != nil {
+		return nil, cerrors.New(err, "failed to list organizations", map[string]interface{}{
+			"userUUID": user.UUID,
+		})
+	}
+
+	export.InvitationsSent, err = s.queries.ListInvitationsByInviterUUID(ctx, user.UUID)
+	if err != nil {
+		return nil, cerrors.New(err, "failed to list invitations", map[string]interface{}{
+			"userUUID": user.UUID,
+		})
+	}
+
+	export.AuditEvents = make([]AuditEvent, 0)
+
+	for offset := 0; ; offset += exportAuditEventsPageSize {
+		events, err := s.queries.ListAuditEvents(ctx, user.UUID, "", exportAuditEventsPageSize, offset)
+		if err != nil {
+			return nil, cerrors.New(err, "failed to list audit events", map[string]interface{}{
+				"userUUID": user.UUID,
+			})
+		}
+
+		export.AuditEvents = append(export.AuditEvents, events...)
+
+		if len(events) < exportAuditEventsPageSize {
+			break
+		}
+	}
+
+	export.Apps = make(map[string]interface{})
+
+	for _, hook := range s.getUserDataHooks() {
+		data, err := hook.ExportUserData(ctx, user.UUID)
+		if err != nil {
+			return nil, cerrors.New(err, "failed to export user data", map[string]interface{}{
+				"userUUID": user.UUID,
+				"hook":     hook.Name(),
+			})
+		}
+
+		export.Apps[hook.Name()] = data
+	}
+
+	return &export, nil
+}
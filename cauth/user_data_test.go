@@ -0,0 +1,141 @@
+package cauth_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"testing"
+
+	"github.com/gocopper/pkg/cauth"
+	"github.com/gocopper/pkg/cauth/cauthtest"
+	"github.com/stretchr/testify/assert"
+)
+
+type testUserDataHook struct {
+	deleted []string
+}
+
+func (h *testUserDataHook) Name() string {
+	return "test"
+}
+
+func (h *testUserDataHook) ExportUserData(_ context.Context, userUUID string) (interface{}, error) {
+	return map[string]string{"favorite_color": "blue", "user_uuid": userUUID}, nil
+}
+
+func (h *testUserDataHook) DeleteUserData(_ context.Context, userUUID string) error {
+	h.deleted = append(h.deleted, userUUID)
+
+	return nil
+}
+
+func TestRouter_DeleteAndExportAccount(t *testing.T) {
+	t.Parallel()
+
+	var (
+		ctx     = context.Background()
+		dbPath  = path.Join(t.TempDir(), "cauth.db")
+		hook    = &testUserDataHook{}
+		session cauth.SessionResult
+		export  struct {
+			User struct {
+				UUID  string `json:"uuid"`
+				Email string `json:"email"`
+			} `json:"user"`
+			Sessions    []json.RawMessage            `json:"sessions"`
+			AuditEvents []cauth.AuditEvent           `json:"audit_events"`
+			Apps        map[string]map[string]string `json:"apps"`
+		}
+	)
+
+	handler, svc := cauthtest.NewHandlerAndSvc(t, cauthtest.HandlerParams{DBPath: dbPath})
+	svc.RegisterUserDataHook(hook)
+
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	getExport := func(session *cauth.SessionResult) *http.Response {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, server.URL+"/api/auth/account/export", nil)
+		assert.NoError(t, err)
+
+		req.SetBasicAuth(session.Session.UUID, session.PlainSessionToken)
+
+		resp, err := http.DefaultClient.Do(req)
+		assert.NoError(t, err)
+		t.Cleanup(func() { _ = resp.Body.Close() })
+
+		return resp
+	}
+
+	login := func() *http.Response {
+		return postJSON(t, server.URL+"/api/auth/login", `{"email": "delete@example.com", "password": "test-pass"}`, nil)
+	}
+
+	resp := postJSON(t, server.URL+"/api/auth/signup", `{"email": "delete@example.com", "password": "test-pass"}`, nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&session))
+
+	resp = getExport(&session)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Contains(t, resp.Header.Get("Content-Disposition"), "attachment")
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&export))
+	assert.Equal(t, session.User.UUID, export.User.UUID)
+	assert.Equal(t, "delete@example.com", export.User.Email)
+	assert.Len(t, export.Sessions, 1)
+	assert.NotEmpty(t, export.AuditEvents)
+	assert.Equal(t, map[string]string{"favorite_color": "blue", "user_uuid": session.User.UUID}, export.Apps["test"])
+
+	resp = postJSON(t, server.URL+"/api/auth/account/delete", `{"password": "wrong-pass"}`, &session)
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	resp = postJSON(t, server.URL+"/api/auth/account/delete", `{"password": "test-pass"}`, &session)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	// Deleted users are logged out and can't login during the grace period
+	assert.Equal(t, http.StatusUnauthorized, getExport(&session).StatusCode)
+	assert.Equal(t, http.StatusUnauthorized, login().StatusCode)
+
+	purged, err := svc.PurgeDeletedUsers(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, purged)
+
+	assert.NoError(t, svc.RestoreUser(ctx, session.User.UUID))
+
+	resp = login()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&session))
+
+	resp = postJSON(t, server.URL+"/api/auth/account/delete", `{"password": "test-pass"}`, &session)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	// Without a grace period, deleted users are purged right away
+	_, noGracePeriodSvc := cauthtest.NewHandlerAndSvc(t, cauthtest.HandlerParams{
+		DBPath: dbPath,
+		Config: `
+[cauth]
+account_deletion_grace_period = "0s"
+`,
+	})
+	noGracePeriodSvc.RegisterUserDataHook(hook)
+
+	purged, err = noGracePeriodSvc.PurgeDeletedUsers(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, purged)
+	assert.Equal(t, []string{session.User.UUID}, hook.deleted)
+
+	_, err = svc.GetUserByUUID(ctx, session.User.UUID)
+	assert.ErrorIs(t, err, cauth.ErrNotFound)
+
+	events, err := svc.ListAuditEvents(ctx, cauth.ListAuditEventsParams{UserUUID: session.User.UUID})
+	assert.NoError(t, err)
+
+	if assert.Len(t, events, 1) {
+		assert.Equal(t, cauth.AuditEventUserPurged, events[0].Type)
+	}
+
+	// The email can be used again once the user is purged
+	resp = postJSON(t, server.URL+"/api/auth/signup", `{"email": "delete@example.com", "password": "test-pass"}`, nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+}
@@ -0,0 +1,230 @@
+package cauth
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/gocopper/copper/cerrors"
+	"github.com/google/uuid"
+)
+
+const (
+	usernameMinLen = 3
+	usernameMaxLen = 32
+)
+
+var (
+	// ErrInvalidUsername is returned when a username is too short, too long or contains characters other than
+	// letters, digits, underscores, dashes and dots.
+	ErrInvalidUsername = errors.New("invalid username")
+
+	// ErrUsernameUnavailable is returned when a username is reserved, taken by another user or was recently used
+	// by another user.
+	ErrUsernameUnavailable = errors.New("username unavailable")
+
+	// ErrPasswordRequired is returned when a user signs up with only a username and no password.
+	ErrPasswordRequired = errors.New("password required")
+)
+
+// ChangeUsernameParams hold the params needed to change a user's username.
+type ChangeUsernameParams struct {
+	UserUUID string `json:"-"`
+	Username string `json:"username"`
+}
+
+// NormalizeUsername trims and lowercases the given username and checks that it is valid. Usernames must be
+// between 3 and 32 characters long, start with a letter or a digit, and only contain letters, digits, underscores,
+// dashes and dots.
+func NormalizeUsername(username string) (string, error) {
+	username = strings.ToLower(strings.TrimSpace(username))
+
+	if len(username) < usernameMinLen || len(username) > usernameMaxLen {
+		return "", ErrInvalidUsername
+	}
+
+	for i, r := range username {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9':
+		case i > 0 && (r == '_' || r == '-' || r == '.'):
+		default:
+			return "", ErrInvalidUsername
+		}
+	}
+
+	return username, nil
+}
+
+// ChangeUsername sets a new username for the given user. The user's previous username is recorded so that it
+// cannot be claimed by another user until Config.UsernameReuseDelayDays have passed. Users can always reclaim
+// their own previous usernames.
+func (s *Svc) ChangeUsername(ctx context.Context, p ChangeUsernameParams) (*User, error) {
+	username, err := NormalizeUsername(p.Username)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := s.queries.GetUserByUUID(ctx, p.UserUUID)
+	if err != nil {
+		return nil, cerrors.New(err, "failed to get user by uuid", map[string]interface{}{
+			"userUUID": p.UserUUID,
+		})
+	}
+
+	if user.Username == username {
+		return user, nil
+	}
+
+	err = s.checkUsernameAvailable(ctx, user.UUID, username)
+	if err != nil {
+		return nil, err
+	}
+
+	if user.Username != "" {
+		err = s.queries.InsertUsernameHistory(ctx, &UsernameHistory{
+			UUID:      uuid.New().String(),
+			CreatedAt: time.Now(),
+			UserUUID:  user.UUID,
+			Username:  user.Username,
+		})
+		if err != nil {
+			return nil, cerrors.New(err, "failed to insert username history", map[string]interface{}{
+				"userUUID": user.UUID,
+			})
+		}
+	}
+
+	oldUsername := user.Username
+
+	user.UpdatedAt = time.Now()
+	user.Username = username
+
+	err = s.queries.UpdateUser(ctx, user)
+	if err != nil {
+		return nil, cerrors.New(err, "failed to update user", map[string]interface{}{
+			"userUUID": user.UUID,
+		})
+	}
+
+	err = s.recordAuditEvent(ctx, &AuditEvent{
+		Type:     AuditEventUsernameChanged,
+		UserUUID: user.UUID,
+		Metadata: AuditMetadata{
+			"old_username": oldUsername,
+			"new_username": username,
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+// checkUsernameAvailable returns ErrUsernameUnavailable if the normalized username is reserved, belongs to a user
+// other than userUUID or was given up by another user within the reuse delay.
+func (s *Svc) checkUsernameAvailable(ctx context.Context, userUUID, username string) error {
+	for _, reserved := range s.config.ReservedUsernames {
+		if strings.EqualFold(reserved, username) {
+			return ErrUsernameUnavailable
+		}
+	}
+
+	existingUser, err := s.queries.GetUserByUsername(ctx, username)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return cerrors.New(err, "failed to get user by username", map[string]interface{}{
+			"username": username,
+		})
+	} else if err == nil && existingUser.UUID != userUUID {
+		return ErrUsernameUnavailable
+	}
+
+	since := time.Now().AddDate(0, 0, -s.config.UsernameReuseDelayDays)
+
+	history, err := s.queries.ListUsernameHistorySince(ctx, username, since)
+	if err != nil {
+		return cerrors.New(err, "failed to list username history", map[string]interface{}{
+			"username": username,
+		})
+	}
+
+	for i := range history {
+		if history[i].UserUUID != userUUID {
+			return ErrUsernameUnavailable
+		}
+	}
+
+	return nil
+}
+
+func (s *Svc) signupWithUsername(ctx context.Context, username string, password *string) (*SessionResult, error) {
+	if password == nil {
+		// There is no way to send a verification code to a username, so a password is required
+		return nil, ErrPasswordRequired
+	}
+
+	hp, err := s.hasher.Hash(*password)
+	if err != nil {
+		return nil, cerrors.New(err, "failed to hash password", nil)
+	}
+
+	user := &User{
+		UUID:      uuid.New().String(),
+		CreatedAt: time.Now(),
+		UpdatedAt: time.Now(),
+		Username:  username,
+		Password:  hp,
+	}
+
+	err = s.queries.InsertUser(ctx, user)
+	if err != nil {
+		return nil, cerrors.New(err, "failed to insert user", nil)
+	}
+
+	sessionResult, err := s.createSessionResult(ctx, user, false)
+	if err != nil {
+		return nil, err
+	}
+
+	sessionResult.NewUser = true
+
+	return sessionResult, nil
+}
+
+func (s *Svc) loginWithUsernamePassword(ctx context.Context, username, password string, rememberMe bool) (*SessionResult, error) {
+	username, err := NormalizeUsername(username)
+	if err != nil {
+		return nil, ErrInvalidCredentials
+	}
+
+	accountKey := attemptKeyUsername(username)
+
+	err = s.checkAttempts(ctx, accountKey)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := s.queries.GetUserByUsername(ctx, username)
+	if err != nil && errors.Is(err, ErrNotFound) {
+		return nil, s.recordFailedAttempt(ctx, ErrInvalidCredentials, accountKey)
+	} else if err != nil {
+		return nil, cerrors.New(err, "failed to get user by username", map[string]interface{}{
+			"username": username,
+		})
+	}
+
+	ok, err := s.verifyPassword(ctx, user, password)
+	if err != nil {
+		return nil, err
+	} else if !ok {
+		return nil, s.recordFailedAttempt(ctx, ErrInvalidCredentials, accountKey)
+	}
+
+	err = s.resetAttempts(ctx, accountKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.loginUser(ctx, user, rememberMe)
+}
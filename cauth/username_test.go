@@ -0,0 +1,102 @@
+package cauth_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gocopper/pkg/cauth"
+	"github.com/gocopper/pkg/cauth/cauthtest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNormalizeUsername(t *testing.T) {
+	t.Parallel()
+
+	valid := map[string]string{
+		"alice":       "alice",
+		" Alice ":     "alice",
+		"bob_smith.1": "bob_smith.1",
+		"x-y":         "x-y",
+	}
+
+	for input, want := range valid {
+		got, err := cauth.NormalizeUsername(input)
+		assert.NoError(t, err, input)
+		assert.Equal(t, want, got)
+	}
+
+	invalid := []string{
+		"",
+		"ab",
+		"_alice",
+		"alice smith",
+		"alice@example.com",
+		"ålice",
+		"a-very-long-username-that-is-over-32",
+	}
+
+	for _, input := range invalid {
+		_, err := cauth.NormalizeUsername(input)
+		assert.ErrorIs(t, err, cauth.ErrInvalidUsername, input)
+	}
+}
+
+func TestRouter_Username(t *testing.T) {
+	t.Parallel()
+
+	var (
+		alice cauth.SessionResult
+		bob   cauth.SessionResult
+		user  cauth.User
+	)
+
+	server := httptest.NewServer(cauthtest.NewHandler(t))
+	defer server.Close()
+
+	resp := postJSON(t, server.URL+"/api/auth/signup", `{"username": "Alice", "password": "test-pass"}`, nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&alice))
+	assert.Equal(t, "alice", alice.User.Username)
+
+	// Usernames are case-insensitive
+	resp = postJSON(t, server.URL+"/api/auth/signup", `{"username": "ALICE", "password": "test-pass"}`, nil)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	resp = postJSON(t, server.URL+"/api/auth/signup", `{"username": "Admin", "password": "test-pass"}`, nil)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	resp = postJSON(t, server.URL+"/api/auth/signup", `{"username": "no-password"}`, nil)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	resp = postJSON(t, server.URL+"/api/auth/signup", `{"username": "bob", "email": "bob@example.com", "password": "test-pass"}`, nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&bob))
+	assert.Equal(t, "bob", bob.User.Username)
+	assert.Equal(t, "bob@example.com", bob.User.Email)
+
+	resp = postJSON(t, server.URL+"/api/auth/login", `{"username": "ALICE", "password": "test-pass"}`, nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	resp = postJSON(t, server.URL+"/api/auth/login", `{"username": "alice", "password": "wrong-pass"}`, nil)
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	resp = postJSON(t, server.URL+"/api/auth/username", `{"username": "bob"}`, &alice)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	resp = postJSON(t, server.URL+"/api/auth/username", `{"username": "alice2"}`, &alice)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&user))
+	assert.Equal(t, "alice2", user.Username)
+
+	// The old username is held for the previous owner
+	resp = postJSON(t, server.URL+"/api/auth/username", `{"username": "alice"}`, &bob)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	resp = postJSON(t, server.URL+"/api/auth/signup", `{"username": "alice", "password": "test-pass"}`, nil)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	resp = postJSON(t, server.URL+"/api/auth/username", `{"username": "alice"}`, &alice)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+}
@@ -0,0 +1,706 @@
+package cauth
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/gocopper/copper/cerrors"
+	"github.com/google/uuid"
+)
+
+const (
+	webAuthnChallengeLen = 32
+	webAuthnTimeout      = 5 * time.Minute
+
+	webAuthnCeremonyRegistration = "registration"
+	webAuthnCeremonyLogin        = "login"
+
+	webAuthnFlagUserPresent  = 0x01
+	webAuthnFlagUserVerified = 0x04
+	webAuthnFlagAttestedData = 0x40
+
+	coseAlgES256 = -7
+	coseAlgEdDSA = -8
+	coseAlgRS256 = -257
+
+	coseKtyOKP = 1
+	coseKtyEC2 = 2
+	coseKtyRSA = 3
+
+	coseCrvP256    = 1
+	coseCrvEd25519 = 6
+)
+
+// ErrWebAuthnCredentialExists is returned when a user tries to register a credential that is already registered.
+var ErrWebAuthnCredentialExists = errors.New("webauthn credential already exists")
+
+// WebAuthnBytes holds binary data that is encoded as unpadded base64url in JSON, which is the encoding used by
+// the WebAuthn JSON serialization in browsers.
+type WebAuthnBytes []byte
+
+// MarshalJSON implements json.Marshaler.
+func (b WebAuthnBytes) MarshalJSON() ([]byte, error) {
+	return json.Marshal(base64.RawURLEncoding.EncodeToString(b))
+}
+
+// UnmarshalJSON implements json.Unmarshaler. Both padded and unpadded base64url are accepted.
+func (b *WebAuthnBytes) UnmarshalJSON(data []byte) error {
+	var s string
+
+	err := json.Unmarshal(data, &s)
+	if err != nil {
+		return err
+	}
+
+	decoded, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+	if err != nil {
+		return err
+	}
+
+	*b = decoded
+
+	return nil
+}
+
+// WebAuthnCredentialDescriptor identifies a credential in the creation and request options.
+type WebAuthnCredentialDescriptor struct {
+	Type string        `json:"type"`
+	ID   WebAuthnBytes `json:"id"`
+}
+
+// WebAuthnCredentialParameter describes a public key algorithm that is accepted for new credentials.
+type WebAuthnCredentialParameter struct {
+	Type string `json:"type"`
+	Alg  int    `json:"alg"`
+}
+
+// WebAuthnRegistrationOptions is returned by BeginWebAuthnRegistration. PublicKey should be passed to
+// navigator.credentials.create and ChallengeUUID must be sent back to FinishWebAuthnRegistration.
+type WebAuthnRegistrationOptions struct {
+	ChallengeUUID string `json:"challenge_uuid"`
+	PublicKey     struct {
+		Challenge WebAuthnBytes `json:"challenge"`
+		RP        struct {
+			ID   string `json:"id"`
+			Name string `json:"name"`
+		} `json:"rp"`
+		User struct {
+			ID          WebAuthnBytes `json:"id"`
+			Name        string        `json:"name"`
+			DisplayName string        `json:"displayName"`
+		} `json:"user"`
+		PubKeyCredParams       []WebAuthnCredentialParameter  `json:"pubKeyCredParams"`
+		Timeout                int64                          `json:"timeout"`
+		ExcludeCredentials     []WebAuthnCredentialDescriptor `json:"excludeCredentials"`
+		AuthenticatorSelection struct {
+			ResidentKey      string `json:"residentKey"`
+			UserVerification string `json:"userVerification"`
+		} `json:"authenticatorSelection"`
+		Attestation string `json:"attestation"`
+	} `json:"public_key"`
+}
+
+// WebAuthnLoginOptions is returned by BeginWebAuthnLogin. PublicKey should be passed to navigator.credentials.get
+// and ChallengeUUID must be sent back to FinishWebAuthnLogin.
+type WebAuthnLoginOptions struct {
+	ChallengeUUID string `json:"challenge_uuid"`
+	PublicKey     struct {
+		Challenge        WebAuthnBytes                  `json:"challenge"`
+		RPID             string                         `json:"rpId"`
+		Timeout          int64                          `json:"timeout"`
+		AllowCredentials []WebAuthnCredentialDescriptor `json:"allowCredentials"`
+		UserVerification string                         `json:"userVerification"`
+	} `json:"public_key"`
+}
+
+// FinishWebAuthnRegistrationParams hold the params needed to complete a registration ceremony.
+type FinishWebAuthnRegistrationParams struct {
+	UserUUID      string `json:"-"`
+	ChallengeUUID string `json:"challenge_uuid"`
+	Name          string `json:"name"`
+
+	Credential WebAuthnAttestationCredential `json:"credential"`
+}
+
+// WebAuthnAttestationCredential is the JSON serialization of the PublicKeyCredential returned by
+// navigator.credentials.create.
+type WebAuthnAttestationCredential struct {
+	ID       string        `json:"id"`
+	RawID    WebAuthnBytes `json:"rawId"`
+	Type     string        `json:"type"`
+	Response struct {
+		ClientDataJSON    WebAuthnBytes `json:"clientDataJSON"`
+		AttestationObject WebAuthnBytes `json:"attestationObject"`
+	} `json:"response"`
+}
+
+// BeginWebAuthnLoginParams hold the params needed to start a login ceremony. If Email is empty, the browser is
+// asked for a discoverable credential (passkey).
+type BeginWebAuthnLoginParams struct {
+	Email string `json:"email"`
+}
+
+// FinishWebAuthnLoginParams hold the params needed to complete a login ceremony.
+type FinishWebAuthnLoginParams struct {
+	ChallengeUUID string `json:"challenge_uuid"`
+	RememberMe    bool   `json:"remember_me"`
+
+	Credential WebAuthnAssertionCredential `json:"credential"`
+}
+
+// WebAuthnAssertionCredential is the JSON serialization of the PublicKeyCredential returned by
+// navigator.credentials.get.
+type WebAuthnAssertionCredential struct {
+	ID       string        `json:"id"`
+	RawID    WebAuthnBytes `json:"rawId"`
+	Type     string        `json:"type"`
+	Response struct {
+		ClientDataJSON    WebAuthnBytes `json:"clientDataJSON"`
+		AuthenticatorData WebAuthnBytes `json:"authenticatorData"`
+		Signature         WebAuthnBytes `json:"signature"`
+		UserHandle        WebAuthnBytes `json:"userHandle"`
+	} `json:"response"`
+}
+
+type webAuthnClientData struct {
+	Type      string `json:"type"`
+	Challenge string `json:"challenge"`
+	Origin    string `json:"origin"`
+}
+
+type webAuthnAuthenticatorData struct {
+	RPIDHash     []byte
+	Flags        byte
+	SignCount    uint32
+	CredentialID []byte
+	PublicKey    []byte
+}
+
+// BeginWebAuthnRegistration starts a registration ceremony for a new credential for the given user.
+func (s *Svc) BeginWebAuthnRegistration(ctx context.Context, userUUID string) (*WebAuthnRegistrationOptions, error) {
+	user, err := s.queries.GetUserByUUID(ctx, userUUID)
+	if err != nil {
+		return nil, cerrors.New(err, "failed to get user by uuid", map[string]interface{}{
+			"userUUID": userUUID,
+		})
+	}
+
+	credentials, err := s.queries.ListWebAuthnCredentialsByUserUUID(ctx, user.UUID)
+	if err != nil {
+		return nil, cerrors.New(err, "failed to list webauthn credentials", map[string]interface{}{
+			"userUUID": user.UUID,
+		})
+	}
+
+	challenge, err := s.createWebAuthnChallenge(ctx, webAuthnCeremonyRegistration, &user.UUID)
+	if err != nil {
+		return nil, cerrors.New(err, "failed to create webauthn challenge", map[string]interface{}{
+			"userUUID": user.UUID,
+		})
+	}
+
+	var options WebAuthnRegistrationOptions
+
+	options.ChallengeUUID = challenge.UUID
+	options.PublicKey.Challenge = challenge.Challenge
+	options.PublicKey.RP.ID = s.config.WebAuthnRPID
+	options.PublicKey.RP.Name = s.config.WebAuthnRPName
+	options.PublicKey.User.ID = []byte(user.UUID)
+	options.PublicKey.User.Name = user.accountName()
+	options.PublicKey.User.DisplayName = user.accountName()
+	options.PublicKey.Timeout = webAuthnTimeout.Milliseconds()
+	options.PublicKey.AuthenticatorSelection.ResidentKey = "preferred"
+	options.PublicKey.AuthenticatorSelection.UserVerification = "required"
+	options.PublicKey.Attestation = "none"
+
+	for _, alg := range []int{coseAlgES256, coseAlgEdDSA, coseAlgRS256} {
+		options.PublicKey.PubKeyCredParams = append(options.PublicKey.PubKeyCredParams, WebAuthnCredentialParameter{
+			Type: "public-key",
+			Alg:  alg,
+		})
+	}
+
+	options.PublicKey.ExcludeCredentials = make([]WebAuthnCredentialDescriptor, len(credentials))
+	for i := range credentials {
+		options.PublicKey.ExcludeCredentials[i] = WebAuthnCredentialDescriptor{
+			Type: "public-key",
+			ID:   credentials[i].CredentialID,
+		}
+	}
+
+	return &options, nil
+}
+
+// FinishWebAuthnRegistration verifies the authenticator's response to a registration ceremony and saves the new
+// credential. Attestation statements are not verified since the ceremony requests "none" attestation.
+func (s *Svc) FinishWebAuthnRegistration(ctx context.Context, p FinishWebAuthnRegistrationParams) (*WebAuthnCredential, error) {
+	challenge, err := s.consumeWebAuthnChallenge(ctx, p.ChallengeUUID, webAuthnCeremonyRegistration)
+	if err != nil {
+		return nil, err
+	}
+
+	if challenge.UserUUID == nil || *challenge.UserUUID != p.UserUUID {
+		return nil, ErrInvalidCredentials
+	}
+
+	err = s.verifyWebAuthnClientData(p.Credential.Response.ClientDataJSON, "webauthn.create", challenge.Challenge)
+	if err != nil {
+		return nil, err
+	}
+
+	attestation, _, err := decodeCBOR(p.Credential.Response.AttestationObject)
+	if err != nil {
+		return nil, ErrInvalidCredentials
+	}
+
+	attestationMap, _ := attestation.(map[interface{}]interface{})
+	rawAuthData, _ := attestationMap["authData"].([]byte)
+
+	authData, err := s.parseWebAuthnAuthenticatorData(rawAuthData)
+	if err != nil {
+		return nil, err
+	}
+
+	if authData.Flags&webAuthnFlagAttestedData == 0 || len(authData.CredentialID) == 0 {
+		return nil, ErrInvalidCredentials
+	}
+
+	_, err = parseCOSEPublicKey(authData.PublicKey)
+	if err != nil {
+		return nil, ErrInvalidCredentials
+	}
+
+	_, err = s.queries.GetWebAuthnCredentialByCredentialID(ctx, authData.CredentialID)
+	if err == nil {
+		return nil, ErrWebAuthnCredentialExists
+	} else if !errors.Is(err, ErrNotFound) {
+		return nil, cerrors.New(err, "failed to get webauthn credential", nil)
+	}
+
+	credential := &WebAuthnCredential{
+		UUID:         uuid.New().String(),
+		CreatedAt:    time.Now(),
+		UpdatedAt:    time.Now(),
+		UserUUID:     p.UserUUID,
+		Name:         p.Name,
+		CredentialID: authData.CredentialID,
+		PublicKey:    authData.PublicKey,
+		SignCount:    int64(authData.SignCount),
+	}
+
+	err = s.queries.InsertWebAuthnCredential(ctx, credential)
+	if err != nil {
+		return nil, cerrors.New(err, "failed to insert webauthn credential", map[string]interface{}{
+			"userUUID": p.UserUUID,
+		})
+	}
+
+	err = s.recordAuditEvent(ctx, &AuditEvent{
+		Type:     AuditEventWebAuthnCredentialAdded,
+		UserUUID: p.UserUUID,
+		Metadata: AuditMetadata{"credential_uuid": credential.UUID},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return credential, nil
+}
+
+// BeginWebAuthnLogin starts a login ceremony. If an email is provided, only that user's credentials are allowed.
+func (s *Svc) BeginWebAuthnLogin(ctx context.Context, p BeginWebAuthnLoginParams) (*WebAuthnLoginOptions, error) {
+	var (
+		options     WebAuthnLoginOptions
+		userUUID    *string
+		credentials = make([]WebAuthnCredential, 0)
+	)
+
+	if p.Email != "" {
+		user, err := s.queries.GetUserByEmail(ctx, p.Email)
+		if err != nil && errors.Is(err, ErrNotFound) {
+			return nil, ErrInvalidCredentials
+		} else if err != nil {
+			return nil, cerrors.New(err, "failed to get user by email", map[string]interface{}{
+				"email": p.Email,
+			})
+		}
+
+		credentials, err = s.queries.ListWebAuthnCredentialsByUserUUID(ctx, user.UUID)
+		if err != nil {
+			return nil, cerrors.New(err, "failed to list webauthn credentials", map[string]interface{}{
+				"userUUID": user.UUID,
+			})
+		}
+
+		userUUID = &user.UUID
+	}
+
+	challenge, err := s.createWebAuthnChallenge(ctx, webAuthnCeremonyLogin, userUUID)
+	if err != nil {
+		return nil, cerrors.New(err, "failed to create webauthn challenge", nil)
+	}
+
+	options.ChallengeUUID = challenge.UUID
+	options.PublicKey.Challenge = challenge.Challenge
+	options.PublicKey.RPID = s.config.WebAuthnRPID
+	options.PublicKey.Timeout = webAuthnTimeout.Milliseconds()
+	options.PublicKey.UserVerification = "required"
+	options.PublicKey.AllowCredentials = make([]WebAuthnCredentialDescriptor, len(credentials))
+
+	for i := range credentials {
+		options.PublicKey.AllowCredentials[i] = WebAuthnCredentialDescriptor{
+			Type: "public-key",
+			ID:   credentials[i].CredentialID,
+		}
+	}
+
+	return &options, nil
+}
+
+// FinishWebAuthnLogin verifies the authenticator's assertion and creates a new session for the credential's user.
+func (s *Svc) FinishWebAuthnLogin(ctx context.Context, p FinishWebAuthnLoginParams) (*SessionResult, error) {
+	challenge, err := s.consumeWebAuthnChallenge(ctx, p.ChallengeUUID, webAuthnCeremonyLogin)
+	if err != nil {
+		return nil, err
+	}
+
+	credential, err := s.queries.GetWebAuthnCredentialByCredentialID(ctx, p.Credential.RawID)
+	if err != nil && errors.Is(err, ErrNotFound) {
+		return nil, ErrInvalidCredentials
+	} else if err != nil {
+		return nil, cerrors.New(err, "failed to get webauthn credential", nil)
+	}
+
+	if challenge.UserUUID != nil && *challenge.UserUUID != credential.UserUUID {
+		return nil, ErrInvalidCredentials
+	}
+
+	userHandle := p.Credential.Response.UserHandle
+	if len(userHandle) > 0 && string(userHandle) != credential.UserUUID {
+		return nil, ErrInvalidCredentials
+	}
+
+	clientDataJSON := p.Credential.Response.ClientDataJSON
+
+	err = s.verifyWebAuthnClientData(clientDataJSON, "webauthn.get", challenge.Challenge)
+	if err != nil {
+		return nil, err
+	}
+
+	rawAuthData := p.Credential.Response.AuthenticatorData
+
+	authData, err := s.parseWebAuthnAuthenticatorData(rawAuthData)
+	if err != nil {
+		return nil, err
+	}
+
+	publicKey, err := parseCOSEPublicKey(credential.PublicKey)
+	if err != nil {
+		return nil, cerrors.New(err, "failed to parse stored webauthn public key", map[string]interface{}{
+			"credentialUUID": credential.UUID,
+		})
+	}
+
+	clientDataHash := sha256.Sum256(clientDataJSON)
+	signed := append(append([]byte(nil), rawAuthData...), clientDataHash[:]...)
+
+	if !publicKey.verify(signed, p.Credential.Response.Signature) {
+		return nil, ErrInvalidCredentials
+	}
+
+	// A sign count that does not increase may indicate a cloned authenticator. Authenticators that do not
+	// implement a counter always report 0.
+	if (authData.SignCount != 0 || credential.SignCount != 0) && int64(authData.SignCount) <= credential.SignCount {
+		return nil, ErrInvalidCredentials
+	}
+
+	credential.UpdatedAt = time.Now()
+	credential.LastUsedAt = &credential.UpdatedAt
+	credential.SignCount = int64(authData.SignCount)
+
+	err = s.queries.UpdateWebAuthnCredential(ctx, credential)
+	if err != nil {
+		return nil, cerrors.New(err, "failed to update webauthn credential", map[string]interface{}{
+			"credentialUUID": credential.UUID,
+		})
+	}
+
+	user, err := s.queries.GetUserByUUID(ctx, credential.UserUUID)
+	if err != nil {
+		return nil, cerrors.New(err, "failed to get user by uuid", map[string]interface{}{
+			"userUUID": credential.UserUUID,
+		})
+	}
+
+	sessionResult, err := s.createSessionResult(ctx, user, p.RememberMe)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.recordLogin(ctx, loginMethodWebAuthn, sessionResult)
+	if err != nil {
+		return nil, err
+	}
+
+	return sessionResult, nil
+}
+
+// ListWebAuthnCredentials returns the credentials registered by the given user.
+func (s *Svc) ListWebAuthnCredentials(ctx context.Context, userUUID string) ([]WebAuthnCredential, error) {
+	return s.queries.ListWebAuthnCredentialsByUserUUID(ctx, userUUID)
+}
+
+// DeleteWebAuthnCredential deletes the credential identified by the given uuid if it belongs to the given user.
+func (s *Svc) DeleteWebAuthnCredential(ctx context.Context, userUUID, credentialUUID string) error {
+	err := s.queries.DeleteWebAuthnCredential(ctx, userUUID, credentialUUID)
+	if err != nil {
+		return cerrors.New(err, "failed to delete webauthn credential", map[string]interface{}{
+			"userUUID":       userUUID,
+			"credentialUUID": credentialUUID,
+		})
+	}
+
+	return s.recordAuditEvent(ctx, &AuditEvent{
+		Type:     AuditEventWebAuthnCredentialDeleted,
+		UserUUID: userUUID,
+		Metadata: AuditMetadata{"credential_uuid": credentialUUID},
+	})
+}
+
+func (s *Svc) createWebAuthnChallenge(ctx context.Context, ceremony string, userUUID *string) (*WebAuthnChallenge, error) {
+	challengeBytes := make([]byte, webAuthnChallengeLen)
+
+	_, err := rand.Read(challengeBytes)
+	if err != nil {
+		return nil, cerrors.New(err, "failed to generate webauthn challenge", nil)
+	}
+
+	challenge := &WebAuthnChallenge{
+		UUID:      uuid.New().String(),
+		CreatedAt: time.Now(),
+		UpdatedAt: time.Now(),
+		UserUUID:  userUUID,
+		Ceremony:  ceremony,
+		Challenge: challengeBytes,
+		ExpiresAt: time.Now().Add(webAuthnTimeout),
+	}
+
+	err = s.queries.InsertWebAuthnChallenge(ctx, challenge)
+	if err != nil {
+		return nil, cerrors.New(err, "failed to insert webauthn challenge", nil)
+	}
+
+	return challenge, nil
+}
+
+// consumeWebAuthnChallenge fetches the challenge and expires it so that it cannot be used again.
+func (s *Svc) consumeWebAuthnChallenge(ctx context.Context, challengeUUID, ceremony string) (*WebAuthnChallenge, error) {
+	challenge, err := s.queries.GetWebAuthnChallenge(ctx, challengeUUID)
+	if err != nil && errors.Is(err, ErrNotFound) {
+		return nil, ErrInvalidCredentials
+	} else if err != nil {
+		return nil, cerrors.New(err, "failed to get webauthn challenge", map[string]interface{}{
+			"challengeUUID": challengeUUID,
+		})
+	}
+
+	if challenge.Ceremony != ceremony || time.Now().After(challenge.ExpiresAt) {
+		return nil, ErrInvalidCredentials
+	}
+
+	challenge.UpdatedAt = time.Now()
+	challenge.ExpiresAt = challenge.UpdatedAt
+
+	err = s.queries.UpdateWebAuthnChallenge(ctx, challenge)
+	if err != nil {
+		return nil, cerrors.New(err, "failed to update webauthn challenge", map[string]interface{}{
+			"challengeUUID": challengeUUID,
+		})
+	}
+
+	return challenge, nil
+}
+
+func (s *Svc) verifyWebAuthnClientData(clientDataJSON []byte, ceremonyType string, challenge []byte) error {
+	var clientData webAuthnClientData
+
+	err := json.Unmarshal(clientDataJSON, &clientData)
+	if err != nil {
+		return ErrInvalidCredentials
+	}
+
+	if clientData.Type != ceremonyType {
+		return ErrInvalidCredentials
+	}
+
+	expectedChallenge := base64.RawURLEncoding.EncodeToString(challenge)
+	if subtle.ConstantTimeCompare([]byte(strings.TrimRight(clientData.Challenge, "=")), []byte(expectedChallenge)) != 1 {
+		return ErrInvalidCredentials
+	}
+
+	for _, origin := range s.config.WebAuthnOrigins {
+		if clientData.Origin == origin {
+			return nil
+		}
+	}
+
+	return ErrInvalidCredentials
+}
+
+// parseWebAuthnAuthenticatorData parses the authenticator data and verifies the rp id hash and the user presence and
+// user verification flags. User verification is required because a passkey login skips both the password and the
+// second factor, so the authenticator must have checked a PIN or biometric on top of the user's presence.
+func (s *Svc) parseWebAuthnAuthenticatorData(data []byte) (*webAuthnAuthenticatorData, error) {
+	const (
+		minLen      = 37
+		aaguidLen   = 16
+		credIDLenSz = 2
+	)
+
+	if len(data) < minLen {
+		return nil, ErrInvalidCredentials
+	}
+
+	authData := webAuthnAuthenticatorData{
+		RPIDHash:  data[:32],
+		Flags:     data[32],
+		SignCount: binary.BigEndian.Uint32(data[33:37]),
+	}
+
+	rpIDHash := sha256.Sum256([]byte(s.config.WebAuthnRPID))
+	if !bytes.Equal(authData.RPIDHash, rpIDHash[:]) {
+		return nil, ErrInvalidCredentials
+	}
+
+	if authData.Flags&webAuthnFlagUserPresent == 0 || authData.Flags&webAuthnFlagUserVerified == 0 {
+		return nil, ErrInvalidCredentials
+	}
+
+	if authData.Flags&webAuthnFlagAttestedData == 0 {
+		return &authData, nil
+	}
+
+	rest := data[minLen:]
+	if len(rest) < aaguidLen+credIDLenSz {
+		return nil, ErrInvalidCredentials
+	}
+
+	rest = rest[aaguidLen:]
+	credIDLen := int(binary.BigEndian.Uint16(rest[:credIDLenSz]))
+	rest = rest[credIDLenSz:]
+
+	if len(rest) < credIDLen {
+		return nil, ErrInvalidCredentials
+	}
+
+	authData.CredentialID = rest[:credIDLen]
+	rest = rest[credIDLen:]
+
+	_, keyLen, err := decodeCBOR(rest)
+	if err != nil {
+		return nil, ErrInvalidCredentials
+	}
+
+	authData.PublicKey = rest[:keyLen]
+
+	return &authData, nil
+}
+
+type cosePublicKey struct {
+	key crypto.PublicKey
+}
+
+func (k *cosePublicKey) verify(data, sig []byte) bool {
+	switch pub := k.key.(type) {
+	case *ecdsa.PublicKey:
+		digest := sha256.Sum256(data)
+		return ecdsa.VerifyASN1(pub, digest[:], sig)
+	case *rsa.PublicKey:
+		digest := sha256.Sum256(data)
+		return rsa.VerifyPKCS1v15(pub, crypto.SHA256, digest[:], sig) == nil
+	case ed25519.PublicKey:
+		return ed25519.Verify(pub, data, sig)
+	default:
+		return false
+	}
+}
+
+// parseCOSEPublicKey parses a COSE_Key encoded public key. ES256, RS256 and EdDSA keys are supported.
+func parseCOSEPublicKey(data []byte) (*cosePublicKey, error) {
+	decoded, _, err := decodeCBOR(data)
+	if err != nil {
+		return nil, cerrors.New(err, "failed to decode cose key", nil)
+	}
+
+	m, ok := decoded.(map[interface{}]interface{})
+	if !ok {
+		return nil, cerrors.New(nil, "cose key is not a map", nil)
+	}
+
+	kty, _ := m[int64(1)].(int64)
+	alg, _ := m[int64(3)].(int64)
+
+	switch {
+	case kty == coseKtyEC2 && alg == coseAlgES256:
+		crv, _ := m[int64(-1)].(int64)
+		x, _ := m[int64(-2)].([]byte)
+		y, _ := m[int64(-3)].([]byte)
+
+		if crv != coseCrvP256 || len(x) != 32 || len(y) != 32 {
+			return nil, cerrors.New(nil, "invalid ec2 cose key", nil)
+		}
+
+		pub := &ecdsa.PublicKey{
+			Curve: elliptic.P256(),
+			X:     new(big.Int).SetBytes(x),
+			Y:     new(big.Int).SetBytes(y),
+		}
+
+		if !pub.Curve.IsOnCurve(pub.X, pub.Y) { //nolint:staticcheck
+			return nil, cerrors.New(nil, "ec2 cose key is not on curve", nil)
+		}
+
+		return &cosePublicKey{key: pub}, nil
+	case kty == coseKtyRSA && alg == coseAlgRS256:
+		n, _ := m[int64(-1)].([]byte)
+		e, _ := m[int64(-2)].([]byte)
+
+		if len(n) == 0 || len(e) == 0 || len(e) > 4 {
+			return nil, cerrors.New(nil, "invalid rsa cose key", nil)
+		}
+
+		return &cosePublicKey{key: &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}}, nil
+	case kty == coseKtyOKP && alg == coseAlgEdDSA:
+		crv, _ := m[int64(-1)].(int64)
+		x, _ := m[int64(-2)].([]byte)
+
+		if crv != coseCrvEd25519 || len(x) != ed25519.PublicKeySize {
+			return nil, cerrors.New(nil, "invalid okp cose key", nil)
+		}
+
+		return &cosePublicKey{key: ed25519.PublicKey(x)}, nil
+	default:
+		return nil, cerrors.New(nil, "unsupported cose key", map[string]interface{}{
+			"kty": kty,
+			"alg": alg,
+		})
+	}
+}
@@ -0,0 +1,339 @@
+package cauth_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"testing"
+
+	"github.com/gocopper/pkg/cauth"
+	"github.com/gocopper/pkg/cauth/cauthtest"
+	"github.com/stretchr/testify/assert"
+)
+
+// softAuthenticator is a software implementation of a WebAuthn authenticator with a single ES256 credential. It
+// produces the same JSON that browsers send after navigator.credentials.create and navigator.credentials.get.
+type softAuthenticator struct {
+	t *testing.T
+
+	rpID         string
+	origin       string
+	credentialID []byte
+	key          *ecdsa.PrivateKey
+	signCount    uint32
+
+	// skipUserVerification makes assertions report that the user was present but not verified
+	skipUserVerification bool
+}
+
+func newSoftAuthenticator(t *testing.T) *softAuthenticator {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+
+	credentialID := make([]byte, 16)
+	_, err = rand.Read(credentialID)
+	assert.NoError(t, err)
+
+	return &softAuthenticator{
+		t:            t,
+		rpID:         "localhost",
+		origin:       "http://localhost:7501",
+		credentialID: credentialID,
+		key:          key,
+	}
+}
+
+func (a *softAuthenticator) create(options *cauth.WebAuthnRegistrationOptions) cauth.WebAuthnAttestationCredential {
+	var credential cauth.WebAuthnAttestationCredential
+
+	coseKey := cborMap(map[interface{}]interface{}{
+		int64(1):  int64(2),
+		int64(3):  int64(-7),
+		int64(-1): int64(1),
+		int64(-2): padTo32(a.key.X.Bytes()),
+		int64(-3): padTo32(a.key.Y.Bytes()),
+	})
+
+	attestedData := make([]byte, 16)
+	attestedData = binary.BigEndian.AppendUint16(attestedData, uint16(len(a.credentialID)))
+	attestedData = append(attestedData, a.credentialID...)
+	attestedData = append(attestedData, coseKey...)
+
+	authData := a.authData(0x45, attestedData)
+
+	credential.ID = base64.RawURLEncoding.EncodeToString(a.credentialID)
+	credential.RawID = a.credentialID
+	credential.Type = "public-key"
+	credential.Response.ClientDataJSON = a.clientData("webauthn.create", options.PublicKey.Challenge)
+	credential.Response.AttestationObject = cborMap(map[interface{}]interface{}{
+		"fmt":      "none",
+		"attStmt":  map[interface{}]interface{}{},
+		"authData": authData,
+	})
+
+	return credential
+}
+
+func (a *softAuthenticator) get(options *cauth.WebAuthnLoginOptions, userUUID string) cauth.WebAuthnAssertionCredential {
+	var credential cauth.WebAuthnAssertionCredential
+
+	a.signCount++
+
+	flags := byte(0x05)
+	if a.skipUserVerification {
+		flags = 0x01
+	}
+
+	authData := a.authData(flags, nil)
+	clientDataJSON := a.clientData("webauthn.get", options.PublicKey.Challenge)
+	clientDataHash := sha256.Sum256(clientDataJSON)
+	digest := sha256.Sum256(append(append([]byte(nil), authData...), clientDataHash[:]...))
+
+	sig, err := ecdsa.SignASN1(rand.Reader, a.key, digest[:])
+	assert.NoError(a.t, err)
+
+	credential.ID = base64.RawURLEncoding.EncodeToString(a.credentialID)
+	credential.RawID = a.credentialID
+	credential.Type = "public-key"
+	credential.Response.ClientDataJSON = clientDataJSON
+	credential.Response.AuthenticatorData = authData
+	credential.Response.Signature = sig
+	credential.Response.UserHandle = []byte(userUUID)
+
+	return credential
+}
+
+func (a *softAuthenticator) authData(flags byte, attestedData []byte) []byte {
+	rpIDHash := sha256.Sum256([]byte(a.rpID))
+
+	data := append([]byte(nil), rpIDHash[:]...)
+	data = append(data, flags)
+	data = binary.BigEndian.AppendUint32(data, a.signCount)
+
+	return append(data, attestedData...)
+}
+
+func (a *softAuthenticator) clientData(typ string, challenge []byte) []byte {
+	data, err := json.Marshal(map[string]string{
+		"type":      typ,
+		"challenge": base64.RawURLEncoding.EncodeToString(challenge),
+		"origin":    a.origin,
+	})
+	assert.NoError(a.t, err)
+
+	return data
+}
+
+func padTo32(b []byte) []byte {
+	return append(make([]byte, 32-len(b)), b...)
+}
+
+// cborMap encodes a map using the subset of CBOR needed by the software authenticator.
+func cborMap(m map[interface{}]interface{}) []byte {
+	keys := make([]interface{}, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		return string(cborEncode(keys[i])) < string(cborEncode(keys[j]))
+	})
+
+	out := cborHead(5, uint64(len(m)))
+	for _, k := range keys {
+		out = append(out, cborEncode(k)...)
+		out = append(out, cborEncode(m[k])...)
+	}
+
+	return out
+}
+
+func cborEncode(v interface{}) []byte {
+	switch v := v.(type) {
+	case int64:
+		if v < 0 {
+			return cborHead(1, uint64(-1-v))
+		}
+		return cborHead(0, uint64(v))
+	case []byte:
+		return append(cborHead(2, uint64(len(v))), v...)
+	case string:
+		return append(cborHead(3, uint64(len(v))), v...)
+	case map[interface{}]interface{}:
+		return cborMap(v)
+	default:
+		panic("unsupported cbor type")
+	}
+}
+
+func cborHead(major byte, n uint64) []byte {
+	switch {
+	case n < 24:
+		return []byte{major<<5 | byte(n)}
+	case n <= 0xff:
+		return []byte{major<<5 | 24, byte(n)}
+	case n <= 0xffff:
+		return binary.BigEndian.AppendUint16([]byte{major<<5 | 25}, uint16(n))
+	default:
+		return binary.BigEndian.AppendUint32([]byte{major<<5 | 26}, uint32(n))
+	}
+}
+
+func TestRouter_WebAuthn(t *testing.T) {
+	t.Parallel()
+
+	var (
+		registrationOptions cauth.WebAuthnRegistrationOptions
+		loginOptions        cauth.WebAuthnLoginOptions
+		credential          cauth.WebAuthnCredential
+		sessionResult       cauth.SessionResult
+
+		authenticator = newSoftAuthenticator(t)
+	)
+
+	server := httptest.NewServer(cauthtest.NewHandler(t))
+	defer server.Close()
+
+	session := cauthtest.CreateNewUserSession(t, server)
+
+	resp := postJSON(t, server.URL+"/api/auth/webauthn/register/begin", `{}`, session)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&registrationOptions))
+
+	// Users without an email are named after their username
+	assert.Equal(t, "test-user", registrationOptions.PublicKey.User.Name)
+	assert.Equal(t, "test-user", registrationOptions.PublicKey.User.DisplayName)
+
+	registerBody, err := json.Marshal(cauth.FinishWebAuthnRegistrationParams{
+		ChallengeUUID: registrationOptions.ChallengeUUID,
+		Name:          "Test Key",
+		Credential:    authenticator.create(&registrationOptions),
+	})
+	assert.NoError(t, err)
+
+	resp = postJSON(t, server.URL+"/api/auth/webauthn/register/finish", string(registerBody), session)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&credential))
+	assert.Equal(t, "Test Key", credential.Name)
+
+	// The registration challenge cannot be reused
+	resp = postJSON(t, server.URL+"/api/auth/webauthn/register/finish", string(registerBody), session)
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	resp = postJSON(t, server.URL+"/api/auth/webauthn/login/begin", `{}`, nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&loginOptions))
+
+	loginBody, err := json.Marshal(cauth.FinishWebAuthnLoginParams{
+		ChallengeUUID: loginOptions.ChallengeUUID,
+		Credential:    authenticator.get(&loginOptions, session.User.UUID),
+	})
+	assert.NoError(t, err)
+
+	resp = postJSON(t, server.URL+"/api/auth/webauthn/login/finish", string(loginBody), nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&sessionResult))
+	assert.Equal(t, session.User.UUID, sessionResult.User.UUID)
+	assert.NotEmpty(t, sessionResult.PlainSessionToken)
+
+	// A replayed assertion is rejected
+	resp = postJSON(t, server.URL+"/api/auth/webauthn/login/finish", string(loginBody), nil)
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+}
+
+func TestRouter_WebAuthn_InvalidSignature(t *testing.T) {
+	t.Parallel()
+
+	var (
+		registrationOptions cauth.WebAuthnRegistrationOptions
+		loginOptions        cauth.WebAuthnLoginOptions
+
+		authenticator = newSoftAuthenticator(t)
+	)
+
+	server := httptest.NewServer(cauthtest.NewHandler(t))
+	defer server.Close()
+
+	session := cauthtest.CreateNewUserSession(t, server)
+
+	resp := postJSON(t, server.URL+"/api/auth/webauthn/register/begin", `{}`, session)
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&registrationOptions))
+
+	registerBody, err := json.Marshal(cauth.FinishWebAuthnRegistrationParams{
+		ChallengeUUID: registrationOptions.ChallengeUUID,
+		Credential:    authenticator.create(&registrationOptions),
+	})
+	assert.NoError(t, err)
+
+	resp = postJSON(t, server.URL+"/api/auth/webauthn/register/finish", string(registerBody), session)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	resp = postJSON(t, server.URL+"/api/auth/webauthn/login/begin", `{}`, nil)
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&loginOptions))
+
+	assertion := authenticator.get(&loginOptions, session.User.UUID)
+	assertion.Response.Signature[len(assertion.Response.Signature)-1] ^= 0xff
+
+	loginBody, err := json.Marshal(cauth.FinishWebAuthnLoginParams{
+		ChallengeUUID: loginOptions.ChallengeUUID,
+		Credential:    assertion,
+	})
+	assert.NoError(t, err)
+
+	resp = postJSON(t, server.URL+"/api/auth/webauthn/login/finish", string(loginBody), nil)
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+}
+
+func TestRouter_WebAuthn_UserNotVerified(t *testing.T) {
+	t.Parallel()
+
+	var (
+		registrationOptions cauth.WebAuthnRegistrationOptions
+		loginOptions        cauth.WebAuthnLoginOptions
+
+		authenticator = newSoftAuthenticator(t)
+	)
+
+	server := httptest.NewServer(cauthtest.NewHandler(t))
+	defer server.Close()
+
+	session := cauthtest.CreateNewUserSession(t, server)
+
+	resp := postJSON(t, server.URL+"/api/auth/webauthn/register/begin", `{}`, session)
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&registrationOptions))
+	assert.Equal(t, "required", registrationOptions.PublicKey.AuthenticatorSelection.UserVerification)
+
+	registerBody, err := json.Marshal(cauth.FinishWebAuthnRegistrationParams{
+		ChallengeUUID: registrationOptions.ChallengeUUID,
+		Credential:    authenticator.create(&registrationOptions),
+	})
+	assert.NoError(t, err)
+
+	resp = postJSON(t, server.URL+"/api/auth/webauthn/register/finish", string(registerBody), session)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	resp = postJSON(t, server.URL+"/api/auth/webauthn/login/begin", `{}`, nil)
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&loginOptions))
+	assert.Equal(t, "required", loginOptions.PublicKey.UserVerification)
+
+	// A passkey replaces both factors, so an assertion without user verification is rejected
+	authenticator.skipUserVerification = true
+
+	loginBody, err := json.Marshal(cauth.FinishWebAuthnLoginParams{
+		ChallengeUUID: loginOptions.ChallengeUUID,
+		Credential:    authenticator.get(&loginOptions, session.User.UUID),
+	})
+	assert.NoError(t, err)
+
+	resp = postJSON(t, server.URL+"/api/auth/webauthn/login/finish", string(loginBody), nil)
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+}
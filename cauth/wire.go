@@ -1,17 +1,40 @@
 package cauth
 
 import (
+	"github.com/gocopper/pkg/cpubsub"
 	"github.com/google/wire"
 )
 
-// WireModule can be used as part of google/wire setup.
+// WireModule can be used as part of google/wire setup. It does not provide an AttemptStore, so it must be used along
+// with WireModuleSQLAttemptStore, WireModuleRedisAttemptStore or a provider of a custom AttemptStore. Svc also needs
+// a cpubsub.PubSub to publish events, e.g. from cpubsub.WireModuleLocal, or WireModuleNoPubSub to publish none.
 var WireModule = wire.NewSet( //nolint:gochecknoglobals
 	NewSvc,
 	NewQueries,
 	NewVerifySessionMiddleware,
 	NewSetSessionIfAnyMiddleware,
+	NewVerifyAPIKeyMiddleware,
+	NewVerifyAccessTokenMiddleware,
+	NewVerifyOrganizationMiddleware,
+	NewCSRFMiddleware,
+	NewJanitor,
 	LoadConfig,
 
 	wire.Struct(new(NewRouterParams), "*"),
 	NewRouter,
 )
+
+// WireModuleSQLAttemptStore provides an AttemptStore that keeps counters in the database.
+var WireModuleSQLAttemptStore = wire.NewSet( //nolint:gochecknoglobals
+	NewSQLAttemptStore,
+)
+
+// WireModuleRedisAttemptStore provides an AttemptStore that keeps counters in Redis. It needs a *redis.Client.
+var WireModuleRedisAttemptStore = wire.NewSet( //nolint:gochecknoglobals
+	NewRedisAttemptStore,
+)
+
+// WireModuleNoPubSub provides a nil cpubsub.PubSub for apps that don't use events, so that Svc does not publish them.
+var WireModuleNoPubSub = wire.NewSet( //nolint:gochecknoglobals
+	wire.InterfaceValue(new(cpubsub.PubSub), cpubsub.PubSub(nil)),
+)